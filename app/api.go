@@ -0,0 +1,313 @@
+package app
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"osssync/client"
+	"osssync/common/config"
+	"osssync/common/progress"
+	"osssync/common/scheduler"
+	"osssync/common/tracing"
+	"osssync/core"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	resultRunning  = "running"
+	resultSuccess  = "success"
+	resultFailure  = "failure"
+	resultCanceled = "canceled"
+)
+
+type runStatus struct {
+	RunId     string             `json:"run_id,omitempty"`
+	Job       string             `json:"job"`
+	Operation string             `json:"operation"`
+	Start     time.Time          `json:"start"`
+	End       *time.Time         `json:"end,omitempty"`
+	Result    string             `json:"result"`
+	Error     string             `json:"error,omitempty"`
+	Progress  *progress.Snapshot `json:"progress,omitempty"`
+}
+
+type jobStatus struct {
+	Name      string     `json:"name"`
+	Operation string     `json:"operation"`
+	Source    string     `json:"source"`
+	Dest      string     `json:"dest"`
+	Schedule  string     `json:"schedule,omitempty"`
+	NextRun   *time.Time `json:"next_run,omitempty"`
+	Running   *runStatus `json:"running,omitempty"`
+	LastRun   *runStatus `json:"last_run,omitempty"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// registerAPI adds the control api to mux:
+//
+//	GET  /healthz                 liveness for the container health check
+//	GET  /api/jobs                jobs with their schedule, run in progress and last run
+//	GET  /api/jobs/{name}
+//	POST /api/jobs/{name}/run     runs a job now, daemon mode only
+//	POST /api/jobs/{name}/cancel  cancels the run in progress of a job
+//	GET  /api/transfers           progress of the runs in progress
+//	GET  /api/runs?job=&limit=    recorded runs newest first
+//	GET  /api/runs/{id}           a recorded run with its journal
+//
+// The /api endpoints require "Authorization: Bearer <OSY_HTTP_TOKEN>". Without a token only the GET endpoints are served,
+// a job can't be run or canceled by anyone reaching the address.
+func registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", handleHealth)
+	mux.Handle("/api/jobs", authorized(http.HandlerFunc(handleJobs)))
+	mux.Handle("/api/jobs/", authorized(http.HandlerFunc(handleJob)))
+	mux.Handle("/api/transfers", authorized(http.HandlerFunc(handleTransfers)))
+	mux.Handle("/api/runs", authorized(http.HandlerFunc(handleRuns)))
+	mux.Handle("/api/runs/", authorized(http.HandlerFunc(handleRun)))
+}
+
+func authorized(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := config.GetStringOrDefault(core.Arg_HttpToken, "")
+		if token == "" {
+			if r.Method != http.MethodGet {
+				writeError(w, http.StatusForbidden, fmt.Errorf("%s is not set, the api is read only", core.Arg_HttpToken))
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "ok",
+		"daemon":  currentDaemon() != nil,
+		"running": len(runningRuns()),
+	})
+}
+
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+	jobs, err := selectJobs()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	statuses := make([]jobStatus, 0, len(jobs))
+	for _, job := range jobs {
+		statuses = append(statuses, statusOf(job))
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// handleJob serves /api/jobs/{name} and its actions
+func handleJob(w http.ResponseWriter, r *http.Request) {
+	name, action := strings.TrimPrefix(r.URL.Path, "/api/jobs/"), ""
+	if i := strings.Index(name, "/"); i >= 0 {
+		name, action = name[:i], name[i+1:]
+	}
+	job, err := findSelectedJob(name)
+	if err != nil {
+		if tracing.IsError(err, client.ErrJobNotFound) {
+			writeError(w, http.StatusNotFound, err)
+		} else {
+			writeError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	method := http.MethodPost
+	if action == "" {
+		method = http.MethodGet
+	}
+	if r.Method != method {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+
+	switch action {
+	case "":
+		writeJSON(w, http.StatusOK, statusOf(job))
+	case "run":
+		d := currentDaemon()
+		if d == nil {
+			writeError(w, http.StatusServiceUnavailable, fmt.Errorf("runs can only be triggered in daemon mode"))
+			return
+		}
+		err := d.trigger(name)
+		if err != nil {
+			if tracing.IsError(err, scheduler.ErrJobRunning) {
+				writeError(w, http.StatusConflict, err)
+			} else {
+				writeError(w, http.StatusInternalServerError, err)
+			}
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"job": name, "result": "triggered"})
+	case "cancel":
+		err := cancelRun(name)
+		if err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"job": name, "result": "canceling"})
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown action %s", action))
+	}
+}
+
+func handleTransfers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+	runs := runningRuns()
+	statuses := make([]runStatus, 0, len(runs))
+	for _, run := range runs {
+		statuses = append(statuses, run.status())
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func handleRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+	limit := historyLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %s", v))
+			return
+		}
+		limit = n
+	}
+	runs, err := client.History(r.URL.Query().Get("job"), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+func handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+	run, err := client.FindRun(strings.TrimPrefix(r.URL.Path, "/api/runs/"))
+	if err != nil {
+		if tracing.IsError(err, client.ErrRunNotFound) {
+			writeError(w, http.StatusNotFound, err)
+		} else {
+			writeError(w, http.StatusBadRequest, err)
+		}
+		return
+	}
+	journal, err := client.RunJournal(run.Id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		*client.RunRecord
+		Journal []client.JournalEntry `json:"journal"`
+	}{run, journal})
+}
+
+func findSelectedJob(name string) (*client.Job, error) {
+	jobs, err := selectJobs()
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	for _, job := range jobs {
+		if job.Name == name {
+			return job, nil
+		}
+	}
+	return nil, tracing.Errorf(fmt.Sprintf("job %s not found", name), client.ErrJobNotFound)
+}
+
+func statusOf(job *client.Job) jobStatus {
+	status := jobStatus{
+		Name:      job.Name,
+		Operation: job.Operation,
+		Source:    job.Source,
+		Dest:      job.Dest,
+		Schedule:  job.Schedule,
+	}
+	if d := currentDaemon(); d != nil {
+		if next := d.sched.Next(job.Name); !next.IsZero() {
+			status.NextRun = &next
+		}
+	}
+	inProgress, last := findRuns(job.Name)
+	if inProgress != nil {
+		s := inProgress.status()
+		status.Running = &s
+	}
+	if last != nil {
+		s := last.status()
+		status.LastRun = &s
+	}
+	return status
+}
+
+func (r *run) status() runStatus {
+	runsLock.Lock()
+	end, err := r.end, r.err
+	runsLock.Unlock()
+
+	status := runStatus{
+		RunId:     r.runId,
+		Job:       r.job.Name,
+		Operation: r.job.Operation,
+		Start:     r.start,
+		Result:    resultRunning,
+	}
+	if r.tracker != nil {
+		snapshot := r.tracker.Snapshot()
+		status.Progress = &snapshot
+	}
+	if end.IsZero() {
+		return status
+	}
+	status.End = &end
+	switch {
+	case err == nil:
+		status.Result = resultSuccess
+	case tracing.IsError(err, client.ErrCanceled):
+		status.Result = resultCanceled
+	default:
+		status.Result = resultFailure
+		status.Error = tracing.Message(err)
+	}
+	return status
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, apiError{Error: tracing.Message(err)})
+}
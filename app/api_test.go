@@ -0,0 +1,52 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"osssync/common/config"
+	"osssync/core"
+	"testing"
+)
+
+func TestAPIToken(t *testing.T) {
+	t.Setenv(core.Arg_HttpToken, "")
+	mux := http.NewServeMux()
+	registerAPI(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	request := func(method string, path string, token string) int {
+		r, _ := http.NewRequest(method, server.URL+path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// without a token nothing can be run or canceled
+	config.AttachValue(core.Arg_HttpToken, "")
+	for _, path := range []string{"/api/jobs/backup/run", "/api/jobs/backup/cancel"} {
+		if status := request(http.MethodPost, path, ""); status != http.StatusForbidden {
+			t.Fatalf("POST %s without a token: %d", path, status)
+		}
+	}
+	if status := request(http.MethodGet, "/healthz", ""); status != http.StatusOK {
+		t.Fatalf("GET /healthz: %d", status)
+	}
+
+	config.AttachValue(core.Arg_HttpToken, "secret")
+	defer config.AttachValue(core.Arg_HttpToken, "")
+	if status := request(http.MethodPost, "/api/jobs/backup/run", ""); status != http.StatusUnauthorized {
+		t.Fatalf("POST run without the token: %d", status)
+	}
+	if status := request(http.MethodPost, "/api/jobs/backup/run", "wrong"); status != http.StatusUnauthorized {
+		t.Fatalf("POST run with a wrong token: %d", status)
+	}
+	if status := request(http.MethodGet, "/healthz", ""); status != http.StatusOK {
+		t.Fatalf("GET /healthz: %d", status)
+	}
+}
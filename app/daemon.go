@@ -0,0 +1,244 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"osssync/client"
+	"osssync/common/config"
+	"osssync/common/logging"
+	"osssync/common/scheduler"
+	"osssync/common/tracing"
+	"osssync/core"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// configCheckInterval is how often the daemon looks for changes of the config files
+var configCheckInterval = time.Minute
+
+type daemon struct {
+	sched *scheduler.Scheduler
+	// schedules is the cron expression of every job, empty for the jobs only run on demand
+	schedules map[string]string
+	// jitter is the OSY_CRON_JITTER the jobs are scheduled with
+	jitter time.Duration
+}
+
+// activeDaemon is the running daemon, nil when not in daemon mode
+var activeDaemon *daemon
+var activeDaemonLock sync.Mutex
+
+func currentDaemon() *daemon {
+	activeDaemonLock.Lock()
+	defer activeDaemonLock.Unlock()
+	return activeDaemon
+}
+
+func setDaemon(d *daemon) {
+	activeDaemonLock.Lock()
+	activeDaemon = d
+	activeDaemonLock.Unlock()
+}
+
+// RunDaemon keeps running and executes every selected job by its schedule, OSY_CRON for the jobs without one.
+// A run-now of all jobs can be requested with SIGUSR1, of one job by the http api, config files are reloaded when they change.
+func RunDaemon() error {
+	// OSY_EXEC_NOW was any non-empty value before it was a flag, a value which is not a bool must not silently disable it
+	execNow, err := strconv.ParseBool(config.GetStringOrDefault(core.Arg_ExecNow, "false"))
+	if err != nil {
+		return tracing.Errorf(fmt.Sprintf("invalid %s, expect true or false", core.Arg_ExecNow), err)
+	}
+	jobs, err := selectJobs()
+	if err != nil {
+		return tracing.Error(err)
+	}
+	scheduled := false
+	for _, job := range jobs {
+		scheduled = scheduled || job.Schedule != ""
+	}
+	if !scheduled && !httpServed() {
+		logging.Info("cron expression not found, execute once", nil)
+		return Run()
+	}
+
+	d := &daemon{
+		sched:     scheduler.New(),
+		schedules: make(map[string]string),
+	}
+	d.sched.OnError = func(name string, err error) {
+		if err == scheduler.ErrJobRunning {
+			logging.Warn(fmt.Sprintf("Previous run of job %s is still in progress, skipped", name), nil)
+			return
+		}
+		if tracing.IsError(err, client.ErrCanceled) {
+			logging.Warn(fmt.Sprintf("Job %s canceled", name), nil)
+			return
+		}
+		logging.Error(tracing.Errorf(fmt.Sprintf("Job %s failed", name), err), nil)
+	}
+	err = d.schedule(jobs)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	d.sched.Start()
+	defer d.sched.Stop()
+	setDaemon(d)
+	defer setDaemon(nil)
+
+	if execNow {
+		logging.Info("executing now", nil)
+		d.triggerAll()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append(stopSignals, append(triggerSignals, reloadSignals...)...)...)
+	defer signal.Stop(signals)
+
+	ticker := time.NewTicker(configCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case sig := <-signals:
+			switch {
+			case containsSignal(triggerSignals, sig):
+				logging.Info(fmt.Sprintf("received %s, executing now", sig), nil)
+				d.triggerAll()
+			case containsSignal(reloadSignals, sig):
+				d.reload()
+			default:
+				done := make(chan struct{})
+				defer close(done)
+				shutdown(sig, signals, done)
+				// no run is scheduled anymore, the runs in progress are waited for
+				d.sched.Stop()
+				return nil
+			}
+		case <-ticker.C:
+			d.reload()
+		}
+	}
+}
+
+// schedule adds the jobs whose cron expression or jitter changed and removes the jobs no longer selected,
+// nothing is changed when any cron expression is invalid
+func (d *daemon) schedule(jobs []*client.Job) error {
+	schedules := make(map[string]scheduler.Schedule)
+	for _, job := range jobs {
+		if job.Schedule == "" {
+			if _, ok := d.schedules[job.Name]; !ok {
+				logging.Warn(fmt.Sprintf("Job %s has no schedule, it only runs on demand", job.Name), nil)
+			}
+			schedules[job.Name] = scheduler.Manual
+			continue
+		}
+		schedule, err := scheduler.Parse(job.Schedule)
+		if err != nil {
+			return tracing.Errorf(fmt.Sprintf("invalid schedule of job %s", job.Name), err)
+		}
+		schedules[job.Name] = schedule
+	}
+	jitter := time.Duration(0)
+	if jitterExpr := config.GetStringOrDefault(core.Arg_CronJitter, ""); jitterExpr != "" {
+		var err error
+		jitter, err = time.ParseDuration(jitterExpr)
+		if err != nil {
+			return tracing.Errorf(fmt.Sprintf("invalid %s", core.Arg_CronJitter), err)
+		}
+	}
+
+	for name := range d.schedules {
+		if _, ok := schedules[name]; !ok {
+			d.sched.Remove(name)
+			delete(d.schedules, name)
+			logging.Info(fmt.Sprintf("Job %s is unscheduled", name), nil)
+		}
+	}
+	for _, job := range jobs {
+		if current, ok := d.schedules[job.Name]; ok && current == job.Schedule && jitter == d.jitter {
+			continue
+		}
+		d.sched.Add(job.Name, schedules[job.Name], jitter, runScheduled(job.Name))
+		d.schedules[job.Name] = job.Schedule
+		if job.Schedule != "" {
+			logging.Info(fmt.Sprintf("Job %s is scheduled by %q, next run at %s", job.Name, job.Schedule, d.sched.Next(job.Name).Format(time.RFC3339)), nil)
+		}
+	}
+	d.jitter = jitter
+	return nil
+}
+
+// trigger runs a job now, scheduler.ErrJobNotFound if the job is not selected
+func (d *daemon) trigger(name string) error {
+	err := d.sched.Trigger(name)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	logging.Info(fmt.Sprintf("Job %s triggered", name), nil)
+	return nil
+}
+
+func (d *daemon) triggerAll() {
+	for name := range d.schedules {
+		if err := d.sched.Trigger(name); err != nil {
+			logging.Warn(fmt.Sprintf("Job %s can not execute now: %v", name, err), nil)
+		}
+	}
+}
+
+// reload reloads the config files and reschedules the jobs, the current schedules are kept on errors
+func (d *daemon) reload() {
+	changed, err := config.Reload()
+	if err != nil {
+		logging.Error(tracing.Error(err), nil)
+		return
+	}
+	if !changed {
+		return
+	}
+	logging.Info("config reloaded", nil)
+	jobs, err := selectJobs()
+	if err == nil {
+		err = d.schedule(jobs)
+	}
+	if err != nil {
+		logging.Error(tracing.Error(err), nil)
+	}
+}
+
+// runScheduled runs the job with the settings of the latest config files
+func runScheduled(name string) scheduler.Job {
+	return func() error {
+		if _, err := config.Reload(); err != nil {
+			logging.Error(tracing.Error(err), nil)
+		}
+		jobs, err := selectJobs()
+		if err != nil {
+			return tracing.Error(err)
+		}
+		for _, job := range jobs {
+			if job.Name != name {
+				continue
+			}
+			start := time.Now()
+			logging.Info(fmt.Sprintf("Job %s %s started", job.Name, job.Operation), nil)
+			err := runJobWithProgress(job, progressMode(len(jobs)))
+			if err != nil {
+				return tracing.Error(err)
+			}
+			logging.Info(fmt.Sprintf("Job %s %s finished in %s", job.Name, job.Operation, time.Since(start)), nil)
+			return nil
+		}
+		return tracing.Errorf(fmt.Sprintf("job %s not found", name), client.ErrJobNotFound)
+	}
+}
+
+func containsSignal(signals []os.Signal, sig os.Signal) bool {
+	for _, s := range signals {
+		if s == sig {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package app
+
+import (
+	"os"
+	"syscall"
+)
+
+var triggerSignals = []os.Signal{syscall.SIGUSR1}
+var reloadSignals = []os.Signal{syscall.SIGHUP}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package app
+
+import "os"
+
+// windows has no user signals, the daemon only runs by the schedule
+var triggerSignals = []os.Signal{}
+var reloadSignals = []os.Signal{}
@@ -0,0 +1,36 @@
+package app
+
+import (
+	"osssync/client"
+	"osssync/common/tracing"
+	"osssync/core"
+)
+
+// exit codes of the process
+const (
+	ExitSuccess = 0
+	// a run failed as a whole or every file failed, also an invalid config
+	ExitFailure = 1
+	// some files failed while the others were transferred
+	ExitPartialFailure = 2
+	// a file does not match its checksum
+	ExitVerificationFailed = 3
+	// the run was stopped by a signal or the api, 128 + SIGINT by the convention of the shells
+	ExitCanceled = 130
+)
+
+// ExitCode maps the error of a run to the exit code of the process
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitSuccess
+	case tracing.IsError(err, client.ErrVerificationFailed), tracing.IsError(err, core.ErrCRC64NotMatch):
+		return ExitVerificationFailed
+	case tracing.IsError(err, client.ErrCanceled):
+		return ExitCanceled
+	case tracing.IsError(err, client.ErrPartialFailure):
+		return ExitPartialFailure
+	default:
+		return ExitFailure
+	}
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"osssync/client"
+	"osssync/common/config"
+	"osssync/common/progress"
+	"osssync/common/tracing"
+	"osssync/core"
+	"text/tabwriter"
+	"time"
+)
+
+// historyLimit is the count of runs listed by the history operation
+const historyLimit = 20
+
+// printHistory lists the recent runs, of the job of -job if set, or the details of the run of -run
+func printHistory() error {
+	if runId := config.GetStringOrDefault(core.Arg_Run, ""); runId != "" {
+		return printRun(runId)
+	}
+	runs, err := client.History(config.GetStringOrDefault(core.Arg_Job, ""), historyLimit)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	if len(runs) == 0 {
+		fmt.Println("no run is recorded")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tJOB\tOPERATION\tSTART\tDURATION\tSTATUS\tTRANSFERRED\tSKIPPED\tFAILED\tBYTES")
+	for _, run := range runs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%s\n",
+			shortRunId(run.Id), run.Job, run.Operation, run.Start.Local().Format("2006-01-02 15:04:05"), runDuration(run),
+			run.Status, run.Transferred, run.Skipped, run.Failed, progress.FormatBytes(run.Bytes))
+	}
+	return w.Flush()
+}
+
+func printRun(runId string) error {
+	run, err := client.FindRun(runId)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	entries, err := client.RunJournal(run.Id)
+	if err != nil {
+		return tracing.Error(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Run:\t%s\n", run.Id)
+	fmt.Fprintf(w, "Job:\t%s %s\n", run.Job, run.Operation)
+	fmt.Fprintf(w, "Source:\t%s\n", run.Source)
+	fmt.Fprintf(w, "Dest:\t%s\n", run.Dest)
+	fmt.Fprintf(w, "Start:\t%s\n", run.Start.Local().Format(time.RFC3339))
+	fmt.Fprintf(w, "Duration:\t%s\n", runDuration(*run))
+	fmt.Fprintf(w, "Status:\t%s\n", run.Status)
+	if run.Error != "" {
+		fmt.Fprintf(w, "Error:\t%s\n", run.Error)
+	}
+	fmt.Fprintf(w, "Files:\t%d transferred, %d skipped, %d failed, %s\n", run.Transferred, run.Skipped, run.Failed, progress.FormatBytes(run.Bytes))
+	err = w.Flush()
+	if err != nil {
+		return tracing.Error(err)
+	}
+
+	failed := 0
+	for _, entry := range entries {
+		if client.IsFailedAction(entry.Action) {
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Println()
+		fmt.Println("Failed files:")
+		for _, entry := range entries {
+			if client.IsFailedAction(entry.Action) {
+				fmt.Printf("  %s: %s %s\n", entry.Path, entry.Action, entry.Error)
+			}
+		}
+	}
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tACTION\tBYTES\tDURATION\tPATH")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", entry.Time.Local().Format("15:04:05"), entry.Action,
+			progress.FormatBytes(entry.Bytes), time.Duration(entry.DurationMs)*time.Millisecond, entry.Path)
+	}
+	return w.Flush()
+}
+
+func shortRunId(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+func runDuration(run client.RunRecord) string {
+	if run.End.IsZero() {
+		return "-"
+	}
+	return run.End.Sub(run.Start).Round(time.Millisecond).String()
+}
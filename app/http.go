@@ -0,0 +1,43 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"osssync/common/config"
+	"osssync/common/logging"
+	"osssync/common/metrics"
+	"osssync/common/tracing"
+	"osssync/core"
+)
+
+// httpServed returns true if the http endpoints are served
+func httpServed() bool {
+	return config.GetStringOrDefault(core.Arg_HttpAddr, "") != ""
+}
+
+// serveHTTP starts the http endpoints when OSY_HTTP_ADDR is set: /metrics for Prometheus and the control api
+func serveHTTP() error {
+	if !httpServed() {
+		return nil
+	}
+	addr := config.GetStringOrDefault(core.Arg_HttpAddr, "")
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.DefaultRegistry.Handler())
+	registerAPI(mux)
+	if config.GetStringOrDefault(core.Arg_HttpToken, "") == "" {
+		logging.Warn(fmt.Sprintf("%s is not set, the http api is read only and not protected", core.Arg_HttpToken), nil)
+	}
+	go func() {
+		err := http.Serve(listener, mux)
+		if err != nil {
+			logging.Error(tracing.Error(err), nil)
+		}
+	}()
+	logging.Info(fmt.Sprintf("http endpoints listen on %s", listener.Addr()), nil)
+	return nil
+}
@@ -0,0 +1,25 @@
+package app
+
+import (
+	"osssync/common/metrics"
+)
+
+var (
+	lastSuccess = metrics.NewGaugeVec("osssync_last_success_timestamp_seconds",
+		"Unix time of the last successful run of a job.", "job")
+	jobRuns = metrics.NewCounterVec("osssync_job_runs_total",
+		"Runs of a job by result: success, failure or canceled.", "job", "result")
+	jobRunning = metrics.NewGaugeVec("osssync_job_running",
+		"1 while a job is running.", "job")
+	queueDepth = metrics.NewGaugeVec("osssync_queue_depth",
+		"Files found and not transferred yet by the running run of a job.", "job")
+)
+
+func init() {
+	metrics.DefaultRegistry.OnCollect(func() {
+		for _, r := range runningRuns() {
+			s := r.tracker.Snapshot()
+			queueDepth.WithLabelValues(r.job.Name).Set(float64(s.FilesTotal - s.FilesDone))
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"osssync/common/config"
+	"osssync/common/logging"
+	"osssync/common/progress"
+	"osssync/core"
+	"time"
+)
+
+const (
+	progressAuto = "auto"
+	progressBar  = "bar"
+	progressLog  = "log"
+	progressJSON = "json"
+	progressNone = "none"
+)
+
+const barWidth = 30
+const barInterval = 500 * time.Millisecond
+
+// progressMode returns the mode of OSY_PROGRESS, auto renders a bar on a terminal unless several jobs run at once
+func progressMode(concurrentJobs int) string {
+	mode := config.GetStringOrDefault(core.Arg_Progress, "")
+	if mode == "" || mode == progressAuto {
+		if concurrentJobs > 1 || !isTerminal(os.Stderr) {
+			return progressLog
+		}
+		return progressBar
+	}
+	return mode
+}
+
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+// reportProgress reports the progress of a job until the returned function is called
+func reportProgress(jobName string, mode string, tracker *progress.Tracker) func() {
+	interval := 10 * time.Second
+	if v := config.GetStringOrDefault(core.Arg_ProgressInterval, ""); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			interval = d
+		} else {
+			logging.Warn(fmt.Sprintf("invalid %s %q, use %s", core.Arg_ProgressInterval, v, interval), nil)
+		}
+	}
+	if mode == progressBar {
+		interval = barInterval
+	}
+
+	var last progress.Snapshot
+	report := func(final bool) {
+		s := tracker.Snapshot()
+		changed := s.FilesDone != last.FilesDone || s.BytesDone != last.BytesDone || s.FilesTotal != last.FilesTotal || s.FilesFailed != last.FilesFailed
+		last = s
+		switch mode {
+		case progressBar:
+			fmt.Fprintf(os.Stderr, "\r\x1b[K%s %s", jobName, s.Bar(barWidth))
+			if final {
+				fmt.Fprintln(os.Stderr)
+			}
+		case progressJSON:
+			if final {
+				fmt.Println(s.JSON("done", jobName))
+			} else if changed {
+				fmt.Println(s.JSON("progress", jobName))
+			}
+		case progressLog:
+			if final {
+				logging.Info(fmt.Sprintf("Job %s done: %s in %s", jobName, s, s.Elapsed.Round(time.Second)), nil)
+			} else if changed {
+				logging.Info(fmt.Sprintf("Job %s progress: %s", jobName, s), nil)
+			}
+		}
+	}
+
+	if mode == progressNone {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				report(true)
+				return
+			case <-ticker.C:
+				report(false)
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
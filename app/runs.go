@@ -0,0 +1,113 @@
+package app
+
+import (
+	"errors"
+	"osssync/client"
+	"osssync/common/progress"
+	"osssync/common/tracing"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrNotRunning error = errors.New("job is not running")
+
+// run is a run of a job, in progress or finished
+type run struct {
+	runId   string
+	job     *client.Job
+	tracker *progress.Tracker
+	start   time.Time
+	end     time.Time
+	err     error
+}
+
+var runsLock sync.Mutex
+
+// running are the runs in progress by job name, lastRuns the last finished run of each job
+var running = make(map[string]*run)
+var lastRuns = make(map[string]*run)
+
+// shuttingDown cancels the runs beginning once the process is stopping
+var shuttingDown bool
+
+func beginRun(runId string, job *client.Job, tracker *progress.Tracker) *run {
+	r := &run{runId: runId, job: job, tracker: tracker, start: time.Now()}
+	runsLock.Lock()
+	running[job.Name] = r
+	if shuttingDown {
+		job.Cancel()
+	}
+	runsLock.Unlock()
+	jobRunning.WithLabelValues(job.Name).Set(1)
+	return r
+}
+
+func (r *run) finish(err error) {
+	name := r.job.Name
+	runsLock.Lock()
+	r.end = time.Now()
+	r.err = err
+	if running[name] == r {
+		delete(running, name)
+	}
+	lastRuns[name] = r
+	runsLock.Unlock()
+
+	// the queue depth is only exposed while the job runs
+	queueDepth.Delete(name)
+	jobRunning.WithLabelValues(name).Set(0)
+	if tracing.IsError(err, client.ErrCanceled) {
+		jobRuns.WithLabelValues(name, resultCanceled).Inc()
+		return
+	}
+	if err != nil {
+		jobRuns.WithLabelValues(name, resultFailure).Inc()
+		return
+	}
+	jobRuns.WithLabelValues(name, resultSuccess).Inc()
+	lastSuccess.WithLabelValues(name).SetToCurrentTime()
+}
+
+// runningRuns returns the runs in progress ordered by job name
+func runningRuns() []*run {
+	runsLock.Lock()
+	defer runsLock.Unlock()
+	runs := make([]*run, 0, len(running))
+	for _, r := range running {
+		runs = append(runs, r)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].job.Name < runs[j].job.Name })
+	return runs
+}
+
+// findRuns returns the run in progress and the last finished run of a job, either may be nil
+func findRuns(name string) (*run, *run) {
+	runsLock.Lock()
+	defer runsLock.Unlock()
+	return running[name], lastRuns[name]
+}
+
+// cancelRun cancels the run in progress of a job
+func cancelRun(name string) error {
+	r, _ := findRuns(name)
+	if r == nil {
+		return tracing.Error(ErrNotRunning)
+	}
+	r.job.Cancel()
+	return nil
+}
+
+// cancelRuns cancels the runs in progress and the runs beginning later, abort also aborts their files in progress
+func cancelRuns(abort bool) {
+	runsLock.Lock()
+	defer runsLock.Unlock()
+	shuttingDown = true
+	for _, r := range running {
+		if abort {
+			r.job.Abort()
+		} else {
+			r.job.Cancel()
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"osssync/common/config"
+	"osssync/common/logging"
+	"osssync/core"
+	"syscall"
+)
+
+// stopSignals stop the process gracefully: the runs in progress start no new file, their files in progress
+// may finish within OSY_SHUTDOWN_TIMEOUT. A second signal aborts the files in progress at once,
+// the parts they uploaded to OSS are kept and the next run resumes their multipart uploads.
+var stopSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+
+// shutdown cancels the runs in progress after the first stop signal,
+// and aborts their files in progress if another stop signal is received before done is closed
+func shutdown(sig os.Signal, signals <-chan os.Signal, done <-chan struct{}) {
+	timeout := config.GetStringOrDefault(core.Arg_ShutdownTimeout, "")
+	if timeout == "" {
+		timeout = "30s"
+	}
+	logging.Info(fmt.Sprintf("received %s, waiting up to %s for the files in progress, send it again to abort them", sig, timeout), nil)
+	cancelRuns(false)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if containsSignal(stopSignals, sig) {
+					logging.Warn(fmt.Sprintf("received %s again, aborting the files in progress", sig), nil)
+					cancelRuns(true)
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// handleStopSignals shuts down the runs of the process on the stop signals until the returned func is called
+func handleStopSignals() func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, stopSignals...)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			shutdown(sig, signals, done)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -4,12 +4,16 @@ import (
 	"fmt"
 	"os"
 	"osssync/client"
+	"osssync/common/bandwidth"
 	"osssync/common/config"
 	"osssync/common/dataAccess/nosqlite"
 	"osssync/common/logging"
+	"osssync/common/progress"
 	"osssync/common/tracing"
 	"osssync/core"
 	"path/filepath"
+	"sync"
+	"time"
 )
 
 func Startup() error {
@@ -66,11 +70,50 @@ func Startup() error {
 	if err != nil {
 		return tracing.Error(err)
 	}
+	var retryDelay time.Duration
+	if v := config.GetStringOrDefault(core.Arg_RetryDelay, ""); v != "" {
+		retryDelay, err = time.ParseDuration(v)
+		if err != nil {
+			return tracing.Errorf(fmt.Sprintf("invalid %s", core.Arg_RetryDelay), err)
+		}
+	}
+	core.SetRetryPolicy(config.GetValueOrDefault(core.Arg_Retries, 0), retryDelay)
+	if v := config.GetStringOrDefault(core.Arg_Bandwidth, ""); v != "" {
+		schedule, err := bandwidth.Parse(v)
+		if err != nil {
+			return tracing.Errorf(fmt.Sprintf("invalid %s", core.Arg_Bandwidth), err)
+		}
+		core.SetBandwidth(schedule)
+		logging.Info(fmt.Sprintf("bandwidth: %s", schedule), nil)
+	}
+	if v := config.GetStringOrDefault(core.Arg_ShutdownTimeout, ""); v != "" {
+		if _, err := time.ParseDuration(v); err != nil {
+			return tracing.Errorf(fmt.Sprintf("invalid %s", core.Arg_ShutdownTimeout), err)
+		}
+	}
+	if v := config.GetStringOrDefault(core.Arg_StorageClass, ""); v != "" {
+		if _, err := core.ParseStorageClass(v); err != nil {
+			return tracing.Errorf(fmt.Sprintf("invalid %s", core.Arg_StorageClass), err)
+		}
+	}
+	if v := config.GetStringOrDefault(core.Arg_RestorePoll, ""); v != "" {
+		if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+			return fmt.Errorf("invalid %s %s, expect a positive duration", core.Arg_RestorePoll, v)
+		}
+	}
+	if v := config.GetValueOrDefault[float64](core.Arg_VerifySample, 0); v < 0 || v > 100 {
+		return fmt.Errorf("invalid %s %g, expect a percentage from 0 to 100", core.Arg_VerifySample, v)
+	}
+
+	err = serveHTTP()
+	if err != nil {
+		return tracing.Error(err)
+	}
 	return nil
 }
 
 func Run() error {
-	operation := config.RequireString(core.Arg_Operation)
+	operation := config.GetStringOrDefault(core.Arg_Operation, "")
 	if operation == "generateKey" {
 		_, err := core.PrintMnemonic()
 		if err != nil {
@@ -78,25 +121,181 @@ func Run() error {
 		}
 		return nil
 	}
+	if operation == "history" {
+		return printHistory()
+	}
 
-	sourcePath := config.RequireString(core.Arg_SourcePath)
-	destPath := config.GetStringOrDefault(core.Arg_DestPath, "")
+	jobs, err := selectJobs()
+	if err != nil {
+		return tracing.Error(err)
+	}
+	stop := handleStopSignals()
+	defer stop()
+	mode := progressMode(len(jobs))
+	if len(jobs) == 1 {
+		return runJobWithProgress(jobs[0], mode)
+	}
 
-	switch operation {
+	var wg sync.WaitGroup
+	errs := make([]error, len(jobs))
+	for i, job := range jobs {
+		i, job := i, job
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := runJobWithProgress(job, mode)
+			if err != nil {
+				errs[i] = err
+				logging.Error(tracing.Errorf(fmt.Sprintf("Job %s failed", job.Name), err), nil)
+			}
+		}()
+	}
+	wg.Wait()
+	return jobsError(errs)
+}
+
+// jobsError combines the errors of the jobs run together, the result is a verification failure if a job
+// failed its verification, a cancellation if a job was canceled, a total failure if every job failed totally,
+// otherwise a partial failure
+func jobsError(errs []error) error {
+	failed, total, canceled := 0, 0, 0
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		failed++
+		switch ExitCode(err) {
+		case ExitVerificationFailed:
+			return tracing.Errorf(fmt.Sprintf("%d of %d jobs failed", failed, len(errs)), client.ErrVerificationFailed)
+		case ExitCanceled:
+			canceled++
+		case ExitFailure:
+			total++
+		}
+	}
+	switch {
+	case failed == 0:
+		return nil
+	case canceled > 0:
+		return tracing.Errorf(fmt.Sprintf("%d of %d jobs canceled", canceled, len(errs)), client.ErrCanceled)
+	case total == len(errs):
+		return tracing.Errorf(fmt.Sprintf("all %d jobs failed", failed), client.ErrTotalFailure)
+	default:
+		return tracing.Errorf(fmt.Sprintf("%d of %d jobs failed", failed, len(errs)), client.ErrPartialFailure)
+	}
+}
+
+// RunJob executes the operation of a job
+func RunJob(job *client.Job) error {
+	switch job.Operation {
 
 	case "push":
-		return client.PushDir(sourcePath,
-			destPath,
-			config.RequireValue[bool](core.Arg_FullIndex))
+		return client.Push(job)
 
 	case "pull":
-		return client.Pull(sourcePath, destPath)
+		return client.Pull(job)
+
+	case "scrub":
+		return client.Scrub(job)
+
+	case "watch":
+		return client.Watch(job)
+
+	case "verify":
+		return client.Verify(job)
 
 	case "sync":
 		return fmt.Errorf("sync operation is not supported yet")
 
 	default:
-		return fmt.Errorf("unknown operation: %s", operation)
+		return fmt.Errorf("unknown operation: %s", job.Operation)
 	}
-	return nil
+}
+
+// runJobWithProgress runs a job and reports its progress, watch and scrub are not tracked
+func runJobWithProgress(job *client.Job, mode string) (err error) {
+	var tracker *progress.Tracker
+	if job.Operation == "push" || job.Operation == "pull" {
+		tracker = progress.NewTracker()
+	}
+	job.UseProgress(tracker)
+	journal, journalErr := client.StartRun(job)
+	if journalErr != nil {
+		logging.Warn(fmt.Sprintf("Run of job %s is not recorded to the history: %s", job.Name, tracing.Message(journalErr)), nil)
+	}
+	job.UseJournal(journal)
+	summary := client.NewSummary()
+	job.UseSummary(summary)
+	r := beginRun(journal.RunId(), job, tracker)
+	defer func() {
+		// the files failed by a run which otherwise succeeded make it a partial or total failure
+		if err == nil {
+			err = summary.Err()
+		}
+		logSummary(job, summary)
+		r.finish(err)
+		if journalErr := journal.Finish(err); journalErr != nil {
+			logging.Warn(fmt.Sprintf("Run of job %s is not saved to the history: %s", job.Name, tracing.Message(journalErr)), nil)
+		}
+	}()
+	if tracker == nil {
+		return RunJob(job)
+	}
+	stop := reportProgress(job.Name, mode, tracker)
+	defer stop()
+	return RunJob(job)
+}
+
+func logSummary(job *client.Job, summary *client.Summary) {
+	logging.Info(fmt.Sprintf("Job %s summary: %s", job.Name, summary), nil)
+	for _, failure := range summary.Failures() {
+		logging.Warn(fmt.Sprintf("Job %s failed file %s: %s", job.Name, failure.Path, tracing.Message(failure.Error)), nil)
+	}
+}
+
+// workers is the budget of workers shared by all running jobs
+var workers *client.Workers
+var workersOnce sync.Once
+
+// selectJobs returns the job named by -job, all the jobs of the config files with -all,
+// otherwise the job configured by the flags
+func selectJobs() ([]*client.Job, error) {
+	workersOnce.Do(func() {
+		n := config.GetValueOrDefault(core.Arg_Workers, 0)
+		if n <= 0 {
+			n = 8
+		}
+		workers = client.NewWorkers(n)
+	})
+
+	jobs := make([]*client.Job, 0)
+	if name := config.GetStringOrDefault(core.Arg_Job, ""); name != "" {
+		job, err := client.FindJob(name)
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		jobs = append(jobs, job)
+	} else if config.GetValueOrDefault(core.Arg_AllJobs, false) {
+		declared, err := client.LoadJobs()
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		if len(declared) == 0 {
+			return nil, fmt.Errorf("no job is declared in the config files")
+		}
+		for _, name := range client.SortedJobNames(declared) {
+			jobs = append(jobs, declared[name])
+		}
+	} else {
+		job := client.DefaultJob()
+		if job.Source == "" {
+			return nil, fmt.Errorf("source path is required")
+		}
+		jobs = append(jobs, job)
+	}
+
+	for _, job := range jobs {
+		job.UseWorkers(workers)
+	}
+	return jobs, nil
 }
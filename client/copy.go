@@ -0,0 +1,105 @@
+package client
+
+import (
+	"fmt"
+	"osssync/common/progress"
+	"osssync/common/tracing"
+	"osssync/core"
+)
+
+// CopyObject copies an object of srcPath to dstPath within the object storage, the content does not go through the host.
+// The object is copied as it is, a job encrypting its files does not encrypt it again,
+// an archived object is restored first.
+// ErrUpToDate is returned when the destination has the same content already.
+func CopyObject(job *Job, srcPath string, dstPath string, relativePath string, counter *progress.File) error {
+	ctx := job.Context()
+	srcFile, err := core.OpenFile(ctx, srcPath, relativePath, job.SourceCredentials)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	defer srcFile.Close()
+	exists, err := srcFile.Exists(ctx)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	if !exists {
+		return fmt.Errorf("object %s does not exist", core.JoinUri(srcPath, relativePath))
+	}
+
+	destFile, err := core.OpenFile(ctx, dstPath, relativePath, job.Credentials)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	defer destFile.Close()
+	copier, ok := destFile.(core.Copier)
+	if !ok {
+		return tracing.Errorf(fmt.Sprintf("%s can't copy from %s", dstPath, srcPath), core.ErrCopyNotSupported)
+	}
+	destExists, err := destFile.Exists(ctx)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	if destExists {
+		srcCrc64, err := srcFile.CRC64(ctx)
+		if err != nil {
+			return tracing.Error(err)
+		}
+		destCrc64, err := destFile.CRC64(ctx)
+		if err != nil {
+			return tracing.Error(err)
+		}
+		if srcCrc64 != 0 && srcCrc64 == destCrc64 {
+			return tracing.Error(ErrUpToDate)
+		}
+	}
+
+	err = waitReadable(job, srcFile)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	if class := job.storageClassOf(relativePath, modTime(srcFile)); class != "" {
+		if tiered, ok := destFile.(core.Tiered); ok {
+			tiered.SetStorageClass(class)
+		}
+	}
+	err = copier.CopyFrom(ctx, srcFile)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	copiedBytes.WithLabelValues(job.Name).Add(float64(srcFile.Size()))
+	counter.Add(srcFile.Size())
+	transferredObjects.WithLabelValues(job.Name).Inc()
+	return nil
+}
+
+// removeMoved deletes the source of a moved object once the destination is checked to have its content
+func removeMoved(job *Job, srcPath string, dstPath string, relativePath string) error {
+	ctx := job.Context()
+	srcFile, err := core.OpenFile(ctx, srcPath, relativePath, job.SourceCredentials)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	defer srcFile.Close()
+	destFile, err := core.OpenFile(ctx, dstPath, relativePath, job.Credentials)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	defer destFile.Close()
+	srcCrc64, err := srcFile.CRC64(ctx)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	destCrc64, err := destFile.CRC64(ctx)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	if srcCrc64 == 0 || srcCrc64 != destCrc64 || srcFile.Size() != destFile.Size() {
+		return tracing.Errorf(fmt.Sprintf("%s is not moved, CRC64 of the copy %d does not match the source %d",
+			core.JoinUri(srcPath, relativePath), destCrc64, srcCrc64), core.ErrCRC64NotMatch)
+	}
+	err = srcFile.Remove(ctx)
+	if err != nil {
+		return tracing.Errorf(fmt.Sprintf("failed to remove the moved object %s", core.JoinUri(srcPath, relativePath)), err)
+	}
+	return nil
+}
@@ -1,14 +1,14 @@
 package client
 
 import (
+	"context"
 	"fmt"
-	"os"
 	"osssync/common/logging"
 	"osssync/common/tracing"
 	"osssync/core"
 )
 
-func CheckCRC64(src core.FileInfo, dest core.FileInfo) error {
+func CheckCRC64(ctx context.Context, src core.FileInfo, dest core.FileInfo) error {
 	if _, ok := src.(core.CryptoFileInfo); ok {
 		logging.Info(fmt.Sprintf("File %s is encrypted, skip CRC64 check", src.Name()), nil)
 		return nil
@@ -17,19 +17,18 @@ func CheckCRC64(src core.FileInfo, dest core.FileInfo) error {
 		logging.Info(fmt.Sprintf("File %s is encrypted, skip CRC64 check", src.Name()), nil)
 		return nil
 	}
-	srcCrc, err := src.CRC64()
+	srcCrc, err := src.CRC64(ctx)
 	if err != nil {
 		return tracing.Error(err)
 	}
 
-	destCrc, err := dest.CRC64()
+	destCrc, err := dest.CRC64(ctx)
 	if err != nil {
 		return tracing.Error(err)
 	}
 
 	if srcCrc != destCrc {
-		logging.Error(fmt.Errorf("CRC64 check failed, src: %d, dest: %d", srcCrc, destCrc), nil)
-		os.Exit(-1)
+		return tracing.Errorf(fmt.Sprintf("CRC64 check of %s failed, src: %d, dest: %d", src.Name(), srcCrc, destCrc), core.ErrCRC64NotMatch)
 	}
 
 	logging.Debug(fmt.Sprintf("CRC64 check passed: %s", src.Name()), nil)
@@ -1,13 +1,14 @@
 package client
 
 import (
-	"fmt"
 	"hash/crc64"
 	"osssync/common/dataAccess/nosqlite"
-	"osssync/common/logging"
+	"osssync/common/tracing"
 	"osssync/core"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func FindFileIndex(fileInfo core.FileInfo) (*ObjectIndexModel, error) {
@@ -73,7 +74,52 @@ func SetIndexModel(src core.FileInfo, dest core.FileInfo, crc64 uint64) error {
 			V: dest.FileType(),
 		})
 	if err != nil {
-		logging.Warn(fmt.Sprintf("failed to set file index: %s", err.Error()), nil)
+		return tracing.Error(err)
+	}
+	return nil
+}
+
+// ETagModel is the ETag of a file of a remote file system once it is written from a source of a size and a modification time
+type ETagModel struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	ETag string `json:"etag"`
+}
+
+func (e ETagModel) ID() string {
+	return e.Id
+}
+
+func (ETagModel) TableName() string {
+	return "object_etag"
+}
+
+func computeETagName(dest core.FileInfo, size int64, modTime time.Time) string {
+	return ComputeIndexName(dest.FileType(), path.Join(dest.Path(), dest.Name()), strconv.FormatInt(size, 10), strconv.FormatInt(modTime.Unix(), 10))
+}
+
+// FindETag returns the ETag recorded by SetETag for dest written from a source of size and modTime, empty if none
+func FindETag(dest core.FileInfo, size int64, modTime time.Time) (string, error) {
+	etagModel, err := nosqlite.Get[ETagModel](computeETagName(dest, size, modTime))
+	if err == nosqlite.ErrRecordNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", tracing.Error(err)
+	}
+	return etagModel.ETag, nil
+}
+
+// SetETag records the ETag of dest written from a source of size and modTime, nothing is recorded for a file without ETag
+func SetETag(dest core.FileInfo, size int64, modTime time.Time) error {
+	etag := dest.Properties()[core.PropertyName_ContentETag]
+	if etag == "" {
+		return nil
+	}
+	name := computeETagName(dest, size, modTime)
+	err := nosqlite.Set(name, ETagModel{Id: nosqlite.GenerateUUID(), Name: name, ETag: etag})
+	if err != nil {
+		return tracing.Error(err)
 	}
 	return nil
 }
@@ -0,0 +1,338 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"osssync/common/config"
+	"osssync/common/dataAccess/nosqlite"
+	"osssync/common/logging"
+	"osssync/common/tracing"
+	"osssync/core"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrRunNotFound error = errors.New("run not found")
+
+// status of a run
+const (
+	RunRunning  = "running"
+	RunSuccess  = "success"
+	RunFailure  = "failure"
+	RunCanceled = "canceled"
+)
+
+// action of a file in the journal
+const (
+	ActionTransferred = "transferred"
+	ActionUpToDate    = "up_to_date"
+	ActionSynced      = "synced"
+	ActionFailed      = "failed"
+
+	// results of the verify operation
+	ActionVerified  = "verified"
+	ActionMissing   = "missing"
+	ActionStale     = "stale"
+	ActionCorrupted = "corrupted"
+	ActionExtra     = "extra"
+)
+
+// IsFailedAction returns true for the actions counted as failed files: the errors and the problems found by verify
+func IsFailedAction(action string) bool {
+	switch action {
+	case ActionFailed, ActionMissing, ActionStale, ActionCorrupted:
+		return true
+	}
+	return false
+}
+
+// RunRecord is a run of a job in the history
+type RunRecord struct {
+	Id        string    `json:"id"`
+	Job       string    `json:"job"`
+	Operation string    `json:"operation"`
+	Source    string    `json:"source"`
+	Dest      string    `json:"dest"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+
+	Transferred int64 `json:"transferred"`
+	Skipped     int64 `json:"skipped"`
+	Failed      int64 `json:"failed"`
+	Bytes       int64 `json:"bytes"`
+}
+
+func (e RunRecord) ID() string {
+	return e.Id
+}
+
+func (RunRecord) TableName() string {
+	return "run_history"
+}
+
+// JournalEntry is what a run did to a file
+type JournalEntry struct {
+	Id         string    `json:"id"`
+	RunId      string    `json:"run_id"`
+	Path       string    `json:"path"`
+	Action     string    `json:"action"`
+	Bytes      int64     `json:"bytes"`
+	DurationMs int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+func (e JournalEntry) ID() string {
+	return e.Id
+}
+
+func (JournalEntry) TableName() string {
+	return "run_journal"
+}
+
+// Journal records a run and what it does to each file, a nil Journal records nothing.
+// Entries are written in background so that the transfers don't wait for the database.
+type Journal struct {
+	record RunRecord
+	// lock guards the counters of the record
+	lock sync.Mutex
+	// closing is held for reading while an entry is sent and for writing to close the entries,
+	// the transfers wait for the writer of the entries but not for each other
+	closing sync.RWMutex
+	closed  bool
+	entries chan JournalEntry
+	done    chan struct{}
+}
+
+// StartRun adds a running run of job to the history, Finish must be called at the end of the run
+func StartRun(job *Job) (*Journal, error) {
+	journal := &Journal{
+		record: RunRecord{
+			Id:        nosqlite.GenerateUUID(),
+			Job:       job.Name,
+			Operation: job.Operation,
+			Source:    job.Source,
+			Dest:      job.Dest,
+			Start:     time.Now(),
+			Status:    RunRunning,
+		},
+		entries: make(chan JournalEntry, 1024),
+		done:    make(chan struct{}),
+	}
+	err := saveRun(journal.record)
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	go journal.write()
+	return journal, nil
+}
+
+func (journal *Journal) RunId() string {
+	if journal == nil {
+		return ""
+	}
+	return journal.record.Id
+}
+
+// Record adds the action on the file of relativePath to the journal, bytes are the transferred bytes
+func (journal *Journal) Record(relativePath string, action string, bytes int64, duration time.Duration, err error) {
+	if journal == nil {
+		return
+	}
+	entry := JournalEntry{
+		Id:         nosqlite.GenerateUUID(),
+		RunId:      journal.record.Id,
+		Path:       relativePath,
+		Action:     action,
+		Bytes:      bytes,
+		DurationMs: duration.Milliseconds(),
+		Time:       time.Now(),
+	}
+	if err != nil {
+		entry.Error = tracing.Message(err)
+	}
+
+	journal.closing.RLock()
+	defer journal.closing.RUnlock()
+	if journal.closed {
+		return
+	}
+	journal.lock.Lock()
+	switch {
+	case action == ActionTransferred:
+		journal.record.Transferred++
+		journal.record.Bytes += bytes
+	case IsFailedAction(action):
+		journal.record.Failed++
+	default:
+		journal.record.Skipped++
+	}
+	journal.lock.Unlock()
+	journal.entries <- entry
+}
+
+// journalBatchSize is the max count of entries written by a transaction
+const journalBatchSize = 256
+
+// write writes the entries queued by a transaction
+func (journal *Journal) write() {
+	defer close(journal.done)
+	batch := make([]nosqlite.Item[JournalEntry], 0, journalBatchSize)
+	for entry := range journal.entries {
+		batch = append(batch[:0], journalItem(entry))
+	queued:
+		for len(batch) < journalBatchSize {
+			select {
+			case entry, ok := <-journal.entries:
+				if !ok {
+					break queued
+				}
+				batch = append(batch, journalItem(entry))
+			default:
+				break queued
+			}
+		}
+		err := nosqlite.SetMany(batch)
+		if err != nil {
+			logging.Warn(fmt.Sprintf("failed to journal %d files from %s: %s", len(batch), batch[0].Data.Path, tracing.Message(err)), nil)
+		}
+	}
+}
+
+func journalItem(entry JournalEntry) nosqlite.Item[JournalEntry] {
+	return nosqlite.Item[JournalEntry]{Name: entry.Id, Data: entry, Indexes: []nosqlite.KV{{K: "run", V: entry.RunId}}}
+}
+
+// Finish saves the result of the run once its entries are written,
+// the oldest runs of the job beyond OSY_HISTORY_KEEP are removed with their journal
+func (journal *Journal) Finish(runErr error) error {
+	if journal == nil {
+		return nil
+	}
+	journal.closing.Lock()
+	if journal.closed {
+		journal.closing.Unlock()
+		return nil
+	}
+	journal.closed = true
+	close(journal.entries)
+	journal.closing.Unlock()
+	<-journal.done
+
+	record := journal.record
+	record.End = time.Now()
+	switch {
+	case runErr == nil:
+		record.Status = RunSuccess
+	case tracing.IsError(runErr, ErrCanceled):
+		record.Status = RunCanceled
+	default:
+		record.Status = RunFailure
+		record.Error = tracing.Message(runErr)
+	}
+	err := saveRun(record)
+	if err != nil {
+		return tracing.Error(err)
+	}
+
+	keep := config.GetValueOrDefault(core.Arg_HistoryKeep, 0)
+	if keep <= 0 {
+		keep = 100
+	}
+	err = pruneHistory(record.Job, keep)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	return nil
+}
+
+func saveRun(record RunRecord) error {
+	err := nosqlite.Set(record.Id, record, nosqlite.KV{K: "job", V: record.Job})
+	if err != nil {
+		return tracing.Error(err)
+	}
+	return nil
+}
+
+func pruneHistory(jobName string, keep int) error {
+	runs, err := History(jobName, 0)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	for i := keep; i < len(runs); i++ {
+		err = nosqlite.RemoveByIndex[JournalEntry](nosqlite.KV{K: "run", V: runs[i].Id})
+		if err != nil {
+			return tracing.Error(err)
+		}
+		err = nosqlite.Remove[RunRecord](runs[i].Id)
+		if err != nil {
+			return tracing.Error(err)
+		}
+	}
+	return nil
+}
+
+// History returns the runs newest first, only the runs of jobName if not empty, at most limit runs if limit > 0
+func History(jobName string, limit int) ([]RunRecord, error) {
+	var runs []RunRecord
+	if jobName != "" {
+		found, err := nosqlite.GetByIndex[RunRecord](nosqlite.KV{K: "job", V: jobName})
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		runs = found
+	} else {
+		all, err := nosqlite.GetAll[RunRecord]()
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		for _, run := range all {
+			runs = append(runs, run)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Start.After(runs[j].Start) })
+	if limit > 0 && len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return runs, nil
+}
+
+// FindRun returns the run whose id starts with prefix, the prefix must be unique
+func FindRun(prefix string) (*RunRecord, error) {
+	if prefix == "" {
+		return nil, tracing.Error(ErrRunNotFound)
+	}
+	all, err := nosqlite.GetAll[RunRecord]()
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	var found *RunRecord
+	for id, run := range all {
+		if !strings.HasPrefix(id, prefix) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("run id %s is ambiguous", prefix)
+		}
+		run := run
+		found = &run
+	}
+	if found == nil {
+		return nil, tracing.Errorf(fmt.Sprintf("run %s not found", prefix), ErrRunNotFound)
+	}
+	return found, nil
+}
+
+// RunJournal returns the journal of a run in the order the files finished
+func RunJournal(runId string) ([]JournalEntry, error) {
+	entries, err := nosqlite.GetByIndex[JournalEntry](nosqlite.KV{K: "run", V: runId})
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries, nil
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"osssync/common/config"
+	"osssync/common/dataAccess/nosqlite"
+	"osssync/core"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	err := nosqlite.Init(fmt.Sprintf("file:%s?cache=shared", filepath.Join(t.TempDir(), "osssync.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.AttachValue(core.Arg_HistoryKeep, 5)
+	job := &Job{Name: "photos", Operation: "push", Source: "/data/photos", Dest: "/backup/photos"}
+
+	var runIds []string
+	for i := 0; i < 3; i++ {
+		journal, err := StartRun(job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		runIds = append(runIds, journal.RunId())
+		journal.Record("/a.jpg", ActionTransferred, 100, time.Second, nil)
+		journal.Record("/b.jpg", ActionUpToDate, 0, time.Millisecond, nil)
+		journal.Record("/c.jpg", ActionFailed, 0, time.Millisecond, errors.New("permission denied"))
+		if err := journal.Finish(errors.New("1 file failed")); err != nil {
+			t.Fatal(err)
+		}
+		// the record after the end of the run is ignored
+		journal.Record("/d.jpg", ActionTransferred, 100, time.Second, nil)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := pruneHistory(job.Name, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := History(job.Name, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].Id != runIds[2] || runs[1].Id != runIds[1] {
+		t.Fatalf("unexpected runs %+v", runs)
+	}
+	run := runs[0]
+	if run.Status != RunFailure || run.Error != "1 file failed" || run.Transferred != 1 || run.Skipped != 1 || run.Failed != 1 || run.Bytes != 100 || run.End.IsZero() {
+		t.Fatalf("unexpected run %+v", run)
+	}
+
+	found, err := FindRun(run.Id[:8])
+	if err != nil || found.Id != run.Id {
+		t.Fatalf("run not found by prefix: %v", err)
+	}
+	entries, err := RunJournal(run.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].Path != "/a.jpg" || entries[2].Action != ActionFailed || entries[2].Error != "permission denied" {
+		t.Fatalf("unexpected journal %+v", entries)
+	}
+	if entries, _ := RunJournal(runIds[0]); len(entries) != 0 {
+		t.Fatalf("journal of the pruned run is kept: %+v", entries)
+	}
+}
+
+func TestJournalConcurrentRecords(t *testing.T) {
+	err := nosqlite.Init(fmt.Sprintf("file:%s?cache=shared", filepath.Join(t.TempDir(), "osssync.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.AttachValue(core.Arg_HistoryKeep, 5)
+	journal, err := StartRun(&Job{Name: "videos", Operation: "push"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// more entries than the queue holds, recorded by concurrent transfers
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 400; i++ {
+				journal.Record(fmt.Sprintf("/%d/%d.mp4", worker, i), ActionTransferred, 1, time.Millisecond, nil)
+			}
+		}(worker)
+	}
+	wg.Wait()
+	if err := journal.Finish(nil); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := RunJournal(journal.RunId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, err := FindRun(journal.RunId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3200 || run.Transferred != 3200 || run.Bytes != 3200 {
+		t.Fatalf("unexpected %d entries of run %+v", len(entries), run)
+	}
+}
@@ -1,35 +1,54 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
-	"osssync/common/config"
 	"osssync/common/logging"
+	"osssync/common/metrics"
+	"osssync/common/progress"
 	"osssync/common/tracing"
 	"osssync/core"
-	"strings"
+	"time"
 )
 
-func TransferFile(srcPath string, dstPath string, relativePath string) error {
+// TransferFile copies a file from srcPath to dstPath, the written bytes are counted by counter which may be nil.
+// ErrUpToDate is returned when the destination has the same content already.
+// An object copied to the same object storage is copied by the storage itself,
+// or downloaded when the storage can't copy it, e.g. from another region. A moved object is then deleted.
+// A file keeps the permissions and the modification time of its source on the destinations which are file systems,
+// the ETag of a file of a server which doesn't keep the modification time is recorded to tell it is unchanged.
+func TransferFile(job *Job, srcPath string, dstPath string, relativePath string, counter *progress.File) error {
+	if srcType := core.ResolveUriType(srcPath); srcType != core.FileType_Physical {
+		if srcType == core.FileType_AliOSS && core.ResolveUriType(dstPath) == core.FileType_AliOSS {
+			err := CopyObject(job, srcPath, dstPath, relativePath, counter)
+			if tracing.IsError(err, core.ErrCopyNotSupported) {
+				logging.Debug(fmt.Sprintf("%s is downloaded: %s", relativePath, tracing.Message(err)), nil)
+				err = DownloadFile(job, srcPath, dstPath, relativePath, counter)
+			}
+			if enabled(job.Move) && (err == nil || tracing.IsError(err, ErrUpToDate)) {
+				if moveErr := removeMoved(job, srcPath, dstPath, relativePath); moveErr != nil {
+					return tracing.Error(moveErr)
+				}
+			}
+			return err
+		}
+		return DownloadFile(job, srcPath, dstPath, relativePath, counter)
+	}
+	ctx := job.Context()
 	srcStat, err := os.Stat(core.JoinUri(srcPath, relativePath))
 	if err != nil {
 		return tracing.Error(err)
 	}
 
 	fileSize := srcStat.Size()
-	chunkSizeMb := int64(config.GetValueOrDefault[float64](core.Arg_ChunkSizeMb, 5))
-	if chunkSizeMb <= 0 {
-		chunkSizeMb = 5
-	}
-	chunkSize := chunkSizeMb * 1024 * 1024
+	chunkSize := job.ChunkSizeMb * 1024 * 1024
 	var srcReader io.Reader
-	destRelativePath := relativePath
+	destRelativePath := job.destRelativePath(relativePath)
 	var destCrc64 uint64
-	if config.GetValueOrDefault(core.Arg_Zip, false) {
-		if !strings.HasSuffix(relativePath, ".crypto") {
-			destRelativePath = core.JoinUri(relativePath, ".crypto")
-		}
+	if enabled(job.Zip) {
 		destCrc64 = core.GetCrytoFileCrc64(core.JoinUri(dstPath, destRelativePath))
 	}
 
@@ -39,49 +58,72 @@ func TransferFile(srcPath string, dstPath string, relativePath string) error {
 	}
 
 	if srcCrc64 == destCrc64 {
-		logging.Info(fmt.Sprintf("%s:%s is up to date", srcPath, relativePath), nil)
-		return nil
+		return tracing.Error(ErrUpToDate)
 	}
 
-	destFile, err := core.GetFile(dstPath, destRelativePath)
+	destFile, err := core.OpenFile(ctx, dstPath, destRelativePath, job.Credentials)
 	if err != nil {
 		return tracing.Error(err)
 	}
 	defer destFile.Close()
-	destExists, err := destFile.Exists()
+	destExists, err := destFile.Exists(ctx)
 	if err != nil {
 		return tracing.Error(err)
 	}
-	if destExists {
-		destCrc64, err = destFile.CRC64()
+	byModTime := !enabled(job.Zip) && core.ResolveUriType(dstPath).ComparesByModTime()
+	if destExists && byModTime {
+		if unchanged(destFile, fileSize, srcStat.ModTime()) {
+			return tracing.Error(ErrUpToDate)
+		}
+		// the file of a server which doesn't keep the modification time is unchanged while it has the ETag it was written with
+		if etag := destFile.Properties()[core.PropertyName_ContentETag]; etag != "" {
+			written, err := FindETag(destFile, fileSize, srcStat.ModTime())
+			if err != nil {
+				return tracing.Error(err)
+			}
+			if written == etag {
+				return tracing.Error(ErrUpToDate)
+			}
+		}
+	} else if destExists {
+		destCrc64, err = destFile.CRC64(ctx)
 		if err != nil {
 			return tracing.Error(err)
 		}
 	}
 	if srcCrc64 == destCrc64 {
-		logging.Info(fmt.Sprintf("%s:%s is up to date", srcPath, relativePath), nil)
-		return nil
+		return tracing.Error(ErrUpToDate)
 	} else if destExists {
-		err = destFile.Remove()
+		err = destFile.Remove(ctx)
 		if err != nil {
 			return tracing.Error(err)
 		}
 		destFile.Close()
-		destFile, err = core.GetFile(dstPath, destRelativePath)
+		destFile, err = core.OpenFile(ctx, dstPath, destRelativePath, job.Credentials)
 		if err != nil {
 			return tracing.Error(err)
 		}
 	}
+	if attributed, ok := destFile.(core.Attributed); ok {
+		attributed.SetAttributes(srcStat.Mode().Perm(), srcStat.ModTime())
+	}
+	if class := job.storageClassOf(relativePath, srcStat.ModTime()); class != "" {
+		if tiered, ok := destFile.(core.Tiered); ok {
+			tiered.SetStorageClass(class)
+		}
+	}
 
-	if config.GetValueOrDefault(core.Arg_Zip, false) {
-		pwd := config.RequireString(core.Arg_Password)
-		seed := core.GetPasswordSeed(pwd)
+	if enabled(job.Zip) {
+		if job.Password == "" {
+			return fmt.Errorf("password of job %s is required to encrypt files", job.Name)
+		}
+		seed := core.GetPasswordSeed(job.Password)
 		pk, err := core.GenerateRsaKey(seed)
 		if err != nil {
 			return tracing.Error(err)
 		}
 
-		cryptoFilePath, err := core.EncryptFile(srcPath, config.RequireString(core.Arg_TmpDir), relativePath, &pk.PublicKey, srcCrc64)
+		cryptoFilePath, err := core.EncryptFile(srcPath, job.TmpDir, relativePath, &pk.PublicKey, srcCrc64)
 		if err != nil {
 			return tracing.Error(err)
 		}
@@ -93,7 +135,7 @@ func TransferFile(srcPath string, dstPath string, relativePath string) error {
 		defer cryptoFile.Close()
 		srcReader = cryptoFile
 	} else {
-		srcFile, err := core.GetFile(srcPath, relativePath)
+		srcFile, err := core.OpenFile(ctx, srcPath, relativePath, job.Credentials)
 		if err != nil {
 			return tracing.Error(err)
 		}
@@ -101,26 +143,181 @@ func TransferFile(srcPath string, dstPath string, relativePath string) error {
 		srcReader = srcFile.Reader()
 	}
 
-	destWriter := destFile.Writer()
+	destWriter := counter.Writer(&meteredWriter{w: destFile.Writer(), bytes: transferredBytes.WithLabelValues(job.Name)})
 	if chunkSize > fileSize {
-		_, err = CopyFile(destWriter, srcReader)
+		_, err = CopyFile(destWriter, &contextReader{ctx: ctx, r: srcReader})
 		if err != nil {
 			return tracing.Error(err)
 		}
 	} else {
-		err = destFile.WalkChunk(srcReader, chunkSize, fileSize, destFile.WriteChunk)
+		err = destFile.WalkChunk(ctx, srcReader, chunkSize, fileSize, countChunks(job, destFile.WriteChunk, counter))
 		if err != nil {
 			return tracing.Error(err)
 		}
 	}
-	err = destFile.Flush()
+	err = destFile.Flush(ctx)
 	if err != nil {
 		return tracing.Error(err)
 	}
+	if byModTime && !unchanged(destFile, fileSize, srcStat.ModTime()) {
+		err = SetETag(destFile, fileSize, srcStat.ModTime())
+		if err != nil {
+			return tracing.Error(err)
+		}
+	}
+	transferredObjects.WithLabelValues(job.Name).Inc()
+
+	return nil
+}
+
+// DownloadFile copies an object of srcPath to dstPath, an archived object is restored first.
+// The object is copied as it is, an encrypted object stays encrypted.
+// ErrUpToDate is returned when the destination has the same content already.
+// A file of a remote file system is compared by its size and modification time, which the destination keeps.
+// The user metadata and the content headers of an object are kept, a file larger than the chunk size is written by chunks.
+func DownloadFile(job *Job, srcPath string, dstPath string, relativePath string, counter *progress.File) error {
+	ctx := job.Context()
+	srcFile, err := core.OpenFile(ctx, srcPath, relativePath, job.SourceCredentials)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	defer srcFile.Close()
+	var srcMode fs.FileMode
+	var srcModTime time.Time
+	byModTime := core.ResolveUriType(srcPath).ComparesByModTime()
+	if attributed, ok := srcFile.(core.Attributed); ok && byModTime {
+		srcMode, srcModTime = attributed.Attributes()
+	}
+	var srcCrc64 uint64
+	if !byModTime {
+		srcCrc64, err = srcFile.CRC64(ctx)
+		if err != nil {
+			return tracing.Error(err)
+		}
+	}
+
+	destFile, err := core.OpenFile(ctx, dstPath, relativePath, job.Credentials)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	defer destFile.Close()
+	destExists, err := destFile.Exists(ctx)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	if destExists {
+		if byModTime && unchanged(destFile, srcFile.Size(), srcModTime) {
+			return tracing.Error(ErrUpToDate)
+		}
+		if !byModTime {
+			destCrc64, err := destFile.CRC64(ctx)
+			if err != nil {
+				return tracing.Error(err)
+			}
+			if srcCrc64 != 0 && srcCrc64 == destCrc64 {
+				return tracing.Error(ErrUpToDate)
+			}
+		}
+		err = destFile.Remove(ctx)
+		if err != nil {
+			return tracing.Error(err)
+		}
+		destFile.Close()
+		destFile, err = core.OpenFile(ctx, dstPath, relativePath, job.Credentials)
+		if err != nil {
+			return tracing.Error(err)
+		}
+	}
 
+	if attributed, ok := destFile.(core.Attributed); ok && byModTime {
+		attributed.SetAttributes(srcMode, srcModTime)
+	}
+	if class := job.storageClassOf(relativePath, modTime(srcFile)); class != "" {
+		if tiered, ok := destFile.(core.Tiered); ok {
+			tiered.SetStorageClass(class)
+		}
+	}
+	if src, ok := srcFile.(core.Annotated); ok {
+		if dest, ok := destFile.(core.Annotated); ok {
+			dest.SetMetadata(src.Metadata())
+		}
+	}
+
+	err = waitReadable(job, srcFile)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	srcReader, err := core.OpenReader(ctx, srcFile)
+	if err != nil {
+		return tracing.Errorf("failed to download", err)
+	}
+	defer srcReader.Close()
+	// a large object is written by chunks instead of being buffered whole by an object storage
+	if chunkSize := job.ChunkSizeMb * 1024 * 1024; srcFile.Size() > chunkSize {
+		err = destFile.WalkChunk(ctx, srcReader, chunkSize, srcFile.Size(), countChunks(job, destFile.WriteChunk, counter))
+	} else {
+		destWriter := counter.Writer(&meteredWriter{w: destFile.Writer(), bytes: transferredBytes.WithLabelValues(job.Name)})
+		_, err = CopyFile(destWriter, &contextReader{ctx: ctx, r: srcReader})
+	}
+	if err != nil {
+		return tracing.Error(err)
+	}
+	err = destFile.Flush(ctx)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	transferredObjects.WithLabelValues(job.Name).Inc()
 	return nil
 }
 
+// unchanged returns true if file has the size and the modification time, to the second, of its source
+func unchanged(file core.FileInfo, size int64, modTime time.Time) bool {
+	attributed, ok := file.(core.Attributed)
+	if !ok || modTime.IsZero() || file.Size() != size {
+		return false
+	}
+	_, fileModTime := attributed.Attributes()
+	return fileModTime.Unix() == modTime.Unix()
+}
+
+// countChunks counts the bytes and the latency of every chunk written by writer
+func countChunks(job *Job, writer core.FileChunkWriter, counter *progress.File) core.FileChunkWriter {
+	bytes := transferredBytes.WithLabelValues(job.Name)
+	latency := partUploadSeconds.WithLabelValues(job.Name)
+	return func(ctx context.Context, content []byte, chunk *core.FileChunkInfo) (n int, err error) {
+		start := time.Now()
+		n, err = writer(ctx, content, chunk)
+		latency.ObserveSince(start)
+		bytes.Add(float64(n))
+		counter.Add(int64(n))
+		return n, err
+	}
+}
+
+// contextReader stops reading once ctx is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (reader *contextReader) Read(p []byte) (int, error) {
+	if err := reader.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return reader.r.Read(p)
+}
+
+type meteredWriter struct {
+	w     io.Writer
+	bytes *metrics.Value
+}
+
+func (writer *meteredWriter) Write(p []byte) (int, error) {
+	n, err := writer.w.Write(p)
+	writer.bytes.Add(float64(n))
+	return n, err
+}
+
 func WriteZip(bufferSize int64, reader io.Reader, zip *core.ZipFileInfo) (n int, err error) {
 	var eof bool
 	var nv int
@@ -0,0 +1,354 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"osssync/common/config"
+	"osssync/common/progress"
+	"osssync/common/tracing"
+	"osssync/core"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrJobNotFound error = errors.New("job not found")
+var ErrCanceled error = errors.New("run canceled")
+
+const jobsConfigKey = "jobs"
+
+const cryptoSuffix = ".crypto"
+
+// Job is a named pair of source and destination with its own settings, declared in the config file:
+//
+//	jobs:
+//	  photos:
+//	    source: /data/photos
+//	    dest: oss://bucket/photos
+//	    credentials: /config/credential.yaml
+//	    exclude: ["*.tmp", "cache"]
+//	    schedule: "0 3 * * *"
+//	    concurrency: 4
+//	    storageClass: IA
+//
+// Settings a job leaves empty fall back to the flags and OSY_* env vars.
+type Job struct {
+	Name        string   `yaml:"-"`
+	Operation   string   `yaml:"operation"`
+	Source      string   `yaml:"source"`
+	Dest        string   `yaml:"dest"`
+	Credentials string   `yaml:"credentials"`
+	Include     []string `yaml:"include"`
+	Exclude     []string `yaml:"exclude"`
+	Zip         *bool    `yaml:"zip"`
+	Password    string   `yaml:"password"`
+	ChunkSizeMb int64    `yaml:"chunkSizeMb"`
+	FullIndex   *bool    `yaml:"fullIndex"`
+	Schedule    string   `yaml:"schedule"`
+	Concurrency int      `yaml:"concurrency"`
+	TmpDir      string   `yaml:"-"`
+	// StorageClass is the storage class of the uploaded objects, the default of the bucket if empty
+	StorageClass string        `yaml:"storageClass"`
+	StorageRules []StorageRule `yaml:"storageRules"`
+	// SourceCredentials is the credentials file of the source, e.g. a bucket of another region, Credentials if empty
+	SourceCredentials string `yaml:"sourceCredentials"`
+	// Move deletes the objects pulled from oss to oss once their copies are verified
+	Move *bool `yaml:"move"`
+
+	workers  *Workers
+	limit    *Workers
+	progress *progress.Tracker
+	journal  *Journal
+	summary  *Summary
+
+	// ctx is done once the job is canceled, no new file is started then
+	ctx  context.Context
+	stop context.CancelFunc
+	// transfers is the context of the files in progress, done once the grace period after a cancel is over
+	transfers       context.Context
+	abort           context.CancelFunc
+	shutdownTimeout time.Duration
+	cancelOnce      sync.Once
+}
+
+// DefaultJob is the job configured by the flags and OSY_* env vars only
+func DefaultJob() *Job {
+	job := &Job{Name: "default"}
+	job.applyDefaults()
+	// an invalid storage class is reported by the startup
+	_ = job.validateStorage()
+	return job
+}
+
+// LoadJobs reads the jobs of the config files
+func LoadJobs() (map[string]*Job, error) {
+	jobs := make(map[string]*Job)
+	_, err := config.BindValue(jobsConfigKey, &jobs)
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	for name, job := range jobs {
+		if job == nil {
+			job = &Job{}
+			jobs[name] = job
+		}
+		job.Name = name
+		job.applyDefaults()
+		if job.Source == "" {
+			return nil, fmt.Errorf("source of job %s is required", name)
+		}
+		if job.Dest == "" && job.Operation != "scrub" {
+			return nil, fmt.Errorf("dest of job %s is required", name)
+		}
+		if err := job.validateStorage(); err != nil {
+			return nil, tracing.Error(err)
+		}
+	}
+	return jobs, nil
+}
+
+// FindJob returns the job of the config files named name
+func FindJob(name string) (*Job, error) {
+	jobs, err := LoadJobs()
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	job, ok := jobs[name]
+	if !ok {
+		return nil, tracing.Errorf(fmt.Sprintf("job %s not found", name), ErrJobNotFound)
+	}
+	return job, nil
+}
+
+// SortedJobNames returns the names of jobs in order
+func SortedJobNames(jobs map[string]*Job) []string {
+	names := make([]string, 0, len(jobs))
+	for name := range jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (job *Job) applyDefaults() {
+	if job.Operation == "" {
+		job.Operation = config.GetStringOrDefault(core.Arg_Operation, "")
+		if job.Operation == "" {
+			job.Operation = "push"
+		}
+	}
+	if job.Source == "" {
+		job.Source = config.GetStringOrDefault(core.Arg_SourcePath, "")
+	}
+	if job.Dest == "" {
+		job.Dest = config.GetStringOrDefault(core.Arg_DestPath, "")
+	}
+	if job.Credentials == "" {
+		job.Credentials = config.GetStringOrDefault(core.Arg_CredentialsFile, "")
+	}
+	if job.SourceCredentials == "" {
+		job.SourceCredentials = config.GetStringOrDefault(core.Arg_SourceCredentials, "")
+	}
+	if job.SourceCredentials == "" {
+		job.SourceCredentials = job.Credentials
+	}
+	job.Zip = switchOrDefault(job.Zip, core.Arg_Zip)
+	if job.Password == "" {
+		job.Password = config.GetStringOrDefault(core.Arg_Password, "")
+	}
+	if job.ChunkSizeMb <= 0 {
+		job.ChunkSizeMb = int64(config.GetValueOrDefault[float64](core.Arg_ChunkSizeMb, 5))
+		if job.ChunkSizeMb <= 0 {
+			job.ChunkSizeMb = 5
+		}
+	}
+	job.FullIndex = switchOrDefault(job.FullIndex, core.Arg_FullIndex)
+	if job.Schedule == "" {
+		job.Schedule = config.GetStringOrDefault(core.Arg_Cron, "")
+	}
+	if job.Concurrency <= 0 {
+		job.Concurrency = config.GetValueOrDefault(core.Arg_Concurrency, 0)
+		if job.Concurrency <= 0 {
+			job.Concurrency = 4
+		}
+	}
+	if job.StorageClass == "" {
+		job.StorageClass = config.GetStringOrDefault(core.Arg_StorageClass, "")
+	}
+	job.Move = switchOrDefault(job.Move, core.Arg_Move)
+	job.TmpDir = config.GetStringOrDefault(core.Arg_TmpDir, "")
+	job.Source = strings.TrimSuffix(job.Source, "/")
+	job.limit = NewWorkers(job.Concurrency)
+	job.ctx, job.stop = context.WithCancel(context.Background())
+	job.transfers, job.abort = context.WithCancel(context.Background())
+	// an invalid timeout is reported by the startup
+	job.shutdownTimeout, _ = durationOrDefault(core.Arg_ShutdownTimeout, 30*time.Second)
+}
+
+// switchOrDefault returns the switch set by a job, false included, otherwise the one of the flag or OSY_* env var of key
+func switchOrDefault(v *bool, key string) *bool {
+	if v == nil {
+		value := config.GetValueOrDefault(key, false)
+		return &value
+	}
+	return v
+}
+
+// enabled returns true if a switch of a job is set to true
+func enabled(v *bool) bool {
+	return v != nil && *v
+}
+
+// UseWorkers shares the budget of workers with other jobs, on top of the concurrency of the job
+func (job *Job) UseWorkers(workers *Workers) {
+	job.workers = workers
+}
+
+// UseProgress counts the files and bytes of the next run of the job by tracker, nil counts nothing
+func (job *Job) UseProgress(tracker *progress.Tracker) {
+	job.progress = tracker
+}
+
+// UseJournal records the files of the next run of the job to journal, nil records nothing
+func (job *Job) UseJournal(journal *Journal) {
+	job.journal = journal
+}
+
+// UseSummary collects the results of the files of the next run of the job to summary, nil collects nothing
+func (job *Job) UseSummary(summary *Summary) {
+	job.summary = summary
+}
+
+// Cancel stops the run of the job: no new file is started, the files in progress may finish
+// within OSY_SHUTDOWN_TIMEOUT, then they are aborted
+func (job *Job) Cancel() {
+	job.cancelOnce.Do(func() {
+		job.stop()
+		time.AfterFunc(job.shutdownTimeout, job.abort)
+	})
+}
+
+// Abort cancels the job and aborts the files in progress without waiting for them
+func (job *Job) Abort() {
+	job.Cancel()
+	job.abort()
+}
+
+// Done is closed when the job is canceled
+func (job *Job) Done() <-chan struct{} {
+	return job.ctx.Done()
+}
+
+func (job *Job) Canceled() bool {
+	return job.ctx.Err() != nil
+}
+
+// Context is the context of the transfers of the job, it is done when the files in progress are aborted
+func (job *Job) Context() context.Context {
+	return job.transfers
+}
+
+// acquire takes a slot of the job and one of the shared budget, release must be called when the work is done.
+// false is returned if the job is canceled while waiting, nothing is taken then.
+func (job *Job) acquire() bool {
+	if !job.limit.acquire(job.Done()) {
+		return false
+	}
+	if !job.workers.acquire(job.Done()) {
+		job.limit.Release()
+		return false
+	}
+	return true
+}
+
+func (job *Job) release() {
+	job.workers.Release()
+	job.limit.Release()
+}
+
+// destRelativePath returns the path of the destination of a source file, an encrypted file ends with .crypto
+func (job *Job) destRelativePath(relativePath string) string {
+	if enabled(job.Zip) && !strings.HasSuffix(relativePath, cryptoSuffix) {
+		return core.JoinUri(relativePath, cryptoSuffix)
+	}
+	return relativePath
+}
+
+// Matches returns true if the file of relativePath should be synced by the include and exclude filters.
+// A pattern without "/" is matched against the name of the file, otherwise against its path relative to the source.
+func (job *Job) Matches(relativePath string) bool {
+	if job.Excludes(relativePath) {
+		return false
+	}
+	if len(job.Include) == 0 {
+		return true
+	}
+	for _, pattern := range job.Include {
+		if matchPattern(pattern, relativePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// Excludes returns true if the file or directory of relativePath is excluded
+func (job *Job) Excludes(relativePath string) bool {
+	for _, pattern := range job.Exclude {
+		if matchPattern(pattern, relativePath) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPattern(pattern string, relativePath string) bool {
+	relativePath = strings.TrimPrefix(relativePath, "/")
+	name := relativePath
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(relativePath)
+	} else {
+		pattern = strings.TrimPrefix(pattern, "/")
+	}
+	matched, err := path.Match(pattern, name)
+	return err == nil && matched
+}
+
+// Workers limits the count of concurrent works, a nil Workers is unlimited
+type Workers struct {
+	slots chan struct{}
+}
+
+func NewWorkers(n int) *Workers {
+	if n <= 0 {
+		return nil
+	}
+	return &Workers{slots: make(chan struct{}, n)}
+}
+
+func (workers *Workers) Acquire() {
+	if workers != nil {
+		workers.slots <- struct{}{}
+	}
+}
+
+// acquire takes a slot unless done is closed first
+func (workers *Workers) acquire(done <-chan struct{}) bool {
+	if workers == nil {
+		return true
+	}
+	select {
+	case workers.slots <- struct{}{}:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+func (workers *Workers) Release() {
+	if workers != nil {
+		<-workers.slots
+	}
+}
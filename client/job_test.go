@@ -0,0 +1,150 @@
+package client
+
+import (
+	"os"
+	"osssync/common/config"
+	"osssync/core"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadJobs(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "jobs.yaml")
+	os.WriteFile(configPath, []byte(`
+jobs:
+  photos:
+    source: /data/photos/
+    dest: /backup/photos
+    include: ["*.jpg", "raw/*"]
+    exclude: ["tmp*", "cache"]
+    concurrency: 2
+    schedule: "0 3 * * *"
+  docs:
+    source: /data/docs
+    dest: /backup/docs
+    zip: true
+  videos:
+    source: /data/videos
+    dest: /backup/videos
+    zip: false
+`), 0644)
+	config.AttachValue(core.Arg_Password, "secret")
+	config.AttachValue(core.Arg_FullIndex, true)
+	config.AttachValue(core.Arg_Zip, true)
+	defer config.AttachValue(core.Arg_FullIndex, false)
+	defer config.AttachValue(core.Arg_Zip, false)
+	if err := config.AttachFile(configPath); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := LoadJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := SortedJobNames(jobs); len(names) != 3 || names[0] != "docs" || names[1] != "photos" {
+		t.Fatalf("unexpected jobs %v", names)
+	}
+	photos := jobs["photos"]
+	if photos.Name != "photos" || photos.Source != "/data/photos" || photos.Operation != "push" ||
+		photos.Concurrency != 2 || photos.Schedule != "0 3 * * *" || photos.ChunkSizeMb != 5 {
+		t.Fatalf("unexpected job %+v", photos)
+	}
+	docs := jobs["docs"]
+	if !enabled(docs.Zip) || !enabled(docs.FullIndex) || docs.Password != "secret" || docs.Concurrency != 4 {
+		t.Fatalf("settings of the flags are not applied %+v", docs)
+	}
+	if videos := jobs["videos"]; enabled(videos.Zip) || !enabled(videos.FullIndex) {
+		t.Fatalf("a switch turned off by the job is overridden by the flag %+v", videos)
+	}
+
+	for relativePath, expected := range map[string]bool{
+		"/a/1.jpg":       true,
+		"/1.jpg":         true,
+		"/1.png":         false,
+		"/raw/1.cr2":     true,
+		"/a/raw/1.cr2":   false,
+		"/a/tmp1.jpg":    false,
+		"/cache":         false,
+		"/a/cache/1.jpg": true,
+	} {
+		if actual := photos.Matches(relativePath); actual != expected {
+			t.Errorf("%s: expect %v, got %v", relativePath, expected, actual)
+		}
+	}
+	if !photos.Excludes("/a/cache") || docs.Excludes("/a/cache") || !docs.Matches("/1.png") {
+		t.Error("unexpected filter result")
+	}
+
+	if _, err := FindJob("music"); err == nil {
+		t.Fatal("expect an error of unknown job")
+	}
+}
+
+func TestJobCancel(t *testing.T) {
+	config.AttachValue(core.Arg_ShutdownTimeout, "50ms")
+	job := &Job{Name: "cancel", Concurrency: 1}
+	job.applyDefaults()
+
+	if !job.acquire() {
+		t.Fatal("slot of an idle job is not acquired")
+	}
+	acquired := make(chan bool)
+	go func() { acquired <- job.acquire() }()
+	job.Cancel()
+	if <-acquired {
+		t.Fatal("slot is acquired by a canceled job")
+	}
+	job.release()
+
+	if !job.Canceled() || job.Context().Err() != nil {
+		t.Fatal("files in progress are aborted before the shutdown timeout")
+	}
+	select {
+	case <-job.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("files in progress are not aborted after the shutdown timeout")
+	}
+
+	job = &Job{Name: "abort"}
+	job.applyDefaults()
+	job.Abort()
+	if !job.Canceled() || job.Context().Err() == nil {
+		t.Fatal("abort does not cancel the job and its files in progress")
+	}
+}
+
+func TestStorageRules(t *testing.T) {
+	job := &Job{
+		Name:         "archive",
+		StorageClass: "ia",
+		StorageRules: []StorageRule{
+			{Pattern: "*.mp4", Class: "Standard"},
+			{OlderThan: "365d", Class: "archive"},
+		},
+	}
+	if err := job.validateStorage(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, c := range []struct {
+		path     string
+		modTime  time.Time
+		expected core.StorageClass
+	}{
+		{"/a/1.mp4", now.AddDate(-2, 0, 0), core.StorageClass_Standard},
+		{"/a/1.jpg", now.AddDate(-2, 0, 0), core.StorageClass_Archive},
+		{"/a/1.jpg", now.AddDate(0, -1, 0), core.StorageClass_IA},
+	} {
+		if actual := job.storageClassOf(c.path, c.modTime); actual != c.expected {
+			t.Errorf("%s: expect %s, got %s", c.path, c.expected, actual)
+		}
+	}
+
+	for _, invalid := range []StorageRule{{Class: "Glacier"}, {Class: "IA", OlderThan: "a year"}} {
+		job := &Job{Name: "invalid", StorageRules: []StorageRule{invalid}}
+		if err := job.validateStorage(); err == nil {
+			t.Errorf("expect an error of rule %+v", invalid)
+		}
+	}
+}
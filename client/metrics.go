@@ -0,0 +1,80 @@
+package client
+
+import (
+	"net"
+	"os"
+	"osssync/common/metrics"
+	"osssync/common/tracing"
+	"osssync/core"
+	"strings"
+)
+
+var (
+	transferredBytes = metrics.NewCounterVec("osssync_transferred_bytes_total",
+		"Bytes written to the destinations.", "job")
+	copiedBytes = metrics.NewCounterVec("osssync_copied_bytes_total",
+		"Bytes copied within the object storage, not going through the host.", "job")
+	transferredObjects = metrics.NewCounterVec("osssync_transferred_objects_total",
+		"Files written to the destinations.", "job")
+	skippedObjects = metrics.NewCounterVec("osssync_skipped_objects_total",
+		"Files not transferred, by reason: up_to_date, synced or excluded.", "job", "reason")
+	failures = metrics.NewCounterVec("osssync_failures_total",
+		"Files failed to transfer, by error class: crc_mismatch, not_found, permission, network or other.", "job", "class")
+	crcMismatches = metrics.NewCounterVec("osssync_crc_mismatches_total",
+		"CRC64 mismatches found by transfers and scrubs.", "job")
+	partUploadSeconds = metrics.NewHistogramVec("osssync_part_upload_duration_seconds",
+		"Latency of writing a chunk of a multipart transfer.", nil, "job")
+)
+
+const (
+	skipUpToDate = "up_to_date"
+	skipSynced   = "synced"
+	skipExcluded = "excluded"
+)
+
+const (
+	errorClassCRC        = "crc_mismatch"
+	errorClassNotFound   = "not_found"
+	errorClassPermission = "permission"
+	errorClassNetwork    = "network"
+	errorClassOther      = "other"
+)
+
+// recordFailure counts a failed file by the class of its error
+func recordFailure(job *Job, err error) {
+	class := classifyError(err)
+	failures.WithLabelValues(job.Name, class).Inc()
+	if class == errorClassCRC {
+		crcMismatches.WithLabelValues(job.Name).Inc()
+	}
+}
+
+// classifyError returns the class of the innermost error, by its type or its message
+func classifyError(err error) string {
+	err = tracing.Cause(err)
+	if err == core.ErrCRC64NotMatch {
+		return errorClassCRC
+	}
+	if os.IsNotExist(err) {
+		return errorClassNotFound
+	}
+	if os.IsPermission(err) {
+		return errorClassPermission
+	}
+	if _, ok := err.(net.Error); ok {
+		return errorClassNetwork
+	}
+
+	message := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(message, "crc"):
+		return errorClassCRC
+	case strings.Contains(message, "nosuchkey") || strings.Contains(message, "no such file") || strings.Contains(message, "not found"):
+		return errorClassNotFound
+	case strings.Contains(message, "accessdenied") || strings.Contains(message, "permission denied") || strings.Contains(message, "forbidden"):
+		return errorClassPermission
+	case strings.Contains(message, "timeout") || strings.Contains(message, "connection") || strings.Contains(message, "no such host") || strings.Contains(message, "eof"):
+		return errorClassNetwork
+	}
+	return errorClassOther
+}
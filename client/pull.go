@@ -2,28 +2,51 @@ package client
 
 import (
 	"fmt"
-	"osssync/common/config"
 	"osssync/common/logging"
 	"osssync/common/tracing"
 	"osssync/core"
 	"sync"
+	"time"
 )
 
-func Pull(srcPath string, destPath string) error {
+func Pull(job *Job) error {
+	srcPath := job.Source
 	fileType := core.ResolveUriType(srcPath)
+	if enabled(job.Move) && (fileType != core.FileType_AliOSS || core.ResolveUriType(job.Dest) != core.FileType_AliOSS) {
+		return fmt.Errorf("job %s can only move objects from oss to oss", job.Name)
+	}
 
 	if fileType == core.FileType_AliOSS {
-		credentialFilePath := config.RequireString(core.Arg_CredentialsFile)
-		aliCfg := core.AliOSSCfgWrapper{}
-		err := config.BindYaml(credentialFilePath, &aliCfg)
+		if job.SourceCredentials == "" {
+			return fmt.Errorf("credentials of job %s is required", job.Name)
+		}
+		aliCfg, err := core.LoadAliOSSConfig(job.SourceCredentials)
+		if err != nil {
+			return tracing.Error(err)
+		}
+		bk, err := core.LsAliOss(job.ctx, aliCfg, srcPath, "")
 		if err != nil {
 			return tracing.Error(err)
 		}
-		bk, err := core.LsAliOss(aliCfg.Config, srcPath, "")
+		err = PullAliBucket(job, aliCfg, bk)
 		if err != nil {
 			return tracing.Error(err)
 		}
-		PullAliBucket(aliCfg.Config, bk, destPath)
+		if job.Canceled() {
+			return tracing.Error(ErrCanceled)
+		}
+	} else if fileType == core.FileType_SFTP || fileType == core.FileType_WebDAV || fileType == core.FileType_AzureBlob || fileType == core.FileType_GCS {
+		backend, err := core.OpenBackend(job.ctx, srcPath, job.SourceCredentials)
+		if err != nil {
+			return tracing.Error(err)
+		}
+		err = PullBackend(job, backend)
+		if err != nil {
+			return tracing.Error(err)
+		}
+		if job.Canceled() {
+			return tracing.Error(ErrCanceled)
+		}
 	} else {
 		logging.Info(fmt.Sprintf("File type %s is not supported for pull", fileType), nil)
 	}
@@ -31,30 +54,94 @@ func Pull(srcPath string, destPath string) error {
 	return nil
 }
 
-func PullAliBucket(config core.AliOSSConfig, bucketInfo *core.BucketInfo, destPath string) {
+// PullAliBucket pulls the objects of bucketInfo and of the following pages of the listing,
+// the files being pulled are finished when the listing fails.
+// The restores of the archived objects of a page are requested before any of them is pulled.
+func PullAliBucket(job *Job, config core.AliOSSConfig, bucketInfo *core.BucketInfo) error {
 	bkInfo := bucketInfo
 	var wg sync.WaitGroup
+	defer wg.Wait()
 	for {
-		for _, objectInfo := range bucketInfo.Objects {
+		requestRestores(job, bkInfo.Objects)
+		for _, objectInfo := range bkInfo.Objects {
+			if job.Canceled() {
+				break
+			}
 			basePath := objectInfo.BasePath
 			relativePath := objectInfo.RelativePath
+			if !job.Matches(relativePath) {
+				logging.Debug(fmt.Sprintf("Exclude file %s", relativePath), nil)
+				skippedObjects.WithLabelValues(job.Name, skipExcluded).Inc()
+				continue
+			}
+			if !job.acquire() {
+				break
+			}
+			job.progress.AddFile(objectInfo.Size)
+			counter := job.progress.StartFile(objectInfo.Size)
 			wg.Add(1)
+			size := objectInfo.Size
 			go func() {
 				defer wg.Done()
-				err := TransferFile(basePath, destPath, relativePath)
-				if err != nil {
-					logging.Error(err, nil)
-				}
+				defer job.release()
+				start := time.Now()
+				err := TransferFile(job, basePath, job.Dest, relativePath, counter)
+				reportResult(job, relativePath, size, start, counter, err)
 			}()
 		}
-		if !bkInfo.IsTruncated {
+		if !bkInfo.IsTruncated || job.Canceled() {
+			job.progress.Counted()
 			break
 		}
-		bk, err := core.LsAliOss(config, bucketInfo.BasePath, bucketInfo.ContinueToken)
+		bk, err := core.LsAliOss(job.ctx, config, bkInfo.BasePath, bkInfo.ContinueToken)
 		if err != nil {
-			logging.Error(err, nil)
+			return tracing.Errorf(fmt.Sprintf("failed to list %s", bkInfo.BasePath), err)
 		}
 		bkInfo = bk
 	}
-	wg.Wait()
+	return nil
+}
+
+// PullBackend pulls the files of the pages of the listing of backend, the files being pulled are finished when the listing fails
+func PullBackend(job *Job, backend core.Backend) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	token := ""
+	for {
+		page, err := backend.List(job.ctx, "", token)
+		if err != nil {
+			return tracing.Errorf(fmt.Sprintf("failed to list %s", backend.Root()), err)
+		}
+		for _, stat := range page.Files {
+			if job.Canceled() {
+				break
+			}
+			relativePath := "/" + stat.RelativePath
+			if !job.Matches(relativePath) {
+				logging.Debug(fmt.Sprintf("Exclude file %s", relativePath), nil)
+				skippedObjects.WithLabelValues(job.Name, skipExcluded).Inc()
+				continue
+			}
+			if !job.acquire() {
+				break
+			}
+			job.progress.AddFile(stat.Size)
+			counter := job.progress.StartFile(stat.Size)
+			wg.Add(1)
+			size := stat.Size
+			go func() {
+				defer wg.Done()
+				defer job.release()
+				start := time.Now()
+				err := TransferFile(job, job.Source, job.Dest, relativePath, counter)
+				reportResult(job, relativePath, size, start, counter, err)
+			}()
+		}
+		if !page.IsTruncated || job.Canceled() {
+			job.progress.Counted()
+			break
+		}
+		token = page.ContinueToken
+	}
+	return nil
 }
@@ -3,27 +3,45 @@ package client
 import (
 	"fmt"
 	"os"
-	"osssync/common/config"
 	"osssync/common/logging"
+	"osssync/common/progress"
 	"osssync/common/tracing"
 	"osssync/core"
 	"strings"
 	"sync"
+	"time"
 )
 
 var ErrIndexedAlready error = fmt.Errorf("indexed already")
 var ErrObjectExists error = fmt.Errorf("object exists")
 var ErrSyncedAlready error = fmt.Errorf("synced already")
+var ErrUpToDate error = fmt.Errorf("up to date")
 
-func PushFile(srcPath string, dstPath string, relativePath string, fullIndex bool) error {
-	err := TransferFile(srcPath, dstPath, relativePath)
+// Push pushes the source of job, its files are counted in parallel so that the progress knows the totals early
+func Push(job *Job) error {
+	if job.progress == nil {
+		return PushDir(job, job.Source)
+	}
+	counted := make(chan struct{})
+	go func() {
+		defer close(counted)
+		countDir(job, job.Source)
+		job.progress.Counted()
+	}()
+	err := PushDir(job, job.Source)
+	<-counted
+	return err
+}
+
+func PushFile(job *Job, relativePath string, counter *progress.File) error {
+	err := TransferFile(job, job.Source, job.Dest, relativePath, counter)
 	if err != nil {
 		return tracing.Error(err)
 	}
 	return nil
 }
 
-func PushDir(path string, destPath string, fullIndex bool) error {
+func PushDir(job *Job, path string) error {
 	rds, err := os.ReadDir(path)
 	if err != nil {
 		return tracing.Error(err)
@@ -34,9 +52,13 @@ func PushDir(path string, destPath string, fullIndex bool) error {
 		return nil
 	}
 
-	sourcePath := config.RequireString(core.Arg_SourcePath)
+	sourcePath := job.Source
 	var wg sync.WaitGroup
 	for _, rd := range rds {
+		if job.Canceled() {
+			wg.Wait()
+			return tracing.Error(ErrCanceled)
+		}
 		rdName := rd.Name()
 		if strings.HasPrefix(rdName, ".") {
 			logging.Info(fmt.Sprintf("Ignore file %s", rdName), nil)
@@ -44,33 +66,108 @@ func PushDir(path string, destPath string, fullIndex bool) error {
 		}
 		if rd.IsDir() {
 			subPath := core.JoinUri(path, rd.Name())
+			if job.Excludes(strings.TrimPrefix(subPath, sourcePath)) {
+				logging.Debug(fmt.Sprintf("Exclude directory %s", subPath), nil)
+				skippedObjects.WithLabelValues(job.Name, skipExcluded).Inc()
+				continue
+			}
 			logging.Info(fmt.Sprintf("Enter directory %s", subPath), nil)
-			err = PushDir(subPath, destPath, fullIndex)
-			if err != nil {
+			start := time.Now()
+			err = PushDir(job, subPath)
+			if tracing.IsError(err, ErrCanceled) {
+				wg.Wait()
 				return tracing.Error(err)
 			}
+			if err != nil {
+				// the other directories are still pushed, the directory is reported as a failed file
+				reportResult(job, strings.TrimPrefix(subPath, sourcePath), 0, start, nil, err)
+			}
 			continue
 		}
 		filePath := core.JoinUri(path, rd.Name())
 		relativePath := strings.TrimPrefix(filePath, sourcePath)
+		if !job.Matches(relativePath) {
+			logging.Debug(fmt.Sprintf("Exclude file %s", relativePath), nil)
+			skippedObjects.WithLabelValues(job.Name, skipExcluded).Inc()
+			continue
+		}
+
+		var size int64
+		if info, err := rd.Info(); err == nil {
+			size = info.Size()
+		}
+		if !job.acquire() {
+			wg.Wait()
+			return tracing.Error(ErrCanceled)
+		}
+		counter := job.progress.StartFile(size)
 
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			err := PushFile(sourcePath, destPath, relativePath, fullIndex)
-			if err != nil {
-				if tracing.IsError(ErrSyncedAlready, err) {
-					logging.Debug(fmt.Sprintf("File [%s] has been synced already", relativePath), nil)
-				} else if tracing.IsError(err, ErrObjectExists) {
-					logging.Debug(fmt.Sprintf("File [%s] exists at remote storage provider", relativePath), nil)
-				} else {
-					logging.Error(err, nil)
-				}
-			} else {
-				logging.Info(fmt.Sprintf("File [%s] successfully synced", relativePath), nil)
-			}
+			defer job.release()
+			start := time.Now()
+			err := PushFile(job, relativePath, counter)
+			reportResult(job, relativePath, size, start, counter, err)
 		}()
 	}
 	wg.Wait()
 	return nil
 }
+
+// countDir adds the files PushDir would push to the progress
+func countDir(job *Job, path string) {
+	rds, err := os.ReadDir(path)
+	if err != nil {
+		return
+	}
+	for _, rd := range rds {
+		if strings.HasPrefix(rd.Name(), ".") {
+			continue
+		}
+		subPath := core.JoinUri(path, rd.Name())
+		relativePath := strings.TrimPrefix(subPath, job.Source)
+		if rd.IsDir() {
+			if !job.Excludes(relativePath) {
+				countDir(job, subPath)
+			}
+			continue
+		}
+		if !job.Matches(relativePath) {
+			continue
+		}
+		if info, err := rd.Info(); err == nil {
+			job.progress.AddFile(info.Size())
+		}
+	}
+}
+
+// reportResult logs the transfer of a file and records it to the progress, the metrics, the journal and the summary of the run
+func reportResult(job *Job, relativePath string, size int64, start time.Time, counter *progress.File, err error) {
+	duration := time.Since(start)
+	switch {
+	case err == nil:
+		counter.Done()
+		job.journal.Record(relativePath, ActionTransferred, size, duration, nil)
+		job.summary.add(relativePath, ActionTransferred, size, nil)
+		logging.Info(fmt.Sprintf("File [%s] successfully synced", relativePath), nil)
+	case tracing.IsError(err, ErrUpToDate):
+		counter.Done()
+		skippedObjects.WithLabelValues(job.Name, skipUpToDate).Inc()
+		job.journal.Record(relativePath, ActionUpToDate, 0, duration, nil)
+		job.summary.add(relativePath, ActionUpToDate, 0, nil)
+		logging.Info(fmt.Sprintf("File [%s] is up to date", relativePath), nil)
+	case tracing.IsError(err, ErrSyncedAlready), tracing.IsError(err, ErrObjectExists):
+		counter.Done()
+		skippedObjects.WithLabelValues(job.Name, skipSynced).Inc()
+		job.journal.Record(relativePath, ActionSynced, 0, duration, nil)
+		job.summary.add(relativePath, ActionSynced, 0, nil)
+		logging.Debug(fmt.Sprintf("File [%s] has been synced already", relativePath), nil)
+	default:
+		counter.Fail()
+		recordFailure(job, err)
+		job.journal.Record(relativePath, ActionFailed, 0, duration, err)
+		job.summary.add(relativePath, ActionFailed, 0, err)
+		logging.Error(err, nil)
+	}
+}
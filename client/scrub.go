@@ -0,0 +1,64 @@
+package client
+
+import (
+	"fmt"
+	distributedstorage "osssync/common/distributedStorage"
+	"osssync/common/logging"
+	"osssync/common/tracing"
+	"sort"
+)
+
+// Scrub checks every distributed file of the source of job and repairs the damaged shards it can
+func Scrub(job *Job) error {
+	dir := job.Source
+	sets, err := distributedstorage.FindShardSets(dir)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	if len(sets) == 0 {
+		logging.Info(fmt.Sprintf("No distributed file found in %s", dir), nil)
+		return nil
+	}
+
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	damaged := 0
+	for _, name := range names {
+		if job.Canceled() {
+			return tracing.Error(ErrCanceled)
+		}
+		report, err := scrubFile(sets[name])
+		if err != nil {
+			damaged++
+			logging.Error(tracing.Errorf(fmt.Sprintf("Failed to scrub distributed file [%s]", name), err), nil)
+			continue
+		}
+		for _, shard := range report.Shards {
+			crcMismatches.WithLabelValues(job.Name).Add(float64(shard.Corrupted))
+		}
+		if !report.Healthy() {
+			damaged++
+			logging.Warn(fmt.Sprintf("Distributed file [%s] %s", name, report), nil)
+		} else {
+			logging.Info(fmt.Sprintf("Distributed file [%s] %s", name, report), nil)
+		}
+	}
+
+	if damaged > 0 {
+		return tracing.Errorf(fmt.Sprintf("%d of %d distributed files are damaged", damaged, len(names)), ErrVerificationFailed)
+	}
+	return nil
+}
+
+func scrubFile(paths []string) (*distributedstorage.HealthReport, error) {
+	dfile, err := distributedstorage.OpenDistributedFile(paths)
+	if err != nil {
+		return nil, err
+	}
+	defer dfile.Close()
+	return dfile.Scrub(true)
+}
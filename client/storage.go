@@ -0,0 +1,159 @@
+package client
+
+import (
+	"fmt"
+	"osssync/common/logging"
+	"osssync/common/tracing"
+	"osssync/core"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StorageRule selects the storage class of the files matching its pattern and older than OlderThan,
+// e.g. archive the files not modified for a year:
+//
+//	storageRules:
+//	  - pattern: "*.mp4"
+//	    class: IA
+//	  - olderThan: 365d
+//	    class: Archive
+type StorageRule struct {
+	// Pattern is matched like the include filters, an empty pattern matches every file
+	Pattern string `yaml:"pattern"`
+	// OlderThan is the min age of the last modification of the file, e.g. 720h or 365d
+	OlderThan string `yaml:"olderThan"`
+	Class     string `yaml:"class"`
+
+	olderThan time.Duration
+	class     core.StorageClass
+}
+
+// parseAge reads a duration of time.ParseDuration or a count of days such as 365d
+func parseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %s", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %s", s)
+	}
+	return d, nil
+}
+
+// validateStorage checks the storage class and the storage rules of the job
+func (job *Job) validateStorage() error {
+	if job.StorageClass != "" {
+		class, err := core.ParseStorageClass(job.StorageClass)
+		if err != nil {
+			return tracing.Errorf(fmt.Sprintf("storage class of job %s", job.Name), err)
+		}
+		job.StorageClass = string(class)
+	}
+	for i := range job.StorageRules {
+		rule := &job.StorageRules[i]
+		class, err := core.ParseStorageClass(rule.Class)
+		if err != nil {
+			return tracing.Errorf(fmt.Sprintf("storage rule %d of job %s", i+1, job.Name), err)
+		}
+		rule.class = class
+		if rule.OlderThan != "" {
+			rule.olderThan, err = parseAge(rule.OlderThan)
+			if err != nil {
+				return tracing.Errorf(fmt.Sprintf("storage rule %d of job %s", i+1, job.Name), err)
+			}
+		}
+	}
+	return nil
+}
+
+// storageClassOf returns the storage class of the copy of a file by the first matching rule,
+// otherwise the storage class of the job, empty to keep the default of the bucket
+func (job *Job) storageClassOf(relativePath string, modTime time.Time) core.StorageClass {
+	for _, rule := range job.StorageRules {
+		if rule.Pattern != "" && !matchPattern(rule.Pattern, relativePath) {
+			continue
+		}
+		if rule.olderThan > 0 && time.Since(modTime) < rule.olderThan {
+			continue
+		}
+		return rule.class
+	}
+	return core.StorageClass(job.StorageClass)
+}
+
+// requestRestores requests the restore of the archived objects so that they are restored at the same time,
+// rather than one after another as they are downloaded
+func requestRestores(job *Job, objects []*core.ObjectInfo) {
+	for _, object := range objects {
+		if job.Canceled() {
+			return
+		}
+		if !object.StorageClass.IsArchived() || !job.Matches(object.RelativePath) {
+			continue
+		}
+		err := restoreObject(job, object)
+		if err != nil {
+			logging.Warn(fmt.Sprintf("Failed to request the restore of %s: %s", object.RelativePath, tracing.Message(err)), nil)
+		}
+	}
+}
+
+func restoreObject(job *Job, object *core.ObjectInfo) error {
+	ctx := job.Context()
+	file, err := core.OpenFile(ctx, object.BasePath, object.RelativePath, job.SourceCredentials)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	defer file.Close()
+	tiered, ok := file.(core.Tiered)
+	if !ok {
+		return nil
+	}
+	readable, err := tiered.Readable(ctx)
+	if err != nil || readable {
+		return err
+	}
+	logging.Info(fmt.Sprintf("Restoring %s object %s", tiered.StorageClass(), object.RelativePath), nil)
+	return tiered.Restore(ctx)
+}
+
+// waitReadable requests the restore of an archived file and polls every OSY_RESTORE_POLL until it can be read
+func waitReadable(job *Job, file core.FileInfo) error {
+	tiered, ok := file.(core.Tiered)
+	if !ok {
+		return nil
+	}
+	ctx := job.Context()
+	readable, err := tiered.Readable(ctx)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	if readable {
+		return nil
+	}
+	err = tiered.Restore(ctx)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	poll, _ := durationOrDefault(core.Arg_RestorePoll, time.Minute)
+	logging.Info(fmt.Sprintf("Waiting for the restore of %s", file.RelativePath()), nil)
+	for {
+		select {
+		case <-job.Done():
+			return tracing.Error(ErrCanceled)
+		case <-time.After(poll):
+		}
+		readable, err := tiered.Readable(ctx)
+		if err != nil {
+			return tracing.Error(err)
+		}
+		if readable {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"osssync/common/progress"
+	"osssync/common/tracing"
+	"sync"
+	"time"
+)
+
+// the result of a run whose files did not all succeed
+var ErrPartialFailure error = errors.New("some files failed")
+var ErrTotalFailure error = errors.New("all files failed")
+var ErrVerificationFailed error = errors.New("verification failed")
+
+// maxFailures is the count of failed files kept by a summary to be reported
+const maxFailures = 50
+
+// Summary collects the results of the files of a run, a nil Summary collects nothing
+type Summary struct {
+	lock        sync.Mutex
+	start       time.Time
+	transferred int64
+	skipped     int64
+	failed      int64
+	mismatched  int64
+	bytes       int64
+	failures    []FileFailure
+}
+
+type FileFailure struct {
+	Path  string
+	Error error
+}
+
+func NewSummary() *Summary {
+	return &Summary{start: time.Now()}
+}
+
+// add counts the result of a file, err is nil for the transferred and skipped files
+func (summary *Summary) add(relativePath string, action string, bytes int64, err error) {
+	if summary == nil {
+		return
+	}
+	summary.lock.Lock()
+	defer summary.lock.Unlock()
+	switch {
+	case action == ActionTransferred:
+		summary.transferred++
+		summary.bytes += bytes
+	case IsFailedAction(action):
+		summary.failed++
+		if action == ActionCorrupted || (err != nil && classifyError(err) == errorClassCRC) {
+			summary.mismatched++
+		}
+		if len(summary.failures) < maxFailures {
+			summary.failures = append(summary.failures, FileFailure{Path: relativePath, Error: err})
+		}
+	default:
+		summary.skipped++
+	}
+}
+
+// Failures returns the first failed files
+func (summary *Summary) Failures() []FileFailure {
+	if summary == nil {
+		return nil
+	}
+	summary.lock.Lock()
+	defer summary.lock.Unlock()
+	return append([]FileFailure{}, summary.failures...)
+}
+
+// Err returns ErrVerificationFailed if a file did not match its checksum, ErrTotalFailure if every file failed,
+// ErrPartialFailure if some files failed, otherwise nil
+func (summary *Summary) Err() error {
+	if summary == nil {
+		return nil
+	}
+	summary.lock.Lock()
+	defer summary.lock.Unlock()
+	switch {
+	case summary.mismatched > 0:
+		return tracing.Errorf(fmt.Sprintf("%d files do not match their checksum", summary.mismatched), ErrVerificationFailed)
+	case summary.failed > 0 && summary.transferred+summary.skipped == 0:
+		return tracing.Errorf(fmt.Sprintf("all %d files failed", summary.failed), ErrTotalFailure)
+	case summary.failed > 0:
+		return tracing.Errorf(fmt.Sprintf("%d of %d files failed", summary.failed, summary.failed+summary.transferred+summary.skipped), ErrPartialFailure)
+	}
+	return nil
+}
+
+func (summary *Summary) String() string {
+	if summary == nil {
+		return ""
+	}
+	summary.lock.Lock()
+	defer summary.lock.Unlock()
+	return fmt.Sprintf("%d transferred (%s), %d skipped, %d failed in %s",
+		summary.transferred, progress.FormatBytes(summary.bytes), summary.skipped, summary.failed, time.Since(summary.start).Round(time.Millisecond))
+}
@@ -0,0 +1,37 @@
+package client
+
+import (
+	"fmt"
+	"osssync/common/tracing"
+	"osssync/core"
+	"testing"
+)
+
+func TestSummary(t *testing.T) {
+	var none *Summary
+	none.add("a", ActionFailed, 0, fmt.Errorf("ignored"))
+	if none.Err() != nil {
+		t.Fatal("nil summary reports an error")
+	}
+
+	summary := NewSummary()
+	if summary.Err() != nil {
+		t.Fatal("empty summary reports an error")
+	}
+	summary.add("a", ActionFailed, 0, fmt.Errorf("permission denied"))
+	if err := summary.Err(); !tracing.IsError(err, ErrTotalFailure) {
+		t.Fatalf("expect total failure, got %v", err)
+	}
+	summary.add("b", ActionTransferred, 10, nil)
+	summary.add("c", ActionUpToDate, 0, nil)
+	if err := summary.Err(); !tracing.IsError(err, ErrPartialFailure) {
+		t.Fatalf("expect partial failure, got %v", err)
+	}
+	summary.add("d", ActionFailed, 0, tracing.Errorf("check d", core.ErrCRC64NotMatch))
+	if err := summary.Err(); !tracing.IsError(err, ErrVerificationFailed) {
+		t.Fatalf("expect verification failure, got %v", err)
+	}
+	if failures := summary.Failures(); len(failures) != 2 || failures[0].Path != "a" || failures[1].Path != "d" {
+		t.Fatalf("unexpected failures %v", failures)
+	}
+}
@@ -0,0 +1,493 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"osssync/common/config"
+	"osssync/common/dataAccess/nosqlite"
+	"osssync/common/logging"
+	"osssync/common/tracing"
+	"osssync/core"
+	"osssync/core/azurefake"
+	"osssync/core/gcsfake"
+	"osssync/core/ossfake"
+	"osssync/core/sftpfake"
+	"osssync/core/webdavfake"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeJob returns a job of operation from source to dest whose credentials are those of the fake
+func fakeJob(t *testing.T, server *ossfake.Server, operation string, source string, dest string) *Job {
+	t.Setenv(core.Env_AccessKeyId, "")
+	t.Setenv(core.Env_AccessKeySecret, "")
+	return newTestJob(t, fmt.Sprintf(`
+alioss:
+  endpoint: %s
+  access_key_id: id
+  access_key_secret: secret
+`, server.Endpoint()), operation, source, dest)
+}
+
+// sftpJob returns a job of operation from source to dest whose credentials are the keys of the fake
+func sftpJob(t *testing.T, server *sftpfake.Server, operation string, source string, dest string) *Job {
+	privateKeyPath, knownHostsPath, err := server.WriteCredentials(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestJob(t, fmt.Sprintf(`
+sftp:
+  private_key_path: %s
+  known_hosts_path: %s
+`, privateKeyPath, knownHostsPath), operation, source, dest)
+}
+
+// webdavJob returns a job of operation from source to dest whose credentials are those of the user of the fake
+func webdavJob(t *testing.T, server *webdavfake.Server, operation string, source string, dest string) *Job {
+	return newTestJob(t, fmt.Sprintf(`
+webdav:
+  password: %s
+`, server.Password), operation, source, dest)
+}
+
+func newTestJob(t *testing.T, credentialsYaml string, operation string, source string, dest string) *Job {
+	config.AttachValue("logging.path", os.TempDir())
+	config.AttachValue("logging.enableStdOut", false)
+	logging.Init()
+	credentials := filepath.Join(t.TempDir(), "credential.yaml")
+	os.WriteFile(credentials, []byte(credentialsYaml), 0644)
+	job := &Job{Name: "e2e", Operation: operation, Source: source, Dest: dest, Credentials: credentials}
+	job.applyDefaults()
+	job.UseSummary(NewSummary())
+	return job
+}
+
+func TestPushPullVerify(t *testing.T) {
+	server := ossfake.NewServer("photos")
+	defer server.Close()
+	files := map[string][]byte{
+		"/a.jpg":       []byte("the content of a"),
+		"/2022/b.jpg":  []byte("the content of b"),
+		"/2022/c/d.js": bytes.Repeat([]byte("d"), 6*1024*1024),
+	}
+	source := t.TempDir()
+	for relativePath, content := range files {
+		os.MkdirAll(filepath.Dir(source+relativePath), 0755)
+		os.WriteFile(source+relativePath, content, 0644)
+	}
+
+	push := fakeJob(t, server, "push", source, "oss://photos/backup")
+	if err := Push(push); err != nil {
+		t.Fatal(err)
+	}
+	if err := push.summary.Err(); err != nil || push.summary.transferred != 3 {
+		t.Fatalf("unexpected push %s %v", push.summary, err)
+	}
+	for relativePath, content := range files {
+		object, ok := server.Object("photos", "backup"+relativePath)
+		if !ok || !bytes.Equal(object.Data, content) {
+			t.Fatalf("object of %s is not pushed", relativePath)
+		}
+	}
+	if server.Requests("UploadPart") != 2 {
+		t.Fatalf("expect the large file to be uploaded by 2 parts, got %d", server.Requests("UploadPart"))
+	}
+
+	again := fakeJob(t, server, "push", source, "oss://photos/backup")
+	if err := Push(again); err != nil {
+		t.Fatal(err)
+	}
+	if again.summary.transferred != 0 || again.summary.skipped != 3 {
+		t.Fatalf("unchanged files are pushed again %s", again.summary)
+	}
+
+	verify := fakeJob(t, server, "verify", source, "oss://photos/backup")
+	if err := Verify(verify); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := t.TempDir()
+	pull := fakeJob(t, server, "pull", "oss://photos/backup", dest)
+	if err := Pull(pull); err != nil {
+		t.Fatal(err)
+	}
+	if err := pull.summary.Err(); err != nil || pull.summary.transferred != 3 {
+		t.Fatalf("unexpected pull %s %v", pull.summary, err)
+	}
+	for relativePath, content := range files {
+		pulled, err := os.ReadFile(dest + relativePath)
+		if err != nil || !bytes.Equal(pulled, content) {
+			t.Fatalf("%s is not pulled %v", relativePath, err)
+		}
+	}
+
+	server.PutObject("photos", "backup/2022/b.jpg", []byte("damaged"), "")
+	server.PutObject("photos", "backup/extra.jpg", []byte("extra"), "")
+	verify = fakeJob(t, server, "verify", source, "oss://photos/backup")
+	if err := Verify(verify); !tracing.IsError(err, ErrVerificationFailed) {
+		t.Fatalf("expect the damaged object to fail the verify, got %v", err)
+	}
+}
+
+func TestPullArchivedObject(t *testing.T) {
+	server := ossfake.NewServer("photos")
+	defer server.Close()
+	server.PutObject("photos", "backup/old.jpg", []byte("old"), string(core.StorageClass_Archive))
+
+	dest := t.TempDir()
+	pull := fakeJob(t, server, "pull", "oss://photos/backup", dest)
+	if err := Pull(pull); err != nil {
+		t.Fatal(err)
+	}
+	if err := pull.summary.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if server.Requests("RestoreObject") != 1 {
+		t.Fatalf("expect the restore to be requested once, got %d", server.Requests("RestoreObject"))
+	}
+	if pulled, _ := os.ReadFile(filepath.Join(dest, "old.jpg")); string(pulled) != "old" {
+		t.Fatalf("unexpected content %q", pulled)
+	}
+}
+
+func TestPullBucketToBucket(t *testing.T) {
+	server := ossfake.NewServer("photos", "backup")
+	defer server.Close()
+	other := ossfake.NewServer("archive")
+	defer other.Close()
+	content := []byte("the content of a")
+	server.PutObject("photos", "2022/a.jpg", content, "")
+	server.PutObject("photos", "2022/b.jpg", content, "")
+
+	// the copy within the storage is denied, the object is downloaded instead
+	server.FailNext("CopyObject", 1, http.StatusForbidden, "AccessDenied")
+	pull := fakeJob(t, server, "pull", "oss://photos/2022", "oss://backup/2022")
+	pull.StorageClass = string(core.StorageClass_IA)
+	if err := Pull(pull); err != nil {
+		t.Fatal(err)
+	}
+	if err := pull.summary.Err(); err != nil || pull.summary.transferred != 2 {
+		t.Fatalf("unexpected pull %s %v", pull.summary, err)
+	}
+	for _, key := range []string{"2022/a.jpg", "2022/b.jpg"} {
+		object, ok := server.Object("backup", key)
+		if !ok || !bytes.Equal(object.Data, content) || object.StorageClass != string(core.StorageClass_IA) {
+			t.Fatalf("%s is not copied", key)
+		}
+	}
+	if server.Requests("CopyObject") != 2 || server.Requests("PutObject") != 1 {
+		t.Fatalf("expect one copy and one download, got %d copies and %d puts", server.Requests("CopyObject"), server.Requests("PutObject"))
+	}
+
+	// another region can't copy from the source, a move deletes the verified sources
+	yes := true
+	large := bytes.Repeat([]byte("0123456789"), 1024*1024+1)
+	server.PutObject("photos", "2022/large.bin", large, "")
+	server.SetHeader("photos", "2022/large.bin", "X-Oss-Meta-Owner", "backup")
+	server.SetHeader("photos", "2022/large.bin", "Content-Type", "application/x-backup")
+	move := fakeJob(t, other, "pull", "oss://photos/2022", "oss://archive/2022")
+	move.SourceCredentials = pull.Credentials
+	move.Move = &yes
+	move.ChunkSizeMb = 5
+	if err := Pull(move); err != nil {
+		t.Fatal(err)
+	}
+	if err := move.summary.Err(); err != nil || move.summary.transferred != 3 {
+		t.Fatalf("unexpected move %s %v", move.summary, err)
+	}
+	// the large object is streamed by parts with its metadata
+	object, ok := other.Object("archive", "2022/large.bin")
+	if !ok || !bytes.Equal(object.Data, large) || other.Requests("UploadPart") != 3 {
+		t.Fatalf("the large object is not moved by parts, %d parts", other.Requests("UploadPart"))
+	}
+	if object.Header.Get("X-Oss-Meta-Owner") != "backup" || object.Header.Get("Content-Type") != "application/x-backup" {
+		t.Fatalf("the metadata is not kept %v", object.Header)
+	}
+	if other.Requests("CopyObject") != 0 || server.Requests("CopyObject") != 2 {
+		t.Fatal("expect the objects of another region to be downloaded")
+	}
+	for _, key := range []string{"2022/a.jpg", "2022/b.jpg"} {
+		if object, ok := other.Object("archive", key); !ok || !bytes.Equal(object.Data, content) {
+			t.Fatalf("%s is not moved", key)
+		}
+		if _, ok := server.Object("photos", key); ok {
+			t.Fatalf("the source of the moved %s is kept", key)
+		}
+	}
+
+	// a move within the region is copied by the storage
+	back := fakeJob(t, server, "pull", "oss://backup/2022", "oss://photos/2022")
+	back.Move = &yes
+	if err := Pull(back); err != nil {
+		t.Fatal(err)
+	}
+	if server.Requests("CopyObject") != 4 || len(server.Keys("backup")) != 0 || len(server.Keys("photos")) != 2 {
+		t.Fatalf("unexpected move within the region, %d copies", server.Requests("CopyObject"))
+	}
+
+	// a pull to the file system does not move
+	local := fakeJob(t, server, "pull", "oss://backup/2022", t.TempDir())
+	local.Move = &yes
+	if err := Pull(local); err == nil {
+		t.Fatal("expect a move out of oss to fail")
+	}
+}
+
+func TestPushPullSFTP(t *testing.T) {
+	server := sftpfake.NewServer()
+	defer server.Close()
+	files := map[string][]byte{
+		"/a.sh":        []byte("#!/bin/sh"),
+		"/2022/b.jpg":  []byte("the content of b"),
+		"/2022/c/d.js": bytes.Repeat([]byte("d"), 6*1024*1024),
+	}
+	source := t.TempDir()
+	for relativePath, content := range files {
+		os.MkdirAll(filepath.Dir(source+relativePath), 0755)
+		os.WriteFile(source+relativePath, content, 0644)
+	}
+	os.Chmod(source+"/a.sh", 0755)
+	remote := t.TempDir()
+
+	push := sftpJob(t, server, "push", source, server.Uri("backup", remote))
+	if err := Push(push); err != nil {
+		t.Fatal(err)
+	}
+	if err := push.summary.Err(); err != nil || push.summary.transferred != 3 {
+		t.Fatalf("unexpected push %s %v", push.summary, err)
+	}
+	for relativePath, content := range files {
+		pushed, err := os.ReadFile(remote + relativePath)
+		if err != nil || !bytes.Equal(pushed, content) {
+			t.Fatalf("%s is not pushed %v", relativePath, err)
+		}
+	}
+	if statInfo, _ := os.Stat(remote + "/a.sh"); statInfo == nil || statInfo.Mode().Perm() != 0755 {
+		t.Fatal("the permissions of a.sh are not kept")
+	}
+
+	// the unchanged files are told by their size and modification time
+	again := sftpJob(t, server, "push", source, server.Uri("backup", remote))
+	if err := Push(again); err != nil {
+		t.Fatal(err)
+	}
+	if again.summary.transferred != 0 || again.summary.skipped != 3 {
+		t.Fatalf("unchanged files are pushed again %s", again.summary)
+	}
+
+	verify := sftpJob(t, server, "verify", source, server.Uri("backup", remote))
+	if err := Verify(verify); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := t.TempDir()
+	pull := sftpJob(t, server, "pull", server.Uri("backup", remote), dest)
+	if err := Pull(pull); err != nil {
+		t.Fatal(err)
+	}
+	if err := pull.summary.Err(); err != nil || pull.summary.transferred != 3 {
+		t.Fatalf("unexpected pull %s %v", pull.summary, err)
+	}
+	for relativePath, content := range files {
+		pulled, err := os.ReadFile(dest + relativePath)
+		if err != nil || !bytes.Equal(pulled, content) {
+			t.Fatalf("%s is not pulled %v", relativePath, err)
+		}
+	}
+	pullAgain := sftpJob(t, server, "pull", server.Uri("backup", remote), dest)
+	if err := Pull(pullAgain); err != nil {
+		t.Fatal(err)
+	}
+	if pullAgain.summary.transferred != 0 || pullAgain.summary.skipped != 3 {
+		t.Fatalf("unchanged files are pulled again %s", pullAgain.summary)
+	}
+}
+
+func TestPushPullWebDAV(t *testing.T) {
+	server := webdavfake.NewServer(t.TempDir(), "alice", "secret")
+	defer server.Close()
+	os.MkdirAll(filepath.Join(server.Dir, "remote.php/dav/uploads/alice"), 0755)
+	os.MkdirAll(filepath.Join(server.Dir, "remote.php/dav/files/alice"), 0755)
+	files := map[string][]byte{
+		"/a.jpg":       []byte("the content of a"),
+		"/2022/c/d.js": bytes.Repeat([]byte("d"), 6*1024*1024),
+	}
+	source := t.TempDir()
+	for relativePath, content := range files {
+		os.MkdirAll(filepath.Dir(source+relativePath), 0755)
+		os.WriteFile(source+relativePath, content, 0644)
+	}
+	remote := server.Uri("/remote.php/dav/files/alice/backup")
+
+	push := webdavJob(t, server, "push", source, remote)
+	if err := Push(push); err != nil {
+		t.Fatal(err)
+	}
+	if err := push.summary.Err(); err != nil || push.summary.transferred != 2 {
+		t.Fatalf("unexpected push %s %v", push.summary, err)
+	}
+	for relativePath, content := range files {
+		pushed, err := os.ReadFile(filepath.Join(server.Dir, "remote.php/dav/files/alice/backup", relativePath))
+		if err != nil || !bytes.Equal(pushed, content) {
+			t.Fatalf("%s is not pushed %v", relativePath, err)
+		}
+	}
+	if server.Requests("MOVE") != 2 {
+		t.Fatalf("expect the large file to be a chunked upload, got %d MOVE", server.Requests("MOVE"))
+	}
+
+	again := webdavJob(t, server, "push", source, remote)
+	if err := Push(again); err != nil {
+		t.Fatal(err)
+	}
+	if again.summary.transferred != 0 || again.summary.skipped != 2 {
+		t.Fatalf("unchanged files are pushed again %s", again.summary)
+	}
+
+	verify := webdavJob(t, server, "verify", source, remote)
+	if err := Verify(verify); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := t.TempDir()
+	pull := webdavJob(t, server, "pull", remote, dest)
+	if err := Pull(pull); err != nil {
+		t.Fatal(err)
+	}
+	if err := pull.summary.Err(); err != nil || pull.summary.transferred != 2 {
+		t.Fatalf("unexpected pull %s %v", pull.summary, err)
+	}
+	for relativePath, content := range files {
+		pulled, err := os.ReadFile(dest + relativePath)
+		if err != nil || !bytes.Equal(pulled, content) {
+			t.Fatalf("%s is not pulled %v", relativePath, err)
+		}
+	}
+}
+
+func TestPushWebDAVETag(t *testing.T) {
+	err := nosqlite.Init(fmt.Sprintf("file:%s?cache=shared", filepath.Join(t.TempDir(), "osssync.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := webdavfake.NewServer(t.TempDir(), "alice", "secret")
+	defer server.Close()
+	server.IgnoreModTime = true
+	source := t.TempDir()
+	os.WriteFile(filepath.Join(source, "a.jpg"), []byte("the content of a"), 0644)
+	modTime := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(source, "a.jpg"), modTime, modTime)
+	remote := server.Uri("/backup")
+
+	push := webdavJob(t, server, "push", source, remote)
+	if err := Push(push); err != nil {
+		t.Fatal(err)
+	}
+	if err := push.summary.Err(); err != nil || push.summary.transferred != 1 {
+		t.Fatalf("unexpected push %s %v", push.summary, err)
+	}
+	// the file is told unchanged by its ETag, the server didn't keep the modification time
+	again := webdavJob(t, server, "push", source, remote)
+	if err := Push(again); err != nil {
+		t.Fatal(err)
+	}
+	if again.summary.transferred != 0 || again.summary.skipped != 1 {
+		t.Fatalf("unchanged files are pushed again %s", again.summary)
+	}
+
+	os.WriteFile(filepath.Join(source, "a.jpg"), []byte("the new content of a"), 0644)
+	changed := webdavJob(t, server, "push", source, remote)
+	if err := Push(changed); err != nil {
+		t.Fatal(err)
+	}
+	if changed.summary.transferred != 1 {
+		t.Fatalf("changed files are not pushed %s", changed.summary)
+	}
+}
+
+func TestPushPullAzureBlobAndGCS(t *testing.T) {
+	t.Setenv(core.Env_AzureStorageAccount, "")
+	t.Setenv(core.Env_AzureStorageKey, "")
+	azure := azurefake.NewServer("backups")
+	defer azure.Close()
+	gcs := gcsfake.NewServer("backups")
+	defer gcs.Close()
+	credentials := fmt.Sprintf(`
+azblob:
+  account_name: %s
+  account_key: %s
+  endpoint: %s
+gcs:
+  endpoint: %s
+  without_authentication: true
+`, azurefake.AccountName, azurefake.AccountKey, azure.Endpoint(), gcs.Endpoint())
+	files := map[string][]byte{
+		"/a.jpg":       []byte("the content of a"),
+		"/2022/c/d.js": bytes.Repeat([]byte("d"), 6*1024*1024),
+	}
+	source := t.TempDir()
+	for relativePath, content := range files {
+		os.MkdirAll(filepath.Dir(source+relativePath), 0755)
+		os.WriteFile(source+relativePath, content, 0644)
+	}
+	stored := map[string]func(name string) ([]byte, bool){
+		"azblob://backups/photos": func(name string) ([]byte, bool) {
+			blob, ok := azure.Blob("backups", name)
+			return blob.Data, ok
+		},
+		"gs://backups/photos": func(name string) ([]byte, bool) {
+			object, ok := gcs.Object("backups", name)
+			return object.Data, ok
+		},
+	}
+	for remote, read := range stored {
+		t.Run(remote, func(t *testing.T) {
+			push := newTestJob(t, credentials, "push", source, remote)
+			if err := Push(push); err != nil {
+				t.Fatal(err)
+			}
+			if err := push.summary.Err(); err != nil || push.summary.transferred != 2 {
+				t.Fatalf("unexpected push %s %v", push.summary, err)
+			}
+			for relativePath, content := range files {
+				if pushed, ok := read("photos" + relativePath); !ok || !bytes.Equal(pushed, content) {
+					t.Fatalf("%s is not pushed", relativePath)
+				}
+			}
+
+			again := newTestJob(t, credentials, "push", source, remote)
+			if err := Push(again); err != nil {
+				t.Fatal(err)
+			}
+			if again.summary.transferred != 0 || again.summary.skipped != 2 {
+				t.Fatalf("unchanged files are pushed again %s", again.summary)
+			}
+
+			verify := newTestJob(t, credentials, "verify", source, remote)
+			if err := Verify(verify); err != nil {
+				t.Fatal(err)
+			}
+
+			dest := t.TempDir()
+			pull := newTestJob(t, credentials, "pull", remote, dest)
+			if err := Pull(pull); err != nil {
+				t.Fatal(err)
+			}
+			if err := pull.summary.Err(); err != nil || pull.summary.transferred != 2 {
+				t.Fatalf("unexpected pull %s %v", pull.summary, err)
+			}
+			for relativePath, content := range files {
+				pulled, err := os.ReadFile(dest + relativePath)
+				if err != nil || !bytes.Equal(pulled, content) {
+					t.Fatalf("%s is not pulled %v", relativePath, err)
+				}
+			}
+		})
+	}
+	if names := gcs.Names("backups"); len(names) != 2 {
+		t.Fatalf("unexpected objects %v", names)
+	}
+}
@@ -0,0 +1,381 @@
+package client
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"osssync/common/config"
+	"osssync/common/logging"
+	"osssync/common/tracing"
+	"osssync/core"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrMissingCopy error = errors.New("copy is missing from the destination")
+
+// verifyReport counts the results of the files of a verify run
+type verifyReport struct {
+	lock   sync.Mutex
+	counts map[string]int
+}
+
+func (report *verifyReport) add(action string) {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+	report.counts[action]++
+}
+
+func (report *verifyReport) String() string {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+	return fmt.Sprintf("%d verified, %d missing, %d stale, %d corrupted, %d extra, %d failed",
+		report.counts[ActionVerified], report.counts[ActionMissing], report.counts[ActionStale],
+		report.counts[ActionCorrupted], report.counts[ActionExtra], report.counts[ActionFailed])
+}
+
+// problems returns the count of the files whose destination is not a copy of the source
+func (report *verifyReport) problems() int {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+	return report.counts[ActionMissing] + report.counts[ActionStale] + report.counts[ActionCorrupted]
+}
+
+// verifier audits the destination of a job against its source, nothing is transferred
+type verifier struct {
+	job    *Job
+	report *verifyReport
+	// sample is the percentage of the files downloaded in full
+	sample float64
+	// key decrypts the .crypto files, nil if the password is not known
+	key *rsa.PrivateKey
+	// expected are the destination paths of the source files
+	expected map[string]bool
+}
+
+// Verify compares every source file of job to its destination by the strongest signal available:
+// the CRC64 of the object for a plain file, the CRC64 recorded in the header for a .crypto file.
+// OSY_VERIFY_SAMPLE percent of the files are downloaded in full, and decrypted if encrypted,
+// to check the content itself. Missing, stale, corrupted and extra objects are reported,
+// ErrVerificationFailed is returned if a source file has no valid copy.
+func Verify(job *Job) error {
+	v := &verifier{
+		job:      job,
+		report:   &verifyReport{counts: make(map[string]int)},
+		sample:   config.GetValueOrDefault[float64](core.Arg_VerifySample, 0),
+		expected: make(map[string]bool),
+	}
+	if enabled(job.Zip) {
+		if job.Password == "" {
+			logging.Warn(fmt.Sprintf("Password of job %s is not set, encrypted files are checked by their header only", job.Name), nil)
+		} else {
+			key, err := core.GenerateRsaKey(core.GetPasswordSeed(job.Password))
+			if err != nil {
+				return tracing.Error(err)
+			}
+			v.key = key
+		}
+	}
+	logging.Info(fmt.Sprintf("Verifying %s against %s, %g%% of the files downloaded in full", job.Dest, job.Source, v.sample), nil)
+
+	err := v.verifyDir(job.Source)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	err = v.findExtras()
+	if err != nil {
+		return tracing.Error(err)
+	}
+
+	logging.Info(fmt.Sprintf("Job %s verify: %s", job.Name, v.report), nil)
+	if n := v.report.problems(); n > 0 {
+		return tracing.Errorf(fmt.Sprintf("%d files have no valid copy: %s", n, v.report), ErrVerificationFailed)
+	}
+	return nil
+}
+
+func (v *verifier) verifyDir(path string) error {
+	job := v.job
+	rds, err := os.ReadDir(path)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for _, rd := range rds {
+		if job.Canceled() {
+			return tracing.Error(ErrCanceled)
+		}
+		if strings.HasPrefix(rd.Name(), ".") {
+			continue
+		}
+		filePath := core.JoinUri(path, rd.Name())
+		relativePath := strings.TrimPrefix(filePath, job.Source)
+		if rd.IsDir() {
+			if job.Excludes(relativePath) {
+				continue
+			}
+			start := time.Now()
+			err = v.verifyDir(filePath)
+			if tracing.IsError(err, ErrCanceled) {
+				return tracing.Error(err)
+			}
+			if err != nil {
+				v.record(relativePath, ActionFailed, start, err)
+			}
+			continue
+		}
+		if !job.Matches(relativePath) {
+			continue
+		}
+		info, err := rd.Info()
+		if err != nil {
+			v.record(relativePath, ActionFailed, time.Now(), err)
+			continue
+		}
+		v.expected[job.destRelativePath(relativePath)] = true
+
+		if !job.acquire() {
+			return tracing.Error(ErrCanceled)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer job.release()
+			start := time.Now()
+			action, err := v.verifyFile(relativePath, info)
+			v.record(relativePath, action, start, err)
+		}()
+	}
+	return nil
+}
+
+// verifyFile returns the result of the file of relativePath, err explains why the copy is not valid
+func (v *verifier) verifyFile(relativePath string, info fs.FileInfo) (string, error) {
+	job := v.job
+	ctx := job.Context()
+	destRelativePath := job.destRelativePath(relativePath)
+	srcCrc64, err := core.ComputeCrc64(core.JoinUri(job.Source, relativePath))
+	if err != nil {
+		return ActionFailed, tracing.Error(err)
+	}
+	dest, err := core.OpenFile(ctx, job.Dest, destRelativePath, job.Credentials)
+	if err != nil {
+		return ActionFailed, tracing.Error(err)
+	}
+	defer dest.Close()
+	exists, err := dest.Exists(ctx)
+	if err != nil {
+		return ActionFailed, tracing.Error(err)
+	}
+	if !exists {
+		return ActionMissing, tracing.Error(ErrMissingCopy)
+	}
+	download := v.sample > 0 && rand.Float64()*100 < v.sample
+
+	if enabled(job.Zip) {
+		return v.verifyCrypto(dest, info, srcCrc64, download)
+	}
+
+	destCrc64, err := dest.CRC64(ctx)
+	if err != nil {
+		return ActionFailed, tracing.Error(err)
+	}
+	if destCrc64 == 0 {
+		// the object has no CRC64, its content is the only signal
+		download = true
+	} else if destCrc64 != srcCrc64 {
+		return changedOrCorrupted(info.ModTime(), modTime(dest), srcCrc64, destCrc64)
+	}
+	if !download {
+		return ActionVerified, nil
+	}
+	reader, err := core.OpenReader(job.Context(), dest)
+	if err != nil {
+		return ActionFailed, tracing.Errorf("failed to download", err)
+	}
+	defer reader.Close()
+	hash := crc64.New(crc64.MakeTable(crc64.ECMA))
+	_, err = io.Copy(hash, reader)
+	if err != nil {
+		return ActionFailed, tracing.Errorf("failed to download", err)
+	}
+	if hash.Sum64() != srcCrc64 {
+		if destCrc64 == 0 {
+			return changedOrCorrupted(info.ModTime(), modTime(dest), srcCrc64, hash.Sum64())
+		}
+		return ActionCorrupted, fmt.Errorf("content CRC64 %d does not match its object CRC64 %d", hash.Sum64(), destCrc64)
+	}
+	return ActionVerified, nil
+}
+
+// verifyCrypto checks an encrypted copy by the CRC64 of the source recorded in its header, and by decrypting it if download
+func (v *verifier) verifyCrypto(dest core.FileInfo, info fs.FileInfo, srcCrc64 uint64, download bool) (string, error) {
+	reader, err := core.OpenReader(v.job.Context(), dest)
+	if err != nil {
+		return ActionFailed, tracing.Errorf("failed to download", err)
+	}
+	defer reader.Close()
+	if !download || v.key == nil {
+		header, err := core.ReadCryptoFileHeader(reader)
+		if err != nil {
+			return ActionCorrupted, tracing.Errorf("invalid header", err)
+		}
+		if header.CRC64 != srcCrc64 {
+			return changedOrCorrupted(info.ModTime(), time.Unix(header.ModifyTime, 0), srcCrc64, header.CRC64)
+		}
+		return ActionVerified, nil
+	}
+
+	header, err := core.DecryptStream(reader, v.key, io.Discard)
+	if header == nil {
+		return ActionCorrupted, tracing.Errorf("invalid header", err)
+	}
+	if header.CRC64 != srcCrc64 {
+		return changedOrCorrupted(info.ModTime(), time.Unix(header.ModifyTime, 0), srcCrc64, header.CRC64)
+	}
+	if err != nil {
+		return ActionCorrupted, tracing.Errorf("failed to decrypt", err)
+	}
+	return ActionVerified, nil
+}
+
+// changedOrCorrupted tells a copy older than the last change of the source from a damaged copy
+func changedOrCorrupted(srcModTime time.Time, destModTime time.Time, srcCrc64 uint64, destCrc64 uint64) (string, error) {
+	if !destModTime.IsZero() && srcModTime.After(destModTime) {
+		return ActionStale, fmt.Errorf("source is modified at %s after its copy at %s",
+			srcModTime.Format(time.RFC3339), destModTime.Format(time.RFC3339))
+	}
+	return ActionCorrupted, tracing.Errorf(fmt.Sprintf("CRC64 of the copy %d does not match the source %d", destCrc64, srcCrc64), core.ErrCRC64NotMatch)
+}
+
+// modTime returns the time the destination was written, zero if unknown
+func modTime(file core.FileInfo) time.Time {
+	if t, err := time.Parse(time.RFC3339, file.Properties()[core.PropertyName_ContentModTime]); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
+// findExtras reports the objects of the destination which are not the copy of a source file
+func (v *verifier) findExtras() error {
+	job := v.job
+	paths, err := listDest(job)
+	if err != nil {
+		return tracing.Errorf(fmt.Sprintf("failed to list %s", job.Dest), err)
+	}
+	for _, path := range paths {
+		if v.expected[path] {
+			continue
+		}
+		srcPath := path
+		if enabled(job.Zip) {
+			srcPath = strings.TrimSuffix(path, cryptoSuffix)
+		}
+		if !job.Matches(srcPath) {
+			continue
+		}
+		v.record(path, ActionExtra, time.Now(), nil)
+	}
+	return nil
+}
+
+// listDest returns the relative paths of the files of the destination of job
+func listDest(job *Job) ([]string, error) {
+	paths := make([]string, 0)
+	switch core.ResolveUriType(job.Dest) {
+	case core.FileType_Physical:
+		err := filepath.WalkDir(job.Dest, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(d.Name(), ".") && path != job.Dest {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.IsDir() {
+				paths = append(paths, strings.TrimPrefix(filepath.ToSlash(path), strings.TrimSuffix(job.Dest, "/")))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+	case core.FileType_AliOSS:
+		aliCfg, err := core.LoadAliOSSConfig(job.Credentials)
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		token := ""
+		for {
+			bk, err := core.LsAliOss(job.ctx, aliCfg, job.Dest, token)
+			if err != nil {
+				return nil, tracing.Error(err)
+			}
+			for _, object := range bk.Objects {
+				paths = append(paths, "/"+strings.TrimPrefix(object.RelativePath, "/"))
+			}
+			if !bk.IsTruncated {
+				break
+			}
+			token = bk.ContinueToken
+		}
+	case core.FileType_SFTP, core.FileType_WebDAV, core.FileType_AzureBlob, core.FileType_GCS:
+		backend, err := core.OpenBackend(job.ctx, job.Dest, job.Credentials)
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		token := ""
+		for {
+			page, err := backend.List(job.ctx, "", token)
+			if err != nil {
+				return nil, tracing.Error(err)
+			}
+			for _, stat := range page.Files {
+				paths = append(paths, "/"+stat.RelativePath)
+			}
+			if !page.IsTruncated {
+				break
+			}
+			token = page.ContinueToken
+		}
+	default:
+		return nil, fmt.Errorf("unknown file type of %s", job.Dest)
+	}
+	return paths, nil
+}
+
+// record reports the result of a file to the journal, the summary and the log
+func (v *verifier) record(relativePath string, action string, start time.Time, err error) {
+	job := v.job
+	v.report.add(action)
+	job.journal.Record(relativePath, action, 0, time.Since(start), err)
+	job.summary.add(relativePath, action, 0, err)
+	switch action {
+	case ActionVerified:
+		logging.Debug(fmt.Sprintf("File [%s] is verified", relativePath), nil)
+	case ActionFailed:
+		recordFailure(job, err)
+		logging.Error(tracing.Errorf(fmt.Sprintf("Failed to verify file [%s]", relativePath), err), nil)
+	case ActionExtra:
+		logging.Warn(fmt.Sprintf("Object [%s] has no source file", relativePath), nil)
+	default:
+		if action == ActionCorrupted {
+			crcMismatches.WithLabelValues(job.Name).Inc()
+		}
+		if err != nil {
+			logging.Warn(fmt.Sprintf("File [%s] is %s: %s", relativePath, action, tracing.Message(err)), nil)
+		} else {
+			logging.Warn(fmt.Sprintf("File [%s] is %s", relativePath, action), nil)
+		}
+	}
+}
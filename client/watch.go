@@ -0,0 +1,275 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"osssync/common/config"
+	"osssync/common/inotify"
+	"osssync/common/logging"
+	"osssync/common/tracing"
+	"osssync/core"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// writingTimeout is how long a file being written without closing is waited for,
+// a writer keeping the file open would otherwise delay it until the next reconciliation
+const writingTimeout = time.Minute
+
+type watchState struct {
+	job      *Job
+	debounce time.Duration
+
+	// pending is the time of the last event of the changed files and directories
+	pending map[string]time.Time
+	dirs    map[string]bool
+	writing map[string]bool
+
+	// a batch of pushes or a reconciliation runs in background, one at a time
+	batch     chan struct{}
+	reconcile bool
+}
+
+// Watch pushes the files of sourcePath as soon as they are written, by the inotify events of the whole tree.
+// Bursts of writes are debounced, files are pushed after they are closed,
+// and a full reconciliation by PushDir catches whatever the events missed.
+func Watch(job *Job) error {
+	sourcePath := job.Source
+	debounce, err := durationOrDefault(core.Arg_WatchDebounce, 2*time.Second)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	reconcileInterval, err := durationOrDefault(core.Arg_WatchReconcile, time.Hour)
+	if err != nil {
+		return tracing.Error(err)
+	}
+
+	watcher, err := inotify.NewWatcher()
+	if err != nil {
+		return tracing.Error(err)
+	}
+	defer watcher.Close()
+	err = watcher.AddRecursive(sourcePath)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	logging.Info(fmt.Sprintf("Watching %s, debounce %s, reconcile every %s", sourcePath, debounce, reconcileInterval), nil)
+
+	state := &watchState{
+		job:      job,
+		debounce: debounce,
+		pending:  make(map[string]time.Time),
+		dirs:     make(map[string]bool),
+		writing:  make(map[string]bool),
+		// the events received during the first walk are pushed by the walk itself or after it
+		reconcile: true,
+	}
+
+	tick := debounce / 2
+	if tick < 100*time.Millisecond {
+		tick = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	reconcileTicker := time.NewTicker(reconcileInterval)
+	defer reconcileTicker.Stop()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return tracing.Error(inotify.ErrWatcherClosed)
+			}
+			state.handle(watcher, event)
+		case err, ok := <-watcher.Errors:
+			if ok {
+				return tracing.Error(err)
+			}
+		case <-reconcileTicker.C:
+			state.reconcile = true
+		case <-job.Done():
+			if state.batch != nil {
+				<-state.batch
+			}
+			return tracing.Error(ErrCanceled)
+		case <-ticker.C:
+		}
+		state.flush()
+	}
+}
+
+func (state *watchState) handle(watcher *inotify.Watcher, event inotify.Event) {
+	if event.Op&inotify.Overflow != 0 {
+		logging.Warn("Too many file events, some are lost, reconciling", nil)
+		// directories created meanwhile may be unwatched
+		if err := watcher.AddRecursive(state.job.Source); err != nil {
+			logging.Error(tracing.Error(err), nil)
+		}
+		state.reconcile = true
+		return
+	}
+	if state.ignored(event.Path, event.IsDir) {
+		return
+	}
+
+	now := time.Now()
+	if event.IsDir {
+		if event.Op&(inotify.Create|inotify.MovedTo) != 0 {
+			// files may be created before the watch is added, so the whole directory is pushed
+			if err := watcher.AddRecursive(event.Path); err != nil {
+				logging.Error(tracing.Error(err), nil)
+			}
+			state.pending[event.Path] = now
+			state.dirs[event.Path] = true
+		}
+		return
+	}
+
+	switch {
+	case event.Op&inotify.Remove != 0:
+		delete(state.pending, event.Path)
+		delete(state.writing, event.Path)
+	case event.Op&(inotify.CloseWrite|inotify.MovedTo) != 0:
+		delete(state.writing, event.Path)
+		state.pending[event.Path] = now
+	case event.Op&(inotify.Create|inotify.Modify) != 0:
+		state.writing[event.Path] = true
+		state.pending[event.Path] = now
+	}
+}
+
+// ignored returns true for the hidden and the excluded files and directories, which PushDir skips as well
+func (state *watchState) ignored(path string, isDir bool) bool {
+	relativePath, err := filepath.Rel(state.job.Source, path)
+	if err != nil {
+		return true
+	}
+	names := strings.Split(relativePath, string(filepath.Separator))
+	for i, name := range names {
+		if strings.HasPrefix(name, ".") && name != "." {
+			return true
+		}
+		if state.job.Excludes(strings.Join(names[:i+1], "/")) {
+			return true
+		}
+	}
+	return !isDir && !state.job.Matches(relativePath)
+}
+
+func (state *watchState) flush() {
+	if state.batch != nil {
+		select {
+		case <-state.batch:
+			state.batch = nil
+		default:
+			return
+		}
+	}
+
+	if state.reconcile {
+		state.reconcile = false
+		// a full walk covers every pending change
+		state.pending = make(map[string]time.Time)
+		state.dirs = make(map[string]bool)
+		state.writing = make(map[string]bool)
+		state.run(func() {
+			logging.Info(fmt.Sprintf("Reconciling %s", state.job.Source), nil)
+			if err := PushDir(state.job, state.job.Source); err != nil {
+				logging.Error(err, nil)
+			}
+		})
+		return
+	}
+
+	now := time.Now()
+	dirs := make([]string, 0)
+	files := make([]string, 0)
+	for path, lastEvent := range state.pending {
+		quiet := now.Sub(lastEvent)
+		if quiet < state.debounce || (state.writing[path] && quiet < writingTimeout) {
+			continue
+		}
+		if state.dirs[path] {
+			dirs = append(dirs, path)
+		} else {
+			files = append(files, path)
+		}
+		delete(state.pending, path)
+		delete(state.dirs, path)
+		delete(state.writing, path)
+	}
+	if len(dirs) == 0 && len(files) == 0 {
+		return
+	}
+
+	state.run(func() {
+		for _, dir := range dirs {
+			if underAny(dir, dirs) {
+				continue
+			}
+			logging.Info(fmt.Sprintf("Enter directory %s", dir), nil)
+			if err := PushDir(state.job, dir); err != nil {
+				logging.Error(err, nil)
+			}
+		}
+		var wg sync.WaitGroup
+		for _, path := range files {
+			if underAny(path, dirs) {
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				logging.Debug(fmt.Sprintf("File [%s] is gone before pushed", path), nil)
+				continue
+			}
+			relativePath := strings.TrimPrefix(path, state.job.Source)
+			if !state.job.acquire() {
+				break
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer state.job.release()
+				start := time.Now()
+				err := PushFile(state.job, relativePath, nil)
+				reportResult(state.job, relativePath, info.Size(), start, nil, err)
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+func underAny(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (state *watchState) run(batch func()) {
+	done := make(chan struct{})
+	state.batch = done
+	go func() {
+		defer close(done)
+		batch()
+	}()
+}
+
+func durationOrDefault(k string, defaultValue time.Duration) (time.Duration, error) {
+	v := config.GetStringOrDefault(k, "")
+	if v == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, tracing.Errorf(fmt.Sprintf("invalid %s", k), err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s must be positive", k)
+	}
+	return d, nil
+}
@@ -0,0 +1,225 @@
+package bandwidth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const mb = 1024 * 1024
+
+// Schedule is the rate limit by the time of day, in bytes per second, 0 is unlimited
+type Schedule struct {
+	Windows []Window
+	// Default applies outside of the windows
+	Default int64
+}
+
+// Window is a time of day range with its own rate, from Start included to End excluded,
+// a window ending before it starts spans midnight
+type Window struct {
+	Start time.Duration
+	End   time.Duration
+	Rate  int64
+}
+
+// Parse reads a schedule of rates in MB/s separated by commas: a window "HH:MM-HH:MM=rate"
+// or a rate applying outside of the windows, "unlimited" or 0 removes the limit, e.g.
+//
+//	"2"                   2 MB/s all day
+//	"09:00-18:00=2"       2 MB/s from 9:00 to 18:00, unlimited at night
+//	"09:00-18:00=2,10"    2 MB/s from 9:00 to 18:00, 10 MB/s otherwise
+func Parse(s string) (Schedule, error) {
+	schedule := Schedule{}
+	defaultSet := false
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		window, rate, ok := strings.Cut(field, "=")
+		if !ok {
+			if defaultSet {
+				return Schedule{}, fmt.Errorf("bandwidth %s has more than one default rate", s)
+			}
+			r, err := parseRate(field)
+			if err != nil {
+				return Schedule{}, err
+			}
+			schedule.Default = r
+			defaultSet = true
+			continue
+		}
+		start, end, ok := strings.Cut(window, "-")
+		if !ok {
+			return Schedule{}, fmt.Errorf("invalid bandwidth window %s, expect HH:MM-HH:MM", window)
+		}
+		w := Window{}
+		var err error
+		if w.Start, err = parseTimeOfDay(start); err != nil {
+			return Schedule{}, err
+		}
+		if w.End, err = parseTimeOfDay(end); err != nil {
+			return Schedule{}, err
+		}
+		if w.Start == w.End {
+			return Schedule{}, fmt.Errorf("bandwidth window %s is empty", window)
+		}
+		if w.Rate, err = parseRate(rate); err != nil {
+			return Schedule{}, err
+		}
+		schedule.Windows = append(schedule.Windows, w)
+	}
+	return schedule, nil
+}
+
+func parseRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "unlimited") {
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid bandwidth %s, expect MB/s or unlimited", s)
+	}
+	return int64(v * mb), nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %s, expect HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Rate returns the rate at t in bytes per second, the first window containing t wins
+func (schedule Schedule) Rate(t time.Time) int64 {
+	hour, min, sec := t.Clock()
+	clock := time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute + time.Duration(sec)*time.Second
+	for _, w := range schedule.Windows {
+		if w.Start < w.End && clock >= w.Start && clock < w.End {
+			return w.Rate
+		}
+		if w.Start > w.End && (clock >= w.Start || clock < w.End) {
+			return w.Rate
+		}
+	}
+	return schedule.Default
+}
+
+func (schedule Schedule) String() string {
+	fields := make([]string, 0, len(schedule.Windows)+1)
+	for _, w := range schedule.Windows {
+		fields = append(fields, fmt.Sprintf("%02d:%02d-%02d:%02d=%s", int(w.Start.Hours()), int(w.Start.Minutes())%60,
+			int(w.End.Hours()), int(w.End.Minutes())%60, formatRate(w.Rate)))
+	}
+	fields = append(fields, formatRate(schedule.Default))
+	return strings.Join(fields, ",")
+}
+
+func formatRate(rate int64) string {
+	if rate <= 0 {
+		return "unlimited"
+	}
+	return strconv.FormatFloat(float64(rate)/mb, 'f', -1, 64) + "MB/s"
+}
+
+// Limiter is a token bucket shared by all the streams it throttles, its rate follows a schedule.
+// A bucket holds the bytes of a second at most, so that an idle period allows a short burst only.
+// A nil Limiter is unlimited.
+type Limiter struct {
+	lock     sync.Mutex
+	schedule Schedule
+	tokens   float64
+	last     time.Time
+	now      func() time.Time
+}
+
+func NewLimiter(schedule Schedule) *Limiter {
+	return &Limiter{schedule: schedule, now: time.Now}
+}
+
+// Rate returns the current rate in bytes per second, 0 is unlimited
+func (limiter *Limiter) Rate() int64 {
+	if limiter == nil {
+		return 0
+	}
+	return limiter.schedule.Rate(limiter.now())
+}
+
+// reserve takes n tokens and returns how long to wait for the debt to be paid
+func (limiter *Limiter) reserve(n int) time.Duration {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	now := limiter.now()
+	rate := float64(limiter.schedule.Rate(now))
+	if rate <= 0 {
+		limiter.tokens = 0
+		limiter.last = now
+		return 0
+	}
+	if !limiter.last.IsZero() {
+		limiter.tokens += now.Sub(limiter.last).Seconds() * rate
+	}
+	if limiter.tokens > rate {
+		limiter.tokens = rate
+	}
+	limiter.last = now
+	limiter.tokens -= float64(n)
+	if limiter.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-limiter.tokens / rate * float64(time.Second))
+}
+
+// WaitN waits until n bytes may be transferred, or ctx is done
+func (limiter *Limiter) WaitN(ctx context.Context, n int) error {
+	if limiter == nil || n <= 0 {
+		return nil
+	}
+	delay := limiter.reserve(n)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Reader throttles the bytes read from r
+func (limiter *Limiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if limiter == nil {
+		return r
+	}
+	return &reader{ctx: ctx, r: r, limiter: limiter}
+}
+
+// maxRead bounds a read so that a large buffer does not stall the other streams for seconds
+const maxRead = 64 * 1024
+
+type reader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *Limiter
+}
+
+func (reader *reader) Read(p []byte) (int, error) {
+	if len(p) > maxRead && reader.limiter.Rate() > 0 {
+		p = p[:maxRead]
+	}
+	n, err := reader.r.Read(p)
+	if waitErr := reader.limiter.WaitN(reader.ctx, n); waitErr != nil {
+		return n, waitErr
+	}
+	return n, err
+}
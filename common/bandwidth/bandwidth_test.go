@@ -0,0 +1,91 @@
+package bandwidth
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func at(clock string) time.Time {
+	t, _ := time.Parse("2006-01-02 15:04", "2024-05-06 "+clock)
+	return t
+}
+
+func TestParse(t *testing.T) {
+	schedule, err := Parse("09:00-18:00=2, 22:00-06:00=unlimited, 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for clock, expected := range map[string]int64{
+		"08:59": 10 * mb,
+		"09:00": 2 * mb,
+		"17:59": 2 * mb,
+		"18:00": 10 * mb,
+		"23:30": 0,
+		"05:59": 0,
+		"06:00": 10 * mb,
+	} {
+		if rate := schedule.Rate(at(clock)); rate != expected {
+			t.Errorf("%s: expect %d, got %d", clock, expected, rate)
+		}
+	}
+	if s := schedule.String(); s != "09:00-18:00=2MB/s,22:00-06:00=unlimited,10MB/s" {
+		t.Errorf("unexpected string %s", s)
+	}
+
+	if schedule, err := Parse("0.5"); err != nil || schedule.Rate(at("12:00")) != mb/2 {
+		t.Fatalf("unexpected all day rate %v %v", schedule, err)
+	}
+	for _, invalid := range []string{"fast", "-1", "9-18=2", "09:00-09:00=2", "25:00-06:00=1", "1,2"} {
+		if _, err := Parse(invalid); err == nil {
+			t.Errorf("%s: expect an error", invalid)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	schedule, _ := Parse("09:00-18:00=1")
+	limiter := NewLimiter(schedule)
+	now := at("12:00")
+	limiter.now = func() time.Time { return now }
+
+	if delay := limiter.reserve(mb / 2); delay != 500*time.Millisecond {
+		t.Fatalf("expect 500ms for half a second of bytes, got %s", delay)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if delay := limiter.reserve(mb); delay != time.Second {
+		t.Fatalf("expect 1s, got %s", delay)
+	}
+	// an idle hour refills the bytes of a second only
+	now = now.Add(time.Hour)
+	if delay := limiter.reserve(2 * mb); delay != time.Second {
+		t.Fatalf("expect the burst bounded to 1s, got %s", delay)
+	}
+
+	now = at("20:00")
+	if delay := limiter.reserve(100 * mb); delay != 0 {
+		t.Fatalf("expect no limit at night, got %s", delay)
+	}
+
+	var unlimited *Limiter
+	if err := unlimited.WaitN(context.Background(), mb); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLimiterReader(t *testing.T) {
+	schedule, _ := Parse("0.1")
+	limiter := NewLimiter(schedule)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	n, err := io.Copy(io.Discard, limiter.Reader(ctx, bytes.NewReader(make([]byte, mb))))
+	if err != context.DeadlineExceeded || n >= mb {
+		t.Fatalf("expect the read stopped by the deadline, got %d bytes, %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("read is not stopped by its context, %s", elapsed)
+	}
+}
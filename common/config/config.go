@@ -19,9 +19,15 @@ import (
 
 var rootPath string
 var data map[string]interface{}
+var dataLock sync.RWMutex
 var initDataOnce sync.Once
 
+// files records the modification time of every config file read, so that Reload knows what changed
+var files = make(map[string]time.Time)
+
 func Print() {
+	dataLock.RLock()
+	defer dataLock.RUnlock()
 	fmt.Println("-------- config --------")
 	for k, v := range data {
 		fmt.Printf("%s: %v\n", k, v)
@@ -37,6 +43,8 @@ func GetRootPath() string {
 }
 
 func IsAvailable() bool {
+	dataLock.RLock()
+	defer dataLock.RUnlock()
 	return data != nil
 }
 
@@ -66,7 +74,66 @@ func AttachFile(filePath string) error {
 	return nil
 }
 
+// Reload reads the config files again if any of them (or the yaml files of the root path) changed since they were read,
+// values of the files overwrite the current ones as they did at startup.
+func Reload() (bool, error) {
+	changed := false
+	dataLock.RLock()
+	for filePath, modTime := range files {
+		stat, err := os.Stat(filePath)
+		if err != nil || !stat.ModTime().Equal(modTime) {
+			changed = true
+			break
+		}
+	}
+	dataLock.RUnlock()
+	if !changed && rootPath != "" {
+		rds, err := os.ReadDir(rootPath)
+		if err != nil {
+			return false, tracing.Error(err)
+		}
+		dataLock.RLock()
+		for _, rd := range rds {
+			if rd.IsDir() || (!strings.HasSuffix(rd.Name(), ".yaml")) {
+				continue
+			}
+			if _, ok := files[filepath.Join(rootPath, rd.Name())]; !ok {
+				changed = true
+				break
+			}
+		}
+		dataLock.RUnlock()
+	}
+	if !changed {
+		return false, nil
+	}
+
+	dataLock.RLock()
+	filePaths := make([]string, 0, len(files))
+	for filePath := range files {
+		if rootPath == "" || filepath.Dir(filePath) != rootPath {
+			filePaths = append(filePaths, filePath)
+		}
+	}
+	dataLock.RUnlock()
+	if rootPath != "" {
+		err := readFromRoot()
+		if err != nil {
+			return false, tracing.Error(err)
+		}
+	}
+	for _, filePath := range filePaths {
+		err := readFromFile(filePath)
+		if err != nil {
+			return false, tracing.Error(err)
+		}
+	}
+	return true, nil
+}
+
 func AttachEnv(k string, overwrite bool) {
+	dataLock.Lock()
+	defer dataLock.Unlock()
 	if v, ok := os.LookupEnv(k); ok {
 		if v == "" {
 			return
@@ -78,10 +145,12 @@ func AttachEnv(k string, overwrite bool) {
 }
 
 func AttachValue(k string, v interface{}) {
+	dataLock.Lock()
 	if data == nil {
 		data = make(map[string]interface{})
 	}
 	data[k] = ConvertToStr(v)
+	dataLock.Unlock()
 	AttachEnv(k, true)
 }
 
@@ -104,8 +173,27 @@ func BindYaml(filePath string, receiver any) error {
 	return nil
 }
 
+// BindValue binds the value of k, usually a nested mapping of the config files, to receiver by its yaml tags
+func BindValue(k string, receiver any) (bool, error) {
+	v, ok := findPath(k)
+	if !ok {
+		return false, nil
+	}
+	buffer, err := yaml.Marshal(v)
+	if err != nil {
+		return false, tracing.Error(err)
+	}
+	err = yaml.Unmarshal(buffer, receiver)
+	if err != nil {
+		return false, tracing.Error(err)
+	}
+	return true, nil
+}
+
 func findPath(path string) (interface{}, bool) {
 	PanicIfNotAvailable()
+	dataLock.RLock()
+	defer dataLock.RUnlock()
 	ks := strings.Split(path, ".")
 	if len(ks) == 0 {
 		return nil, false
@@ -119,8 +207,15 @@ func findV(ks []string, index int, data interface{}) (interface{}, bool) {
 	}
 	k := ks[index]
 	if reflect.TypeOf(data).Kind() == reflect.Map {
-		m := data.(map[string]interface{})
-		v, ok := m[k]
+		var v interface{}
+		var ok bool
+		switch m := data.(type) {
+		case map[string]interface{}:
+			v, ok = m[k]
+		// nested mappings of yaml files
+		case map[interface{}]interface{}:
+			v, ok = m[k]
+		}
 		if !ok {
 			return nil, false
 		}
@@ -256,14 +351,15 @@ func readFromRoot() error {
 }
 
 func readFromFile(filePath string) error {
-	if data == nil {
-		data = make(map[string]interface{})
-	}
 	f, err := os.Open(filePath)
 	if err != nil {
 		return tracing.Error(err)
 	}
 	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return tracing.Error(err)
+	}
 
 	buffer, err := ioutil.ReadAll(f)
 	if err != nil {
@@ -276,9 +372,15 @@ func readFromFile(filePath string) error {
 		return tracing.Error(err)
 	}
 
+	dataLock.Lock()
+	defer dataLock.Unlock()
+	if data == nil {
+		data = make(map[string]interface{})
+	}
 	for k, v := range values {
 		data[k] = v
 	}
+	files[filePath] = stat.ModTime()
 
 	return nil
 }
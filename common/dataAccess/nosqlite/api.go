@@ -2,6 +2,7 @@ package nosqlite
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -56,17 +57,23 @@ func CreateTableIfNotExists[T NoSqliteEntity]() error {
 }
 
 func Set[T NoSqliteEntity](name string, data T, indexes ...KV) error {
+	return SetMany([]Item[T]{{Name: name, Data: data, Indexes: indexes}})
+}
+
+// Item is a record of SetMany
+type Item[T NoSqliteEntity] struct {
+	Name    string
+	Data    T
+	Indexes []KV
+}
+
+// SetMany sets the records of items by a single transaction
+func SetMany[T NoSqliteEntity](items []Item[T]) error {
 	err := CreateTableIfNotExists[T]()
 	if err != nil {
 		return tracing.Error(err)
 	}
 
-	var payload string
-	jsonData, err := json.Marshal(data)
-	if err == nil {
-		payload = string(jsonData)
-	}
-
 	db, err := Factory.CreateConnection(context.Background())
 	if err != nil {
 		return tracing.Error(err)
@@ -78,42 +85,63 @@ func Set[T NoSqliteEntity](name string, data T, indexes ...KV) error {
 	}
 	defer tx.Rollback()
 
-	obj, err := Get[T](name)
-	if err != nil && err != ErrRecordNotFound {
+	for _, item := range items {
+		err = set(tx, item)
+		if err != nil {
+			return tracing.Error(err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return tracing.Error(err)
+	}
+
+	return nil
+}
+
+func set[T NoSqliteEntity](tx *sql.Tx, item Item[T]) error {
+	var payload string
+	jsonData, err := json.Marshal(item.Data)
+	if err == nil {
+		payload = string(jsonData)
+	}
+
+	// the id of the row is not the id of the entity, the row is found by name
+	var objID string
+	err = tx.QueryRow(fmt.Sprintf(`SELECT "id" FROM "%s" WHERE "name" = ?`, (*new(T)).TableName()), item.Name).Scan(&objID)
+	if err != nil && !IfNoRows(err) {
 		return tracing.Error(err)
 	}
 
-	objID := GenerateUUID()
-	sql := ""
+	query := ""
 	args := make([]interface{}, 0)
-	if err == ErrRecordNotFound {
-		sql = fmt.Sprintf(`INSERT INTO "%s" ("id", "name", "data") VALUES (?, ?, ?)`, (*new(T)).TableName())
-		args = append(args, objID, name, payload)
+	if objID == "" {
+		objID = GenerateUUID()
+		query = fmt.Sprintf(`INSERT INTO "%s" ("id", "name", "data") VALUES (?, ?, ?)`, (*new(T)).TableName())
+		args = append(args, objID, item.Name, payload)
 	} else {
-		sql = fmt.Sprintf(`UPDATE "%s" SET "data" = ? WHERE "id" = ?`, (*new(T)).TableName())
-		args = append(args, payload, obj.ID())
-		objID = obj.ID()
+		query = fmt.Sprintf(`UPDATE "%s" SET "data" = ? WHERE "id" = ?`, (*new(T)).TableName())
+		args = append(args, payload, objID)
 	}
 
-	_, err = tx.Exec(sql, args...)
+	_, err = tx.Exec(query, args...)
 	if err != nil {
 		return tracing.Error(err)
 	}
 
 	err = RemoveIndex[T](tx, objID)
+	if err != nil {
+		return tracing.Error(err)
+	}
 
-	for _, index := range indexes {
-		err = SetIndex[T](tx, objID, index.K, index.V)
+	// the indexes of the object are removed, they are added without looking for them
+	for _, index := range item.Indexes {
+		err = AddIndex[T](tx, objID, index.K, index.V)
 		if err != nil {
 			return tracing.Error(err)
 		}
 	}
-
-	err = tx.Commit()
-	if err != nil {
-		return tracing.Error(err)
-	}
-
 	return nil
 }
 
@@ -176,21 +204,27 @@ func GetByIndex[T NoSqliteEntity](indexes ...KV) ([]T, error) {
 		}
 		return nil, tracing.Error(err)
 	}
-	ids := make([]string, 0)
+	items := make([]T, 0)
+	if len(idxes) == 0 {
+		return items, nil
+	}
+	ids := make([]interface{}, 0, len(idxes))
+	placeholders := make([]string, 0, len(idxes))
 	for _, idx := range idxes {
-		ids = append(ids, idx.ID)
+		ids = append(ids, idx.ObjectID)
+		placeholders = append(placeholders, "?")
 	}
 
-	sql := fmt.Sprintf(`SELECT "data" FROM "%s" WHERE "id" IN (?)`, (*new(T)).TableName())
-	rows, err := tx.Query(sql, ids)
+	sql := fmt.Sprintf(`SELECT "data" FROM "%s" WHERE "id" IN (%s)`, (*new(T)).TableName(), strings.Join(placeholders, ","))
+	rows, err := tx.Query(sql, ids...)
 	if err != nil {
 		if IfNoRows(err) {
 			return nil, ErrRecordNotFound
 		}
 		return nil, tracing.Error(err)
 	}
+	defer rows.Close()
 
-	items := make([]T, 0)
 	for rows.Next() {
 		var payload string
 		err = rows.Scan(&payload)
@@ -261,6 +295,10 @@ func Remove[T NoSqliteEntity](name string) error {
 	defer db.Close()
 
 	tx, err := db.Begin()
+	if err != nil {
+		return tracing.Error(err)
+	}
+	defer tx.Rollback()
 	var id string
 	err = tx.QueryRow(fmt.Sprintf(`SELECT "id" FROM "%s" WHERE "name" = ?`, (*new(T)).TableName()), name).Scan(&id)
 	if err != nil {
@@ -270,16 +308,59 @@ func Remove[T NoSqliteEntity](name string) error {
 		return tracing.Error(err)
 	}
 
-	_, err = db.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE "id" = ?`, (*new(T)).TableName()), id)
+	_, err = tx.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE "id" = ?`, (*new(T)).TableName()), id)
 	if err != nil {
 		return tracing.Error(err)
 	}
-	tableName := (*new(T)).TableName()
-	_, err = db.Exec(fmt.Sprintf(`DELETE FROM "%s_dyanmicidx" WHERE "object_id" = ?`, tableName), id)
+	err = RemoveIndex[T](tx, id)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return tracing.Error(err)
+	}
+
+	return nil
+}
+
+// RemoveByIndex removes every record matching all the indexes
+func RemoveByIndex[T NoSqliteEntity](indexes ...KV) error {
+	err := CreateTableIfNotExists[T]()
 	if err != nil {
 		return tracing.Error(err)
 	}
-	tx.Commit()
 
+	db, err := Factory.CreateConnection(context.Background())
+	if err != nil {
+		return tracing.Error(err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return tracing.Error(err)
+	}
+	defer tx.Rollback()
+
+	idxes, err := GetIndexes[T](tx, indexes...)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	tableName := (*new(T)).TableName()
+	for _, idx := range idxes {
+		_, err = tx.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE "id" = ?`, tableName), idx.ObjectID)
+		if err != nil {
+			return tracing.Error(err)
+		}
+		err = RemoveIndex[T](tx, idx.ObjectID)
+		if err != nil {
+			return tracing.Error(err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return tracing.Error(err)
+	}
 	return nil
 }
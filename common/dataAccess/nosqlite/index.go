@@ -120,6 +120,17 @@ func SetIndex[T NoSqliteEntity](db *sql.Tx, objId string, name string, value str
 	return nil
 }
 
+// AddIndex adds an index of an object which does not have one of the name, e.g. once its indexes are removed
+func AddIndex[T NoSqliteEntity](db *sql.Tx, objId string, name string, value string) error {
+	tableName := (*new(T)).TableName()
+	_, err := db.Exec(fmt.Sprintf(`INSERT INTO "%s_dyanmicidx" ("id", "object_id", "field_name", "field_value") VALUES (?, ?, ?, ?)`, tableName),
+		GenerateUUID(), objId, name, value)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	return nil
+}
+
 func RemoveIndex[T NoSqliteEntity](db *sql.Tx, objId string) error {
 	tableName := (*new(T)).TableName()
 	_, err := db.Exec(fmt.Sprintf(`DELETE FROM "%s_dyanmicidx" WHERE "object_id" = ?`, tableName), objId)
@@ -1,18 +1,30 @@
 package distributedstorage
 
 import (
+	"bytes"
+	"errors"
 	"io"
 	"os"
 	"strings"
 )
 
+var ErrUnknownFormat error = errors.New("unknown distributed file format")
+var ErrNegativeOffset error = errors.New("negative offset")
+var ErrInvalidWhence error = errors.New("invalid whence")
+
 type DistributedFile interface {
 	io.Writer
 	io.Reader
+	io.ReaderAt
+	io.Seeker
 	io.Closer
 
+	// Size returns the size of the decoded file
+	Size() (int64, error)
 	WriteHeader(version int64, fileSize int64) error
 	RebuildBlk() error
+	// Scrub walks all stripes to find missing or corrupted shards, and repairs them in place if repair is true
+	Scrub(repair bool) (*HealthReport, error)
 }
 
 func OpenFile(path string, createIfNotExists bool) (*os.File, error) {
@@ -34,3 +46,45 @@ func OpenFile(path string, createIfNotExists bool) (*os.File, error) {
 		return os.OpenFile(path, os.O_RDWR, 0755)
 	}
 }
+
+// OpenDistributedFile opens the shards of an existing distributed file,
+// the format is detected from the shard headers so both V5 and V6 files can be read.
+func OpenDistributedFile(paths []string) (DistributedFile, error) {
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		magic := make([]byte, len(ShardMagic))
+		_, err = io.ReadFull(f, magic)
+		f.Close()
+		if err == nil && bytes.Equal(magic, ShardMagic[:]) {
+			return OpenV6(paths)
+		}
+	}
+	if len(paths) == 3 {
+		return OpenV5([3]string{paths[0], paths[1], paths[2]}, false)
+	}
+	return nil, ErrUnknownFormat
+}
+
+func seekPosition(current int64, fileSize int64, offset int64, whence int) (int64, error) {
+	var position int64
+	switch whence {
+	case io.SeekStart:
+		position = offset
+	case io.SeekCurrent:
+		position = current + offset
+	case io.SeekEnd:
+		position = fileSize + offset
+	default:
+		return 0, ErrInvalidWhence
+	}
+	if position < 0 {
+		return 0, ErrNegativeOffset
+	}
+	return position, nil
+}
@@ -3,19 +3,29 @@ package distributedstorage
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"sync"
 )
 
+const VersionV5 = 5
+
+var ErrFrameCorrupted error = errors.New("frame corrupted")
+
 type DistributedFileV5 struct {
-	filePaths   [3]string
-	files       []*os.File
-	eof         bool
-	eofLock     sync.Mutex
-	offset      int64
-	decodedSize int64
+	filePaths [3]string
+	files     []*os.File
+	eof       bool
+	eofLock   sync.Mutex
+	// offset in the shards, used by the sequential walks of RebuildBlk and Scrub
+	offset int64
+	// position is the logical offset of Read
+	position int64
+
+	fileSize     int64
+	fileSizeLock sync.Mutex
 
 	missingPart int
 	rebuildBlk  *os.File
@@ -26,6 +36,7 @@ func OpenV5(path [3]string, createIfNotExists bool) (DistributedFile, error) {
 		filePaths: path,
 		files:     make([]*os.File, 3),
 		offset:    8,
+		fileSize:  -1,
 	}
 	var err error
 	dfile.files[0], err = OpenFile(path[0], createIfNotExists)
@@ -173,24 +184,36 @@ func (dfile *DistributedFileV5) WriteHeader(version int64, fileSize int64) error
 	dfile.files[2].WriteAt(bts[2], 0)
 
 	dfile.offset = 8
+	dfile.fileSizeLock.Lock()
+	dfile.fileSize = fileSize
+	dfile.fileSizeLock.Unlock()
 	return nil
 }
 
 func (dfile *DistributedFileV5) readHeader() (ver int64, fileSize int64, err error) {
+	miss, err := dfile.CheckMissing()
+	if err != nil {
+		return 0, 0, err
+	}
 	buf := [][]byte{
 		make([]byte, 8),
 		make([]byte, 8),
 		make([]byte, 8),
 	}
-	dfile.files[0].ReadAt(buf[0], 0)
-	dfile.files[1].ReadAt(buf[1], 0)
-	dfile.files[2].ReadAt(buf[2], 0)
-	frames := GetFrames(buf)
-	if _, _, ok := CheckFrameV5(frames[0]); !ok {
-		fmt.Println(fmt.Sprintf("frame %v is not valid", 0))
+	for i, f := range dfile.files {
+		if f == nil {
+			continue
+		}
+		if _, err := f.ReadAt(buf[i], 0); err != nil {
+			return 0, 0, err
+		}
 	}
-	if _, _, ok := CheckFrameV5(frames[1]); !ok {
-		fmt.Println(fmt.Sprintf("frame %v is not valid", 1))
+	frames := GetFrames(buf)
+	for i, frame := range frames {
+		frames[i], err = dfile.checkFrame(miss, frame)
+		if err != nil {
+			return 0, 0, fmt.Errorf("header frame %d: %w", i, err)
+		}
 	}
 	verb := DecodeFrameV5(frames[0])
 	sizeb := DecodeFrameV5(frames[1])
@@ -199,6 +222,17 @@ func (dfile *DistributedFileV5) readHeader() (ver int64, fileSize int64, err err
 	return int64(verv), int64(sizev), nil
 }
 
+// checkFrame rebuilds the fields of the missing shard, or checks the xor fields if no shard is missing
+func (dfile *DistributedFileV5) checkFrame(miss int, frame [3][4]byte) ([3][4]byte, error) {
+	if miss > -1 {
+		return RepairFrameV5(miss, frame), nil
+	}
+	if _, _, ok := CheckFrameV5(frame); !ok {
+		return frame, ErrFrameCorrupted
+	}
+	return frame, nil
+}
+
 func (dfile *DistributedFileV5) readBuf(bufferSize int) (int64, [][]byte, error) {
 	if bufferSize%4 != 0 {
 		return 0, nil, fmt.Errorf("bufferSize %v is not valid", bufferSize)
@@ -212,47 +246,26 @@ func (dfile *DistributedFileV5) readBuf(bufferSize int) (int64, [][]byte, error)
 		make([]byte, bufferSize),
 	}
 
-	if dfile.files[0] != nil {
-		n, err := dfile.files[0].ReadAt(stripeData[0], dfile.offset)
-		if err != nil {
-			if err == io.EOF {
-				dfile.setEof(true)
-				endBuf := make([]byte, n)
-				copy(endBuf, stripeData[0])
-				stripeData[0] = endBuf
-			} else {
-				return 0, stripeData, err
-			}
+	for i, f := range dfile.files {
+		if f == nil {
+			continue
 		}
-		n1 = int64(n)
-	}
-
-	if dfile.files[1] != nil {
-		n, err := dfile.files[1].ReadAt(stripeData[1], dfile.offset)
+		n, err := f.ReadAt(stripeData[i], dfile.offset)
 		if err != nil {
 			if err == io.EOF {
 				dfile.setEof(true)
-				endBuf := make([]byte, n)
-				copy(endBuf, stripeData[1])
-				stripeData[1] = endBuf
 			} else {
 				return 0, stripeData, err
 			}
 		}
+		if int64(n) > n1 {
+			n1 = int64(n)
+		}
 	}
 
-	if dfile.files[2] != nil {
-		n, err := dfile.files[2].ReadAt(stripeData[2], dfile.offset)
-		if err != nil {
-			if err == io.EOF {
-				dfile.setEof(true)
-				endBuf := make([]byte, n)
-				copy(endBuf, stripeData[2])
-				stripeData[2] = endBuf
-			} else {
-				return 0, stripeData, err
-			}
-		}
+	// a missing shard is read as zero, trim every shard to the bytes really read
+	for i := range stripeData {
+		stripeData[i] = stripeData[i][:n1]
 	}
 
 	dfile.offset += int64(n1)
@@ -274,62 +287,99 @@ func (dfile *DistributedFileV5) CheckMissing() (int, error) {
 	}
 	return -1, nil
 }
-func (dfile *DistributedFileV5) Read(p []byte) (n int, err error) {
-	miss, err := dfile.CheckMissing()
+
+// Size returns the size of the decoded file, the header is only read once
+func (dfile *DistributedFileV5) Size() (int64, error) {
+	dfile.fileSizeLock.Lock()
+	defer dfile.fileSizeLock.Unlock()
+	if dfile.fileSize < 0 {
+		_, fileSize, err := dfile.readHeader()
+		if err != nil {
+			return 0, err
+		}
+		dfile.fileSize = fileSize
+	}
+	return dfile.fileSize, nil
+}
+
+// ReadAt decodes the frames covering [off, off+len(p)), every 8 bytes of the file
+// are one frame stored as 4 bytes in each shard after the 8 bytes header
+func (dfile *DistributedFileV5) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, ErrNegativeOffset
+	}
+	fileSize, err := dfile.Size()
 	if err != nil {
 		return 0, err
 	}
-	if miss > -1 {
-		panic(fmt.Sprintf("missing file %v", miss))
+	if off >= fileSize {
+		return 0, io.EOF
 	}
-	bufferSize := len(p) / 8 * 4
-	bufw := bytes.NewBuffer([]byte{})
-
-	_, fileSize, err := dfile.readHeader()
+	end := off + int64(len(p))
+	if end > fileSize {
+		end = fileSize
+	}
+	if end == off {
+		return 0, nil
+	}
+	miss, err := dfile.CheckMissing()
 	if err != nil {
 		return 0, err
 	}
 
-	var readNum int
-
-	for {
-		if readNum == len(p) || dfile.eof {
-			break
-		}
-		// debug: set buffer size to 4, to test each frame decodes correctly
-		//bufferSize = 4
-		_, stripeData, err := dfile.readBuf(bufferSize)
-		if err != nil {
-			return 0, err
+	firstFrame := off / 8
+	lastFrame := (end - 1) / 8
+	bufferSize := (lastFrame - firstFrame + 1) * 4
+	stripeData := make([][]byte, 3)
+	for i, f := range dfile.files {
+		stripeData[i] = make([]byte, bufferSize)
+		if f == nil {
+			continue
 		}
-		frames := GetFrames(stripeData)
-		decodeBuf := bytes.NewBuffer([]byte{})
-		for i, frame := range frames {
-			if _, _, ok := CheckFrameV5(frame); !ok {
-				fmt.Println(fmt.Sprintf("frame %v is not valid", i))
+		if _, err := f.ReadAt(stripeData[i], 8+firstFrame*4); err != nil {
+			if err == io.EOF {
+				return 0, io.ErrUnexpectedEOF
 			}
-			decodeFrame := DecodeFrameV5(frame)
-			decodeBuf.Write(decodeFrame[:])
+			return 0, err
 		}
-		decodeData := decodeBuf.Bytes()
-		bufw.Write(decodeData)
-		readNum += len(decodeData)
 	}
 
-	remainSize := fileSize - dfile.decodedSize
-	if remainSize < int64(readNum) {
-		readNum = int(remainSize)
+	decoded := make([]byte, 0, bufferSize*2)
+	for i, frame := range GetFrames(stripeData) {
+		frame, err = dfile.checkFrame(miss, frame)
+		if err != nil {
+			return 0, fmt.Errorf("frame %d: %w", firstFrame+int64(i), err)
+		}
+		decodeFrame := DecodeFrameV5(frame)
+		decoded = append(decoded, decodeFrame[:]...)
+	}
+	n = copy(p, decoded[off-firstFrame*8:end-firstFrame*8])
+	if n < len(p) {
+		return n, io.EOF
 	}
+	return n, nil
+}
 
-	copy(p, bufw.Bytes())
+func (dfile *DistributedFileV5) Read(p []byte) (n int, err error) {
+	n, err = dfile.ReadAt(p, dfile.position)
+	dfile.position += int64(n)
+	if err == io.EOF && n > 0 {
+		return n, nil
+	}
+	return n, err
+}
 
-	if dfile.eof {
-		dfile.decodedSize += int64(readNum)
-		return readNum, io.EOF
-	} else {
-		dfile.decodedSize += int64(readNum)
-		return readNum, nil
+func (dfile *DistributedFileV5) Seek(offset int64, whence int) (int64, error) {
+	fileSize, err := dfile.Size()
+	if err != nil {
+		return 0, err
 	}
+	position, err := seekPosition(dfile.position, fileSize, offset, whence)
+	if err != nil {
+		return 0, err
+	}
+	dfile.position = position
+	return position, nil
 }
 
 func (dfile *DistributedFileV5) setEof(v bool) {
@@ -340,25 +390,41 @@ func (dfile *DistributedFileV5) setEof(v bool) {
 
 func (dfile *DistributedFileV5) Close() error {
 	for _, f := range dfile.files {
-		f.Close()
+		if f != nil {
+			f.Close()
+		}
 	}
 	return nil
 }
 
 func (dfile *DistributedFileV5) RebuildBlk() error {
+	miss, err := dfile.CheckMissing()
+	if err != nil {
+		return err
+	}
+	if miss == -1 {
+		return nil
+	}
 	dfile.offset = 0
-	miss, _ := dfile.CheckMissing()
-	var err error
+	dfile.setEof(false)
+	defer func() {
+		dfile.offset = 8
+		dfile.setEof(false)
+	}()
 	dfile.rebuildBlk, err = OpenFile(dfile.filePaths[miss], true)
 	if err != nil {
 		return err
 	}
-	defer dfile.rebuildBlk.Close()
+	if err := dfile.rebuildBlk.Truncate(0); err != nil {
+		dfile.rebuildBlk.Close()
+		return err
+	}
 	bufferSize := 2048
 	writeOffset := int64(0)
 	for {
 		n, buf, err := dfile.readBuf(bufferSize)
 		if err != nil {
+			dfile.rebuildBlk.Close()
 			return err
 		}
 		writeBuf := make([]byte, n)
@@ -381,5 +447,87 @@ func (dfile *DistributedFileV5) RebuildBlk() error {
 
 		writeOffset += int64(bufferSize)
 	}
+	// the rebuilt shard takes part in the following reads
+	dfile.files[miss] = dfile.rebuildBlk
 	return nil
 }
+
+// Scrub checks the xor fields of every frame. V5 has only one xor field per line,
+// so a corrupted frame can be detected but not located, only a missing or truncated shard can be repaired.
+func (dfile *DistributedFileV5) Scrub(repair bool) (*HealthReport, error) {
+	report := newHealthReport(VersionV5, dfile.filePaths[:])
+	for i, f := range dfile.files {
+		if f == nil {
+			report.Shards[i].Missing = true
+		}
+	}
+	if _, err := dfile.CheckMissing(); err != nil {
+		return report, ErrTooManyShardsLost
+	}
+
+	sizes := make([]int64, 3)
+	for i, f := range dfile.files {
+		if f == nil {
+			sizes[i] = -1
+			continue
+		}
+		stat, err := f.Stat()
+		if err != nil {
+			return report, err
+		}
+		sizes[i] = stat.Size()
+	}
+	// a shard shorter or longer than the two others is as good as missing
+	for i := range sizes {
+		a, b := sizes[(i+1)%3], sizes[(i+2)%3]
+		if sizes[i] >= 0 && a >= 0 && a == b && sizes[i] != a {
+			diff := a - sizes[i]
+			if diff < 0 {
+				diff = -diff
+			}
+			report.Shards[i].Corrupted = (diff + 3) / 4
+			dfile.files[i].Close()
+			dfile.files[i] = nil
+			break
+		}
+	}
+	miss, _ := dfile.CheckMissing()
+	for i, f := range dfile.files {
+		if f != nil {
+			report.Stripes = sizes[i] / 4
+			break
+		}
+	}
+
+	_, fileSize, err := dfile.readHeader()
+	if err == nil {
+		report.FileSize = fileSize
+	}
+
+	if miss == -1 {
+		dfile.offset = 0
+		dfile.setEof(false)
+		for !dfile.eof {
+			_, buf, err := dfile.readBuf(4096)
+			if err != nil {
+				return report, err
+			}
+			for _, frame := range GetFrames(buf) {
+				if _, _, ok := CheckFrameV5(frame); !ok {
+					report.Unrepairable++
+				}
+			}
+		}
+		dfile.offset = 8
+		dfile.setEof(false)
+		return report, nil
+	}
+
+	if repair {
+		if err := dfile.RebuildBlk(); err != nil {
+			return report, err
+		}
+		report.Shards[miss].Repaired = true
+	}
+	return report, nil
+}
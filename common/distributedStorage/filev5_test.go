@@ -1,66 +1,109 @@
 package distributedstorage
 
 import (
+	"bytes"
+	"errors"
 	"io"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"testing"
 )
 
-func TestDFileV5(t *testing.T) {
-	srcFilePath := "/mnt/c/test/1.bmp"
-	destDir := "/mnt/c/test/"
+func writeSampleFile(t *testing.T, dir string, size int) string {
+	content := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(content)
+	srcFilePath := filepath.Join(dir, "1.bmp")
+	if err := os.WriteFile(srcFilePath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return srcFilePath
+}
+
+func slicePathsV5(dir string) [3]string {
+	return [3]string{
+		filepath.Join(dir, "slice.01"),
+		filepath.Join(dir, "slice.02"),
+		filepath.Join(dir, "slice.03"),
+	}
+}
+
+func writeV5(t *testing.T, srcFilePath string, destDir string) {
 	srcFile, err := os.Open(srcFilePath)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 	defer srcFile.Close()
 	srcStat, err := srcFile.Stat()
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
-	dfile, err := OpenV5([3]string{
-		filepath.Join(destDir, "slice.01"),
-		filepath.Join(destDir, "slice.02"),
-		filepath.Join(destDir, "slice.03"),
-	}, true)
+	dfile, err := OpenV5(slicePathsV5(destDir), true)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 	defer dfile.Close()
-	dfile.WriteHeader(5, srcStat.Size())
+	dfile.WriteHeader(VersionV5, srcStat.Size())
 	n, err := io.Copy(dfile, srcFile)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 	t.Logf("Copied %d bytes", n)
 }
 
-func TestReadFile(t *testing.T) {
-	srcPath := "/mnt/c/test/"
-	destPath := "/mnt/c/test/decoded2.bmp"
-	dfile, err := OpenV5([3]string{
-		filepath.Join(srcPath, "slice.01"),
-		filepath.Join(srcPath, "slice.02"),
-		filepath.Join(srcPath, "slice.03"),
-	}, false)
+func readV5(t *testing.T, srcPath string, destPath string) {
+	dfile, err := OpenV5(slicePathsV5(srcPath), false)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 	defer dfile.Close()
 	destFile, err := os.Create(destPath)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 	defer destFile.Close()
 
 	n, err := io.Copy(destFile, dfile)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 	t.Logf("Copied %d bytes", n)
+}
 
+func assertSameFile(t *testing.T, expectedPath string, actualPath string) {
+	expected, err := os.ReadFile(expectedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Fatalf("%s and %s are different", expectedPath, actualPath)
+	}
+}
+
+func TestDFileV5(t *testing.T) {
+	destDir := t.TempDir()
+	srcFilePath := writeSampleFile(t, destDir, 100003)
+	writeV5(t, srcFilePath, destDir)
+	for _, path := range slicePathsV5(destDir) {
+		if _, err := os.Stat(path); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	srcPath := t.TempDir()
+	srcFilePath := writeSampleFile(t, srcPath, 100003)
+	writeV5(t, srcFilePath, srcPath)
+
+	destPath := filepath.Join(srcPath, "decoded2.bmp")
+	readV5(t, srcPath, destPath)
+	assertSameFile(t, srcFilePath, destPath)
 }
 
 func TestRebuildByteWithXor(t *testing.T) {
@@ -73,12 +116,18 @@ func TestRebuildByteWithXor(t *testing.T) {
 }
 
 func TestRebuildBlk(t *testing.T) {
-	srcPath := "/mnt/c/test/"
-	dfile, err := OpenV5([3]string{
-		filepath.Join(srcPath, "slice.01"),
-		filepath.Join(srcPath, "slice.02"),
-		filepath.Join(srcPath, "slice.03"),
-	}, false)
+	srcPath := t.TempDir()
+	srcFilePath := writeSampleFile(t, srcPath, 100003)
+	writeV5(t, srcFilePath, srcPath)
+	expected, err := os.ReadFile(slicePathsV5(srcPath)[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(slicePathsV5(srcPath)[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	dfile, err := OpenV5(slicePathsV5(srcPath), false)
 	if err != nil {
 		t.Error(err)
 	}
@@ -89,4 +138,94 @@ func TestRebuildBlk(t *testing.T) {
 		t.Error(err)
 	}
 
+	actual, err := os.ReadFile(slicePathsV5(srcPath)[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Error("rebuilt slice is different")
+	}
+}
+
+func TestReadFileDegraded(t *testing.T) {
+	srcPath := t.TempDir()
+	srcFilePath := writeSampleFile(t, srcPath, 100003)
+	writeV5(t, srcFilePath, srcPath)
+	for i, path := range slicePathsV5(srcPath) {
+		dir := t.TempDir()
+		for j, p := range slicePathsV5(srcPath) {
+			if i == j {
+				continue
+			}
+			data, err := os.ReadFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			os.WriteFile(slicePathsV5(dir)[j], data, 0644)
+		}
+		destPath := filepath.Join(dir, "decoded.bmp")
+		readV5(t, dir, destPath)
+		assertSameFile(t, srcFilePath, destPath)
+		t.Logf("read without %s", path)
+	}
+}
+
+func TestScrubV5(t *testing.T) {
+	srcPath := t.TempDir()
+	srcFilePath := writeSampleFile(t, srcPath, 100003)
+	writeV5(t, srcFilePath, srcPath)
+	paths := slicePathsV5(srcPath)
+	expected, err := os.ReadFile(paths[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(paths[2], 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	dfile, err := OpenV5(paths, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := dfile.Scrub(true)
+	dfile.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Shards[2].Corrupted == 0 || !report.Shards[2].Repaired || !report.Healthy() {
+		t.Fatalf("unexpected report %s", report)
+	}
+	actual, err := os.ReadFile(paths[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Fatal("repaired slice is different")
+	}
+
+	first, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(paths[0], os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte{^first[100]}, 100)
+	f.Close()
+	dfile, err = OpenV5(paths, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dfile.Close()
+	report, err = dfile.Scrub(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Unrepairable != 1 || report.Healthy() {
+		t.Fatalf("unexpected report %s", report)
+	}
+	if _, err := io.ReadAll(dfile); !errors.Is(err, ErrFrameCorrupted) {
+		t.Fatalf("expect ErrFrameCorrupted, got %v", err)
+	}
 }
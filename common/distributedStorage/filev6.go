@@ -0,0 +1,530 @@
+package distributedstorage
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"os"
+	"sync"
+)
+
+const VersionV6 = 6
+
+// DefaultStripeSize is the count of bytes every shard contributes to one stripe
+const DefaultStripeSize = 64 * 1024
+
+var ErrHeaderNotWritten error = errors.New("header not written")
+var ErrFileSizeExceeded error = errors.New("file size exceeded")
+var ErrIncompleteWrite error = errors.New("incomplete write")
+
+// DistributedFileV6 splits a file into stripes of DataShards*StripeSize bytes,
+// every stripe is coded with Reed-Solomon into DataShards+ParityShards shards,
+// so the file survives the loss of any ParityShards shard files.
+type DistributedFileV6 struct {
+	filePaths []string
+	files     []*os.File
+	// headers of every shard, nil when the shard is missing or its header is broken
+	headers []*ShardHeader
+	header  *ShardHeader
+	// layout of a file created by CreateV6 before WriteHeader knows its size
+	layout *ShardHeader
+	codec  *ReedSolomon
+
+	writable    bool
+	writeBuf    []byte
+	writeStripe int64
+	written     int64
+	dirty       bool
+
+	// position is the logical offset of Read
+	position int64
+	// the last decoded stripe, shared by Read and ReadAt
+	stripeIdx   int64
+	stripeBytes []byte
+	stripeLock  sync.Mutex
+}
+
+func CreateV6(paths []string, dataShards int, parityShards int, stripeSize int) (DistributedFile, error) {
+	if len(paths) != dataShards+parityShards {
+		return nil, fmt.Errorf("expect %d paths for %d+%d shards, got %d", dataShards+parityShards, dataShards, parityShards, len(paths))
+	}
+	if stripeSize <= 0 {
+		stripeSize = DefaultStripeSize
+	}
+	codec, err := NewReedSolomon(dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+	dfile := &DistributedFileV6{
+		filePaths: paths,
+		files:     make([]*os.File, len(paths)),
+		headers:   make([]*ShardHeader, len(paths)),
+		codec:     codec,
+		stripeIdx: -1,
+	}
+	for i, path := range paths {
+		dfile.files[i], err = OpenFile(path, true)
+		if err != nil {
+			dfile.Close()
+			return nil, err
+		}
+	}
+	dfile.layout = NewShardHeader(dataShards, parityShards, stripeSize, 0)
+	return dfile, nil
+}
+
+func OpenV6(paths []string) (DistributedFile, error) {
+	dfile := &DistributedFileV6{
+		filePaths: paths,
+		files:     make([]*os.File, len(paths)),
+		headers:   make([]*ShardHeader, len(paths)),
+		stripeIdx: -1,
+	}
+	var err error
+	candidates := make([]*ShardHeader, len(paths))
+	for i, path := range paths {
+		dfile.files[i], err = OpenFile(path, false)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				dfile.Close()
+				return nil, err
+			}
+			dfile.files[i] = nil
+			continue
+		}
+		header, err := ReadShardHeader(dfile.files[i])
+		if err != nil {
+			if err != ErrInvalidShardHeader {
+				dfile.Close()
+				return nil, err
+			}
+			continue
+		}
+		if header.ShardIndex != int32(i) {
+			continue
+		}
+		candidates[i] = header
+	}
+	// the layout shared by most shards wins, a stale shard left by an earlier write is dropped
+	votes := 0
+	for _, candidate := range candidates {
+		if candidate == nil {
+			continue
+		}
+		count := 0
+		for _, other := range candidates {
+			if other != nil && candidate.SameLayout(other) {
+				count++
+			}
+		}
+		if count > votes {
+			dfile.header, votes = candidate, count
+		}
+	}
+	for i, candidate := range candidates {
+		if candidate != nil && dfile.header.SameLayout(candidate) {
+			dfile.headers[i] = candidate
+		}
+	}
+	if dfile.header == nil {
+		dfile.Close()
+		return nil, ErrInvalidShardHeader
+	}
+	if int(dfile.header.DataShards+dfile.header.ParityShards) != len(paths) {
+		dfile.Close()
+		return nil, fmt.Errorf("expect %d paths for %d+%d shards, got %d",
+			dfile.header.DataShards+dfile.header.ParityShards, dfile.header.DataShards, dfile.header.ParityShards, len(paths))
+	}
+	dfile.codec, err = NewReedSolomon(int(dfile.header.DataShards), int(dfile.header.ParityShards))
+	if err != nil {
+		dfile.Close()
+		return nil, err
+	}
+	dfile.layout = dfile.header
+	return dfile, nil
+}
+
+func (dfile *DistributedFileV6) WriteHeader(version int64, fileSize int64) error {
+	if version != VersionV6 {
+		return ErrVersionNotMatch
+	}
+	layout := dfile.layout
+	for i, f := range dfile.files {
+		if f == nil {
+			return fmt.Errorf("shard %d is not opened", i)
+		}
+		header := NewShardHeader(int(layout.DataShards), int(layout.ParityShards), int(layout.StripeSize), fileSize)
+		header.ShardIndex = int32(i)
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.WriteAt(header.Bytes(), 0); err != nil {
+			return err
+		}
+		dfile.headers[i] = header
+	}
+	dfile.header = dfile.headers[0]
+	dfile.writable = true
+	dfile.writeBuf = make([]byte, 0, layout.StripeDataSize())
+	dfile.writeStripe = 0
+	dfile.written = 0
+	dfile.dirty = fileSize > 0
+	dfile.position = 0
+	dfile.invalidateStripe()
+	return nil
+}
+
+func (dfile *DistributedFileV6) Write(p []byte) (n int, err error) {
+	if !dfile.writable {
+		return 0, ErrHeaderNotWritten
+	}
+	if dfile.written+int64(len(p)) > dfile.header.FileSize {
+		return 0, ErrFileSizeExceeded
+	}
+	stripeDataSize := int(dfile.header.StripeDataSize())
+	for n < len(p) {
+		size := stripeDataSize - len(dfile.writeBuf)
+		if size > len(p)-n {
+			size = len(p) - n
+		}
+		dfile.writeBuf = append(dfile.writeBuf, p[n:n+size]...)
+		n += size
+		dfile.written += int64(size)
+		if len(dfile.writeBuf) == stripeDataSize {
+			if err := dfile.flushStripe(); err != nil {
+				return n, err
+			}
+		}
+	}
+	if dfile.written == dfile.header.FileSize {
+		if err := dfile.finishWrite(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (dfile *DistributedFileV6) flushStripe() error {
+	if len(dfile.writeBuf) == 0 {
+		return nil
+	}
+	shards := dfile.splitStripe(dfile.writeBuf)
+	if err := dfile.codec.Encode(shards); err != nil {
+		return err
+	}
+	offset := dfile.header.StripeOffset(dfile.writeStripe)
+	for i, shard := range shards {
+		if _, err := dfile.files[i].WriteAt(shard, offset); err != nil {
+			return err
+		}
+		dfile.headers[i].Checksums[dfile.writeStripe] = crc64.Checksum(shard, crc64Table)
+	}
+	dfile.writeStripe++
+	dfile.writeBuf = dfile.writeBuf[:0]
+	return nil
+}
+
+// splitStripe pads the stripe with zero and cuts it into data shards followed by empty parity shards
+func (dfile *DistributedFileV6) splitStripe(data []byte) [][]byte {
+	stripeSize := int(dfile.header.StripeSize)
+	buf := make([]byte, stripeSize*dfile.codec.TotalShards())
+	copy(buf, data)
+	shards := make([][]byte, dfile.codec.TotalShards())
+	for i := range shards {
+		shards[i] = buf[i*stripeSize : (i+1)*stripeSize]
+	}
+	return shards
+}
+
+func (dfile *DistributedFileV6) finishWrite() error {
+	if !dfile.dirty {
+		return nil
+	}
+	if err := dfile.flushStripe(); err != nil {
+		return err
+	}
+	for i, header := range dfile.headers {
+		if _, err := dfile.files[i].WriteAt(header.Bytes(), 0); err != nil {
+			return err
+		}
+	}
+	dfile.dirty = false
+	return nil
+}
+
+// readStripe reads all shards of a stripe, shards missing or failing their checksum are nil
+func (dfile *DistributedFileV6) readStripe(stripeIdx int64) ([][]byte, error) {
+	shards := make([][]byte, dfile.codec.TotalShards())
+	offset := dfile.header.StripeOffset(stripeIdx)
+	for i, f := range dfile.files {
+		if f == nil || dfile.headers[i] == nil {
+			continue
+		}
+		buf := make([]byte, dfile.header.StripeSize)
+		n, err := f.ReadAt(buf, offset)
+		if err != nil {
+			if err == io.EOF && n < len(buf) {
+				continue
+			}
+			if err != io.EOF {
+				return nil, err
+			}
+		}
+		if crc64.Checksum(buf, crc64Table) != dfile.headers[i].Checksums[stripeIdx] {
+			continue
+		}
+		shards[i] = buf
+	}
+	return shards, nil
+}
+
+func (dfile *DistributedFileV6) decodeStripe(stripeIdx int64) ([][]byte, error) {
+	shards, err := dfile.readStripe(stripeIdx)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < dfile.codec.DataShards; i++ {
+		if shards[i] == nil {
+			if err := dfile.codec.Reconstruct(shards); err != nil {
+				return nil, fmt.Errorf("stripe %d: %w", stripeIdx, err)
+			}
+			break
+		}
+	}
+	return shards, nil
+}
+
+func (dfile *DistributedFileV6) Size() (int64, error) {
+	if dfile.header == nil {
+		return 0, ErrHeaderNotWritten
+	}
+	return dfile.header.FileSize, nil
+}
+
+// stripe returns the data of a decoded stripe, the last one is cached for the sequential reads
+func (dfile *DistributedFileV6) stripe(stripeIdx int64) ([]byte, error) {
+	dfile.stripeLock.Lock()
+	if stripeIdx == dfile.stripeIdx {
+		stripeBytes := dfile.stripeBytes
+		dfile.stripeLock.Unlock()
+		return stripeBytes, nil
+	}
+	dfile.stripeLock.Unlock()
+
+	shards, err := dfile.decodeStripe(stripeIdx)
+	if err != nil {
+		return nil, err
+	}
+	stripeBytes := make([]byte, 0, dfile.header.StripeDataSize())
+	for i := 0; i < dfile.codec.DataShards; i++ {
+		stripeBytes = append(stripeBytes, shards[i]...)
+	}
+
+	dfile.stripeLock.Lock()
+	dfile.stripeIdx = stripeIdx
+	dfile.stripeBytes = stripeBytes
+	dfile.stripeLock.Unlock()
+	return stripeBytes, nil
+}
+
+func (dfile *DistributedFileV6) invalidateStripe() {
+	dfile.stripeLock.Lock()
+	defer dfile.stripeLock.Unlock()
+	dfile.stripeIdx = -1
+	dfile.stripeBytes = nil
+}
+
+// ReadAt decodes the stripes covering [off, off+len(p)),
+// byte off of the file is in stripe off/(DataShards*StripeSize)
+func (dfile *DistributedFileV6) ReadAt(p []byte, off int64) (n int, err error) {
+	if dfile.header == nil || dfile.dirty {
+		return 0, ErrHeaderNotWritten
+	}
+	if off < 0 {
+		return 0, ErrNegativeOffset
+	}
+	fileSize := dfile.header.FileSize
+	if off >= fileSize {
+		return 0, io.EOF
+	}
+	stripeDataSize := dfile.header.StripeDataSize()
+	position := off
+	for n < len(p) && position < fileSize {
+		stripeIdx := position / stripeDataSize
+		stripeBytes, err := dfile.stripe(stripeIdx)
+		if err != nil {
+			return n, err
+		}
+		stripeStart := stripeIdx * stripeDataSize
+		stripeEnd := stripeDataSize
+		if fileSize-stripeStart < stripeEnd {
+			stripeEnd = fileSize - stripeStart
+		}
+		copied := copy(p[n:], stripeBytes[position-stripeStart:stripeEnd])
+		n += copied
+		position += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (dfile *DistributedFileV6) Read(p []byte) (n int, err error) {
+	n, err = dfile.ReadAt(p, dfile.position)
+	dfile.position += int64(n)
+	if err == io.EOF && n > 0 {
+		return n, nil
+	}
+	return n, err
+}
+
+func (dfile *DistributedFileV6) Seek(offset int64, whence int) (int64, error) {
+	fileSize, err := dfile.Size()
+	if err != nil {
+		return 0, err
+	}
+	position, err := seekPosition(dfile.position, fileSize, offset, whence)
+	if err != nil {
+		return 0, err
+	}
+	dfile.position = position
+	return position, nil
+}
+
+// RebuildBlk recreates the shard files which are missing or have a broken header
+func (dfile *DistributedFileV6) RebuildBlk() error {
+	if dfile.header == nil || dfile.dirty {
+		return ErrHeaderNotWritten
+	}
+	lost := make([]int, 0)
+	for i := range dfile.files {
+		if dfile.files[i] == nil || dfile.headers[i] == nil {
+			lost = append(lost, i)
+		}
+	}
+	if len(lost) == 0 {
+		return nil
+	}
+	if len(lost) > dfile.codec.ParityShards {
+		return ErrTooManyShardsLost
+	}
+
+	rebuilt := make(map[int]*ShardHeader)
+	for _, i := range lost {
+		if dfile.files[i] == nil {
+			f, err := OpenFile(dfile.filePaths[i], true)
+			if err != nil {
+				return err
+			}
+			dfile.files[i] = f
+		}
+		if err := dfile.files[i].Truncate(0); err != nil {
+			return err
+		}
+		header := NewShardHeader(int(dfile.header.DataShards), int(dfile.header.ParityShards), int(dfile.header.StripeSize), dfile.header.FileSize)
+		header.ShardIndex = int32(i)
+		rebuilt[i] = header
+	}
+
+	for stripeIdx := int64(0); stripeIdx < dfile.header.StripeCount; stripeIdx++ {
+		shards, err := dfile.readStripe(stripeIdx)
+		if err != nil {
+			return err
+		}
+		if err := dfile.codec.Reconstruct(shards); err != nil {
+			return fmt.Errorf("stripe %d: %w", stripeIdx, err)
+		}
+		for i, header := range rebuilt {
+			if _, err := dfile.files[i].WriteAt(shards[i], header.StripeOffset(stripeIdx)); err != nil {
+				return err
+			}
+			header.Checksums[stripeIdx] = crc64.Checksum(shards[i], crc64Table)
+		}
+	}
+
+	for i, header := range rebuilt {
+		if _, err := dfile.files[i].WriteAt(header.Bytes(), 0); err != nil {
+			return err
+		}
+		dfile.headers[i] = header
+	}
+	return nil
+}
+
+func (dfile *DistributedFileV6) Scrub(repair bool) (*HealthReport, error) {
+	if dfile.header == nil || dfile.dirty {
+		return nil, ErrHeaderNotWritten
+	}
+	report := newHealthReport(VersionV6, dfile.filePaths)
+	report.FileSize = dfile.header.FileSize
+	report.Stripes = dfile.header.StripeCount
+
+	lost := 0
+	for i := range dfile.files {
+		if dfile.files[i] == nil || dfile.headers[i] == nil {
+			report.Shards[i].Missing = true
+			lost++
+		}
+	}
+
+	for stripeIdx := int64(0); stripeIdx < dfile.header.StripeCount; stripeIdx++ {
+		shards, err := dfile.readStripe(stripeIdx)
+		if err != nil {
+			return report, err
+		}
+		corrupted := make([]int, 0)
+		for i, shard := range shards {
+			if shard == nil && !report.Shards[i].Missing {
+				report.Shards[i].Corrupted++
+				corrupted = append(corrupted, i)
+			}
+		}
+		if lost+len(corrupted) > dfile.codec.ParityShards {
+			report.Unrepairable++
+			continue
+		}
+		if !repair || len(corrupted) == 0 {
+			continue
+		}
+		if err := dfile.codec.Reconstruct(shards); err != nil {
+			return report, fmt.Errorf("stripe %d: %w", stripeIdx, err)
+		}
+		for _, i := range corrupted {
+			if _, err := dfile.files[i].WriteAt(shards[i], dfile.header.StripeOffset(stripeIdx)); err != nil {
+				return report, err
+			}
+		}
+	}
+	dfile.invalidateStripe()
+
+	if !repair || report.Unrepairable > 0 {
+		return report, nil
+	}
+	if lost > 0 {
+		if err := dfile.RebuildBlk(); err != nil {
+			return report, err
+		}
+	}
+	for i := range report.Shards {
+		if report.Shards[i].Missing || report.Shards[i].Corrupted > 0 {
+			report.Shards[i].Repaired = true
+		}
+	}
+	return report, nil
+}
+
+// Close closes the shard files, ErrIncompleteWrite is returned if less than the size of the header was written
+func (dfile *DistributedFileV6) Close() error {
+	var err error
+	if dfile.dirty {
+		err = fmt.Errorf("%d of %d bytes written: %w", dfile.written, dfile.header.FileSize, ErrIncompleteWrite)
+	}
+	for _, f := range dfile.files {
+		if f != nil {
+			f.Close()
+		}
+	}
+	return err
+}
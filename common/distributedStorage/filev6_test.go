@@ -0,0 +1,437 @@
+package distributedstorage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func slicePathsV6(dir string, count int) []string {
+	paths := make([]string, count)
+	for i := range paths {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("slice.%02d", i+1))
+	}
+	return paths
+}
+
+func writeV6(t *testing.T, content []byte, paths []string, dataShards int, parityShards int, stripeSize int) {
+	dfile, err := CreateV6(paths, dataShards, parityShards, stripeSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dfile.WriteHeader(VersionV6, int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	// write in odd sized pieces so that stripes are assembled across writes
+	if _, err := io.CopyBuffer(dfile, bytes.NewReader(content), make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := dfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readAll(t *testing.T, paths []string) ([]byte, error) {
+	dfile, err := OpenDistributedFile(paths)
+	if err != nil {
+		return nil, err
+	}
+	defer dfile.Close()
+	return io.ReadAll(dfile)
+}
+
+func TestReedSolomonReconstruct(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, layout := range [][2]int{{2, 1}, {4, 2}, {6, 3}, {10, 4}} {
+		rs, err := NewReedSolomon(layout[0], layout[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards := make([][]byte, rs.TotalShards())
+		for i := range shards {
+			shards[i] = make([]byte, 257)
+			if i < rs.DataShards {
+				r.Read(shards[i])
+			}
+		}
+		if err := rs.Encode(shards); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := rs.Verify(shards); err != nil || !ok {
+			t.Fatalf("%d+%d: verify failed", layout[0], layout[1])
+		}
+
+		for round := 0; round < 20; round++ {
+			damaged := make([][]byte, len(shards))
+			copy(damaged, shards)
+			for _, i := range r.Perm(len(shards))[:rs.ParityShards] {
+				damaged[i] = nil
+			}
+			if err := rs.Reconstruct(damaged); err != nil {
+				t.Fatal(err)
+			}
+			for i := range shards {
+				if !bytes.Equal(shards[i], damaged[i]) {
+					t.Fatalf("%d+%d: shard %d is not reconstructed", layout[0], layout[1], i)
+				}
+			}
+		}
+
+		damaged := make([][]byte, len(shards))
+		copy(damaged, shards)
+		for _, i := range r.Perm(len(shards))[:rs.ParityShards+1] {
+			damaged[i] = nil
+		}
+		if err := rs.Reconstruct(damaged); err != ErrTooManyShardsLost {
+			t.Fatalf("%d+%d: expect ErrTooManyShardsLost, got %v", layout[0], layout[1], err)
+		}
+	}
+}
+
+func TestDFileV6(t *testing.T) {
+	for _, layout := range [][2]int{{4, 2}, {6, 3}} {
+		for _, size := range []int{0, 1, 4095, 4096 * layout[0], 100003} {
+			dir := t.TempDir()
+			content := make([]byte, size)
+			rand.New(rand.NewSource(int64(size))).Read(content)
+			paths := slicePathsV6(dir, layout[0]+layout[1])
+			writeV6(t, content, paths, layout[0], layout[1], 4096)
+
+			decoded, err := readAll(t, paths)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(content, decoded) {
+				t.Fatalf("%d+%d size %d: decoded content is different", layout[0], layout[1], size)
+			}
+		}
+	}
+}
+
+func TestDFileV6LostShards(t *testing.T) {
+	dir := t.TempDir()
+	content := make([]byte, 100003)
+	rand.New(rand.NewSource(2)).Read(content)
+	paths := slicePathsV6(dir, 6)
+	writeV6(t, content, paths, 4, 2, 4096)
+
+	os.Remove(paths[0])
+	os.Remove(paths[3])
+	decoded, err := readAll(t, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, decoded) {
+		t.Fatal("decoded content is different")
+	}
+
+	os.Remove(paths[5])
+	if _, err := readAll(t, paths); err == nil {
+		t.Fatal("expect an error when more shards than parity are lost")
+	}
+}
+
+func TestDFileV6Corruption(t *testing.T) {
+	dir := t.TempDir()
+	content := make([]byte, 100003)
+	rand.New(rand.NewSource(3)).Read(content)
+	paths := slicePathsV6(dir, 6)
+	writeV6(t, content, paths, 4, 2, 4096)
+
+	f, err := os.OpenFile(paths[1], os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte{0xff, 0xee}, 5000)
+	f.Close()
+
+	decoded, err := readAll(t, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, decoded) {
+		t.Fatal("decoded content is different")
+	}
+}
+
+func TestReadShardHeaderForgedSize(t *testing.T) {
+	content := NewShardHeader(4, 2, 4096, 100003).Bytes()
+	// a header size of 4 GiB whose stripe count matches, on a shard of a few bytes
+	binary.LittleEndian.PutUint32(content[8:12], 0xfffffff4)
+	binary.LittleEndian.PutUint64(content[36:44], (0xfffffff4-shardHeaderFixedSize)/8)
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	before := stats.TotalAlloc
+	if _, err := ReadShardHeader(bytes.NewReader(content)); err != ErrInvalidShardHeader {
+		t.Fatalf("unexpected error %v", err)
+	}
+	runtime.ReadMemStats(&stats)
+	if stats.TotalAlloc-before > 1<<20 {
+		t.Fatalf("%d bytes are allocated to read a forged header", stats.TotalAlloc-before)
+	}
+
+	binary.LittleEndian.PutUint64(content[36:44], 1)
+	if _, err := ReadShardHeader(bytes.NewReader(content)); err != ErrInvalidShardHeader {
+		t.Fatalf("a stripe count not matching the header size is read: %v", err)
+	}
+}
+
+func TestParseShardHeaderStripeCount(t *testing.T) {
+	header := NewShardHeader(4, 2, 4096, 100003)
+	if _, err := ParseShardHeader(header.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	// a header passing its CRC64 whose stripes do not cover the file
+	forged := NewShardHeader(4, 2, 4096, 100003)
+	forged.FileSize = 10 * 100003
+	if _, err := ParseShardHeader(forged.Bytes()); err != ErrInvalidShardHeader {
+		t.Fatalf("a stripe count not covering the file is parsed: %v", err)
+	}
+}
+
+func TestDFileV6StaleShard(t *testing.T) {
+	dir := t.TempDir()
+	paths := slicePathsV6(dir, 6)
+	writeV6(t, make([]byte, 5000), paths, 4, 2, 4096)
+	stale, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := make([]byte, 100003)
+	rand.New(rand.NewSource(7)).Read(content)
+	writeV6(t, content, paths, 4, 2, 4096)
+	os.WriteFile(paths[0], stale, 0644)
+
+	decoded, err := readAll(t, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, decoded) {
+		t.Fatal("the layout of the stale shard is read")
+	}
+}
+
+func TestDFileV6ShortWrite(t *testing.T) {
+	dfile, err := CreateV6(slicePathsV6(t.TempDir(), 6), 4, 2, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dfile.WriteHeader(VersionV6, 100003); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dfile.Write(make([]byte, 50000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := dfile.Close(); !errors.Is(err, ErrIncompleteWrite) {
+		t.Fatalf("expect a short write to fail the close, got %v", err)
+	}
+}
+
+func TestDFileV6RebuildBlk(t *testing.T) {
+	dir := t.TempDir()
+	content := make([]byte, 100003)
+	rand.New(rand.NewSource(4)).Read(content)
+	paths := slicePathsV6(dir, 9)
+	writeV6(t, content, paths, 6, 3, 4096)
+
+	expected := make(map[int][]byte)
+	for _, i := range []int{1, 4, 7} {
+		data, err := os.ReadFile(paths[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected[i] = data
+		os.Remove(paths[i])
+	}
+
+	dfile, err := OpenV6(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dfile.RebuildBlk(); err != nil {
+		t.Fatal(err)
+	}
+	dfile.Close()
+
+	for i, data := range expected {
+		actual, err := os.ReadFile(paths[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, actual) {
+			t.Errorf("rebuilt shard %d is different", i)
+		}
+	}
+}
+
+func TestOpenDistributedFileV5(t *testing.T) {
+	dir := t.TempDir()
+	srcFilePath := writeSampleFile(t, dir, 100003)
+	writeV5(t, srcFilePath, dir)
+	paths := slicePathsV5(dir)
+
+	decoded, err := readAll(t, paths[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(srcFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, decoded) {
+		t.Fatal("decoded content is different")
+	}
+}
+
+func TestDFileV6Scrub(t *testing.T) {
+	dir := t.TempDir()
+	content := make([]byte, 100003)
+	rand.New(rand.NewSource(5)).Read(content)
+	paths := make([]string, 6)
+	for i := range paths {
+		paths[i] = ShardPath(dir, "1.bmp.", i)
+	}
+	writeV6(t, content, paths, 4, 2, 4096)
+	expected := make([][]byte, len(paths))
+	for i, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected[i] = data
+	}
+
+	f, err := os.OpenFile(paths[2], os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte{0xff, 0xee}, 9000)
+	f.Close()
+	os.Remove(paths[5])
+
+	sets, err := FindShardSets(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets["1.bmp."]) != 6 {
+		t.Fatalf("unexpected shard sets %v", sets)
+	}
+	dfile, err := OpenDistributedFile(sets["1.bmp."])
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := dfile.Scrub(true)
+	dfile.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Shards[5].Missing || report.Shards[2].Corrupted != 1 || !report.Healthy() {
+		t.Fatalf("unexpected report %s", report)
+	}
+	for i, path := range paths {
+		actual, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected[i], actual) {
+			t.Errorf("shard %d is not repaired", i)
+		}
+	}
+
+	dfile, err = OpenDistributedFile(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dfile.Close()
+	report, err = dfile.Scrub(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Damaged() {
+		t.Fatalf("unexpected report %s", report)
+	}
+}
+
+func TestReadAtSeek(t *testing.T) {
+	dir := t.TempDir()
+	srcFilePath := writeSampleFile(t, dir, 100003)
+	content, err := os.ReadFile(srcFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v5Dir := filepath.Join(dir, "v5")
+	os.MkdirAll(v5Dir, 0755)
+	writeV5(t, srcFilePath, v5Dir)
+	v5Paths := slicePathsV5(v5Dir)
+	v6Paths := slicePathsV6(dir, 6)
+	writeV6(t, content, v6Paths, 4, 2, 4096)
+	// ranges must still be served from the parity when a shard is lost
+	os.Remove(v5Paths[0])
+	os.Remove(v6Paths[1])
+
+	for _, paths := range [][]string{v5Paths[:], v6Paths} {
+		dfile, err := OpenDistributedFile(paths)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := rand.New(rand.NewSource(6))
+		wg := sync.WaitGroup{}
+		for i := 0; i < 8; i++ {
+			off := r.Int63n(int64(len(content)))
+			size := r.Intn(30000) + 1
+			wg.Add(1)
+			go func(off int64, size int) {
+				defer wg.Done()
+				buf := make([]byte, size)
+				n, err := dfile.ReadAt(buf, off)
+				if err != nil && err != io.EOF {
+					t.Error(err)
+					return
+				}
+				end := off + int64(size)
+				if end > int64(len(content)) {
+					end = int64(len(content))
+					if err != io.EOF {
+						t.Errorf("expect io.EOF reading past the end at %d", off)
+					}
+				}
+				if !bytes.Equal(content[off:end], buf[:n]) {
+					t.Errorf("range %d-%d is different", off, end)
+				}
+			}(off, size)
+		}
+		wg.Wait()
+
+		position, err := dfile.Seek(-10, io.SeekEnd)
+		if err != nil || position != int64(len(content))-10 {
+			t.Fatalf("unexpected seek result %d %v", position, err)
+		}
+		tail, err := io.ReadAll(dfile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(content[len(content)-10:], tail) {
+			t.Fatal("tail is different")
+		}
+		if _, err := dfile.Seek(12345, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		section, err := io.ReadAll(io.LimitReader(dfile, 100))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(content[12345:12445], section) {
+			t.Fatal("section is different")
+		}
+		dfile.Close()
+	}
+}
@@ -68,6 +68,14 @@ func RebuildField(blkIdx int, lineIdx int, frame [3][4]byte) byte {
 	}
 }
 
+// RepairFrameV5 rebuilds every field of the shard blkIdx from the two other shards
+func RepairFrameV5(blkIdx int, frame [3][4]byte) [3][4]byte {
+	for lineIdx := 0; lineIdx < 4; lineIdx++ {
+		frame[blkIdx][lineIdx] = RebuildField(blkIdx, lineIdx, frame)
+	}
+	return frame
+}
+
 // compute direction of xor will have different result, because byte type will not always be 8 bit with zero-fill
 func SumXor(a, b byte, leftToRight bool) byte {
 	if leftToRight {
@@ -93,7 +101,7 @@ func CreateSectorV5(data []byte) ([][3][4]byte, error) {
 	frames := make([][3][4]byte, frameSize)
 
 	for i := 0; i < len(data); i += 8 {
-		if i+7 > len(data) {
+		if i+8 > len(data) {
 			remain := len(data) - i
 			frameData := make([]byte, 8)
 			for j := 0; j < 8; j++ {
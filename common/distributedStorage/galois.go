@@ -0,0 +1,121 @@
+package distributedstorage
+
+import "errors"
+
+var ErrSingularMatrix error = errors.New("matrix is singular")
+
+// GF(2^8) with the generator polynomial x^8 + x^4 + x^3 + x^2 + 1 (0x11d)
+const galoisPolynomial = 0x11d
+
+var galoisExp [512]byte
+var galoisLog [256]byte
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		galoisExp[i] = byte(x)
+		galoisLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= galoisPolynomial
+		}
+	}
+	// duplicate the table so that galMul does not need a modulo
+	for i := 255; i < 512; i++ {
+		galoisExp[i] = galoisExp[i-255]
+	}
+}
+
+func galMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return galoisExp[int(galoisLog[a])+int(galoisLog[b])]
+}
+
+func galInverse(a byte) byte {
+	if a == 0 {
+		panic("galois: inverse of zero")
+	}
+	return galoisExp[255-int(galoisLog[a])]
+}
+
+// galMulSliceXor computes out ^= c * in for every byte
+func galMulSliceXor(c byte, in, out []byte) {
+	if c == 0 {
+		return
+	}
+	if c == 1 {
+		for i := range in {
+			out[i] ^= in[i]
+		}
+		return
+	}
+	logC := int(galoisLog[c])
+	for i, v := range in {
+		if v != 0 {
+			out[i] ^= galoisExp[logC+int(galoisLog[v])]
+		}
+	}
+}
+
+type matrix [][]byte
+
+func newMatrix(rows, cols int) matrix {
+	m := make(matrix, rows)
+	for i := range m {
+		m[i] = make([]byte, cols)
+	}
+	return m
+}
+
+func identityMatrix(size int) matrix {
+	m := newMatrix(size, size)
+	for i := range m {
+		m[i][i] = 1
+	}
+	return m
+}
+
+// Invert returns the inverse of a square matrix using Gauss-Jordan elimination.
+func (m matrix) Invert() (matrix, error) {
+	size := len(m)
+	work := newMatrix(size, size*2)
+	for i := range m {
+		copy(work[i], m[i])
+		work[i][size+i] = 1
+	}
+
+	for col := 0; col < size; col++ {
+		if work[col][col] == 0 {
+			swapped := false
+			for row := col + 1; row < size; row++ {
+				if work[row][col] != 0 {
+					work[col], work[row] = work[row], work[col]
+					swapped = true
+					break
+				}
+			}
+			if !swapped {
+				return nil, ErrSingularMatrix
+			}
+		}
+		if work[col][col] != 1 {
+			scale := galInverse(work[col][col])
+			for j := range work[col] {
+				work[col][j] = galMul(work[col][j], scale)
+			}
+		}
+		for row := 0; row < size; row++ {
+			if row != col && work[row][col] != 0 {
+				galMulSliceXor(work[row][col], work[col], work[row])
+			}
+		}
+	}
+
+	inv := newMatrix(size, size)
+	for i := range inv {
+		copy(inv[i], work[i][size:])
+	}
+	return inv, nil
+}
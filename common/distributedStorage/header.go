@@ -0,0 +1,184 @@
+package distributedstorage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"io"
+)
+
+var ErrInvalidShardHeader error = errors.New("invalid shard header")
+var ErrVersionNotMatch error = errors.New("version not match")
+
+var ShardMagic = [4]byte{'O', 'S', 'D', 'S'}
+
+const shardHeaderFixedSize = 52
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// ShardHeader is stored at the beginning of every shard of a V6 distributed file.
+// The checksums only cover the stripes of the shard carrying the header,
+// so losing a shard never loses the checksums of the others.
+type ShardHeader struct {
+	// Magic: 4
+	Magic [4]byte
+	// Version: 4
+	Version int32
+	// HeaderSize: 4
+	HeaderSize int32
+	// DataShards: 4
+	DataShards int32
+	// ParityShards: 4
+	ParityShards int32
+	// ShardIndex: 4
+	ShardIndex int32
+	// StripeSize: 4, bytes of one shard in one stripe
+	StripeSize int32
+	// FileSize: 8
+	FileSize int64
+	// StripeCount: 8
+	StripeCount int64
+	// CRC64: 8, covers the whole header except this field
+	CRC64 uint64
+	/* FIXED SIZE: 52 */
+	// Checksums: 8 * StripeCount, CRC64 of every stripe of this shard
+	Checksums []uint64
+}
+
+func NewShardHeader(dataShards int, parityShards int, stripeSize int, fileSize int64) *ShardHeader {
+	stripeDataSize := int64(dataShards) * int64(stripeSize)
+	stripeCount := fileSize / stripeDataSize
+	if fileSize%stripeDataSize != 0 {
+		stripeCount++
+	}
+	header := &ShardHeader{
+		Magic:        ShardMagic,
+		Version:      VersionV6,
+		DataShards:   int32(dataShards),
+		ParityShards: int32(parityShards),
+		StripeSize:   int32(stripeSize),
+		FileSize:     fileSize,
+		StripeCount:  stripeCount,
+		Checksums:    make([]uint64, stripeCount),
+	}
+	header.HeaderSize = int32(shardHeaderFixedSize + 8*stripeCount)
+	return header
+}
+
+func (header *ShardHeader) Bytes() []byte {
+	header.HeaderSize = int32(shardHeaderFixedSize + 8*len(header.Checksums))
+	buf := bytes.NewBuffer([]byte{})
+	binary.Write(buf, binary.LittleEndian, header.Magic)
+	binary.Write(buf, binary.LittleEndian, header.Version)
+	binary.Write(buf, binary.LittleEndian, header.HeaderSize)
+	binary.Write(buf, binary.LittleEndian, header.DataShards)
+	binary.Write(buf, binary.LittleEndian, header.ParityShards)
+	binary.Write(buf, binary.LittleEndian, header.ShardIndex)
+	binary.Write(buf, binary.LittleEndian, header.StripeSize)
+	binary.Write(buf, binary.LittleEndian, header.FileSize)
+	binary.Write(buf, binary.LittleEndian, header.StripeCount)
+	binary.Write(buf, binary.LittleEndian, uint64(0))
+	binary.Write(buf, binary.LittleEndian, header.Checksums)
+	content := buf.Bytes()
+	header.CRC64 = crc64.Checksum(content, crc64Table)
+	binary.LittleEndian.PutUint64(content[44:52], header.CRC64)
+	return content
+}
+
+// StripeDataSize returns the count of file bytes held by one stripe
+func (header *ShardHeader) StripeDataSize() int64 {
+	return int64(header.DataShards) * int64(header.StripeSize)
+}
+
+// StripeOffset returns the offset of a stripe inside the shard
+func (header *ShardHeader) StripeOffset(stripeIdx int64) int64 {
+	return int64(header.HeaderSize) + stripeIdx*int64(header.StripeSize)
+}
+
+// SameLayout reports whether two headers belong to the same distributed file
+func (header *ShardHeader) SameLayout(other *ShardHeader) bool {
+	return header.Version == other.Version &&
+		header.DataShards == other.DataShards &&
+		header.ParityShards == other.ParityShards &&
+		header.StripeSize == other.StripeSize &&
+		header.FileSize == other.FileSize &&
+		header.StripeCount == other.StripeCount
+}
+
+func ReadShardHeader(reader io.ReaderAt) (*ShardHeader, error) {
+	fixed := make([]byte, shardHeaderFixedSize)
+	if _, err := reader.ReadAt(fixed, 0); err != nil {
+		if err == io.EOF {
+			return nil, ErrInvalidShardHeader
+		}
+		return nil, err
+	}
+	if !bytes.Equal(fixed[:4], ShardMagic[:]) {
+		return nil, ErrInvalidShardHeader
+	}
+	headerSize := binary.LittleEndian.Uint32(fixed[8:12])
+	stripeCount := binary.LittleEndian.Uint64(fixed[36:44])
+	if headerSize < shardHeaderFixedSize || (headerSize-shardHeaderFixedSize)%8 != 0 || uint64(headerSize-shardHeaderFixedSize)/8 != stripeCount {
+		return nil, ErrInvalidShardHeader
+	}
+	// the size is not verified before the header is read, a header larger than the shard is not allocated
+	if _, err := reader.ReadAt(make([]byte, 1), int64(headerSize)-1); err != nil {
+		if err == io.EOF {
+			return nil, ErrInvalidShardHeader
+		}
+		return nil, err
+	}
+	content := make([]byte, headerSize)
+	if _, err := reader.ReadAt(content, 0); err != nil {
+		if err == io.EOF {
+			return nil, ErrInvalidShardHeader
+		}
+		return nil, err
+	}
+	return ParseShardHeader(content)
+}
+
+func ParseShardHeader(content []byte) (*ShardHeader, error) {
+	if len(content) < shardHeaderFixedSize {
+		return nil, ErrInvalidShardHeader
+	}
+	header := &ShardHeader{}
+	buf := bytes.NewBuffer(content)
+	binary.Read(buf, binary.LittleEndian, &header.Magic)
+	binary.Read(buf, binary.LittleEndian, &header.Version)
+	binary.Read(buf, binary.LittleEndian, &header.HeaderSize)
+	binary.Read(buf, binary.LittleEndian, &header.DataShards)
+	binary.Read(buf, binary.LittleEndian, &header.ParityShards)
+	binary.Read(buf, binary.LittleEndian, &header.ShardIndex)
+	binary.Read(buf, binary.LittleEndian, &header.StripeSize)
+	binary.Read(buf, binary.LittleEndian, &header.FileSize)
+	binary.Read(buf, binary.LittleEndian, &header.StripeCount)
+	binary.Read(buf, binary.LittleEndian, &header.CRC64)
+	if header.Magic != ShardMagic || header.Version != VersionV6 {
+		return nil, ErrInvalidShardHeader
+	}
+	if header.HeaderSize < shardHeaderFixedSize || (header.HeaderSize-shardHeaderFixedSize)%8 != 0 ||
+		int64(header.HeaderSize-shardHeaderFixedSize)/8 != header.StripeCount || len(content) < int(header.HeaderSize) {
+		return nil, ErrInvalidShardHeader
+	}
+	header.Checksums = make([]uint64, header.StripeCount)
+	binary.Read(buf, binary.LittleEndian, header.Checksums)
+
+	verify := make([]byte, header.HeaderSize)
+	copy(verify, content[:header.HeaderSize])
+	binary.LittleEndian.PutUint64(verify[44:52], 0)
+	if crc64.Checksum(verify, crc64Table) != header.CRC64 {
+		return nil, ErrInvalidShardHeader
+	}
+	if header.DataShards <= 0 || header.ParityShards < 0 || header.StripeSize <= 0 || header.FileSize < 0 ||
+		header.ShardIndex < 0 || header.ShardIndex >= header.DataShards+header.ParityShards {
+		return nil, ErrInvalidShardHeader
+	}
+	// the stripes are indexed by the offsets of the file, a count not covering the file exactly would read out of the checksums
+	stripeDataSize := header.StripeDataSize()
+	if header.StripeCount != (header.FileSize+stripeDataSize-1)/stripeDataSize {
+		return nil, ErrInvalidShardHeader
+	}
+	return header, nil
+}
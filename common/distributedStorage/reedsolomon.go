@@ -0,0 +1,170 @@
+package distributedstorage
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrTooManyShardsLost error = errors.New("too many shards lost")
+var ErrShardSizeNotMatch error = errors.New("shard size not match")
+
+// ReedSolomon is a systematic k+m erasure code: the first DataShards rows of the
+// encoding matrix are the identity, the ParityShards rows below form a Cauchy matrix,
+// so any DataShards of the DataShards+ParityShards shards are enough to rebuild the rest.
+type ReedSolomon struct {
+	DataShards   int
+	ParityShards int
+
+	encodeMatrix matrix
+}
+
+func NewReedSolomon(dataShards int, parityShards int) (*ReedSolomon, error) {
+	if dataShards <= 0 || parityShards < 0 {
+		return nil, fmt.Errorf("invalid shard count %d+%d", dataShards, parityShards)
+	}
+	if dataShards+parityShards > 256 {
+		return nil, fmt.Errorf("too many shards %d+%d, at most 256 are supported", dataShards, parityShards)
+	}
+
+	totalShards := dataShards + parityShards
+	encodeMatrix := newMatrix(totalShards, dataShards)
+	for i := 0; i < dataShards; i++ {
+		encodeMatrix[i][i] = 1
+	}
+	for i := dataShards; i < totalShards; i++ {
+		for j := 0; j < dataShards; j++ {
+			// x_i = i and y_j = j never collide since i >= dataShards > j
+			encodeMatrix[i][j] = galInverse(byte(i ^ j))
+		}
+	}
+
+	return &ReedSolomon{
+		DataShards:   dataShards,
+		ParityShards: parityShards,
+		encodeMatrix: encodeMatrix,
+	}, nil
+}
+
+func (rs *ReedSolomon) TotalShards() int {
+	return rs.DataShards + rs.ParityShards
+}
+
+func (rs *ReedSolomon) checkShards(shards [][]byte, allowMissing bool) (int, error) {
+	if len(shards) != rs.TotalShards() {
+		return 0, fmt.Errorf("expect %d shards, got %d", rs.TotalShards(), len(shards))
+	}
+	size := -1
+	for _, shard := range shards {
+		if shard == nil {
+			if !allowMissing {
+				return 0, ErrTooManyShardsLost
+			}
+			continue
+		}
+		if size == -1 {
+			size = len(shard)
+		} else if size != len(shard) {
+			return 0, ErrShardSizeNotMatch
+		}
+	}
+	if size == -1 {
+		return 0, ErrTooManyShardsLost
+	}
+	return size, nil
+}
+
+// Encode computes the parity shards from the data shards, every shard must be allocated with the same size
+func (rs *ReedSolomon) Encode(shards [][]byte) error {
+	if _, err := rs.checkShards(shards, false); err != nil {
+		return err
+	}
+	for i := rs.DataShards; i < rs.TotalShards(); i++ {
+		parity := shards[i]
+		for j := range parity {
+			parity[j] = 0
+		}
+		for j := 0; j < rs.DataShards; j++ {
+			galMulSliceXor(rs.encodeMatrix[i][j], shards[j], parity)
+		}
+	}
+	return nil
+}
+
+// Verify returns true if the parity shards match the data shards
+func (rs *ReedSolomon) Verify(shards [][]byte) (bool, error) {
+	size, err := rs.checkShards(shards, false)
+	if err != nil {
+		return false, err
+	}
+	buf := make([]byte, size)
+	for i := rs.DataShards; i < rs.TotalShards(); i++ {
+		for j := range buf {
+			buf[j] = 0
+		}
+		for j := 0; j < rs.DataShards; j++ {
+			galMulSliceXor(rs.encodeMatrix[i][j], shards[j], buf)
+		}
+		for j := range buf {
+			if buf[j] != shards[i][j] {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// Reconstruct rebuilds the missing (nil) shards in place, at most ParityShards of them can be missing
+func (rs *ReedSolomon) Reconstruct(shards [][]byte) error {
+	size, err := rs.checkShards(shards, true)
+	if err != nil {
+		return err
+	}
+
+	present := make([]int, 0, rs.DataShards)
+	dataMissing := false
+	for i, shard := range shards {
+		if shard != nil {
+			if len(present) < rs.DataShards {
+				present = append(present, i)
+			}
+		} else if i < rs.DataShards {
+			dataMissing = true
+		}
+	}
+	if len(present) < rs.DataShards {
+		return ErrTooManyShardsLost
+	}
+
+	if dataMissing {
+		subMatrix := newMatrix(rs.DataShards, rs.DataShards)
+		for i, shardIdx := range present {
+			copy(subMatrix[i], rs.encodeMatrix[shardIdx])
+		}
+		decodeMatrix, err := subMatrix.Invert()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < rs.DataShards; i++ {
+			if shards[i] != nil {
+				continue
+			}
+			shard := make([]byte, size)
+			for j, shardIdx := range present {
+				galMulSliceXor(decodeMatrix[i][j], shards[shardIdx], shard)
+			}
+			shards[i] = shard
+		}
+	}
+
+	for i := rs.DataShards; i < rs.TotalShards(); i++ {
+		if shards[i] != nil {
+			continue
+		}
+		shard := make([]byte, size)
+		for j := 0; j < rs.DataShards; j++ {
+			galMulSliceXor(rs.encodeMatrix[i][j], shards[j], shard)
+		}
+		shards[i] = shard
+	}
+	return nil
+}
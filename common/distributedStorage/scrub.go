@@ -0,0 +1,167 @@
+package distributedstorage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type ShardHealth struct {
+	Index int
+	Path  string
+	// Missing is true when the shard file does not exist or its header can not be read
+	Missing bool
+	// Corrupted is the count of stripes (frames for V5) whose content of this shard is damaged
+	Corrupted int64
+	Repaired  bool
+}
+
+type HealthReport struct {
+	Version  int64
+	FileSize int64
+	Stripes  int64
+	Shards   []ShardHealth
+	// Unrepairable is the count of stripes (frames for V5) that lost more shards than the parity can rebuild,
+	// or whose damaged shard can not be located
+	Unrepairable int64
+}
+
+func newHealthReport(version int64, paths []string) *HealthReport {
+	report := &HealthReport{
+		Version: version,
+		Shards:  make([]ShardHealth, len(paths)),
+	}
+	for i, path := range paths {
+		report.Shards[i] = ShardHealth{Index: i, Path: path}
+	}
+	return report
+}
+
+// Healthy returns true if no damage was found, or every damage has been repaired
+func (report *HealthReport) Healthy() bool {
+	if report.Unrepairable > 0 {
+		return false
+	}
+	for _, shard := range report.Shards {
+		if (shard.Missing || shard.Corrupted > 0) && !shard.Repaired {
+			return false
+		}
+	}
+	return true
+}
+
+// Damaged returns true if any damage was found, repaired or not
+func (report *HealthReport) Damaged() bool {
+	if report.Unrepairable > 0 {
+		return true
+	}
+	for _, shard := range report.Shards {
+		if shard.Missing || shard.Corrupted > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (report *HealthReport) String() string {
+	status := "healthy"
+	if !report.Healthy() {
+		status = "damaged"
+	} else if report.Damaged() {
+		status = "repaired"
+	}
+	details := make([]string, 0)
+	for _, shard := range report.Shards {
+		if !shard.Missing && shard.Corrupted == 0 {
+			continue
+		}
+		detail := fmt.Sprintf("shard %d", shard.Index)
+		if shard.Missing {
+			detail += " missing"
+		}
+		if shard.Corrupted > 0 {
+			detail += fmt.Sprintf(" corrupted %d", shard.Corrupted)
+		}
+		if shard.Repaired {
+			detail += " repaired"
+		}
+		details = append(details, detail)
+	}
+	if report.Unrepairable > 0 {
+		details = append(details, fmt.Sprintf("%d unrepairable", report.Unrepairable))
+	}
+	text := fmt.Sprintf("v%d %d bytes %d stripes %s", report.Version, report.FileSize, report.Stripes, status)
+	if len(details) > 0 {
+		text += ": " + strings.Join(details, ", ")
+	}
+	return text
+}
+
+var shardFileRegexp = regexp.MustCompile(`^(.*)slice\.(\d+)$`)
+
+// ShardPath returns the path of the shard shardIdx (starts from 0) of the distributed file name
+func ShardPath(dir string, name string, shardIdx int) string {
+	return filepath.Join(dir, fmt.Sprintf("%sslice.%02d", name, shardIdx+1))
+}
+
+// FindShardSets groups the shard files of a directory by distributed file name,
+// a file named "photo.bmp.slice.01" is the first shard of "photo.bmp.".
+// Missing shards are kept in the returned paths so that they can be rebuilt.
+func FindShardSets(dir string) (map[string][]string, error) {
+	rds, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	indexes := make(map[string][]int)
+	for _, rd := range rds {
+		if rd.IsDir() {
+			continue
+		}
+		matches := shardFileRegexp.FindStringSubmatch(rd.Name())
+		if matches == nil {
+			continue
+		}
+		idx, err := strconv.Atoi(matches[2])
+		if err != nil || idx <= 0 {
+			continue
+		}
+		indexes[matches[1]] = append(indexes[matches[1]], idx-1)
+	}
+
+	sets := make(map[string][]string)
+	for name, idxes := range indexes {
+		sort.Ints(idxes)
+		count := idxes[len(idxes)-1] + 1
+		// the header of a V6 shard knows the real count even when the last shards are lost,
+		// files without it are V5 which always has 3 shards
+		isV6 := false
+		for _, idx := range idxes {
+			f, err := os.Open(ShardPath(dir, name, idx))
+			if err != nil {
+				continue
+			}
+			header, err := ReadShardHeader(f)
+			f.Close()
+			if err == nil {
+				if total := int(header.DataShards + header.ParityShards); total > count {
+					count = total
+				}
+				isV6 = true
+				break
+			}
+		}
+		if !isV6 && count < 3 {
+			count = 3
+		}
+		paths := make([]string, count)
+		for i := range paths {
+			paths[i] = ShardPath(dir, name, i)
+		}
+		sets[name] = paths
+	}
+	return sets, nil
+}
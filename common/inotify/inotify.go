@@ -0,0 +1,50 @@
+package inotify
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrNotSupported error = errors.New("inotify is not supported on this platform")
+var ErrWatcherClosed error = errors.New("watcher closed")
+
+type Op uint32
+
+const (
+	// Create is sent when a file or directory is created in a watched directory
+	Create Op = 1 << iota
+	// Modify is sent when a file is written, more writes and a CloseWrite will follow
+	Modify
+	// CloseWrite is sent when a file opened for writing is closed
+	CloseWrite
+	// MovedTo is sent when a file or directory is moved into a watched directory
+	MovedTo
+	// Remove is sent when a file or directory is deleted or moved out of a watched directory
+	Remove
+	// Overflow is sent when the kernel queue overflowed and events were dropped
+	Overflow
+)
+
+type Event struct {
+	Path  string
+	Op    Op
+	IsDir bool
+}
+
+func (op Op) String() string {
+	names := make([]string, 0)
+	for _, item := range []struct {
+		op   Op
+		name string
+	}{{Create, "CREATE"}, {Modify, "MODIFY"}, {CloseWrite, "CLOSE_WRITE"}, {MovedTo, "MOVED_TO"}, {Remove, "REMOVE"}, {Overflow, "OVERFLOW"}} {
+		if op&item.op != 0 {
+			names = append(names, item.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+func (event Event) String() string {
+	return fmt.Sprintf("%s %s", event.Op, event.Path)
+}
@@ -0,0 +1,177 @@
+//go:build linux
+
+package inotify
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const watchMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO |
+	syscall.IN_MOVED_FROM | syscall.IN_DELETE | syscall.IN_DELETE_SELF | syscall.IN_ONLYDIR
+
+// Watcher delivers the events of the watched directories, the directories are not watched recursively
+// by the kernel, new sub directories must be added by the receiver of their Create events.
+type Watcher struct {
+	Events chan Event
+	Errors chan error
+
+	file    *os.File
+	watches map[int32]string
+	lock    sync.Mutex
+	done    chan struct{}
+}
+
+func NewWatcher() (*Watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	watcher := &Watcher{
+		Events: make(chan Event, 256),
+		Errors: make(chan error, 1),
+		// a non-blocking fd is served by the runtime poller, so Close interrupts the pending read
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		watches: make(map[int32]string),
+		done:    make(chan struct{}),
+	}
+	go watcher.readEvents()
+	return watcher, nil
+}
+
+// Add watches a single directory
+func (watcher *Watcher) Add(dir string) error {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+	select {
+	case <-watcher.done:
+		return ErrWatcherClosed
+	default:
+	}
+	rawConn, err := watcher.file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var wd int
+	var addErr error
+	err = rawConn.Control(func(fd uintptr) {
+		wd, addErr = syscall.InotifyAddWatch(int(fd), dir, watchMask)
+	})
+	if err != nil {
+		return err
+	}
+	if addErr != nil {
+		return os.NewSyscallError("inotify_add_watch", addErr)
+	}
+	watcher.watches[int32(wd)] = dir
+	return nil
+}
+
+// AddRecursive watches dir and all its sub directories, hidden directories are skipped
+func (watcher *Watcher) AddRecursive(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
+
+func (watcher *Watcher) Close() error {
+	watcher.lock.Lock()
+	select {
+	case <-watcher.done:
+		watcher.lock.Unlock()
+		return nil
+	default:
+	}
+	close(watcher.done)
+	watcher.lock.Unlock()
+	return watcher.file.Close()
+}
+
+func (watcher *Watcher) readEvents() {
+	defer close(watcher.Events)
+	defer close(watcher.Errors)
+	buf := make([]byte, syscall.SizeofInotifyEvent*4096)
+	for {
+		n, err := watcher.file.Read(buf)
+		if err != nil {
+			select {
+			case <-watcher.done:
+			default:
+				watcher.Errors <- err
+			}
+			return
+		}
+		offset := 0
+		for offset+syscall.SizeofInotifyEvent <= n {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(raw.Len)]
+			name := string(bytes.TrimRight(nameBytes, "\x00"))
+			offset += syscall.SizeofInotifyEvent + int(raw.Len)
+
+			event, ok := watcher.convert(raw.Wd, raw.Mask, name)
+			if !ok {
+				continue
+			}
+			select {
+			case watcher.Events <- event:
+			case <-watcher.done:
+				return
+			}
+		}
+	}
+}
+
+func (watcher *Watcher) convert(wd int32, mask uint32, name string) (Event, bool) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		return Event{Op: Overflow}, true
+	}
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+	dir, ok := watcher.watches[wd]
+	if !ok {
+		return Event{}, false
+	}
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(watcher.watches, wd)
+		return Event{}, false
+	}
+
+	event := Event{Path: dir, IsDir: mask&syscall.IN_ISDIR != 0}
+	if name != "" {
+		event.Path = filepath.Join(dir, name)
+	}
+	if mask&syscall.IN_CREATE != 0 {
+		event.Op |= Create
+	}
+	if mask&syscall.IN_MODIFY != 0 {
+		event.Op |= Modify
+	}
+	if mask&syscall.IN_CLOSE_WRITE != 0 {
+		event.Op |= CloseWrite
+	}
+	if mask&syscall.IN_MOVED_TO != 0 {
+		event.Op |= MovedTo
+	}
+	if mask&(syscall.IN_DELETE|syscall.IN_DELETE_SELF|syscall.IN_MOVED_FROM) != 0 {
+		event.Op |= Remove
+	}
+	if mask&syscall.IN_DELETE_SELF != 0 {
+		event.IsDir = true
+	}
+	return event, event.Op != 0
+}
@@ -0,0 +1,79 @@
+//go:build linux
+
+package inotify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func waitEvent(t *testing.T, watcher *Watcher, path string, op Op) Event {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-watcher.Events:
+			if event.Path == path && event.Op&op != 0 {
+				return event
+			}
+		case err := <-watcher.Errors:
+			t.Fatal(err)
+		case <-timeout:
+			t.Fatalf("no %s event of %s", op, path)
+		}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	os.MkdirAll(filepath.Join(dir, ".hidden"), 0755)
+	watcher, err := NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	if err := watcher.AddRecursive(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	filePath := filepath.Join(dir, "sub", "1.txt")
+	f, err := os.Create(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, watcher, filePath, Create)
+	f.Write([]byte("hello"))
+	waitEvent(t, watcher, filePath, Modify)
+	f.Close()
+	waitEvent(t, watcher, filePath, CloseWrite)
+
+	newDir := filepath.Join(dir, "new")
+	os.Mkdir(newDir, 0755)
+	if event := waitEvent(t, watcher, newDir, Create); !event.IsDir {
+		t.Fatalf("expect a directory event %s", event)
+	}
+	if err := watcher.Add(newDir); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(newDir, "2.txt"), []byte("world"), 0644)
+	waitEvent(t, watcher, filepath.Join(newDir, "2.txt"), CloseWrite)
+
+	os.Rename(filePath, filepath.Join(newDir, "3.txt"))
+	waitEvent(t, watcher, filePath, Remove)
+	waitEvent(t, watcher, filepath.Join(newDir, "3.txt"), MovedTo)
+
+	os.WriteFile(filepath.Join(dir, ".hidden", "4.txt"), []byte("!"), 0644)
+	os.WriteFile(filepath.Join(dir, "5.txt"), []byte("!"), 0644)
+	if event := waitEvent(t, watcher, filepath.Join(dir, "5.txt"), CloseWrite); event.IsDir {
+		t.Fatalf("unexpected directory event %s", event)
+	}
+
+	watcher.Close()
+	if err := watcher.Add(dir); err != ErrWatcherClosed {
+		t.Fatalf("expect ErrWatcherClosed, got %v", err)
+	}
+	for range watcher.Events {
+	}
+}
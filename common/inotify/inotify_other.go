@@ -0,0 +1,24 @@
+//go:build !linux
+
+package inotify
+
+type Watcher struct {
+	Events chan Event
+	Errors chan error
+}
+
+func NewWatcher() (*Watcher, error) {
+	return nil, ErrNotSupported
+}
+
+func (watcher *Watcher) Add(dir string) error {
+	return ErrNotSupported
+}
+
+func (watcher *Watcher) AddRecursive(dir string) error {
+	return ErrNotSupported
+}
+
+func (watcher *Watcher) Close() error {
+	return nil
+}
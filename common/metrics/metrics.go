@@ -0,0 +1,319 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry holds metrics and writes them in the Prometheus text exposition format
+type Registry struct {
+	lock     sync.Mutex
+	metrics  []metric
+	names    map[string]bool
+	collects []func()
+}
+
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// DefaultRegistry is the registry the New* functions register to
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (registry *Registry) register(m metric) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if registry.names[m.name()] {
+		panic(fmt.Sprintf("metric %s is registered already", m.name()))
+	}
+	registry.names[m.name()] = true
+	registry.metrics = append(registry.metrics, m)
+}
+
+// OnCollect adds a function called before the metrics are written, to update the values computed on demand
+func (registry *Registry) OnCollect(fn func()) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.collects = append(registry.collects, fn)
+}
+
+// WriteText writes all metrics ordered by name
+func (registry *Registry) WriteText(w io.Writer) error {
+	registry.lock.Lock()
+	metrics := make([]metric, len(registry.metrics))
+	copy(metrics, registry.metrics)
+	collects := make([]func(), len(registry.collects))
+	copy(collects, registry.collects)
+	registry.lock.Unlock()
+	for _, collect := range collects {
+		collect()
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+func (registry *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		registry.WriteText(w)
+	})
+}
+
+// desc is the name, help and labels shared by the series of a metric
+type desc struct {
+	metricName string
+	help       string
+	metricType string
+	labelNames []string
+}
+
+func (d *desc) name() string {
+	return d.metricName
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.metricName, strings.NewReplacer("\\", `\\`, "\n", `\n`).Replace(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.metricName, d.metricType)
+}
+
+func (d *desc) key(labelValues []string) string {
+	if len(labelValues) != len(d.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", d.metricName, len(d.labelNames), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+// formatLabels renders {a="1",b="2"}, extra is appended as is, e.g. le="0.5" of histograms
+func formatLabels(names []string, values []string, extra string) string {
+	parts := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		value := strings.NewReplacer("\\", `\\`, "\"", `\"`, "\n", `\n`).Replace(values[i])
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, name, value))
+	}
+	if extra != "" {
+		parts = append(parts, extra)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Value is a counter or a gauge of one set of label values
+type Value struct {
+	lock  sync.Mutex
+	value float64
+}
+
+func (v *Value) Add(delta float64) {
+	v.lock.Lock()
+	v.value += delta
+	v.lock.Unlock()
+}
+
+func (v *Value) Inc() {
+	v.Add(1)
+}
+
+func (v *Value) Dec() {
+	v.Add(-1)
+}
+
+func (v *Value) Set(value float64) {
+	v.lock.Lock()
+	v.value = value
+	v.lock.Unlock()
+}
+
+func (v *Value) SetToCurrentTime() {
+	v.Set(float64(time.Now().UnixNano()) / 1e9)
+}
+
+func (v *Value) Get() float64 {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.value
+}
+
+// Vec is a counter or a gauge partitioned by labels
+type Vec struct {
+	desc
+	lock   sync.Mutex
+	values map[string]*Value
+	labels map[string][]string
+}
+
+func newVec(metricType string, name string, help string, labelNames []string) *Vec {
+	vec := &Vec{
+		desc:   desc{metricName: name, help: help, metricType: metricType, labelNames: labelNames},
+		values: make(map[string]*Value),
+		labels: make(map[string][]string),
+	}
+	DefaultRegistry.register(vec)
+	return vec
+}
+
+// NewCounterVec registers a counter, its values must only increase
+func NewCounterVec(name string, help string, labelNames ...string) *Vec {
+	return newVec("counter", name, help, labelNames)
+}
+
+func NewGaugeVec(name string, help string, labelNames ...string) *Vec {
+	return newVec("gauge", name, help, labelNames)
+}
+
+// WithLabelValues returns the value of the label values, in the order of the label names
+func (vec *Vec) WithLabelValues(labelValues ...string) *Value {
+	key := vec.key(labelValues)
+	vec.lock.Lock()
+	defer vec.lock.Unlock()
+	value, ok := vec.values[key]
+	if !ok {
+		value = &Value{}
+		vec.values[key] = value
+		vec.labels[key] = append([]string{}, labelValues...)
+	}
+	return value
+}
+
+// Delete removes the series of the label values
+func (vec *Vec) Delete(labelValues ...string) {
+	key := vec.key(labelValues)
+	vec.lock.Lock()
+	defer vec.lock.Unlock()
+	delete(vec.values, key)
+	delete(vec.labels, key)
+}
+
+func (vec *Vec) write(w *bufio.Writer) {
+	vec.writeHeader(w)
+	vec.lock.Lock()
+	keys := make([]string, 0, len(vec.values))
+	for key := range vec.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", vec.metricName, formatLabels(vec.labelNames, vec.labels[key], ""), formatFloat(vec.values[key].Get()))
+	}
+	vec.lock.Unlock()
+}
+
+// DefaultBuckets suits latencies from milliseconds to minutes, in seconds
+var DefaultBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+type Histogram struct {
+	lock    sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func (histogram *Histogram) Observe(v float64) {
+	histogram.lock.Lock()
+	defer histogram.lock.Unlock()
+	for i, bound := range histogram.buckets {
+		if v <= bound {
+			histogram.counts[i]++
+		}
+	}
+	histogram.sum += v
+	histogram.count++
+}
+
+// ObserveSince observes the seconds elapsed since start
+func (histogram *Histogram) ObserveSince(start time.Time) {
+	histogram.Observe(time.Since(start).Seconds())
+}
+
+type HistogramVec struct {
+	desc
+	buckets    []float64
+	lock       sync.Mutex
+	histograms map[string]*Histogram
+	labels     map[string][]string
+}
+
+// NewHistogramVec registers a histogram of the upper bounds buckets, DefaultBuckets if nil
+func NewHistogramVec(name string, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	vec := &HistogramVec{
+		desc:       desc{metricName: name, help: help, metricType: "histogram", labelNames: labelNames},
+		buckets:    buckets,
+		histograms: make(map[string]*Histogram),
+		labels:     make(map[string][]string),
+	}
+	DefaultRegistry.register(vec)
+	return vec
+}
+
+func (vec *HistogramVec) WithLabelValues(labelValues ...string) *Histogram {
+	key := vec.key(labelValues)
+	vec.lock.Lock()
+	defer vec.lock.Unlock()
+	histogram, ok := vec.histograms[key]
+	if !ok {
+		histogram = &Histogram{buckets: vec.buckets, counts: make([]uint64, len(vec.buckets))}
+		vec.histograms[key] = histogram
+		vec.labels[key] = append([]string{}, labelValues...)
+	}
+	return histogram
+}
+
+func (vec *HistogramVec) write(w *bufio.Writer) {
+	vec.writeHeader(w)
+	vec.lock.Lock()
+	keys := make([]string, 0, len(vec.histograms))
+	for key := range vec.histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		histogram := vec.histograms[key]
+		labels := vec.labels[key]
+		histogram.lock.Lock()
+		for i, bound := range histogram.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", vec.metricName, formatLabels(vec.labelNames, labels, fmt.Sprintf(`le="%s"`, formatFloat(bound))), histogram.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", vec.metricName, formatLabels(vec.labelNames, labels, `le="+Inf"`), histogram.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", vec.metricName, formatLabels(vec.labelNames, labels, ""), formatFloat(histogram.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", vec.metricName, formatLabels(vec.labelNames, labels, ""), histogram.count)
+		histogram.lock.Unlock()
+	}
+	vec.lock.Unlock()
+}
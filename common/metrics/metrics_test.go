@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	counter := NewCounterVec("test_bytes_total", "Bytes\ntransferred.", "job")
+	counter.WithLabelValues("b").Add(10)
+	counter.WithLabelValues(`a"1`).Inc()
+	gauge := NewGaugeVec("test_running", "Running jobs.")
+	gauge.WithLabelValues().Inc()
+	histogram := NewHistogramVec("test_latency_seconds", "Latency.", []float64{1, 0.1}, "job")
+	histogram.WithLabelValues("a").Observe(0.05)
+	histogram.WithLabelValues("a").Observe(0.5)
+	histogram.WithLabelValues("a").Observe(5)
+	collected := false
+	DefaultRegistry.OnCollect(func() { collected = true })
+
+	var buf bytes.Buffer
+	if err := DefaultRegistry.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP test_bytes_total Bytes\ntransferred.
+# TYPE test_bytes_total counter
+test_bytes_total{job="a\"1"} 1
+test_bytes_total{job="b"} 10
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{job="a",le="0.1"} 1
+test_latency_seconds_bucket{job="a",le="1"} 2
+test_latency_seconds_bucket{job="a",le="+Inf"} 3
+test_latency_seconds_sum{job="a"} 5.55
+test_latency_seconds_count{job="a"} 3
+# HELP test_running Running jobs.
+# TYPE test_running gauge
+test_running 1
+`
+	if buf.String() != expected {
+		t.Fatalf("unexpected output:\n%s", buf.String())
+	}
+	if !collected {
+		t.Fatal("collect hook is not called")
+	}
+
+	counter.Delete("b")
+	recorder := httptest.NewRecorder()
+	DefaultRegistry.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain") || strings.Contains(recorder.Body.String(), `job="b"`) {
+		t.Fatalf("unexpected response %s", recorder.Body.String())
+	}
+}
+
+func TestLabelCount(t *testing.T) {
+	counter := NewCounterVec("test_labels_total", "Labels.", "job", "class")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect a panic of wrong label count")
+		}
+	}()
+	counter.WithLabelValues("a")
+}
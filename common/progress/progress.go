@@ -0,0 +1,268 @@
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// throughputWindow is how far back the transferred bytes are looked at to compute the throughput
+const throughputWindow = 10 * time.Second
+
+// Tracker counts the files and bytes of a run, a nil Tracker counts nothing.
+// Totals grow as files are found, so they are only final after Counted is called.
+type Tracker struct {
+	filesTotal  int64
+	filesDone   int64
+	filesFailed int64
+	bytesTotal  int64
+	bytesDone   int64
+	// transferred only counts the bytes really written, the bytes of skipped files are done but not transferred
+	transferred int64
+	counted     int32
+
+	start   time.Time
+	samples []sample
+	lock    sync.Mutex
+}
+
+type sample struct {
+	at          time.Time
+	transferred int64
+}
+
+type Snapshot struct {
+	FilesTotal  int64
+	FilesDone   int64
+	FilesFailed int64
+	BytesTotal  int64
+	BytesDone   int64
+	Transferred int64
+	// Counting is true while the totals are still growing
+	Counting bool
+	Elapsed  time.Duration
+	// Throughput is in bytes per second
+	Throughput float64
+	// ETA is zero when unknown
+	ETA time.Duration
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{start: time.Now()}
+}
+
+// AddFile adds a file to the totals
+func (tracker *Tracker) AddFile(size int64) {
+	if tracker == nil {
+		return
+	}
+	atomic.AddInt64(&tracker.filesTotal, 1)
+	atomic.AddInt64(&tracker.bytesTotal, size)
+}
+
+// Counted tells that every file has been added
+func (tracker *Tracker) Counted() {
+	if tracker == nil {
+		return
+	}
+	atomic.StoreInt32(&tracker.counted, 1)
+}
+
+// StartFile returns the counter of a file added by AddFile, Done or Fail must be called at the end
+func (tracker *Tracker) StartFile(size int64) *File {
+	if tracker == nil {
+		return nil
+	}
+	return &File{tracker: tracker, size: size}
+}
+
+func (tracker *Tracker) Snapshot() Snapshot {
+	if tracker == nil {
+		return Snapshot{}
+	}
+	now := time.Now()
+	s := Snapshot{
+		FilesTotal:  atomic.LoadInt64(&tracker.filesTotal),
+		FilesDone:   atomic.LoadInt64(&tracker.filesDone),
+		FilesFailed: atomic.LoadInt64(&tracker.filesFailed),
+		BytesTotal:  atomic.LoadInt64(&tracker.bytesTotal),
+		BytesDone:   atomic.LoadInt64(&tracker.bytesDone),
+		Transferred: atomic.LoadInt64(&tracker.transferred),
+		Counting:    atomic.LoadInt32(&tracker.counted) == 0,
+		Elapsed:     now.Sub(tracker.start),
+	}
+
+	tracker.lock.Lock()
+	tracker.samples = append(tracker.samples, sample{at: now, transferred: s.Transferred})
+	first := 0
+	for first < len(tracker.samples)-1 && now.Sub(tracker.samples[first].at) > throughputWindow {
+		first++
+	}
+	tracker.samples = tracker.samples[first:]
+	oldest := sample{at: tracker.start}
+	if now.Sub(tracker.start) > throughputWindow {
+		oldest = tracker.samples[0]
+	}
+	tracker.lock.Unlock()
+
+	if elapsed := now.Sub(oldest.at).Seconds(); elapsed > 0 {
+		s.Throughput = float64(s.Transferred-oldest.transferred) / elapsed
+	}
+	if remaining := s.BytesTotal - s.BytesDone; remaining > 0 && s.Throughput > 0 {
+		s.ETA = time.Duration(float64(remaining) / s.Throughput * float64(time.Second))
+	}
+	return s
+}
+
+// File counts the bytes of a file being transferred
+type File struct {
+	tracker *Tracker
+	size    int64
+	written int64
+}
+
+func (file *File) Add(n int64) {
+	if file == nil || file.tracker == nil {
+		return
+	}
+	file.written += n
+	atomic.AddInt64(&file.tracker.bytesDone, n)
+	atomic.AddInt64(&file.tracker.transferred, n)
+}
+
+// Done marks the file done, the whole size is done even if fewer bytes were transferred,
+// e.g. the file was up to date, and bytes above the size (encryption headers) are not counted twice
+func (file *File) Done() {
+	if file == nil || file.tracker == nil {
+		return
+	}
+	atomic.AddInt64(&file.tracker.filesDone, 1)
+	atomic.AddInt64(&file.tracker.bytesDone, file.size-file.written)
+	file.written = file.size
+}
+
+// Fail marks the file failed, its bytes are removed from the totals
+func (file *File) Fail() {
+	if file == nil || file.tracker == nil {
+		return
+	}
+	atomic.AddInt64(&file.tracker.filesFailed, 1)
+	atomic.AddInt64(&file.tracker.filesTotal, -1)
+	atomic.AddInt64(&file.tracker.bytesTotal, -file.size)
+	atomic.AddInt64(&file.tracker.bytesDone, -file.written)
+	file.written = 0
+}
+
+// Writer counts the bytes written to w
+func (file *File) Writer(w io.Writer) io.Writer {
+	if file == nil || file.tracker == nil {
+		return w
+	}
+	return &countingWriter{file: file, w: w}
+}
+
+type countingWriter struct {
+	file *File
+	w    io.Writer
+}
+
+func (writer *countingWriter) Write(p []byte) (int, error) {
+	n, err := writer.w.Write(p)
+	writer.file.Add(int64(n))
+	return n, err
+}
+
+// Percent is the percentage of the bytes done, of the files done when there are only empty files
+func (s Snapshot) Percent() float64 {
+	if s.BytesTotal > 0 {
+		return float64(s.BytesDone) * 100 / float64(s.BytesTotal)
+	}
+	if s.FilesTotal > 0 {
+		return float64(s.FilesDone) * 100 / float64(s.FilesTotal)
+	}
+	return 0
+}
+
+func (s Snapshot) String() string {
+	plus := ""
+	if s.Counting {
+		plus = "+"
+	}
+	text := fmt.Sprintf("%.1f%% files %d/%d%s bytes %s/%s%s %s/s",
+		s.Percent(), s.FilesDone, s.FilesTotal, plus, FormatBytes(s.BytesDone), FormatBytes(s.BytesTotal), plus, FormatBytes(int64(s.Throughput)))
+	if s.FilesFailed > 0 {
+		text += fmt.Sprintf(" failed %d", s.FilesFailed)
+	}
+	if s.ETA > 0 {
+		text += " ETA " + s.ETA.Round(time.Second).String()
+	}
+	return text
+}
+
+// Bar renders a progress bar of width characters followed by the snapshot
+func (s Snapshot) Bar(width int) string {
+	filled := int(s.Percent() * float64(width) / 100)
+	if filled > width {
+		filled = width
+	}
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", width-filled) + "] " + s.String()
+}
+
+type snapshotJSON struct {
+	FilesTotal  int64   `json:"files_total"`
+	FilesDone   int64   `json:"files_done"`
+	FilesFailed int64   `json:"files_failed"`
+	BytesTotal  int64   `json:"bytes_total"`
+	BytesDone   int64   `json:"bytes_done"`
+	Counting    bool    `json:"counting"`
+	Elapsed     float64 `json:"elapsed_seconds"`
+	Throughput  float64 `json:"bytes_per_second"`
+	ETA         float64 `json:"eta_seconds"`
+}
+
+type event struct {
+	Event string `json:"event"`
+	Job   string `json:"job,omitempty"`
+	snapshotJSON
+}
+
+func (s Snapshot) toJSON() snapshotJSON {
+	return snapshotJSON{
+		FilesTotal:  s.FilesTotal,
+		FilesDone:   s.FilesDone,
+		FilesFailed: s.FilesFailed,
+		BytesTotal:  s.BytesTotal,
+		BytesDone:   s.BytesDone,
+		Counting:    s.Counting,
+		Elapsed:     s.Elapsed.Seconds(),
+		Throughput:  s.Throughput,
+		ETA:         s.ETA.Seconds(),
+	}
+}
+
+func (s Snapshot) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.toJSON())
+}
+
+// JSON renders the snapshot as a json event of one line
+func (s Snapshot) JSON(eventName string, job string) string {
+	buffer, _ := json.Marshal(event{Event: eventName, Job: job, snapshotJSON: s.toJSON()})
+	return string(buffer)
+}
+
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
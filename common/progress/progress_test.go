@@ -0,0 +1,76 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTracker(t *testing.T) {
+	tracker := NewTracker()
+	tracker.AddFile(100)
+	tracker.AddFile(50)
+	tracker.AddFile(10)
+
+	// transferred with 20 bytes of encryption header
+	copied := tracker.StartFile(100)
+	if _, err := io.Copy(copied.Writer(io.Discard), bytes.NewReader(make([]byte, 120))); err != nil {
+		t.Fatal(err)
+	}
+	s := tracker.Snapshot()
+	if s.BytesDone != 120 || s.Transferred != 120 || !s.Counting {
+		t.Fatalf("unexpected snapshot %+v", s)
+	}
+	copied.Done()
+	// up to date, nothing transferred
+	tracker.StartFile(50).Done()
+	failed := tracker.StartFile(10)
+	failed.Add(5)
+	failed.Fail()
+	tracker.Counted()
+
+	s = tracker.Snapshot()
+	if s.FilesTotal != 2 || s.FilesDone != 2 || s.FilesFailed != 1 || s.BytesTotal != 150 || s.BytesDone != 150 ||
+		s.Transferred != 125 || s.Counting || s.Percent() != 100 || s.ETA != 0 {
+		t.Fatalf("unexpected snapshot %+v", s)
+	}
+	if s.Throughput <= 0 {
+		t.Fatalf("unexpected throughput %f", s.Throughput)
+	}
+	if text := s.Bar(10); !strings.HasPrefix(text, "[==========] 100.0% files 2/2 bytes 150B/150B") || !strings.Contains(text, "failed 1") {
+		t.Fatalf("unexpected bar %s", text)
+	}
+	values := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(s.JSON("done", "photos")), &values); err != nil {
+		t.Fatal(err)
+	}
+	if values["event"] != "done" || values["job"] != "photos" || values["bytes_done"] != float64(150) {
+		t.Fatalf("unexpected event %v", values)
+	}
+}
+
+func TestNilTracker(t *testing.T) {
+	var tracker *Tracker
+	tracker.AddFile(10)
+	file := tracker.StartFile(10)
+	file.Add(10)
+	file.Done()
+	file.Fail()
+	var buf bytes.Buffer
+	if w := file.Writer(&buf); w != &buf {
+		t.Fatal("expect the writer itself")
+	}
+	if s := tracker.Snapshot(); s.FilesTotal != 0 {
+		t.Fatalf("unexpected snapshot %+v", s)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, expected := range map[int64]string{0: "0B", 1023: "1023B", 1024: "1.0KiB", 1536: "1.5KiB", 5 << 30: "5.0GiB"} {
+		if actual := FormatBytes(n); actual != expected {
+			t.Errorf("%d: expect %s, got %s", n, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"osssync/common/tracing"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Policy retries an operation with exponential backoff and jitter while its errors are retryable
+type Policy struct {
+	// Attempts is the max count of calls, 1 means no retry
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Budget limits the retries of all operations sharing it, nil is unlimited
+	Budget *Budget
+	// Retryable returns true for the transient errors, IsTransient if nil
+	Retryable func(err error) bool
+	// OnRetry is called before waiting for a retry, it may be nil
+	OnRetry func(attempt int, delay time.Duration, err error)
+}
+
+// Do calls fn until it succeeds, fails with a permanent error, the attempts or the budget are exhausted.
+// The error of the last call is returned.
+func (policy Policy) Do(fn func() error) error {
+	return policy.DoContext(context.Background(), fn)
+}
+
+// DoContext is Do stopping the retries once ctx is done, the error of ctx is returned then
+func (policy Policy) DoContext(ctx context.Context, fn func() error) error {
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = IsTransient
+	}
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := fn()
+		if err == nil {
+			policy.Budget.deposit()
+			return nil
+		}
+		if attempt >= policy.Attempts || !retryable(err) || !policy.Budget.withdraw() {
+			return err
+		}
+		delay := policy.Backoff(attempt)
+		if policy.OnRetry != nil {
+			policy.OnRetry(attempt, delay, err)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Backoff returns the delay before the retry following attempt:
+// BaseDelay doubled at each attempt up to MaxDelay, half of it randomized
+func (policy Policy) Backoff(attempt int) time.Duration {
+	delay := policy.BaseDelay
+	for i := 1; i < attempt && (policy.MaxDelay <= 0 || delay < policy.MaxDelay); i++ {
+		delay *= 2
+	}
+	if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// Budget allows a retry for a token, tokens are spent by the retries and refilled by the successes,
+// so that a failing backend is not flooded with retries
+type Budget struct {
+	lock   sync.Mutex
+	tokens float64
+	max    float64
+	refill float64
+}
+
+// NewBudget returns a full budget of max retries, each success gives back refill tokens
+func NewBudget(max int, refill float64) *Budget {
+	return &Budget{tokens: float64(max), max: float64(max), refill: refill}
+}
+
+func (budget *Budget) withdraw() bool {
+	if budget == nil {
+		return true
+	}
+	budget.lock.Lock()
+	defer budget.lock.Unlock()
+	if budget.tokens < 1 {
+		return false
+	}
+	budget.tokens--
+	return true
+}
+
+func (budget *Budget) deposit() {
+	if budget == nil {
+		return
+	}
+	budget.lock.Lock()
+	defer budget.lock.Unlock()
+	budget.tokens += budget.refill
+	if budget.tokens > budget.max {
+		budget.tokens = budget.max
+	}
+}
+
+// Tokens returns the count of retries left
+func (budget *Budget) Tokens() float64 {
+	if budget == nil {
+		return 0
+	}
+	budget.lock.Lock()
+	defer budget.lock.Unlock()
+	return budget.tokens
+}
+
+// IsTransient returns true for the network errors worth a retry: timeouts, resets, refused connections and truncated responses.
+// The other errors of the network, e.g. a host not found, fail the same way again.
+func IsTransient(err error) bool {
+	err = tracing.Cause(err)
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
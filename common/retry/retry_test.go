@@ -0,0 +1,97 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+var errPermanent = errors.New("access denied")
+
+func TestPolicyDo(t *testing.T) {
+	policy := Policy{Attempts: 4, BaseDelay: time.Millisecond, MaxDelay: 4 * time.Millisecond}
+
+	calls := 0
+	err := policy.Do(func() error {
+		calls++
+		if calls < 3 {
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("expect success at the 3rd call, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = policy.Do(func() error {
+		calls++
+		return errPermanent
+	})
+	if err != errPermanent || calls != 1 {
+		t.Fatalf("permanent error is retried: %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = policy.Do(func() error {
+		calls++
+		return io.ErrUnexpectedEOF
+	})
+	if err != io.ErrUnexpectedEOF || calls != 4 {
+		t.Fatalf("expect 4 calls, got %d", calls)
+	}
+}
+
+func TestPolicyDoContext(t *testing.T) {
+	policy := Policy{Attempts: 10, BaseDelay: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	time.AfterFunc(20*time.Millisecond, cancel)
+	err := policy.DoContext(ctx, func() error {
+		calls++
+		return io.ErrUnexpectedEOF
+	})
+	if err != context.Canceled || calls != 1 {
+		t.Fatalf("expect the wait for a retry canceled after 1 call, got %v after %d calls", err, calls)
+	}
+
+	err = policy.DoContext(ctx, func() error {
+		t.Fatal("called with a canceled context")
+		return nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("expect context.Canceled, got %v", err)
+	}
+}
+
+func TestPolicyBudget(t *testing.T) {
+	budget := NewBudget(2, 0.5)
+	policy := Policy{Attempts: 10, Budget: budget}
+	calls := 0
+	policy.Do(func() error {
+		calls++
+		return io.ErrUnexpectedEOF
+	})
+	if calls != 3 || budget.Tokens() != 0 {
+		t.Fatalf("expect 2 retries by the budget, got %d calls, %v tokens left", calls, budget.Tokens())
+	}
+
+	policy.Do(func() error { return nil })
+	policy.Do(func() error { return nil })
+	if budget.Tokens() != 1 {
+		t.Fatalf("expect 1 token refilled by 2 successes, got %v", budget.Tokens())
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := Policy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		delay := policy.Backoff(attempt + 1)
+		if delay < max/2 || delay > max {
+			t.Fatalf("delay of attempt %d is %s, expect between %s and %s", attempt+1, delay, max/2, max)
+		}
+	}
+}
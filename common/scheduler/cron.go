@@ -0,0 +1,247 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Schedule interface {
+	// Next returns the first activation time strictly after t
+	Next(t time.Time) time.Time
+}
+
+// CronSchedule is a standard 5 fields cron expression: minute hour day-of-month month day-of-week
+type CronSchedule struct {
+	Expression string
+
+	minute     uint64
+	hour       uint64
+	dayOfMonth uint64
+	month      uint64
+	dayOfWeek  uint64
+	// day-of-month and day-of-week are OR-ed when both are restricted, as crond does
+	domStar bool
+	dowStar bool
+}
+
+// EverySchedule activates at a fixed interval, "@every 1h30m"
+type EverySchedule struct {
+	Interval time.Duration
+}
+
+func (schedule EverySchedule) Next(t time.Time) time.Time {
+	return t.Add(schedule.Interval)
+}
+
+// Manual never activates, a job of this schedule only runs when triggered
+var Manual Schedule = manualSchedule{}
+
+type manualSchedule struct{}
+
+func (manualSchedule) Next(t time.Time) time.Time {
+	return time.Time{}
+}
+
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var (
+	minuteField     = cronField{name: "minute", min: 0, max: 59}
+	hourField       = cronField{name: "hour", min: 0, max: 23}
+	dayOfMonthField = cronField{name: "day of month", min: 1, max: 31}
+	monthField      = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dayOfWeekField = cronField{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a cron expression, a descriptor like "@daily", or "@every <duration>"
+func Parse(expression string) (Schedule, error) {
+	expression = strings.TrimSpace(expression)
+	if strings.HasPrefix(expression, "@every ") {
+		interval, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(expression, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", expression, err)
+		}
+		if interval < time.Second {
+			return nil, fmt.Errorf("invalid cron expression %q: interval must be at least 1s", expression)
+		}
+		return EverySchedule{Interval: interval}, nil
+	}
+	if descriptor, ok := descriptors[strings.ToLower(expression)]; ok {
+		schedule, err := ParseCron(descriptor)
+		if err != nil {
+			return nil, err
+		}
+		schedule.Expression = expression
+		return schedule, nil
+	}
+	return ParseCron(expression)
+}
+
+func ParseCron(expression string) (*CronSchedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expect 5 fields, got %d", expression, len(fields))
+	}
+	schedule := &CronSchedule{
+		Expression: expression,
+		domStar:    strings.HasPrefix(fields[2], "*"),
+		dowStar:    strings.HasPrefix(fields[4], "*"),
+	}
+	var err error
+	if schedule.minute, err = parseField(fields[0], minuteField); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %w", expression, err)
+	}
+	if schedule.hour, err = parseField(fields[1], hourField); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %w", expression, err)
+	}
+	if schedule.dayOfMonth, err = parseField(fields[2], dayOfMonthField); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %w", expression, err)
+	}
+	if schedule.month, err = parseField(fields[3], monthField); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %w", expression, err)
+	}
+	if schedule.dayOfWeek, err = parseField(fields[4], dayOfWeekField); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %w", expression, err)
+	}
+	// 7 is sunday as well
+	if schedule.dayOfWeek&(1<<7) != 0 {
+		schedule.dayOfWeek |= 1
+	}
+	if !schedule.matchable() {
+		return nil, fmt.Errorf("invalid cron expression %q: no day of month is in the months", expression)
+	}
+	return schedule, nil
+}
+
+// daysInMonth are the most days of the months, february of the leap years
+var daysInMonth = [13]int{0, 31, 29, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}
+
+// matchable is false when a day of month is required but none is in the months, e.g. "0 0 30 2 *".
+// A restricted day of week matches other days when both are restricted.
+func (schedule *CronSchedule) matchable() bool {
+	if !schedule.domStar && !schedule.dowStar {
+		return true
+	}
+	for month := 1; month <= 12; month++ {
+		if schedule.month&(1<<uint(month)) == 0 {
+			continue
+		}
+		days := uint64(1)<<uint(daysInMonth[month]+1) - 2
+		if schedule.dayOfMonth&days != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// parseField parses a comma separated list of "*", "a", "a-b", each optionally followed by "/step"
+func parseField(expression string, field cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(expression, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s", part, field.name)
+			}
+			part = part[:i]
+		}
+
+		low, high := field.min, field.max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = parseValue(bounds[0], field); err != nil {
+				return 0, err
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = parseValue(bounds[1], field); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// "a/step" means from a to the end
+				high = field.max
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid range %q in %s", part, field.name)
+			}
+		}
+
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(value string, field cronField) (int, error) {
+	if v, ok := field.names[strings.ToLower(value)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q in %s", value, field.name)
+	}
+	if v < field.min || v > field.max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d] in %s", v, field.min, field.max, field.name)
+	}
+	return v, nil
+}
+
+func (schedule *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := schedule.dayOfMonth&(1<<uint(t.Day())) != 0
+	dowMatch := schedule.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if schedule.domStar || schedule.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func (schedule *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// no expression needs more than a few years to match again, "0 0 29 2 *" being the worst
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if schedule.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location()).AddDate(0, 1, 0)
+			continue
+		}
+		if !schedule.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).AddDate(0, 0, 1)
+			continue
+		}
+		if schedule.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location()).Add(time.Hour)
+			continue
+		}
+		if schedule.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,46 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2022, 7, 15, 10, 30, 20, 0, time.UTC) // friday
+	cases := []struct {
+		expression string
+		expected   time.Time
+	}{
+		{"* * * * *", time.Date(2022, 7, 15, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2022, 7, 15, 10, 45, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2022, 7, 16, 3, 0, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2022, 7, 16, 10, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * mon-fri", time.Date(2022, 7, 15, 13, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2022, 7, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2022, 7, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// both restricted, either matches
+		{"0 0 20 * 1", time.Date(2022, 7, 18, 0, 0, 0, 0, time.UTC)},
+		{"5,10 1 1 aug *", time.Date(2022, 8, 1, 1, 5, 0, 0, time.UTC)},
+		{"@daily", time.Date(2022, 7, 16, 0, 0, 0, 0, time.UTC)},
+		{"@every 90m", from.Add(90 * time.Minute)},
+	}
+	for _, c := range cases {
+		schedule, err := Parse(c.expression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next := schedule.Next(from); !next.Equal(c.expected) {
+			t.Errorf("%s: expect %s, got %s", c.expression, c.expected, next)
+		}
+	}
+}
+
+func TestCronParseError(t *testing.T) {
+	for _, expression := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "@every 1ms", "@every x", "0 0 30 2 *", "0 0 31 4,6,9,11 *", "0 0 30,31 2 */2"} {
+		if _, err := Parse(expression); err == nil {
+			t.Errorf("%q: expect an error", expression)
+		}
+	}
+}
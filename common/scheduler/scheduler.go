@@ -0,0 +1,227 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var ErrJobRunning error = errors.New("job is still running")
+var ErrJobNotFound error = errors.New("job not found")
+
+type Job func() error
+
+type entry struct {
+	name     string
+	schedule Schedule
+	jitter   time.Duration
+	job      Job
+	next     time.Time
+	running  bool
+}
+
+// Scheduler runs jobs by their schedule, a job never overlaps itself:
+// an activation while the previous run is in progress is skipped.
+type Scheduler struct {
+	// OnError is called with the error of a run, a recovered panic,
+	// or ErrJobRunning when an activation is skipped. It may be nil.
+	OnError func(name string, err error)
+
+	entries map[string]*entry
+	lock    sync.Mutex
+	wake    chan struct{}
+	stop    chan struct{}
+	started bool
+	wg      sync.WaitGroup
+	rand    *rand.Rand
+}
+
+func New() *Scheduler {
+	return &Scheduler{
+		entries: make(map[string]*entry),
+		wake:    make(chan struct{}, 1),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Add registers a job, or replaces the schedule of the job with the same name.
+// Each scheduled run is delayed by a random duration in [0, jitter).
+func (s *Scheduler) Add(name string, schedule Schedule, jitter time.Duration, job Job) {
+	s.lock.Lock()
+	e, ok := s.entries[name]
+	if !ok {
+		e = &entry{name: name}
+		s.entries[name] = e
+	}
+	e.schedule = schedule
+	e.jitter = jitter
+	e.job = job
+	e.next = s.nextTime(e, time.Now())
+	s.lock.Unlock()
+	s.notify()
+}
+
+func (s *Scheduler) Remove(name string) {
+	s.lock.Lock()
+	delete(s.entries, name)
+	s.lock.Unlock()
+	s.notify()
+}
+
+// Next returns the time of the next scheduled run of the job, zero if the job does not exist
+func (s *Scheduler) Next(name string) time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if e, ok := s.entries[name]; ok {
+		return e.next
+	}
+	return time.Time{}
+}
+
+// Running returns true if the job is in progress
+func (s *Scheduler) Running(name string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if e, ok := s.entries[name]; ok {
+		return e.running
+	}
+	return false
+}
+
+// Trigger runs the job now regardless of its schedule, the scheduled runs are not affected
+func (s *Scheduler) Trigger(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e, ok := s.entries[name]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if e.running {
+		return ErrJobRunning
+	}
+	s.run(e)
+	return nil
+}
+
+// Start starts scheduling, a stopped scheduler can be started again
+func (s *Scheduler) Start() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	s.stop = make(chan struct{})
+	s.wg.Add(1)
+	go s.loop(s.stop)
+}
+
+// Stop stops scheduling and waits for the running jobs
+func (s *Scheduler) Stop() {
+	s.lock.Lock()
+	if s.started {
+		s.started = false
+		close(s.stop)
+	}
+	s.lock.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) nextTime(e *entry, now time.Time) time.Time {
+	next := e.schedule.Next(now)
+	if next.IsZero() || e.jitter <= 0 {
+		return next
+	}
+	return next.Add(time.Duration(s.rand.Int63n(int64(e.jitter))))
+}
+
+func (s *Scheduler) loop(stop chan struct{}) {
+	defer s.wg.Done()
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		s.lock.Lock()
+		now := time.Now()
+		var earliest time.Time
+		for _, e := range s.entries {
+			if e.next.IsZero() {
+				continue
+			}
+			if !e.next.After(now) {
+				if e.running {
+					s.reportError(e.name, ErrJobRunning)
+				} else {
+					s.run(e)
+				}
+				e.next = s.nextTime(e, now)
+				if e.next.IsZero() {
+					continue
+				}
+			}
+			if earliest.IsZero() || e.next.Before(earliest) {
+				earliest = e.next
+			}
+		}
+		s.lock.Unlock()
+
+		wait := time.Hour
+		if !earliest.IsZero() {
+			wait = time.Until(earliest)
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-stop:
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// run starts the job in a goroutine, the caller must hold the lock
+func (s *Scheduler) run(e *entry) {
+	e.running = true
+	job := e.job
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		err := invoke(job)
+		s.lock.Lock()
+		e.running = false
+		s.lock.Unlock()
+		if err != nil {
+			s.reportError(e.name, err)
+		}
+	}()
+}
+
+func (s *Scheduler) reportError(name string, err error) {
+	if s.OnError != nil {
+		go s.OnError(name, err)
+	}
+}
+
+func invoke(job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return job()
+}
@@ -0,0 +1,127 @@
+package scheduler
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSchedulerOverlap(t *testing.T) {
+	s := New()
+	skipped := int32(0)
+	s.OnError = func(name string, err error) {
+		if errors.Is(err, ErrJobRunning) {
+			atomic.AddInt32(&skipped, 1)
+		}
+	}
+	runs := int32(0)
+	concurrent := int32(0)
+	s.Add("job", EverySchedule{Interval: 20 * time.Millisecond}, 0, func() error {
+		if atomic.AddInt32(&concurrent, 1) > 1 {
+			t.Error("job overlaps")
+		}
+		atomic.AddInt32(&runs, 1)
+		time.Sleep(70 * time.Millisecond)
+		atomic.AddInt32(&concurrent, -1)
+		return nil
+	})
+	s.Start()
+	time.Sleep(300 * time.Millisecond)
+	s.Stop()
+
+	if atomic.LoadInt32(&runs) == 0 || atomic.LoadInt32(&skipped) == 0 {
+		t.Fatalf("unexpected runs %d skipped %d", runs, skipped)
+	}
+}
+
+func TestSchedulerTrigger(t *testing.T) {
+	s := New()
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	release := make(chan struct{})
+	s.Add("job", EverySchedule{Interval: time.Hour}, time.Minute, func() error {
+		defer wg.Done()
+		<-release
+		panic("boom")
+	})
+	failed := make(chan error, 1)
+	s.OnError = func(name string, err error) {
+		if !errors.Is(err, ErrJobRunning) {
+			failed <- err
+		}
+	}
+	s.Start()
+	defer s.Stop()
+
+	if next := s.Next("job"); next.Before(time.Now().Add(time.Hour)) || next.After(time.Now().Add(time.Hour+time.Minute)) {
+		t.Fatalf("unexpected next run %s", next)
+	}
+	if err := s.Trigger("job"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Trigger("job"); err != ErrJobRunning {
+		t.Fatalf("expect ErrJobRunning, got %v", err)
+	}
+	if err := s.Trigger("other"); err != ErrJobNotFound {
+		t.Fatalf("expect ErrJobNotFound, got %v", err)
+	}
+	close(release)
+	wg.Wait()
+	select {
+	case err := <-failed:
+		t.Log(err)
+	case <-time.After(time.Second):
+		t.Fatal("panic of the job is not reported")
+	}
+}
+
+func TestSchedulerManual(t *testing.T) {
+	s := New()
+	runs := make(chan struct{}, 2)
+	s.Add("job", Manual, 0, func() error {
+		runs <- struct{}{}
+		return nil
+	})
+	s.Start()
+	defer s.Stop()
+
+	if next := s.Next("job"); !next.IsZero() {
+		t.Fatalf("manual job is scheduled at %s", next)
+	}
+	select {
+	case <-runs:
+		t.Fatal("manual job runs without a trigger")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := s.Trigger("job"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-runs:
+	case <-time.After(time.Second):
+		t.Fatal("triggered job does not run")
+	}
+}
+
+func TestSchedulerRestart(t *testing.T) {
+	s := New()
+	runs := make(chan struct{}, 100)
+	s.Add("job", EverySchedule{Interval: 10 * time.Millisecond}, 0, func() error {
+		runs <- struct{}{}
+		return nil
+	})
+	for i := 0; i < 2; i++ {
+		s.Start()
+		select {
+		case <-runs:
+		case <-time.After(time.Second):
+			t.Fatalf("no run once started %d times", i+1)
+		}
+		s.Stop()
+		for len(runs) > 0 {
+			<-runs
+		}
+	}
+}
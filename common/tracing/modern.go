@@ -45,6 +45,7 @@ func Errorf(message string, innerError error) error {
 		inner: &ModernError{
 			msg:        innerError.Error(),
 			stacktrace: stacktrace,
+			inner:      innerError,
 		},
 	}
 }
@@ -69,7 +70,7 @@ func sprintModernError(e *ModernError) string {
 	if e.inner != nil {
 		if me, ok := e.inner.(*ModernError); ok {
 			errText = fmt.Sprintf("%s\n Inner Error: %s", errText, sprintModernError(me))
-		} else {
+		} else if e.inner.Error() != e.msg {
 			errText = fmt.Sprintf("%s\n Inner Error: %s", errText, e.inner.Error())
 		}
 	}
@@ -95,3 +96,29 @@ func IsError(e error, err error) bool {
 
 	return false
 }
+
+// Message returns the messages of err and its inner errors, without the stacktraces
+func Message(err error) string {
+	me, ok := err.(*ModernError)
+	if !ok {
+		return err.Error()
+	}
+	msg := me.msg
+	if me.inner != nil {
+		if inner := Message(me.inner); inner != msg {
+			msg = fmt.Sprintf("%s: %s", msg, inner)
+		}
+	}
+	return msg
+}
+
+// Cause returns the innermost error of err
+func Cause(err error) error {
+	for {
+		modern, ok := err.(*ModernError)
+		if !ok || modern.inner == nil {
+			return err
+		}
+		err = modern.inner
+	}
+}
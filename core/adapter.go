@@ -0,0 +1,478 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"osssync/common/tracing"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mr-tron/base58"
+)
+
+// maxChunks is the max count of the chunks of a file written by WalkChunk
+const maxChunks = 10000
+
+// BackendFile is the FileInfo of a file of a Backend. The file is read by Open and written by an Upload
+// started by the first write and committed by Flush, an upload which is not committed is aborted by Close.
+// A backend without storage classes has readable Standard files only, a backend without ServerCopier copies nothing.
+type BackendFile struct {
+	backend      Backend
+	relativePath string
+	// ctx is the context of the open, the reader and the writer have no context of their own
+	ctx context.Context
+	// stat is nil if the file does not exist
+	stat         *Stat
+	storageClass StorageClass
+	mode         fs.FileMode
+	modTime      time.Time
+	metadata     map[string]string
+	headers      map[string]string
+	upload       Upload
+	multipart    bool
+	body         io.Closer
+
+	hashed bool
+	md5    []byte
+	crc64  uint64
+}
+
+// NewBackendFile opens the file of relativePath of backend, the file may not exist
+func NewBackendFile(ctx context.Context, backend Backend, relativePath string) (*BackendFile, error) {
+	file := &BackendFile{backend: backend, relativePath: relativePath, ctx: ctx}
+	err := file.refresh(ctx)
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	return file, nil
+}
+
+// Backend returns the backend of the file
+func (file *BackendFile) Backend() Backend {
+	return file.backend
+}
+
+func (file *BackendFile) refresh(ctx context.Context) error {
+	stat, err := file.backend.Stat(ctx, file.relativePath)
+	if tracing.IsError(err, ErrNotFound) {
+		stat, err = nil, nil
+	}
+	if err != nil {
+		return tracing.Error(err)
+	}
+	file.stat = stat
+	file.hashed = false
+	return nil
+}
+
+func (file *BackendFile) FileType() string {
+	return string(file.backend.Type())
+}
+
+func (file *BackendFile) location() string {
+	return JoinUri(file.backend.Root(), file.relativePath)
+}
+
+func (file *BackendFile) Name() string {
+	location := file.location()
+	return location[strings.LastIndex(location, "/")+1:]
+}
+
+// Path is the uri of the directory of the file
+func (file *BackendFile) Path() string {
+	location := file.location()
+	lastIndexOf := strings.LastIndex(location, "/")
+	if lastIndexOf == -1 {
+		return "/"
+	}
+	return location[:lastIndexOf]
+}
+
+func (file *BackendFile) RelativePath() string {
+	return file.relativePath
+}
+
+func (file *BackendFile) Size() int64 {
+	if file.stat == nil {
+		return 0
+	}
+	return file.stat.Size
+}
+
+func (file *BackendFile) Exists(ctx context.Context) (bool, error) {
+	return file.stat != nil, nil
+}
+
+// hash computes the checksums of a file of a Hasher once
+func (file *BackendFile) hash(ctx context.Context, hasher Hasher) error {
+	if file.hashed || file.stat == nil {
+		return nil
+	}
+	md5, crc64, err := hasher.Hash(ctx, file.relativePath)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	file.md5, file.crc64, file.hashed = md5, crc64, true
+	return nil
+}
+
+func (file *BackendFile) MD5(ctx context.Context) (string, error) {
+	if hasher, ok := file.backend.(Hasher); ok {
+		if err := file.hash(ctx, hasher); err != nil {
+			return "", tracing.Error(err)
+		}
+		if file.md5 == nil {
+			return "", nil
+		}
+		return base58.Encode(file.md5), nil
+	}
+	if file.stat == nil {
+		return "", nil
+	}
+	return file.stat.Properties[PropertyName_ContentMD5], nil
+}
+
+// CRC64 returns the CRC64 of the content, 0 if the file does not exist or the backend does not know it
+func (file *BackendFile) CRC64(ctx context.Context) (uint64, error) {
+	if hasher, ok := file.backend.(Hasher); ok {
+		if err := file.hash(ctx, hasher); err != nil {
+			return 0, tracing.Error(err)
+		}
+		return file.crc64, nil
+	}
+	if file.stat == nil {
+		return 0, nil
+	}
+	return file.stat.CRC64, nil
+}
+
+// Properties returns the properties of the backend along with the PropertyName_* properties shared by the backends,
+// the checksums of a Hasher are set once they are computed
+func (file *BackendFile) Properties() map[PropertyName]string {
+	properties := make(map[PropertyName]string)
+	properties[PropertyName_ContentType] = "application/octet-stream"
+	properties[PropertyName_ContentLength] = "0"
+	properties[PropertyName_ContentModTime] = ""
+	if file.stat != nil {
+		for k, v := range file.stat.Properties {
+			properties[k] = v
+		}
+		properties[PropertyName_ContentLength] = strconv.FormatInt(file.stat.Size, 10)
+		if !file.stat.ModTime.IsZero() {
+			properties[PropertyName_ContentModTime] = file.stat.ModTime.Format(time.RFC3339)
+		}
+		if file.stat.CRC64 != 0 {
+			properties[PropertyName_ContentCRC64] = strconv.FormatUint(file.stat.CRC64, 10)
+		}
+	}
+	if file.hashed {
+		properties[PropertyName_ContentMD5] = base58.Encode(file.md5)
+		properties[PropertyName_ContentCRC64] = strconv.FormatUint(file.crc64, 10)
+	}
+	properties[PropertyName_ContentName] = file.Name()
+	return properties
+}
+
+func (file *BackendFile) Remove(ctx context.Context) error {
+	file.abortUpload()
+	err := file.backend.Delete(ctx, file.relativePath)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	file.stat = nil
+	file.hashed = false
+	return nil
+}
+
+type errReader struct {
+	err error
+}
+
+func (reader errReader) Read(p []byte) (int, error) {
+	return 0, reader.err
+}
+
+// Reader reads the content of the file, the error opening it is returned by the first read
+func (file *BackendFile) Reader() io.Reader {
+	body, err := file.backend.Open(file.ctx, file.relativePath)
+	if err != nil {
+		return errReader{err: tracing.Errorf(fmt.Sprintf("failed to open %s", file.location()), err)}
+	}
+	if file.body != nil {
+		file.body.Close()
+	}
+	file.body = body
+	return body
+}
+
+// OpenReader reads the content of file with the context of the read, the error of the open is returned
+// instead of a reader failing once read. The reader is closed by the caller.
+func OpenReader(ctx context.Context, file FileInfo) (io.ReadCloser, error) {
+	if backendFile, ok := file.(*BackendFile); ok {
+		body, err := backendFile.backend.Open(ctx, backendFile.relativePath)
+		if err != nil {
+			return nil, tracing.Errorf(fmt.Sprintf("failed to open %s", backendFile.location()), err)
+		}
+		return body, nil
+	}
+	reader := file.Reader()
+	if reader == nil {
+		return nil, tracing.Error(fmt.Errorf("failed to open %s", file.RelativePath()))
+	}
+	return io.NopCloser(reader), nil
+}
+
+// begin starts the upload of the content written next
+func (file *BackendFile) begin(ctx context.Context, multipart bool) error {
+	if file.upload != nil {
+		if file.multipart != multipart {
+			return tracing.Error(errors.New("chunks and content can't be written to the same upload"))
+		}
+		return nil
+	}
+	upload, err := file.backend.Create(ctx, file.relativePath, CreateOptions{
+		StorageClass: file.storageClass,
+		Multipart:    multipart,
+		Mode:         file.mode,
+		ModTime:      file.modTime,
+		Metadata:     file.metadata,
+		Headers:      file.headers,
+	})
+	if err != nil {
+		return tracing.Error(err)
+	}
+	file.upload, file.multipart = upload, multipart
+	return nil
+}
+
+func (file *BackendFile) abortUpload() {
+	if file.upload != nil {
+		file.upload.Abort()
+		file.upload = nil
+	}
+}
+
+// suspendUpload leaves the upload of a canceled transfer open for the next transfer of the file if its backend resumes it,
+// otherwise the upload is aborted
+func (file *BackendFile) suspendUpload() {
+	if suspender, ok := file.upload.(Suspender); ok {
+		suspender.Suspend()
+		file.upload = nil
+		return
+	}
+	file.abortUpload()
+}
+
+type fileWriter struct {
+	file *BackendFile
+}
+
+func (writer fileWriter) Write(p []byte) (int, error) {
+	if err := writer.file.begin(writer.file.ctx, false); err != nil {
+		return 0, err
+	}
+	return writer.file.upload.Write(p)
+}
+
+func (file *BackendFile) Writer() io.Writer {
+	return fileWriter{file: file}
+}
+
+// Flush commits the upload, a file nothing was written to is stored empty.
+// The upload is aborted if ctx is done before it is committed, unless the backend resumes it.
+func (file *BackendFile) Flush(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		file.suspendUpload()
+		return tracing.Error(err)
+	}
+	if err := file.begin(ctx, file.multipart); err != nil {
+		return tracing.Error(err)
+	}
+	err := file.upload.Commit(ctx)
+	if err != nil {
+		file.abortUpload()
+		return tracing.Error(err)
+	}
+	file.upload = nil
+	file.multipart = false
+	err = file.refresh(ctx)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	return nil
+}
+
+// WalkChunk writes the chunks read from reader by writer, no chunk is started once ctx is done
+// and the chunks written already are discarded by aborting the upload, unless the backend resumes it
+func (file *BackendFile) WalkChunk(ctx context.Context, reader io.Reader, chunkSize int64, fileSize int64, writer FileChunkWriter) error {
+	chunkNum := int64(math.Ceil(float64(fileSize) / float64(chunkSize)))
+	if chunkNum <= 0 || chunkNum > maxChunks {
+		return tracing.Error(fmt.Errorf("invalid count of chunks %d", chunkNum))
+	}
+	if err := ctx.Err(); err != nil {
+		return tracing.Error(err)
+	}
+
+	chunkReader := NewChunkReader(reader, chunkSize)
+	defer chunkReader.Close()
+	err := file.begin(ctx, true)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	for i := int64(0); i < chunkNum; i++ {
+		size := chunkSize
+		if i == chunkNum-1 {
+			size = fileSize - i*chunkSize
+		}
+		chunk := &FileChunkInfo{
+			Number:    i + 1,
+			ChunkSize: size,
+			Offset:    i * chunkSize,
+		}
+		if err := ctx.Err(); err != nil {
+			file.suspendUpload()
+			return tracing.Error(err)
+		}
+		_, buffer := chunkReader.ReadNext()
+		_, err = writer(ctx, buffer, chunk)
+		if err != nil && ctx.Err() != nil {
+			file.suspendUpload()
+			return err
+		}
+		if err != nil {
+			file.abortUpload()
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteChunk writes a chunk of the multipart upload
+func (file *BackendFile) WriteChunk(ctx context.Context, content []byte, chunk *FileChunkInfo) (n int, err error) {
+	if err := file.begin(ctx, true); err != nil {
+		return 0, tracing.Error(err)
+	}
+	n, err = file.upload.WriteChunk(ctx, content, chunk)
+	if err != nil {
+		return 0, tracing.Error(err)
+	}
+	return n, nil
+}
+
+// Close closes the reader and aborts the upload which is not committed
+func (file *BackendFile) Close() error {
+	file.abortUpload()
+	if file.body != nil {
+		err := file.body.Close()
+		file.body = nil
+		return err
+	}
+	return nil
+}
+
+// SetStorageClass sets the storage class of the file written or copied next
+func (file *BackendFile) SetStorageClass(class StorageClass) {
+	file.storageClass = class
+}
+
+// StorageClass returns the storage class of the file, Standard if it is not known
+func (file *BackendFile) StorageClass() StorageClass {
+	if file.stat != nil && file.stat.StorageClass != "" {
+		return file.stat.StorageClass
+	}
+	return StorageClass_Standard
+}
+
+// SetAttributes sets the permission bits and the modification time of the file written next, a backend which is not a file system ignores them
+func (file *BackendFile) SetAttributes(mode fs.FileMode, modTime time.Time) {
+	file.mode, file.modTime = mode, modTime
+}
+
+// Attributes returns the permission bits and the modification time of the file
+func (file *BackendFile) Attributes() (fs.FileMode, time.Time) {
+	if file.stat == nil {
+		return 0, time.Time{}
+	}
+	return file.stat.Mode, file.stat.ModTime
+}
+
+// SetMetadata sets the user metadata and the content headers of the file written next, a backend without them ignores them
+func (file *BackendFile) SetMetadata(metadata map[string]string, headers map[string]string) {
+	file.metadata, file.headers = metadata, headers
+}
+
+// Metadata returns the user metadata and the content headers of the file
+func (file *BackendFile) Metadata() (map[string]string, map[string]string) {
+	if file.stat == nil {
+		return nil, nil
+	}
+	return file.stat.Metadata, file.stat.Headers
+}
+
+// Restore requests the restore of an archived file
+func (file *BackendFile) Restore(ctx context.Context) error {
+	restorer, ok := file.backend.(Restorer)
+	class := file.StorageClass()
+	if !ok || !class.IsArchived() {
+		return nil
+	}
+	err := restorer.Restore(ctx, file.relativePath, class)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	return nil
+}
+
+// Readable returns true if the file is not archived, or a restored copy of it is available
+func (file *BackendFile) Readable(ctx context.Context) (bool, error) {
+	if !file.StorageClass().IsArchived() {
+		return true, nil
+	}
+	err := file.refresh(ctx)
+	if err != nil {
+		return false, tracing.Error(err)
+	}
+	return file.stat != nil && file.stat.Readable, nil
+}
+
+// CopyFrom copies src to this file within the service of the backend, the content does not go through the host.
+// The storage class is the one set on this file, the copy is checked by the CRC64 of both files and removed if they do not match.
+func (file *BackendFile) CopyFrom(ctx context.Context, src FileInfo) error {
+	srcFile, ok := src.(*BackendFile)
+	copier, canCopy := file.backend.(ServerCopier)
+	if !ok || !canCopy {
+		return tracing.Error(ErrCopyNotSupported)
+	}
+	if srcFile.stat == nil {
+		return tracing.Error(errors.New("source object does not exist"))
+	}
+	err := copier.Copy(ctx, srcFile.backend, srcFile.relativePath, file.relativePath, CreateOptions{StorageClass: file.storageClass})
+	if err != nil {
+		return tracing.Error(err)
+	}
+	err = file.refresh(ctx)
+	if err != nil {
+		return tracing.Error(err)
+	}
+
+	srcCrc64, err := srcFile.CRC64(ctx)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	destCrc64, err := file.CRC64(ctx)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	if srcCrc64 != 0 && destCrc64 != srcCrc64 {
+		if err := file.Remove(ctx); err != nil {
+			return tracing.Errorf(fmt.Sprintf("failed to remove the damaged copy %s", file.location()), err)
+		}
+		return tracing.Errorf(fmt.Sprintf("CRC64 of the copy %d does not match the source %d", destCrc64, srcCrc64), ErrCRC64NotMatch)
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"hash/crc64"
+	"io"
+	"net/http"
+	"osssync/common/tracing"
+	"osssync/core/ossfake"
+	"testing"
+	"time"
+)
+
+func fakeAliOSS(t *testing.T, buckets ...string) (*ossfake.Server, AliOSSConfig) {
+	t.Setenv(Env_AccessKeyId, "")
+	t.Setenv(Env_AccessKeySecret, "")
+	server := ossfake.NewServer(buckets...)
+	t.Cleanup(server.Close)
+	SetTestRetryPolicy(t)
+	return server, AliOSSConfig{EndPoint: server.Endpoint(), AccessKeyId: "id", AccessKeySecret: "secret"}
+}
+
+func crc64Of(content []byte) uint64 {
+	return crc64.Checksum(content, crc64.MakeTable(crc64.ECMA))
+}
+
+func TestAliOSSPutAndGet(t *testing.T) {
+	server, config := fakeAliOSS(t, "photos")
+	ctx := context.Background()
+	content := []byte("the content of a photo")
+
+	file, err := OpenAliOSS(ctx, config, "photos", "2022", "a/1.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := file.Exists(ctx); exists {
+		t.Fatal("a missing object exists")
+	}
+	// a transient error is retried
+	server.FailNext("PutObject", 1, http.StatusServiceUnavailable, "ServiceUnavailable")
+	file.Writer().Write(content)
+	if err := file.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if object, ok := server.Object("photos", "2022/a/1.jpg"); !ok || !bytes.Equal(object.Data, content) {
+		t.Fatal("the object is not uploaded")
+	}
+	if server.Requests("PutObject") != 2 {
+		t.Fatalf("expect the put to be retried once, got %d requests", server.Requests("PutObject"))
+	}
+
+	file, err = OpenAliOSS(ctx, config, "photos", "2022", "a/1.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if file.Size() != int64(len(content)) || file.Name() != "1.jpg" {
+		t.Fatalf("unexpected size %d or name %s", file.Size(), file.Name())
+	}
+	if crc, _ := file.CRC64(ctx); crc != crc64Of(content) {
+		t.Fatalf("unexpected CRC64 %d", crc)
+	}
+	read, err := io.ReadAll(file.Reader())
+	if err != nil || !bytes.Equal(read, content) {
+		t.Fatalf("unexpected content %q %v", read, err)
+	}
+
+	if err := file.Remove(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Object("photos", "2022/a/1.jpg"); ok {
+		t.Fatal("the object is not removed")
+	}
+}
+
+func TestAliOSSMultipartUpload(t *testing.T) {
+	server, config := fakeAliOSS(t, "photos")
+	ctx := context.Background()
+	content := []byte("0123456789")
+
+	file, err := OpenAliOSS(ctx, config, "photos", "videos", "video.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = file.WalkChunk(ctx, bytes.NewReader(content), 4, int64(len(content)), file.WriteChunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if object, _ := server.Object("photos", "videos/video.mp4"); !bytes.Equal(object.Data, content) {
+		t.Fatalf("unexpected content %q", object.Data)
+	}
+	if server.Requests("UploadPart") != 3 {
+		t.Fatalf("expect 3 parts, got %d", server.Requests("UploadPart"))
+	}
+
+	// an upload failing is aborted
+	file, _ = OpenAliOSS(ctx, config, "photos", "videos", "other.mp4")
+	server.FailNext("UploadPart", 1, http.StatusBadRequest, "InvalidArgument")
+	if err := file.WalkChunk(ctx, bytes.NewReader(content), 4, int64(len(content)), file.WriteChunk); err == nil {
+		t.Fatal("expect an error of the failing part")
+	}
+	if server.Uploads() != 0 {
+		t.Fatal("the failed upload is not aborted")
+	}
+}
+
+func TestAliOSSResumeUpload(t *testing.T) {
+	server, config := fakeAliOSS(t, "photos")
+	content := []byte("0123456789")
+
+	// the transfer is canceled once 2 chunks are written
+	ctx, cancel := context.WithCancel(context.Background())
+	file, err := OpenAliOSS(ctx, config, "photos", "videos", "video.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := func(ctx context.Context, content []byte, chunk *FileChunkInfo) (int, error) {
+		n, err := file.WriteChunk(ctx, content, chunk)
+		if chunk.Number == 2 {
+			cancel()
+		}
+		return n, err
+	}
+	if err := file.WalkChunk(ctx, bytes.NewReader(content), 4, int64(len(content)), writer); err == nil {
+		t.Fatal("expect an error of the canceled transfer")
+	}
+	file.Close()
+	if server.Uploads() != 1 {
+		t.Fatalf("expect the canceled upload to be left open, got %d uploads", server.Uploads())
+	}
+
+	// the next transfer uploads the chunks whose content changed and those not uploaded yet
+	content = []byte("abcd456789")
+	ctx = context.Background()
+	file, err = OpenAliOSS(ctx, config, "photos", "videos", "video.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := file.WalkChunk(ctx, bytes.NewReader(content), 4, int64(len(content)), file.WriteChunk); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if object, _ := server.Object("photos", "videos/video.mp4"); !bytes.Equal(object.Data, content) {
+		t.Fatalf("unexpected content %q", object.Data)
+	}
+	if server.Requests("InitiateMultipartUpload") != 1 || server.Requests("UploadPart") != 4 {
+		t.Fatalf("expect the upload to be resumed, got %d uploads and %d parts", server.Requests("InitiateMultipartUpload"), server.Requests("UploadPart"))
+	}
+	if server.Uploads() != 0 {
+		t.Fatal("the resumed upload is not completed")
+	}
+}
+
+func TestLsAliOss(t *testing.T) {
+	server, config := fakeAliOSS(t, "photos")
+	server.ListPageSize = 2
+	for _, key := range []string{"2022/a.jpg", "2022/b.jpg", "2022/c/d.jpg", "2022/e.jpg", "2023/f.jpg"} {
+		server.PutObject("photos", key, []byte(key), "")
+	}
+	server.PutObject("photos", "2022/old.jpg", []byte("old"), "Archive")
+
+	var paths []string
+	classes := make(map[string]StorageClass)
+	token := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("the listing does not end")
+		}
+		bucketInfo, err := LsAliOss(context.Background(), config, "oss://photos/2022/", token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, object := range bucketInfo.Objects {
+			paths = append(paths, object.RelativePath)
+			classes[object.RelativePath] = object.StorageClass
+		}
+		if !bucketInfo.IsTruncated {
+			break
+		}
+		token = bucketInfo.ContinueToken
+	}
+	expected := []string{"a.jpg", "b.jpg", "c/d.jpg", "e.jpg", "old.jpg"}
+	if len(paths) != len(expected) {
+		t.Fatalf("unexpected objects %v", paths)
+	}
+	for i := range expected {
+		if paths[i] != expected[i] {
+			t.Fatalf("unexpected objects %v", paths)
+		}
+	}
+	if classes["old.jpg"] != StorageClass_Archive || classes["a.jpg"] != StorageClass_Standard {
+		t.Fatalf("unexpected storage classes %v", classes)
+	}
+}
+
+func TestAliOSSCopyFrom(t *testing.T) {
+	server, config := fakeAliOSS(t, "photos", "backup")
+	ctx := context.Background()
+	content := []byte("the content copied within the storage")
+	server.PutObject("photos", "2022/a.jpg", content, "")
+
+	src, err := OpenAliOSS(ctx, config, "photos", "2022", "a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest, _ := OpenAliOSS(ctx, config, "backup", "2022", "a.jpg")
+	dest.(Tiered).SetStorageClass(StorageClass_IA)
+	if err := dest.(Copier).CopyFrom(ctx, src); err != nil {
+		t.Fatal(err)
+	}
+	object, _ := server.Object("backup", "2022/a.jpg")
+	if !bytes.Equal(object.Data, content) || object.StorageClass != string(StorageClass_IA) {
+		t.Fatalf("unexpected copy %q of class %s", object.Data, object.StorageClass)
+	}
+
+	defer func(maxSize int64, partSize int64) {
+		maxCopyObjectSize, copyPartSize = maxSize, partSize
+	}(maxCopyObjectSize, copyPartSize)
+	maxCopyObjectSize, copyPartSize = 8, 5
+	dest, _ = OpenAliOSS(ctx, config, "backup", "2022", "b.jpg")
+	if err := dest.(Copier).CopyFrom(ctx, src); err != nil {
+		t.Fatal(err)
+	}
+	if object, _ := server.Object("backup", "2022/b.jpg"); !bytes.Equal(object.Data, content) {
+		t.Fatalf("unexpected copy by parts %q", object.Data)
+	}
+	if parts := server.Requests("UploadPartCopy"); parts != (len(content)+4)/5 {
+		t.Fatalf("unexpected count of copied parts %d", parts)
+	}
+}
+
+func TestAliOSSCopyNotSupported(t *testing.T) {
+	server, config := fakeAliOSS(t, "photos", "backup")
+	other, otherConfig := fakeAliOSS(t, "backup")
+	ctx := context.Background()
+	server.PutObject("photos", "2022/a.jpg", []byte("the content of another region"), "")
+
+	src, err := OpenAliOSS(ctx, config, "photos", "2022", "a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest, _ := OpenAliOSS(ctx, otherConfig, "backup", "2022", "a.jpg")
+	if err := dest.(Copier).CopyFrom(ctx, src); !tracing.IsError(err, ErrCopyNotSupported) {
+		t.Fatalf("expect a copy to another endpoint to be unsupported, got %v", err)
+	}
+	if other.Requests("CopyObject") != 0 {
+		t.Fatal("expect the copy not to be requested")
+	}
+
+	// the credentials of the destination may not read the source
+	server.FailNext("CopyObject", 1, http.StatusForbidden, "AccessDenied")
+	dest, _ = OpenAliOSS(ctx, config, "backup", "2022", "a.jpg")
+	if err := dest.(Copier).CopyFrom(ctx, src); !tracing.IsError(err, ErrCopyNotSupported) {
+		t.Fatalf("expect a forbidden copy to be unsupported, got %v", err)
+	}
+	if _, ok := server.Object("backup", "2022/a.jpg"); ok {
+		t.Fatal("the forbidden copy is written")
+	}
+}
+
+func TestAliOSSRestore(t *testing.T) {
+	server, config := fakeAliOSS(t, "photos")
+	ctx := context.Background()
+	server.RestoreDelay = time.Hour
+	server.PutObject("photos", "2022/old.jpg", []byte("old"), string(StorageClass_Archive))
+
+	file, err := OpenAliOSS(ctx, config, "photos", "2022", "old.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tiered := file.(Tiered)
+	if tiered.StorageClass() != StorageClass_Archive {
+		t.Fatalf("unexpected storage class %s", tiered.StorageClass())
+	}
+	if readable, _ := tiered.Readable(ctx); readable {
+		t.Fatal("an archived object is readable")
+	}
+	if err := tiered.Restore(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// a restore in progress is not an error
+	if err := tiered.Restore(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if readable, _ := tiered.Readable(ctx); readable {
+		t.Fatal("an object being restored is readable")
+	}
+
+	server.RestoreDelay = 0
+	server.PutObject("photos", "2022/older.jpg", []byte("older"), string(StorageClass_ColdArchive))
+	file, _ = OpenAliOSS(ctx, config, "photos", "2022", "older.jpg")
+	if err := file.(Tiered).Restore(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if readable, err := file.(Tiered).Readable(ctx); !readable || err != nil {
+		t.Fatalf("a restored object is not readable %v", err)
+	}
+}
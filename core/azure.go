@@ -0,0 +1,452 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"osssync/common/config"
+	"osssync/common/tracing"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/mr-tron/base58"
+)
+
+const (
+	Env_AzureStorageAccount = "AZURE_STORAGE_ACCOUNT"
+	Env_AzureStorageKey     = "AZURE_STORAGE_KEY"
+)
+
+// crc64MetadataKey is the user metadata of the CRC64 of the content written by osssync to the object storages computing none,
+// a name of Azure metadata is a C# identifier
+const crc64MetadataKey = "crc64"
+
+// AzureBlobConfig is the azblob section of the credentials file, the account and its key are read from
+// AZURE_STORAGE_ACCOUNT and AZURE_STORAGE_KEY if they are not set:
+//
+//	azblob:
+//	  account_name: backups
+//	  account_key: ...
+type AzureBlobConfig struct {
+	AccountName string `yaml:"account_name"`
+	AccountKey  string `yaml:"account_key"`
+	// SASToken authorizes the requests instead of the account key, e.g. sv=...&sig=...
+	SASToken string `yaml:"sas_token"`
+	// Endpoint is the url of the blob service, https://<account_name>.blob.core.windows.net by default,
+	// e.g. http://127.0.0.1:10000/devstoreaccount1 for Azurite
+	Endpoint string `yaml:"endpoint"`
+}
+
+type AzureBlobCfgWrapper struct {
+	Config AzureBlobConfig `yaml:"azblob"`
+}
+
+var azureBlobConfigs credentialsFiles[AzureBlobConfig]
+
+// LoadAzureBlobConfig reads the azblob section of the credentials file once until it is modified, an empty path is the config of the environment
+func LoadAzureBlobConfig(credentialFilePath string) (AzureBlobConfig, error) {
+	if credentialFilePath == "" {
+		return AzureBlobConfig{}.withEnv(), nil
+	}
+	return azureBlobConfigs.load(credentialFilePath, func() (AzureBlobConfig, error) {
+		azureCfg := AzureBlobCfgWrapper{}
+		err := config.BindYaml(credentialFilePath, &azureCfg)
+		if err != nil {
+			return AzureBlobConfig{}, tracing.Error(err)
+		}
+		return azureCfg.Config.withEnv(), nil
+	})
+}
+
+func (config AzureBlobConfig) withEnv() AzureBlobConfig {
+	if config.AccountName == "" {
+		config.AccountName = os.Getenv(Env_AzureStorageAccount)
+	}
+	if config.AccountKey == "" && config.SASToken == "" {
+		config.AccountKey = os.Getenv(Env_AzureStorageKey)
+	}
+	return config
+}
+
+// azureContainers are the clients of the containers by their config and name, their connections are shared by all the files
+var azureContainers sync.Map
+
+// azureContainer returns the client of a container, created once. The sdk does not retry, the calls are retried by withRetry.
+func azureContainer(config AzureBlobConfig, containerName string) (*container.Client, error) {
+	key := fmt.Sprintf("%+v|%s", config, containerName)
+	if client, ok := azureContainers.Load(key); ok {
+		return client.(*container.Client), nil
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		if config.AccountName == "" {
+			return nil, tracing.Error(fmt.Errorf("the account of azblob is not set: %w", ErrNoCredentials))
+		}
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", config.AccountName)
+	}
+	containerUrl := strings.TrimSuffix(endpoint, "/") + "/" + containerName
+	options := &container.ClientOptions{ClientOptions: azcore.ClientOptions{Retry: policy.RetryOptions{MaxRetries: -1}}}
+	var client *container.Client
+	var err error
+	switch {
+	case config.SASToken != "":
+		client, err = container.NewClientWithNoCredential(containerUrl+"?"+strings.TrimPrefix(config.SASToken, "?"), options)
+	case config.AccountKey != "":
+		var credential *container.SharedKeyCredential
+		credential, err = container.NewSharedKeyCredential(config.AccountName, config.AccountKey)
+		if err == nil {
+			client, err = container.NewClientWithSharedKeyCredential(containerUrl, credential, options)
+		}
+	default:
+		return nil, tracing.Error(fmt.Errorf("neither the key nor a sas token of azblob is set: %w", ErrNoCredentials))
+	}
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	actual, _ := azureContainers.LoadOrStore(key, client)
+	return actual.(*container.Client), nil
+}
+
+// isAzureNotFound returns true if err is the response of a missing blob, a HEAD response has no error code
+func isAzureNotFound(err error) bool {
+	e, ok := tracing.Cause(err).(*azcore.ResponseError)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// azureTier is the access tier of a storage class, nil for the default tier of the account
+func azureTier(class StorageClass) *blob.AccessTier {
+	var tier blob.AccessTier
+	switch class {
+	case StorageClass_Standard:
+		tier = blob.AccessTierHot
+	case StorageClass_IA:
+		tier = blob.AccessTierCool
+	case StorageClass_Archive, StorageClass_ColdArchive:
+		tier = blob.AccessTierArchive
+	default:
+		return nil
+	}
+	return &tier
+}
+
+// azureStorageClass is the storage class of an access tier, the premium tiers are Standard
+func azureStorageClass(tier string) StorageClass {
+	switch blob.AccessTier(tier) {
+	case blob.AccessTierCool:
+		return StorageClass_IA
+	case blob.AccessTierArchive:
+		return StorageClass_Archive
+	}
+	return StorageClass_Standard
+}
+
+// metadataStat sets the user metadata to the properties and the CRC64 of the metadata, the keys of the metadata are case-insensitive
+func metadataStat(stat *Stat, metadata map[string]*string) {
+	for k, v := range metadata {
+		if v == nil {
+			continue
+		}
+		key := strings.ToLower(k)
+		stat.Properties[PropertyName(key)] = *v
+		if key == crc64MetadataKey {
+			stat.CRC64, _ = strconv.ParseUint(*v, 10, 64)
+		}
+	}
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// AzureBlobBackend is the block blobs of a container under a prefix, uri azblob://container/prefix of the account of the config
+type AzureBlobBackend struct {
+	container     *container.Client
+	containerName string
+	prefix        string
+}
+
+func NewAzureBlobBackend(config AzureBlobConfig, containerName string, prefix string) (*AzureBlobBackend, error) {
+	client, err := azureContainer(config, containerName)
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	return &AzureBlobBackend{container: client, containerName: containerName, prefix: strings.Trim(prefix, "/")}, nil
+}
+
+func (backend *AzureBlobBackend) Type() FileType {
+	return FileType_AzureBlob
+}
+
+func (backend *AzureBlobBackend) Root() string {
+	return fmt.Sprintf("azblob://%s/%s", backend.containerName, backend.prefix)
+}
+
+// blobName is the name of the blob of relativePath, a name does not start or end with "/"
+func (backend *AzureBlobBackend) blobName(relativePath string) string {
+	return strings.Trim(JoinUri(backend.prefix, relativePath), "/")
+}
+
+func (backend *AzureBlobBackend) blob(relativePath string) *blockblob.Client {
+	return backend.container.NewBlockBlobClient(backend.blobName(relativePath))
+}
+
+func (backend *AzureBlobBackend) Stat(ctx context.Context, relativePath string) (*Stat, error) {
+	var props blob.GetPropertiesResponse
+	err := withRetry(ctx, "GetBlobProperties", func() (err error) {
+		props, err = backend.blob(relativePath).GetProperties(ctx, nil)
+		return err
+	})
+	if isAzureNotFound(err) {
+		return nil, tracing.Error(ErrNotFound)
+	}
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	stat := &Stat{
+		RelativePath: relativePath,
+		StorageClass: azureStorageClass(stringValue(props.AccessTier)),
+		Properties: map[PropertyName]string{
+			PropertyName_ContentType: stringValue(props.ContentType),
+		},
+	}
+	if props.ContentLength != nil {
+		stat.Size = *props.ContentLength
+	}
+	if props.LastModified != nil {
+		stat.ModTime = *props.LastModified
+	}
+	if props.ETag != nil {
+		stat.Properties[PropertyName_ContentETag] = string(*props.ETag)
+	}
+	if len(props.ContentMD5) > 0 {
+		stat.Properties[PropertyName_ContentMD5] = base58.Encode(props.ContentMD5)
+	}
+	metadataStat(stat, props.Metadata)
+	// a blob being rehydrated stays in the archive tier until it is readable
+	stat.Readable = stat.StorageClass != StorageClass_Archive
+	return stat, nil
+}
+
+// List lists a page of the blobs under prefix, the continue token is the marker of the next page
+func (backend *AzureBlobBackend) List(ctx context.Context, prefix string, continueToken string) (*ListPage, error) {
+	namePrefix := backend.blobName(prefix)
+	if namePrefix != "" {
+		namePrefix += "/"
+	}
+	maxResults := int32(listPageSize)
+	options := &container.ListBlobsFlatOptions{Prefix: &namePrefix, MaxResults: &maxResults, Include: container.ListBlobsInclude{Metadata: true}}
+	if continueToken != "" {
+		options.Marker = &continueToken
+	}
+	var resp container.ListBlobsFlatResponse
+	err := withRetry(ctx, "ListBlobs", func() (err error) {
+		resp, err = backend.container.NewListBlobsFlatPager(options).NextPage(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	page := &ListPage{Files: make([]*Stat, 0)}
+	if resp.NextMarker != nil && *resp.NextMarker != "" {
+		page.IsTruncated, page.ContinueToken = true, *resp.NextMarker
+	}
+	if resp.Segment == nil {
+		return page, nil
+	}
+	rootPrefix := backend.blobName("")
+	if rootPrefix != "" {
+		rootPrefix += "/"
+	}
+	for _, item := range resp.Segment.BlobItems {
+		if item.Name == nil || item.Properties == nil {
+			continue
+		}
+		stat := &Stat{
+			RelativePath: strings.TrimPrefix(*item.Name, rootPrefix),
+			StorageClass: StorageClass_Standard,
+			Properties:   map[PropertyName]string{PropertyName_ContentType: stringValue(item.Properties.ContentType)},
+		}
+		if item.Properties.ContentLength != nil {
+			stat.Size = *item.Properties.ContentLength
+		}
+		if item.Properties.LastModified != nil {
+			stat.ModTime = *item.Properties.LastModified
+		}
+		if item.Properties.AccessTier != nil {
+			stat.StorageClass = azureStorageClass(string(*item.Properties.AccessTier))
+		}
+		metadataStat(stat, item.Metadata)
+		stat.Readable = stat.StorageClass != StorageClass_Archive
+		page.Files = append(page.Files, stat)
+	}
+	return page, nil
+}
+
+func (backend *AzureBlobBackend) Open(ctx context.Context, relativePath string) (io.ReadCloser, error) {
+	var body io.ReadCloser
+	err := withRetry(ctx, "GetBlob", func() error {
+		resp, err := backend.blob(relativePath).DownloadStream(ctx, nil)
+		if err != nil {
+			return err
+		}
+		body = resp.Body
+		return nil
+	})
+	if isAzureNotFound(err) {
+		return nil, tracing.Error(ErrNotFound)
+	}
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	return readCloser{Reader: throttle(ctx, body, -1), Closer: body}, nil
+}
+
+// Create buffers the content of a simple upload, the chunks of a multipart upload are staged as the blocks of the blob
+func (backend *AzureBlobBackend) Create(ctx context.Context, relativePath string, options CreateOptions) (Upload, error) {
+	return &azureBlobUpload{
+		blob:      backend.blob(relativePath),
+		tier:      azureTier(options.StorageClass),
+		multipart: options.Multipart,
+		buffer:    NewBufferWriter(0),
+	}, nil
+}
+
+func (backend *AzureBlobBackend) Delete(ctx context.Context, relativePath string) error {
+	err := withRetry(ctx, "DeleteBlob", func() error {
+		_, err := backend.blob(relativePath).Delete(ctx, nil)
+		return err
+	})
+	if isAzureNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return tracing.Error(err)
+	}
+	return nil
+}
+
+// Restore rehydrates an archived blob to the hot tier, the blob stays in the hot tier once it is readable
+func (backend *AzureBlobBackend) Restore(ctx context.Context, relativePath string, class StorageClass) error {
+	priority := blob.RehydratePriorityStandard
+	err := withRetry(ctx, "SetBlobTier", func() error {
+		_, err := backend.blob(relativePath).SetTier(ctx, blob.AccessTierHot, &blob.SetTierOptions{RehydratePriority: &priority})
+		return err
+	})
+	if bloberror.HasCode(tracing.Cause(err), bloberror.BlobBeingRehydrated) {
+		return nil
+	}
+	if err != nil {
+		return tracing.Error(err)
+	}
+	return nil
+}
+
+// throttledContent is content throttled like the other transfers, the sdk seeks it to retry a request and to compute its length
+type throttledContent struct {
+	io.Reader
+	io.Seeker
+}
+
+func (content throttledContent) Close() error {
+	return nil
+}
+
+func newThrottledContent(ctx context.Context, content []byte) io.ReadSeekCloser {
+	reader := bytes.NewReader(content)
+	return throttledContent{Reader: throttle(ctx, reader, -1), Seeker: reader}
+}
+
+// azureBlockId is the id of the block of a chunk, the ids of the blocks of a blob have the same length
+func azureBlockId(number int64) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%06d", number)))
+}
+
+type azureBlobUpload struct {
+	blob      *blockblob.Client
+	tier      *blob.AccessTier
+	multipart bool
+	buffer    *BufferWriter
+	checksums chunkChecksums
+}
+
+func (upload *azureBlobUpload) Write(p []byte) (int, error) {
+	return upload.buffer.Write(p)
+}
+
+// WriteChunk stages a block, a chunk written again replaces its block
+func (upload *azureBlobUpload) WriteChunk(ctx context.Context, content []byte, chunk *FileChunkInfo) (int, error) {
+	if !upload.multipart {
+		return 0, tracing.Error(errors.New("not a multipart upload"))
+	}
+	if int64(len(content)) > chunk.ChunkSize {
+		return 0, ErrIndexOutOfRange
+	}
+	err := withRetry(ctx, "StageBlock", func() error {
+		_, err := upload.blob.StageBlock(ctx, azureBlockId(chunk.Number), newThrottledContent(ctx, content), nil)
+		return err
+	})
+	if err != nil {
+		return 0, tracing.Error(err)
+	}
+	upload.checksums.add(chunk.Number, content)
+	return len(content), nil
+}
+
+// Commit commits the staged blocks in the order of their numbers or uploads the buffered content,
+// the CRC64 of the content is kept as the metadata of the blob
+func (upload *azureBlobUpload) Commit(ctx context.Context) error {
+	if upload.multipart {
+		numbers := upload.checksums.numbers()
+		blockIds := make([]string, 0, len(numbers))
+		for _, number := range numbers {
+			blockIds = append(blockIds, azureBlockId(number))
+		}
+		crc := strconv.FormatUint(upload.checksums.sum(), 10)
+		err := withRetry(ctx, "CommitBlockList", func() error {
+			_, err := upload.blob.CommitBlockList(ctx, blockIds, &blockblob.CommitBlockListOptions{
+				Metadata: map[string]*string{crc64MetadataKey: &crc},
+				Tier:     upload.tier,
+			})
+			return err
+		})
+		if err != nil {
+			return tracing.Error(err)
+		}
+		return nil
+	}
+	content := upload.buffer.Bytes()
+	crc := strconv.FormatUint(crc64Checksum(content), 10)
+	err := withRetry(ctx, "PutBlob", func() error {
+		_, err := upload.blob.Upload(ctx, newThrottledContent(ctx, content), &blockblob.UploadOptions{
+			Metadata: map[string]*string{crc64MetadataKey: &crc},
+			Tier:     upload.tier,
+		})
+		return err
+	})
+	if err != nil {
+		return tracing.Error(err)
+	}
+	return nil
+}
+
+// Abort discards the buffered content, the staged blocks which are never committed are discarded by the service after a week
+func (upload *azureBlobUpload) Abort() error {
+	upload.buffer = NewBufferWriter(0)
+	upload.checksums = chunkChecksums{}
+	return nil
+}
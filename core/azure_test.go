@@ -0,0 +1,137 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"osssync/core/azurefake"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeAzureBlob returns a server of the containers and the config of its account
+func fakeAzureBlob(t *testing.T, containers ...string) (*azurefake.Server, AzureBlobConfig) {
+	server := azurefake.NewServer(containers...)
+	t.Cleanup(server.Close)
+	SetTestRetryPolicy(t)
+	return server, AzureBlobConfig{AccountName: azurefake.AccountName, AccountKey: azurefake.AccountKey, Endpoint: server.Endpoint()}
+}
+
+func TestAzureBlobBackendList(t *testing.T) {
+	defer func(size int) { listPageSize = size }(listPageSize)
+	listPageSize = 2
+	server, config := fakeAzureBlob(t, "backups")
+	for _, name := range []string{"2022/a.jpg", "2022/b/c.jpg", "2022/d.jpg", "2022-raw/e.jpg", "2023/f.jpg"} {
+		server.PutBlob("backups", name, []byte(name), "")
+	}
+	backend, err := NewAzureBlobBackend(config, "backups", "2022")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPaths(t, listAll(t, backend, ""), []string{"a.jpg", "b/c.jpg", "d.jpg"})
+	expectPaths(t, listAll(t, backend, "b"), []string{"b/c.jpg"})
+	expectPaths(t, listAll(t, backend, "missing"), nil)
+	if server.Requests("ListBlobs") != 4 {
+		t.Fatalf("expect the listing to be paged by the markers, got %d requests", server.Requests("ListBlobs"))
+	}
+
+	backend, _ = NewAzureBlobBackend(config, "missing", "")
+	if _, err := backend.List(context.Background(), "", ""); err == nil {
+		t.Fatal("expect a missing container to fail the listing")
+	}
+}
+
+func TestAzureBlobStagedUpload(t *testing.T) {
+	server, config := fakeAzureBlob(t, "backups")
+	ctx := context.Background()
+	backend, err := NewAzureBlobBackend(config, "backups", "videos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := backend.Create(ctx, "a.mp4", CreateOptions{StorageClass: StorageClass_IA, Multipart: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	// the blocks are staged in any order and committed in the order of the chunks
+	server.FailNext("PutBlock", 1, http.StatusServiceUnavailable, "ServerBusy")
+	for _, number := range []int64{3, 1, 4, 2} {
+		offset := (number - 1) * 300
+		end := offset + 300
+		if end > int64(len(content)) {
+			end = int64(len(content))
+		}
+		_, err := upload.WriteChunk(ctx, content[offset:end], &FileChunkInfo{Number: number, ChunkSize: end - offset, Offset: offset})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := upload.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	blob, ok := server.Blob("backups", "videos/a.mp4")
+	if !ok || !bytes.Equal(blob.Data, content) {
+		t.Fatalf("unexpected content of %d bytes", len(blob.Data))
+	}
+	if blob.Tier != "Cool" {
+		t.Fatalf("unexpected tier %s", blob.Tier)
+	}
+	if server.Requests("PutBlock") != 5 || server.Requests("PutBlockList") != 1 {
+		t.Fatalf("unexpected %d PutBlock and %d PutBlockList", server.Requests("PutBlock"), server.Requests("PutBlockList"))
+	}
+	stat, err := backend.Stat(ctx, "a.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.CRC64 != crc64Of(content) || stat.Properties[PropertyName(crc64MetadataKey)] != strconv.FormatUint(crc64Of(content), 10) {
+		t.Fatalf("unexpected CRC64 %d", stat.CRC64)
+	}
+	if stat.StorageClass != StorageClass_IA || stat.Size != int64(len(content)) || !stat.Readable {
+		t.Fatalf("unexpected stat %+v", stat)
+	}
+}
+
+func TestAzureBlobRestore(t *testing.T) {
+	server, config := fakeAzureBlob(t, "backups")
+	server.RehydrateDelay = 50 * time.Millisecond
+	server.PutBlob("backups", "old.tar", []byte("archived"), "Archive")
+	ctx := context.Background()
+	backend, err := NewAzureBlobBackend(config, "backups", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := NewBackendFile(ctx, backend, "old.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if file.StorageClass() != StorageClass_Archive {
+		t.Fatalf("unexpected storage class %s", file.StorageClass())
+	}
+	if _, err := backend.Open(ctx, "old.tar"); err == nil {
+		t.Fatal("an archived blob can be read")
+	}
+	// a rehydration in progress is not requested again
+	for i := 0; i < 2; i++ {
+		if err := file.Restore(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if readable, err := file.Readable(ctx); readable || err != nil {
+		t.Fatalf("the blob is readable before it is rehydrated: %v", err)
+	}
+	time.Sleep(server.RehydrateDelay)
+	if readable, err := file.Readable(ctx); !readable || err != nil {
+		t.Fatalf("the blob is not readable once rehydrated: %v", err)
+	}
+	reader, err := backend.Open(ctx, "old.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if content, _ := io.ReadAll(reader); string(content) != "archived" {
+		t.Fatalf("unexpected content %q", content)
+	}
+}
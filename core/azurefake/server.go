@@ -0,0 +1,517 @@
+// Package azurefake is an in-process fake of the subset of the Azure Blob protocol used by osssync, for tests running offline:
+// properties, get, put and delete of block blobs, staged blocks and their block lists, flat listings with markers,
+// access tiers and the rehydration of archived blobs.
+//
+// Requests are addressed by path, http://127.0.0.1:port/account/container/blob, like Azurite.
+// Signatures are not checked.
+package azurefake
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccountName and AccountKey are the credentials of the account of the fake, the key of Azurite
+const (
+	AccountName = "devstoreaccount1"
+	AccountKey  = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+// Blob is a block blob stored by the fake
+type Blob struct {
+	Data        []byte
+	Metadata    map[string]string
+	ContentType string
+	// ContentMD5 is only computed for the blobs put by a single request, like the service does
+	ContentMD5 []byte
+	Tier       string
+	ModTime    time.Time
+	// rehydrateAt is when the requested rehydration of an archived blob completes, zero if none is requested
+	rehydrateAt time.Time
+}
+
+func (blob *Blob) etag() string {
+	return fmt.Sprintf(`"0x%X"`, blob.ModTime.UnixNano())
+}
+
+// rehydrate moves a blob whose rehydration is complete to the hot tier
+func (blob *Blob) rehydrate(now time.Time) {
+	if !blob.rehydrateAt.IsZero() && !now.Before(blob.rehydrateAt) {
+		blob.Tier = "Hot"
+		blob.rehydrateAt = time.Time{}
+	}
+}
+
+type failure struct {
+	status int
+	code   string
+	times  int
+}
+
+// Server is a fake blob service of the account AccountName, its containers exist once they are created by NewServer or CreateContainer
+type Server struct {
+	*httptest.Server
+
+	// RehydrateDelay is how long the rehydration of an archived blob takes
+	RehydrateDelay time.Duration
+
+	lock       sync.Mutex
+	containers map[string]map[string]*Blob
+	// blocks are the uncommitted blocks by container and blob
+	blocks   map[string]map[string][]byte
+	requests map[string]int
+	failures map[string]*failure
+}
+
+// NewServer starts a fake with the containers of names, Close stops it
+func NewServer(containers ...string) *Server {
+	server := &Server{
+		containers: make(map[string]map[string]*Blob),
+		blocks:     make(map[string]map[string][]byte),
+		requests:   make(map[string]int),
+		failures:   make(map[string]*failure),
+	}
+	for _, container := range containers {
+		server.CreateContainer(container)
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serve))
+	return server
+}
+
+// Endpoint is the url of the blob service of the account
+func (server *Server) Endpoint() string {
+	return server.URL + "/" + AccountName
+}
+
+func (server *Server) CreateContainer(name string) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	if _, ok := server.containers[name]; !ok {
+		server.containers[name] = make(map[string]*Blob)
+	}
+}
+
+// PutBlob stores a blob directly, tier is Hot if empty
+func (server *Server) PutBlob(container string, name string, data []byte, tier string) {
+	server.CreateContainer(container)
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	if tier == "" {
+		tier = "Hot"
+	}
+	server.containers[container][name] = &Blob{Data: append([]byte{}, data...), Metadata: map[string]string{}, Tier: tier, ModTime: time.Now()}
+}
+
+// Blob returns a copy of a blob
+func (server *Server) Blob(container string, name string) (Blob, bool) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	blob, ok := server.containers[container][name]
+	if !ok {
+		return Blob{}, false
+	}
+	blob.rehydrate(time.Now())
+	copied := *blob
+	copied.Data = append([]byte{}, blob.Data...)
+	copied.Metadata = make(map[string]string)
+	for k, v := range blob.Metadata {
+		copied.Metadata[k] = v
+	}
+	return copied, true
+}
+
+// Names returns the sorted names of the blobs of a container
+func (server *Server) Names(container string) []string {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return sortedNames(server.containers[container])
+}
+
+// Requests returns the count of the requests of an operation, e.g. "PutBlob" or "PutBlock"
+func (server *Server) Requests(operation string) int {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return server.requests[operation]
+}
+
+// FailNext fails the next times requests of an operation with an error of status and code
+func (server *Server) FailNext(operation string, times int, status int, code string) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.failures[operation] = &failure{status: status, code: code, times: times}
+}
+
+func sortedNames(blobs map[string]*Blob) []string {
+	names := make([]string, 0, len(blobs))
+	for name := range blobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// operation names a request like the operations of the REST API
+func operation(r *http.Request, blob string) string {
+	query := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+		if blob == "" && query.Get("comp") == "list" {
+			return "ListBlobs"
+		}
+		return "GetBlob"
+	case http.MethodHead:
+		return "GetBlobProperties"
+	case http.MethodPut:
+		switch query.Get("comp") {
+		case "block":
+			return "PutBlock"
+		case "blocklist":
+			return "PutBlockList"
+		case "tier":
+			return "SetBlobTier"
+		case "":
+			return "PutBlob"
+		}
+	case http.MethodDelete:
+		return "DeleteBlob"
+	}
+	return ""
+}
+
+func (server *Server) serve(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	if len(parts) < 2 || parts[0] != AccountName {
+		writeError(w, r, http.StatusBadRequest, "InvalidUri", "The requested URI does not represent any resource on the server.")
+		return
+	}
+	containerName, name := parts[1], ""
+	if len(parts) == 3 {
+		name = parts[2]
+	}
+	op := operation(r, name)
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.requests[op]++
+	if f, ok := server.failures[op]; ok && f.times > 0 {
+		f.times--
+		writeError(w, r, f.status, f.code, "injected failure")
+		return
+	}
+	container, ok := server.containers[containerName]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "ContainerNotFound", "The specified container does not exist.")
+		return
+	}
+	if blob, ok := container[name]; ok {
+		blob.rehydrate(time.Now())
+	}
+
+	switch op {
+	case "ListBlobs":
+		server.list(w, r, containerName, container)
+	case "GetBlob", "GetBlobProperties":
+		server.get(w, r, container, name, op == "GetBlob")
+	case "PutBlob":
+		server.put(w, r, container, name)
+	case "PutBlock":
+		server.putBlock(w, r, containerName+"/"+name)
+	case "PutBlockList":
+		server.putBlockList(w, r, container, containerName+"/"+name, name)
+	case "SetBlobTier":
+		server.setTier(w, r, container, name)
+	case "DeleteBlob":
+		if _, ok := container[name]; !ok {
+			writeError(w, r, http.StatusNotFound, "BlobNotFound", "The specified blob does not exist.")
+			return
+		}
+		delete(container, name)
+		delete(server.blocks, containerName+"/"+name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", fmt.Sprintf("%s %s is not supported by the fake", r.Method, r.URL))
+	}
+}
+
+// writeError answers an error of code, the code is the x-ms-error-code header as the response of a HEAD has no body
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, message string) {
+	w.Header().Set("X-Ms-Request-Id", "fake")
+	w.Header().Set("X-Ms-Error-Code", code)
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	var buffer bytes.Buffer
+	xml.EscapeText(&buffer, []byte(message))
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, buffer.String())
+}
+
+func writeBlobHeader(w http.ResponseWriter, blob *Blob) {
+	header := w.Header()
+	for k, v := range blob.Metadata {
+		header.Set("X-Ms-Meta-"+k, v)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(blob.Data)))
+	header.Set("Content-Type", blob.ContentType)
+	if len(blob.ContentMD5) > 0 {
+		header.Set("Content-MD5", base64.StdEncoding.EncodeToString(blob.ContentMD5))
+	}
+	header.Set("ETag", blob.etag())
+	header.Set("Last-Modified", blob.ModTime.UTC().Format(http.TimeFormat))
+	header.Set("X-Ms-Blob-Type", "BlockBlob")
+	header.Set("X-Ms-Access-Tier", blob.Tier)
+	if !blob.rehydrateAt.IsZero() {
+		header.Set("X-Ms-Archive-Status", "rehydrate-pending-to-hot")
+	}
+	header.Set("X-Ms-Request-Id", "fake")
+}
+
+func (server *Server) get(w http.ResponseWriter, r *http.Request, container map[string]*Blob, name string, withContent bool) {
+	blob, ok := container[name]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "BlobNotFound", "The specified blob does not exist.")
+		return
+	}
+	if withContent && blob.Tier == "Archive" {
+		writeError(w, r, http.StatusConflict, "BlobArchived", "This operation is not permitted on an archived blob.")
+		return
+	}
+	writeBlobHeader(w, blob)
+	w.WriteHeader(http.StatusOK)
+	if withContent {
+		w.Write(blob.Data)
+	}
+}
+
+// newBlob returns a blob of data with the metadata, the content type and the tier of the headers of a request
+func newBlob(r *http.Request, data []byte) *Blob {
+	blob := &Blob{Data: data, Metadata: make(map[string]string), ContentType: r.Header.Get("X-Ms-Blob-Content-Type"), Tier: r.Header.Get("X-Ms-Access-Tier"), ModTime: time.Now()}
+	if blob.ContentType == "" {
+		blob.ContentType = "application/octet-stream"
+	}
+	if blob.Tier == "" {
+		blob.Tier = "Hot"
+	}
+	for k, v := range r.Header {
+		if lower := strings.ToLower(k); strings.HasPrefix(lower, "x-ms-meta-") {
+			blob.Metadata[strings.TrimPrefix(lower, "x-ms-meta-")] = v[0]
+		}
+	}
+	return blob
+}
+
+func writeCreated(w http.ResponseWriter, blob *Blob) {
+	w.Header().Set("ETag", blob.etag())
+	w.Header().Set("Last-Modified", blob.ModTime.UTC().Format(http.TimeFormat))
+	w.Header().Set("X-Ms-Request-Id", "fake")
+	w.Header().Set("X-Ms-Request-Server-Encrypted", "true")
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (server *Server) put(w http.ResponseWriter, r *http.Request, container map[string]*Blob, name string) {
+	if r.Header.Get("X-Ms-Blob-Type") != "BlockBlob" {
+		writeError(w, r, http.StatusBadRequest, "InvalidHeaderValue", "only block blobs are supported by the fake")
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "InvalidInput", err.Error())
+		return
+	}
+	blob := newBlob(r, data)
+	sum := md5.Sum(data)
+	blob.ContentMD5 = sum[:]
+	container[name] = blob
+	w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(blob.ContentMD5))
+	writeCreated(w, blob)
+}
+
+func (server *Server) putBlock(w http.ResponseWriter, r *http.Request, key string) {
+	id := r.URL.Query().Get("blockid")
+	if _, err := base64.StdEncoding.DecodeString(id); err != nil || id == "" {
+		writeError(w, r, http.StatusBadRequest, "InvalidQueryParameterValue", "invalid block id")
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "InvalidInput", err.Error())
+		return
+	}
+	if server.blocks[key] == nil {
+		server.blocks[key] = make(map[string][]byte)
+	}
+	for other := range server.blocks[key] {
+		if len(other) != len(id) {
+			writeError(w, r, http.StatusBadRequest, "InvalidBlobOrBlock", "the ids of the blocks of a blob have the same length")
+			return
+		}
+	}
+	server.blocks[key][id] = data
+	w.Header().Set("X-Ms-Request-Id", "fake")
+	w.WriteHeader(http.StatusCreated)
+}
+
+type blockList struct {
+	Latest      []string `xml:"Latest"`
+	Uncommitted []string `xml:"Uncommitted"`
+}
+
+// putBlockList commits the uncommitted blocks of the list in its order, the blocks which are not listed are discarded
+func (server *Server) putBlockList(w http.ResponseWriter, r *http.Request, container map[string]*Blob, key string, name string) {
+	var list blockList
+	if err := xml.NewDecoder(r.Body).Decode(&list); err != nil {
+		writeError(w, r, http.StatusBadRequest, "InvalidXmlDocument", err.Error())
+		return
+	}
+	var data []byte
+	for _, id := range append(list.Latest, list.Uncommitted...) {
+		block, ok := server.blocks[key][id]
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, "InvalidBlockList", fmt.Sprintf("block %s is not staged", id))
+			return
+		}
+		data = append(data, block...)
+	}
+	blob := newBlob(r, data)
+	container[name] = blob
+	delete(server.blocks, key)
+	writeCreated(w, blob)
+}
+
+func (server *Server) setTier(w http.ResponseWriter, r *http.Request, container map[string]*Blob, name string) {
+	blob, ok := container[name]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "BlobNotFound", "The specified blob does not exist.")
+		return
+	}
+	tier := r.Header.Get("X-Ms-Access-Tier")
+	if tier != "Hot" && tier != "Cool" && tier != "Archive" {
+		writeError(w, r, http.StatusBadRequest, "InvalidHeaderValue", "invalid access tier")
+		return
+	}
+	w.Header().Set("X-Ms-Request-Id", "fake")
+	if !blob.rehydrateAt.IsZero() {
+		writeError(w, r, http.StatusConflict, "BlobBeingRehydrated", "This operation is not permitted because the blob is being rehydrated.")
+		return
+	}
+	if blob.Tier == "Archive" && tier != "Archive" {
+		blob.rehydrateAt = time.Now().Add(server.RehydrateDelay)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	blob.Tier = tier
+	w.WriteHeader(http.StatusOK)
+}
+
+// metadata is marshaled as the elements named by its keys
+type metadata map[string]string
+
+func (m metadata) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := e.EncodeElement(m[k], xml.StartElement{Name: xml.Name{Local: k}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+type blobProperties struct {
+	LastModified  string `xml:"Last-Modified"`
+	Etag          string `xml:"Etag"`
+	ContentLength int    `xml:"Content-Length"`
+	ContentType   string `xml:"Content-Type"`
+	BlobType      string `xml:"BlobType"`
+	AccessTier    string `xml:"AccessTier"`
+	ArchiveStatus string `xml:"ArchiveStatus,omitempty"`
+}
+
+type blobItem struct {
+	Name       string         `xml:"Name"`
+	Properties blobProperties `xml:"Properties"`
+	Metadata   metadata       `xml:"Metadata,omitempty"`
+}
+
+type enumerationResults struct {
+	XMLName         xml.Name   `xml:"EnumerationResults"`
+	ServiceEndpoint string     `xml:"ServiceEndpoint,attr"`
+	ContainerName   string     `xml:"ContainerName,attr"`
+	Prefix          string     `xml:"Prefix"`
+	Marker          string     `xml:"Marker"`
+	MaxResults      int        `xml:"MaxResults"`
+	Blobs           []blobItem `xml:"Blobs>Blob"`
+	NextMarker      string     `xml:"NextMarker"`
+}
+
+// list returns the blobs of the prefix from the marker, which is the first name of the page
+func (server *Server) list(w http.ResponseWriter, r *http.Request, name string, container map[string]*Blob) {
+	query := r.URL.Query()
+	if query.Get("restype") != "container" {
+		writeError(w, r, http.StatusBadRequest, "InvalidQueryParameterValue", "restype must be container")
+		return
+	}
+	maxResults := 5000
+	if v, err := strconv.Atoi(query.Get("maxresults")); err == nil && v > 0 {
+		maxResults = v
+	}
+	withMetadata := strings.Contains(query.Get("include"), "metadata")
+	result := enumerationResults{
+		ServiceEndpoint: server.URL + "/" + AccountName,
+		ContainerName:   name,
+		Prefix:          query.Get("prefix"),
+		Marker:          query.Get("marker"),
+		MaxResults:      maxResults,
+	}
+	now := time.Now()
+	for _, blobName := range sortedNames(container) {
+		if !strings.HasPrefix(blobName, result.Prefix) || blobName < result.Marker {
+			continue
+		}
+		if len(result.Blobs) == maxResults {
+			result.NextMarker = blobName
+			break
+		}
+		blob := container[blobName]
+		blob.rehydrate(now)
+		item := blobItem{Name: blobName, Properties: blobProperties{
+			LastModified:  blob.ModTime.UTC().Format(http.TimeFormat),
+			Etag:          blob.etag(),
+			ContentLength: len(blob.Data),
+			ContentType:   blob.ContentType,
+			BlobType:      "BlockBlob",
+			AccessTier:    blob.Tier,
+		}}
+		if !blob.rehydrateAt.IsZero() {
+			item.Properties.ArchiveStatus = "rehydrate-pending-to-hot"
+		}
+		if withMetadata && len(blob.Metadata) > 0 {
+			item.Metadata = metadata(blob.Metadata)
+		}
+		result.Blobs = append(result.Blobs, item)
+	}
+	body, _ := xml.Marshal(result)
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("X-Ms-Request-Id", "fake")
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// ErrNotFound is returned by a backend for a file which does not exist
+var ErrNotFound error = errors.New("file not found")
+
+// Backend is a storage of files addressed by their path relative to its root, e.g. a directory or a bucket and a prefix.
+// Nothing is created by reading a backend, and every call stops once ctx is done.
+type Backend interface {
+	Type() FileType
+	// Root is the uri of the root of the backend
+	Root() string
+	// Stat returns the metadata of a file, ErrNotFound if it does not exist
+	Stat(ctx context.Context, relativePath string) (*Stat, error)
+	// List returns a page of the files under prefix, the next page is listed by the ContinueToken of the page
+	List(ctx context.Context, prefix string, continueToken string) (*ListPage, error)
+	// Open reads the content of a file, ErrNotFound if it does not exist
+	Open(ctx context.Context, relativePath string) (io.ReadCloser, error)
+	// Create starts writing a file, the content it had is replaced once the upload is committed
+	Create(ctx context.Context, relativePath string, options CreateOptions) (Upload, error)
+	// Delete removes a file, deleting a missing file is not an error
+	Delete(ctx context.Context, relativePath string) error
+}
+
+// Stat is the metadata of a stored file
+type Stat struct {
+	RelativePath string
+	Size         int64
+	ModTime      time.Time
+	// Mode is the permission bits of a file of a file system, 0 if unknown
+	Mode fs.FileMode
+	// CRC64 is the CRC64 ECMA of the content known by the backend without reading it, 0 if unknown
+	CRC64        uint64
+	StorageClass StorageClass
+	// Readable is false while an archived file is not restored
+	Readable bool
+	// Properties are the metadata specific to the backend, e.g. the headers of an object
+	Properties map[PropertyName]string
+	// Metadata is the user metadata of an object and Headers are its content headers, e.g. Content-Type, nil if the backend has none
+	Metadata map[string]string
+	Headers  map[string]string
+}
+
+// ListPage is a page of the files of a listing
+type ListPage struct {
+	Files         []*Stat
+	IsTruncated   bool
+	ContinueToken string
+}
+
+// CreateOptions are the settings of a file being written
+type CreateOptions struct {
+	// StorageClass is the class of the stored file, the default of the backend if empty
+	StorageClass StorageClass
+	// Multipart writes the content by the chunks of WriteChunk instead of Write
+	Multipart bool
+	// Mode and ModTime are the permission bits and the modification time of the file of a file system, the default of the backend if zero
+	Mode    fs.FileMode
+	ModTime time.Time
+	// Metadata and Headers are the user metadata and the content headers of an object, a backend without them ignores them
+	Metadata map[string]string
+	Headers  map[string]string
+}
+
+// Upload is the content of a file being written, stored by Commit or discarded by Abort
+type Upload interface {
+	io.Writer
+	// WriteChunk writes a chunk of a multipart upload, the chunks are numbered from 1
+	WriteChunk(ctx context.Context, content []byte, chunk *FileChunkInfo) (int, error)
+	Commit(ctx context.Context) error
+	// Abort discards the content written, it is called once the upload can't be committed
+	Abort() error
+}
+
+// Suspender is an Upload whose uploaded parts are kept when its transfer is canceled,
+// the next Create of the file resumes it and the parts which have the content of their chunks are not uploaded again
+type Suspender interface {
+	// Suspend leaves the multipart upload open instead of aborting it
+	Suspend()
+}
+
+// Hasher is a backend computing the checksums of a file itself, e.g. by reading a local file
+type Hasher interface {
+	Hash(ctx context.Context, relativePath string) (md5 []byte, crc64 uint64, err error)
+}
+
+// Restorer is a backend of archived files which are read once they are restored
+type Restorer interface {
+	// Restore requests a readable copy of an archived file, a restore in progress is not requested again
+	Restore(ctx context.Context, relativePath string, class StorageClass) error
+}
+
+// ServerCopier is a backend which copies the files of another backend of the same service without the content leaving the service,
+// ErrCopyNotSupported is returned if the files of src can't be copied this way
+type ServerCopier interface {
+	Copy(ctx context.Context, src Backend, srcPath string, relativePath string, options CreateOptions) error
+}
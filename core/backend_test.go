@@ -0,0 +1,69 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func listAll(t *testing.T, backend Backend, prefix string) []string {
+	var paths []string
+	token := ""
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("the listing does not end")
+		}
+		page, err := backend.List(context.Background(), prefix, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range page.Files {
+			paths = append(paths, file.RelativePath)
+		}
+		if !page.IsTruncated {
+			return paths
+		}
+		token = page.ContinueToken
+	}
+}
+
+func expectPaths(t *testing.T, paths []string, expected []string) {
+	t.Helper()
+	if len(paths) != len(expected) {
+		t.Fatalf("unexpected paths %v", paths)
+	}
+	for i := range expected {
+		if paths[i] != expected[i] {
+			t.Fatalf("unexpected paths %v", paths)
+		}
+	}
+}
+
+func TestPhysicalBackendList(t *testing.T) {
+	defer func(size int) { listPageSize = size }(listPageSize)
+	listPageSize = 2
+	dir := t.TempDir()
+	for _, relativePath := range []string{"a/x", "a/y/z", "a-b", "b", ".hidden", "c/.tmp/d", "c/e"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, relativePath)), 0755)
+		os.WriteFile(filepath.Join(dir, relativePath), []byte(relativePath), 0644)
+	}
+	backend := NewPhysicalBackend(dir)
+	expectPaths(t, listAll(t, backend, ""), []string{"a/x", "a/y/z", "a-b", "b", "c/e"})
+	expectPaths(t, listAll(t, backend, "a"), []string{"a/x", "a/y/z"})
+	expectPaths(t, listAll(t, backend, "missing"), nil)
+}
+
+func TestAliOSSBackendList(t *testing.T) {
+	server, config := fakeAliOSS(t, "photos")
+	server.ListPageSize = 2
+	for _, key := range []string{"2022/a.jpg", "2022/b/c.jpg", "2022/d.jpg", "2022-raw/e.jpg", "2023/f.jpg"} {
+		server.PutObject("photos", key, []byte(key), "")
+	}
+	backend, err := NewAliOSSBackend(context.Background(), config, "photos", "2022")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPaths(t, listAll(t, backend, ""), []string{"a.jpg", "b/c.jpg", "d.jpg"})
+	expectPaths(t, listAll(t, backend, "b"), []string{"b/c.jpg"})
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"context"
+	"io"
+	"osssync/common/bandwidth"
+	"osssync/common/metrics"
+	"sync"
+)
+
+var bandwidthLimit = metrics.NewGaugeVec("osssync_bandwidth_limit_bytes_per_second",
+	"Current limit of the bandwidth of the transfers to and from the storage backends, 0 is unlimited.")
+
+// limiter throttles the uploads and downloads of all the files and parts in progress together
+var limiter *bandwidth.Limiter
+var limiterLock sync.RWMutex
+
+func init() {
+	metrics.DefaultRegistry.OnCollect(func() {
+		bandwidthLimit.WithLabelValues().Set(float64(currentLimiter().Rate()))
+	})
+}
+
+// SetBandwidth limits the bytes per second transferred to and from the storage backends by schedule
+func SetBandwidth(schedule bandwidth.Schedule) {
+	limiterLock.Lock()
+	defer limiterLock.Unlock()
+	if len(schedule.Windows) == 0 && schedule.Default <= 0 {
+		limiter = nil
+		return
+	}
+	limiter = bandwidth.NewLimiter(schedule)
+}
+
+func currentLimiter() *bandwidth.Limiter {
+	limiterLock.RLock()
+	defer limiterLock.RUnlock()
+	return limiter
+}
+
+// throttle limits the bytes read from r by the bandwidth, a size >= 0 keeps the length of an upload known to the SDK
+func throttle(ctx context.Context, r io.Reader, size int64) io.Reader {
+	l := currentLimiter()
+	if l == nil {
+		return r
+	}
+	if size < 0 {
+		return l.Reader(ctx, r)
+	}
+	return &io.LimitedReader{R: l.Reader(ctx, r), N: size}
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"hash/crc64"
+	"sort"
+	"sync"
+)
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// gf2MatrixTimes multiplies the 64x64 matrix over GF(2) mat by vec
+func gf2MatrixTimes(mat []uint64, vec uint64) uint64 {
+	var sum uint64
+	for i := 0; vec != 0; i, vec = i+1, vec>>1 {
+		if vec&1 != 0 {
+			sum ^= mat[i]
+		}
+	}
+	return sum
+}
+
+func gf2MatrixSquare(square []uint64, mat []uint64) {
+	for n := range mat {
+		square[n] = gf2MatrixTimes(mat, mat[n])
+	}
+}
+
+// crc64Combine returns the CRC64 ECMA of the content of crc1 followed by the content of len2 bytes of crc2,
+// like crc32_combine of zlib: the CRC of the first content is moved over len2 zero bytes by squaring the operator of a zero bit
+func crc64Combine(crc1 uint64, crc2 uint64, len2 int64) uint64 {
+	if len2 <= 0 {
+		return crc1
+	}
+	even := make([]uint64, 64)
+	odd := make([]uint64, 64)
+	// the operator of a zero bit
+	odd[0] = crc64.ECMA
+	row := uint64(1)
+	for n := 1; n < 64; n++ {
+		odd[n] = row
+		row <<= 1
+	}
+	// the operators of two and four zero bits
+	gf2MatrixSquare(even, odd)
+	gf2MatrixSquare(odd, even)
+	for {
+		gf2MatrixSquare(even, odd)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(even, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+		gf2MatrixSquare(odd, even)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(odd, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+	}
+	return crc1 ^ crc2
+}
+
+type chunkChecksum struct {
+	crc64 uint64
+	size  int64
+}
+
+// chunkChecksums are the CRC64 of the chunks of an upload by their number, the chunks may be written in any order
+// and again, the CRC64 of the content is the combination of the chunks in the order of their numbers
+type chunkChecksums struct {
+	lock   sync.Mutex
+	chunks map[int64]chunkChecksum
+}
+
+func (checksums *chunkChecksums) add(number int64, content []byte) {
+	checksums.lock.Lock()
+	defer checksums.lock.Unlock()
+	if checksums.chunks == nil {
+		checksums.chunks = make(map[int64]chunkChecksum)
+	}
+	checksums.chunks[number] = chunkChecksum{crc64: crc64Checksum(content), size: int64(len(content))}
+}
+
+// numbers returns the sorted numbers of the chunks written
+func (checksums *chunkChecksums) numbers() []int64 {
+	checksums.lock.Lock()
+	defer checksums.lock.Unlock()
+	numbers := make([]int64, 0, len(checksums.chunks))
+	for number := range checksums.chunks {
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	return numbers
+}
+
+func (checksums *chunkChecksums) sum() uint64 {
+	numbers := checksums.numbers()
+	checksums.lock.Lock()
+	defer checksums.lock.Unlock()
+	var crc uint64
+	for _, number := range numbers {
+		chunk := checksums.chunks[number]
+		crc = crc64Combine(crc, chunk.crc64, chunk.size)
+	}
+	return crc
+}
+
+func crc64Checksum(content []byte) uint64 {
+	return crc64.Checksum(content, crc64Table)
+}
@@ -0,0 +1,29 @@
+package core
+
+import (
+	"bytes"
+	"hash/crc64"
+	"testing"
+)
+
+func TestChunkChecksums(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	for _, chunkSize := range []int{1, 7, 4096, len(content)} {
+		checksums := &chunkChecksums{}
+		// the chunks are added backwards, and the first chunk twice
+		for offset := (len(content) - 1) / chunkSize * chunkSize; offset >= 0; offset -= chunkSize {
+			end := offset + chunkSize
+			if end > len(content) {
+				end = len(content)
+			}
+			checksums.add(int64(offset/chunkSize+1), content[offset:end])
+		}
+		checksums.add(1, content[:chunkSize])
+		if sum, expected := checksums.sum(), crc64.Checksum(content, crc64Table); sum != expected {
+			t.Fatalf("CRC64 of the chunks of %d bytes is %d, expect %d", chunkSize, sum, expected)
+		}
+	}
+	if sum := (&chunkChecksums{}).sum(); sum != 0 {
+		t.Fatalf("CRC64 of no chunk is %d", sum)
+	}
+}
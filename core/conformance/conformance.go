@@ -0,0 +1,274 @@
+// Package conformance is the behavior shared by every core.FileInfo backend, run by the tests of a backend:
+//
+//	conformance.Run(t, func(ctx context.Context, relativePath string) (core.FileInfo, error) {
+//		return core.OpenPhysicalFile(dir, relativePath)
+//	})
+//
+// The contract of a backend:
+//   - opening a missing file creates nothing, the file does not exist, its size is 0 and reading it fails
+//   - Flush stores the content written by Writer, or by WalkChunk with WriteChunk, and replaces the content the file had.
+//     A file nothing was written to is stored empty
+//   - once flushed the file exists, its Size, CRC64 and properties describe the stored content,
+//     on the flushed FileInfo as well as on a FileInfo opened again
+//   - RelativePath is the path the file was opened with, Name is its last element
+//   - the chunks of WalkChunk are numbered from 1 and read in full from readers returning short reads,
+//     nothing is stored once ctx is done
+//   - Remove removes the file, removing a missing file is not an error
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"hash/crc64"
+	"io"
+	"math/rand"
+	"osssync/core"
+	"strconv"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// Opener opens the file of relativePath in the directory of a backend under test, the paths of the cases do not overlap
+type Opener func(ctx context.Context, relativePath string) (core.FileInfo, error)
+
+// Run runs the cases of the contract against the backend of open
+func Run(t *testing.T, open Opener) {
+	s := &suite{open: open}
+	t.Run("Missing", s.testMissing)
+	t.Run("WriteAndRead", s.testWriteAndRead)
+	t.Run("Empty", s.testEmpty)
+	t.Run("Overwrite", s.testOverwrite)
+	t.Run("Remove", s.testRemove)
+	t.Run("Chunks", s.testChunks)
+	t.Run("CanceledChunks", s.testCanceledChunks)
+	t.Run("Properties", s.testProperties)
+}
+
+type suite struct {
+	open Opener
+}
+
+func (s *suite) mustOpen(t *testing.T, relativePath string) core.FileInfo {
+	t.Helper()
+	file, err := s.open(context.Background(), relativePath)
+	if err != nil {
+		t.Fatalf("open %s: %v", relativePath, err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+func (s *suite) write(t *testing.T, relativePath string, content []byte) core.FileInfo {
+	t.Helper()
+	file := s.mustOpen(t, relativePath)
+	if _, err := file.Writer().Write(content); err != nil {
+		t.Fatalf("write %s: %v", relativePath, err)
+	}
+	if err := file.Flush(context.Background()); err != nil {
+		t.Fatalf("flush %s: %v", relativePath, err)
+	}
+	return file
+}
+
+// expectContent checks the file flushed and the file opened again store content
+func (s *suite) expectContent(t *testing.T, file core.FileInfo, content []byte) {
+	t.Helper()
+	ctx := context.Background()
+	expectedCrc64 := crc64.Checksum(content, crc64.MakeTable(crc64.ECMA))
+	for _, f := range []core.FileInfo{file, s.mustOpen(t, file.RelativePath())} {
+		if exists, err := f.Exists(ctx); !exists || err != nil {
+			t.Fatalf("%s does not exist: %v", f.RelativePath(), err)
+		}
+		if f.Size() != int64(len(content)) {
+			t.Fatalf("size of %s is %d, expect %d", f.RelativePath(), f.Size(), len(content))
+		}
+		if crc, err := f.CRC64(ctx); crc != expectedCrc64 || err != nil {
+			t.Fatalf("CRC64 of %s is %d, expect %d: %v", f.RelativePath(), crc, expectedCrc64, err)
+		}
+	}
+	read, err := io.ReadAll(s.mustOpen(t, file.RelativePath()).Reader())
+	if err != nil || !bytes.Equal(read, content) {
+		t.Fatalf("content of %s is %d bytes, expect %d: %v", file.RelativePath(), len(read), len(content), err)
+	}
+}
+
+func (s *suite) testMissing(t *testing.T) {
+	ctx := context.Background()
+	file := s.mustOpen(t, "missing/a.bin")
+	if exists, err := file.Exists(ctx); exists || err != nil {
+		t.Fatalf("a missing file exists: %v", err)
+	}
+	if file.Size() != 0 {
+		t.Fatalf("size of a missing file is %d", file.Size())
+	}
+	if _, err := io.ReadAll(file.Reader()); err == nil {
+		t.Fatal("a missing file can be read")
+	}
+	if _, err := core.OpenReader(ctx, file); err == nil {
+		t.Fatal("a missing file can be opened")
+	}
+	if file.RelativePath() != "missing/a.bin" || file.Name() != "a.bin" {
+		t.Fatalf("unexpected relative path %s or name %s", file.RelativePath(), file.Name())
+	}
+	if exists, _ := s.mustOpen(t, "missing/a.bin").Exists(ctx); exists {
+		t.Fatal("opening a missing file creates it")
+	}
+}
+
+func (s *suite) testWriteAndRead(t *testing.T) {
+	content := []byte("the content written by the writer")
+	file := s.mustOpen(t, "write/dir/a.bin")
+	// the content may be written by pieces
+	for _, piece := range [][]byte{content[:5], content[5:20], content[20:]} {
+		if _, err := file.Writer().Write(piece); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := file.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.expectContent(t, file, content)
+}
+
+func (s *suite) testEmpty(t *testing.T) {
+	file := s.mustOpen(t, "empty/a.bin")
+	if err := file.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.expectContent(t, file, []byte{})
+}
+
+func (s *suite) testOverwrite(t *testing.T) {
+	s.write(t, "overwrite/a.bin", []byte("the content before the overwrite"))
+	file := s.write(t, "overwrite/a.bin", []byte("shorter"))
+	s.expectContent(t, file, []byte("shorter"))
+
+	// chunks replace the content too
+	content := []byte("written by chunks")
+	file = s.mustOpen(t, "overwrite/a.bin")
+	err := file.WalkChunk(context.Background(), bytes.NewReader(content), 4, int64(len(content)), file.WriteChunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.expectContent(t, file, content)
+}
+
+func (s *suite) testRemove(t *testing.T) {
+	ctx := context.Background()
+	file := s.write(t, "remove/a.bin", []byte("removed"))
+	if err := file.Remove(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := file.Exists(ctx); exists || err != nil {
+		t.Fatalf("a removed file exists: %v", err)
+	}
+	if exists, _ := s.mustOpen(t, "remove/a.bin").Exists(ctx); exists {
+		t.Fatal("a removed file exists once opened again")
+	}
+	if err := file.Remove(ctx); err != nil {
+		t.Fatalf("removing a missing file fails: %v", err)
+	}
+
+	// the file can be written again once removed
+	if _, err := file.Writer().Write([]byte("again")); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s.expectContent(t, file, []byte("again"))
+}
+
+func (s *suite) testChunks(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, c := range []struct {
+		size      int
+		chunkSize int64
+		short     bool
+	}{
+		{size: 12, chunkSize: 4},
+		{size: 10, chunkSize: 4},
+		{size: 3, chunkSize: 5},
+		{size: 1, chunkSize: 1},
+		{size: 100, chunkSize: 1},
+		{size: 64 * 1024, chunkSize: 10 * 1024},
+		{size: 64 * 1024, chunkSize: 10 * 1024, short: true},
+	} {
+		content := make([]byte, c.size)
+		random.Read(content)
+		relativePath := "chunks/" + strconv.Itoa(c.size) + "-" + strconv.FormatInt(c.chunkSize, 10) + "-" + strconv.FormatBool(c.short) + ".bin"
+		file := s.mustOpen(t, relativePath)
+		var reader io.Reader = bytes.NewReader(content)
+		if c.short {
+			reader = iotest.HalfReader(reader)
+		}
+
+		var numbers []int64
+		var written int64
+		writer := func(ctx context.Context, content []byte, chunk *core.FileChunkInfo) (int, error) {
+			if int64(len(content)) != chunk.ChunkSize || chunk.Offset != written {
+				t.Fatalf("%s: chunk %d of %d bytes at %d is read as %d bytes", relativePath, chunk.Number, chunk.ChunkSize, chunk.Offset, len(content))
+			}
+			numbers = append(numbers, chunk.Number)
+			written += chunk.ChunkSize
+			return file.WriteChunk(ctx, content, chunk)
+		}
+		err := file.WalkChunk(context.Background(), reader, c.chunkSize, int64(c.size), writer)
+		if err != nil {
+			t.Fatalf("%s: %v", relativePath, err)
+		}
+		for i, number := range numbers {
+			if number != int64(i+1) {
+				t.Fatalf("%s: chunks are numbered %v", relativePath, numbers)
+			}
+		}
+		if err := file.Flush(context.Background()); err != nil {
+			t.Fatalf("%s: %v", relativePath, err)
+		}
+		s.expectContent(t, file, content)
+	}
+}
+
+func (s *suite) testCanceledChunks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	content := []byte("never stored")
+	file := s.mustOpen(t, "canceled/a.bin")
+	if err := file.WalkChunk(ctx, bytes.NewReader(content), 4, int64(len(content)), file.WriteChunk); err == nil {
+		t.Fatal("chunks are written once ctx is done")
+	}
+	if exists, _ := s.mustOpen(t, "canceled/a.bin").Exists(context.Background()); exists {
+		t.Fatal("a canceled file is stored")
+	}
+}
+
+func (s *suite) testProperties(t *testing.T) {
+	ctx := context.Background()
+	content := []byte("the content described by the properties")
+	start := time.Now().Add(-time.Minute)
+	written := s.write(t, "properties/a.bin", content)
+	for _, file := range []core.FileInfo{written, s.mustOpen(t, "properties/a.bin")} {
+		crc, err := file.CRC64(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		properties := file.Properties()
+		if properties[core.PropertyName_ContentLength] != strconv.Itoa(len(content)) {
+			t.Fatalf("unexpected content length %q", properties[core.PropertyName_ContentLength])
+		}
+		if properties[core.PropertyName_ContentName] != "a.bin" {
+			t.Fatalf("unexpected content name %q", properties[core.PropertyName_ContentName])
+		}
+		if properties[core.PropertyName_ContentCRC64] != strconv.FormatUint(crc, 10) {
+			t.Fatalf("CRC64 property %q does not match CRC64 %d", properties[core.PropertyName_ContentCRC64], crc)
+		}
+		modTime, err := time.Parse(time.RFC3339, properties[core.PropertyName_ContentModTime])
+		if err != nil || modTime.Before(start) || modTime.After(time.Now().Add(time.Minute)) {
+			t.Fatalf("unexpected modification time %q: %v", properties[core.PropertyName_ContentModTime], err)
+		}
+	}
+}
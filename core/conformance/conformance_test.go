@@ -0,0 +1,107 @@
+package conformance
+
+import (
+	"context"
+	"os"
+	"osssync/core"
+	"osssync/core/azurefake"
+	"osssync/core/gcsfake"
+	"osssync/core/ossfake"
+	"osssync/core/sftpfake"
+	"osssync/core/webdavfake"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPhysicalFile(t *testing.T) {
+	dir := t.TempDir()
+	Run(t, func(ctx context.Context, relativePath string) (core.FileInfo, error) {
+		return core.OpenPhysicalFile(dir, relativePath)
+	})
+}
+
+func TestAliOSSFile(t *testing.T) {
+	t.Setenv(core.Env_AccessKeyId, "")
+	t.Setenv(core.Env_AccessKeySecret, "")
+	server := ossfake.NewServer("photos")
+	defer server.Close()
+	core.SetTestRetryPolicy(t)
+	config := core.AliOSSConfig{EndPoint: server.Endpoint(), AccessKeyId: "id", AccessKeySecret: "secret"}
+	Run(t, func(ctx context.Context, relativePath string) (core.FileInfo, error) {
+		return core.OpenAliOSS(ctx, config, "photos", "conformance", relativePath)
+	})
+	if server.Uploads() != 0 {
+		t.Fatalf("%d multipart uploads are left", server.Uploads())
+	}
+}
+
+func TestSFTPFile(t *testing.T) {
+	server := sftpfake.NewServer()
+	defer server.Close()
+	core.SetTestRetryPolicy(t)
+	privateKeyPath, knownHostsPath, err := server.WriteCredentials(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := core.SFTPConfig{PrivateKeyPath: privateKeyPath, KnownHostsPath: knownHostsPath}
+	backend, err := core.NewSFTPBackend(config, server.Uri("backup", t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	Run(t, func(ctx context.Context, relativePath string) (core.FileInfo, error) {
+		return core.NewBackendFile(ctx, backend, relativePath)
+	})
+}
+
+func TestWebDAVFile(t *testing.T) {
+	server := webdavfake.NewServer(t.TempDir(), "alice", "secret")
+	defer server.Close()
+	core.SetTestRetryPolicy(t)
+	os.MkdirAll(filepath.Join(server.Dir, "remote.php/dav/uploads/alice"), 0755)
+	os.MkdirAll(filepath.Join(server.Dir, "remote.php/dav/files/alice"), 0755)
+	// the chunks are uploaded by a single PUT, and by a chunked upload of Nextcloud
+	for _, dir := range []string{"/backup", "/remote.php/dav/files/alice/backup"} {
+		backend, err := core.NewWebDAVBackend(core.WebDAVConfig{Password: "secret"}, server.Uri(dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(dir, func(t *testing.T) {
+			Run(t, func(ctx context.Context, relativePath string) (core.FileInfo, error) {
+				return core.NewBackendFile(ctx, backend, relativePath)
+			})
+		})
+	}
+}
+
+func TestAzureBlobFile(t *testing.T) {
+	server := azurefake.NewServer("backups")
+	defer server.Close()
+	core.SetTestRetryPolicy(t)
+	config := core.AzureBlobConfig{AccountName: azurefake.AccountName, AccountKey: azurefake.AccountKey, Endpoint: server.Endpoint()}
+	backend, err := core.NewAzureBlobBackend(config, "backups", "conformance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Run(t, func(ctx context.Context, relativePath string) (core.FileInfo, error) {
+		return core.NewBackendFile(ctx, backend, relativePath)
+	})
+}
+
+func TestGCSFile(t *testing.T) {
+	server := gcsfake.NewServer("backups")
+	defer server.Close()
+	core.SetTestRetryPolicy(t)
+	backend, err := core.NewGCSBackend(core.GCSConfig{Endpoint: server.Endpoint(), WithoutAuthentication: true}, "backups", "conformance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Run(t, func(ctx context.Context, relativePath string) (core.FileInfo, error) {
+		return core.NewBackendFile(ctx, backend, relativePath)
+	})
+	for _, name := range server.Names("backups") {
+		if strings.Contains(name, "/.osy-parts/") {
+			t.Fatalf("the part %s is left", name)
+		}
+	}
+}
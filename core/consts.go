@@ -11,29 +11,76 @@ const (
 	PropertyName_ContentCRC64   PropertyName = "x-content-CRC64"
 	PropertyName_ContentModTime PropertyName = "x-content-modtime"
 	PropertyName_ContentType    PropertyName = "x-content-type"
+	PropertyName_ContentMode    PropertyName = "x-content-mode"
+	PropertyName_ContentETag    PropertyName = "x-content-etag"
+)
+
+type StorageClass string
+
+const (
+	StorageClass_Standard    StorageClass = "Standard"
+	StorageClass_IA          StorageClass = "IA"
+	StorageClass_Archive     StorageClass = "Archive"
+	StorageClass_ColdArchive StorageClass = "ColdArchive"
 )
 
 type FileType string
 
 const (
-	FileType_Physical FileType = "physical"
-	FileType_AliOSS   FileType = "alioss"
+	FileType_Physical  FileType = "physical"
+	FileType_AliOSS    FileType = "alioss"
+	FileType_SFTP      FileType = "sftp"
+	FileType_WebDAV    FileType = "webdav"
+	FileType_AzureBlob FileType = "azblob"
+	FileType_GCS       FileType = "gcs"
 )
 
+// ComparesByModTime is true for the remote file systems whose files are read in full to be hashed,
+// a file of the size and the modification time of its source is taken as unchanged instead
+func (fileType FileType) ComparesByModTime() bool {
+	return fileType == FileType_SFTP || fileType == FileType_WebDAV
+}
+
 const (
-	Arg_Config          = "OSY_CONFIG_PATH"
-	Arg_SourcePath      = "OSY_SOURCE_PATH"
-	Arg_DestPath        = "OSY_DEST_PATH"
-	Arg_CredentialsFile = "OSY_CREDENTIALS"
-	Arg_Operation       = "OSY_OPERATION"
-	Arg_FullIndex       = "OSY_FULL_INDEX"
-	Arg_ChunkSizeMb     = "OSY_CHUNK_SIZE_MB"
-	Arg_DbPath          = "OSY_DB_PATH"
-	Arg_Zip             = "OSY_ZIP"
-	Arg_Password        = "OSY_PASSWORD"
-	Arg_Mnemonic        = "OSY_MNEMONIC"
-	Arg_TmpDir          = "OSY_TMP_DIR"
+	Arg_Config            = "OSY_CONFIG_PATH"
+	Arg_SourcePath        = "OSY_SOURCE_PATH"
+	Arg_DestPath          = "OSY_DEST_PATH"
+	Arg_CredentialsFile   = "OSY_CREDENTIALS"
+	Arg_SourceCredentials = "OSY_SOURCE_CREDENTIALS"
+	Arg_Operation         = "OSY_OPERATION"
+	Arg_FullIndex         = "OSY_FULL_INDEX"
+	Arg_ChunkSizeMb       = "OSY_CHUNK_SIZE_MB"
+	Arg_DbPath            = "OSY_DB_PATH"
+	Arg_Zip               = "OSY_ZIP"
+	Arg_Password          = "OSY_PASSWORD"
+	Arg_Mnemonic          = "OSY_MNEMONIC"
+	Arg_TmpDir            = "OSY_TMP_DIR"
+	Arg_Daemon            = "OSY_DAEMON"
+	Arg_Cron              = "OSY_CRON"
+	Arg_CronJitter        = "OSY_CRON_JITTER"
+	Arg_ExecNow           = "OSY_EXEC_NOW"
+	Arg_WatchDebounce     = "OSY_WATCH_DEBOUNCE"
+	Arg_WatchReconcile    = "OSY_WATCH_RECONCILE"
+	Arg_Job               = "OSY_JOB"
+	Arg_AllJobs           = "OSY_ALL_JOBS"
+	Arg_Workers           = "OSY_WORKERS"
+	Arg_Concurrency       = "OSY_CONCURRENCY"
+	Arg_Progress          = "OSY_PROGRESS"
+	Arg_ProgressInterval  = "OSY_PROGRESS_INTERVAL"
+	Arg_HttpAddr          = "OSY_HTTP_ADDR"
+	Arg_HttpToken         = "OSY_HTTP_TOKEN"
+	Arg_HistoryKeep       = "OSY_HISTORY_KEEP"
+	Arg_Run               = "OSY_RUN"
+	Arg_Retries           = "OSY_RETRIES"
+	Arg_RetryDelay        = "OSY_RETRY_DELAY"
+	Arg_ShutdownTimeout   = "OSY_SHUTDOWN_TIMEOUT"
+	Arg_Bandwidth         = "OSY_BANDWIDTH"
+	Arg_VerifySample      = "OSY_VERIFY_SAMPLE"
+	Arg_StorageClass      = "OSY_STORAGE_CLASS"
+	Arg_RestorePoll       = "OSY_RESTORE_POLL"
+	Arg_Move              = "OSY_MOVE"
 )
 
 var ErrCRC64NotMatch error = fmt.Errorf("crc64 not match")
 var ErrIndexOutOfRange error = fmt.Errorf("index out of range")
+var ErrCopyNotSupported error = fmt.Errorf("copy not supported")
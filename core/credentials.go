@@ -0,0 +1,418 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"osssync/common/config"
+	"osssync/common/tracing"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+var ErrNoCredentials error = errors.New("no credentials")
+
+const (
+	Env_AccessKeyId     = "OSS_ACCESS_KEY_ID"
+	Env_AccessKeySecret = "OSS_ACCESS_KEY_SECRET"
+	Env_SessionToken    = "OSS_SESSION_TOKEN"
+)
+
+// credentialsRefreshMargin is how long before their expiry the temporary credentials are renewed
+const credentialsRefreshMargin = 5 * time.Minute
+
+// credentialCommandTimeout bounds the run of the credential command
+const credentialCommandTimeout = 30 * time.Second
+
+const defaultStsEndpoint = "sts.aliyuncs.com"
+
+// Credentials are the keys of an account or a role, temporary credentials have a security token and an expiration
+type Credentials struct {
+	AccessKeyId     string
+	AccessKeySecret string
+	SecurityToken   string
+	// Expiration is zero for the credentials which do not expire
+	Expiration time.Time
+}
+
+func (credentials *Credentials) GetAccessKeyID() string {
+	return credentials.AccessKeyId
+}
+
+func (credentials *Credentials) GetAccessKeySecret() string {
+	return credentials.AccessKeySecret
+}
+
+func (credentials *Credentials) GetSecurityToken() string {
+	return credentials.SecurityToken
+}
+
+// expiresWithin returns true if the credentials expire before now+d
+func (credentials *Credentials) expiresWithin(now time.Time, d time.Duration) bool {
+	return !credentials.Expiration.IsZero() && now.Add(d).After(credentials.Expiration)
+}
+
+// CredentialsProvider retrieves credentials, ErrNoCredentials is returned by a provider which is not configured
+type CredentialsProvider interface {
+	Retrieve(ctx context.Context) (*Credentials, error)
+}
+
+// AliOSSProfile is a named set of keys of the credentials file
+type AliOSSProfile struct {
+	AccessKeyId     string `yaml:"access_key_id"`
+	AccessKeySecret string `yaml:"access_key_secret"`
+	SecurityToken   string `yaml:"security_token"`
+}
+
+// credentialsFiles are the configs read from the credentials files by path,
+// a config is read again once its file is modified so that a long running daemon picks up the rotated keys
+type credentialsFiles[T any] struct {
+	configs sync.Map
+	// replaced is called with the config read before and the one replacing it, nil if nothing depends on the config
+	replaced func(previous T, current T)
+}
+
+type credentialsFile[T any] struct {
+	modTime time.Time
+	size    int64
+	config  T
+}
+
+// load returns the config of path, read by read unless the file is unchanged since the last read
+func (files *credentialsFiles[T]) load(path string, read func() (T, error)) (T, error) {
+	var cfg T
+	stat, err := os.Stat(path)
+	if err != nil {
+		return cfg, tracing.Error(err)
+	}
+	cached, ok := files.configs.Load(path)
+	if ok {
+		file := cached.(credentialsFile[T])
+		if file.modTime.Equal(stat.ModTime()) && file.size == stat.Size() {
+			return file.config, nil
+		}
+	}
+	cfg, err = read()
+	if err != nil {
+		return cfg, tracing.Error(err)
+	}
+	files.configs.Store(path, credentialsFile[T]{modTime: stat.ModTime(), size: stat.Size(), config: cfg})
+	if ok && files.replaced != nil {
+		files.replaced(cached.(credentialsFile[T]).config, cfg)
+	}
+	return cfg, nil
+}
+
+var aliOSSConfigs = credentialsFiles[AliOSSConfig]{replaced: dropAliOSSClients}
+
+// LoadAliOSSConfig reads the credentials file once until it is modified, the calls in between return the same config and share its credentials
+func LoadAliOSSConfig(credentialFilePath string) (AliOSSConfig, error) {
+	return aliOSSConfigs.load(credentialFilePath, func() (AliOSSConfig, error) {
+		aliCfg := AliOSSCfgWrapper{}
+		err := config.BindYaml(credentialFilePath, &aliCfg)
+		if err != nil {
+			return AliOSSConfig{}, tracing.Error(err)
+		}
+		cfg := aliCfg.Config
+		if cfg.Profile != "" {
+			profile, ok := aliCfg.Profiles[cfg.Profile]
+			if !ok {
+				return AliOSSConfig{}, fmt.Errorf("profile %s is not found in %s", cfg.Profile, credentialFilePath)
+			}
+			cfg.profile = profile
+		}
+		return cfg, nil
+	})
+}
+
+var credentialsProviders sync.Map
+
+// aliOSSCredentials returns the cached credentials of config, shared by all the clients of the same config
+func aliOSSCredentials(config AliOSSConfig) *CachedCredentials {
+	if provider, ok := credentialsProviders.Load(config.key()); ok {
+		return provider.(*CachedCredentials)
+	}
+	provider, _ := credentialsProviders.LoadOrStore(config.key(), NewCachedCredentials(NewCredentialsChain(config)))
+	return provider.(*CachedCredentials)
+}
+
+// NewCredentialsChain returns the providers of config in order: the profile, the keys of the credentials file,
+// the OSS_ACCESS_KEY_ID, OSS_ACCESS_KEY_SECRET and OSS_SESSION_TOKEN env vars, then the credential command.
+// The credentials found are used to assume role_arn when it is set.
+func NewCredentialsChain(config AliOSSConfig) CredentialsProvider {
+	chain := ChainProvider{
+		StaticProvider{AccessKeyId: config.profile.AccessKeyId, AccessKeySecret: config.profile.AccessKeySecret, SecurityToken: config.profile.SecurityToken},
+		StaticProvider{AccessKeyId: config.AccessKeyId, AccessKeySecret: config.AccessKeySecret, SecurityToken: config.SecurityToken},
+		EnvProvider{},
+		CommandProvider{Command: config.CredentialCommand},
+	}
+	if config.RoleArn == "" {
+		return chain
+	}
+	return &AssumeRoleProvider{
+		Source:      NewCachedCredentials(chain),
+		RoleArn:     config.RoleArn,
+		SessionName: config.RoleSessionName,
+		Duration:    time.Duration(config.RoleDurationSeconds) * time.Second,
+		Endpoint:    config.StsEndpoint,
+	}
+}
+
+// ChainProvider returns the credentials of the first configured provider
+type ChainProvider []CredentialsProvider
+
+func (chain ChainProvider) Retrieve(ctx context.Context) (*Credentials, error) {
+	for _, provider := range chain {
+		credentials, err := provider.Retrieve(ctx)
+		if tracing.IsError(err, ErrNoCredentials) {
+			continue
+		}
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		return credentials, nil
+	}
+	return nil, tracing.Errorf("no credentials found in the credentials file, the env vars or the credential command", ErrNoCredentials)
+}
+
+// EnvProvider reads the credentials of the OSS_ACCESS_KEY_ID, OSS_ACCESS_KEY_SECRET and OSS_SESSION_TOKEN env vars
+type EnvProvider struct{}
+
+func (EnvProvider) Retrieve(ctx context.Context) (*Credentials, error) {
+	return StaticProvider{
+		AccessKeyId:     os.Getenv(Env_AccessKeyId),
+		AccessKeySecret: os.Getenv(Env_AccessKeySecret),
+		SecurityToken:   os.Getenv(Env_SessionToken),
+	}.Retrieve(ctx)
+}
+
+// StaticProvider returns fixed keys, a security token makes them the credentials of a STS session
+type StaticProvider struct {
+	AccessKeyId     string
+	AccessKeySecret string
+	SecurityToken   string
+}
+
+func (provider StaticProvider) Retrieve(ctx context.Context) (*Credentials, error) {
+	if provider.AccessKeyId == "" || provider.AccessKeySecret == "" {
+		return nil, ErrNoCredentials
+	}
+	return &Credentials{
+		AccessKeyId:     provider.AccessKeyId,
+		AccessKeySecret: provider.AccessKeySecret,
+		SecurityToken:   provider.SecurityToken,
+	}, nil
+}
+
+// CommandProvider runs a shell command printing the credentials as json:
+//
+//	{"AccessKeyId": "...", "AccessKeySecret": "...", "SecurityToken": "...", "Expiration": "2024-01-01T00:00:00Z"}
+//
+// SecurityToken and Expiration are optional
+type CommandProvider struct {
+	Command string
+}
+
+func (provider CommandProvider) Retrieve(ctx context.Context) (*Credentials, error) {
+	if provider.Command == "" {
+		return nil, ErrNoCredentials
+	}
+	ctx, cancel := context.WithTimeout(ctx, credentialCommandTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", provider.Command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return nil, tracing.Errorf(fmt.Sprintf("credential command failed: %s", strings.TrimSpace(stderr.String())), err)
+	}
+	credentials := &Credentials{}
+	err = json.Unmarshal(stdout.Bytes(), credentials)
+	if err != nil {
+		return nil, tracing.Errorf("invalid output of the credential command", err)
+	}
+	if credentials.AccessKeyId == "" || credentials.AccessKeySecret == "" {
+		return nil, errors.New("credential command printed no AccessKeyId or AccessKeySecret")
+	}
+	return credentials, nil
+}
+
+// AssumeRoleProvider returns the credentials of a STS session of a RAM role, assumed by the credentials of Source
+type AssumeRoleProvider struct {
+	Source      CredentialsProvider
+	RoleArn     string
+	SessionName string
+	// Duration is the lifetime of the session, 1 hour if 0
+	Duration time.Duration
+	// Endpoint is the host of the STS service, sts.aliyuncs.com if empty
+	Endpoint string
+	// Client sends the requests, http.DefaultClient if nil
+	Client *http.Client
+}
+
+func (provider *AssumeRoleProvider) Retrieve(ctx context.Context) (*Credentials, error) {
+	source, err := provider.Source.Retrieve(ctx)
+	if err != nil {
+		return nil, tracing.Errorf("no credentials to assume the role", err)
+	}
+	params := map[string]string{
+		"Action":           "AssumeRole",
+		"Version":          "2015-04-01",
+		"Format":           "JSON",
+		"RoleArn":          provider.RoleArn,
+		"RoleSessionName":  provider.SessionName,
+		"DurationSeconds":  "3600",
+		"AccessKeyId":      source.AccessKeyId,
+		"SignatureMethod":  "HMAC-SHA1",
+		"SignatureVersion": "1.0",
+		"SignatureNonce":   nonce(),
+		"Timestamp":        time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+	}
+	if provider.SessionName == "" {
+		params["RoleSessionName"] = "osssync"
+	}
+	if provider.Duration > 0 {
+		params["DurationSeconds"] = fmt.Sprint(int64(provider.Duration.Seconds()))
+	}
+	if source.SecurityToken != "" {
+		params["SecurityToken"] = source.SecurityToken
+	}
+	params["Signature"] = signRpc(http.MethodGet, params, source.AccessKeySecret)
+
+	endpoint := provider.Endpoint
+	if endpoint == "" {
+		endpoint = defaultStsEndpoint
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	query := url.Values{}
+	for k, v := range params {
+		query.Set(k, v)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/?"+query.Encode(), nil)
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	client := provider.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Code        string
+		Message     string
+		Credentials struct {
+			AccessKeyId     string
+			AccessKeySecret string
+			SecurityToken   string
+			Expiration      time.Time
+		}
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, tracing.Errorf(fmt.Sprintf("invalid response of AssumeRole, status %d", resp.StatusCode), err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to assume role %s: %s %s", provider.RoleArn, result.Code, result.Message)
+	}
+	return &Credentials{
+		AccessKeyId:     result.Credentials.AccessKeyId,
+		AccessKeySecret: result.Credentials.AccessKeySecret,
+		SecurityToken:   result.Credentials.SecurityToken,
+		Expiration:      result.Credentials.Expiration,
+	}, nil
+}
+
+// signRpc returns the signature of a request of the RPC style APIs of Aliyun
+func signRpc(method string, params map[string]string, secret string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, percentEncode(k)+"="+percentEncode(params[k]))
+	}
+	stringToSign := method + "&" + percentEncode("/") + "&" + percentEncode(strings.Join(pairs, "&"))
+	mac := hmac.New(sha1.New, []byte(secret+"&"))
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func percentEncode(s string) string {
+	s = url.QueryEscape(s)
+	s = strings.ReplaceAll(s, "+", "%20")
+	s = strings.ReplaceAll(s, "*", "%2A")
+	return strings.ReplaceAll(s, "%7E", "~")
+}
+
+func nonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// CachedCredentials keeps the credentials of a provider until they are about to expire.
+// It is the credentials provider of the OSS clients, the renewal failing keeps the previous credentials
+// which are valid for a few minutes more.
+type CachedCredentials struct {
+	provider    CredentialsProvider
+	lock        sync.Mutex
+	credentials *Credentials
+	now         func() time.Time
+}
+
+func NewCachedCredentials(provider CredentialsProvider) *CachedCredentials {
+	return &CachedCredentials{provider: provider, now: time.Now}
+}
+
+// Retrieve returns the cached credentials, renewed if they expire within credentialsRefreshMargin
+func (cache *CachedCredentials) Retrieve(ctx context.Context) (*Credentials, error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if cache.credentials != nil && !cache.credentials.expiresWithin(cache.now(), credentialsRefreshMargin) {
+		return cache.credentials, nil
+	}
+	credentials, err := cache.provider.Retrieve(ctx)
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	cache.credentials = credentials
+	return credentials, nil
+}
+
+// GetCredentials implements oss.CredentialsProvider
+func (cache *CachedCredentials) GetCredentials() oss.Credentials {
+	credentials, err := cache.Retrieve(context.Background())
+	if err != nil {
+		cache.lock.Lock()
+		defer cache.lock.Unlock()
+		if cache.credentials != nil {
+			return cache.credentials
+		}
+		return &Credentials{}
+	}
+	return credentials
+}
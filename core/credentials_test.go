@@ -0,0 +1,196 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCredentialsChain(t *testing.T) {
+	t.Setenv(Env_AccessKeyId, "")
+	t.Setenv(Env_AccessKeySecret, "")
+	path := filepath.Join(t.TempDir(), "credential.yaml")
+	os.WriteFile(path, []byte(`
+alioss:
+  endpoint: oss-cn-hangzhou.aliyuncs.com
+  access_key_id: file-id
+  access_key_secret: file-secret
+  profile: backup
+profiles:
+  backup:
+    access_key_id: profile-id
+    access_key_secret: profile-secret
+`), 0644)
+	cfg, err := LoadAliOSSConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	credentials, err := NewCredentialsChain(cfg).Retrieve(ctx)
+	if err != nil || credentials.AccessKeyId != "profile-id" {
+		t.Fatalf("expect the keys of the profile, got %+v %v", credentials, err)
+	}
+
+	t.Setenv(Env_AccessKeyId, "env-id")
+	t.Setenv(Env_AccessKeySecret, "env-secret")
+	t.Setenv(Env_SessionToken, "env-token")
+	credentials, err = NewCredentialsChain(cfg).Retrieve(ctx)
+	if err != nil || credentials.AccessKeyId != "profile-id" {
+		t.Fatalf("expect the keys of the profile before the env vars, got %+v %v", credentials, err)
+	}
+	credentials, err = NewCredentialsChain(AliOSSConfig{}).Retrieve(ctx)
+	if err != nil || credentials.AccessKeyId != "env-id" || credentials.SecurityToken != "env-token" {
+		t.Fatalf("expect the keys of the env vars, got %+v %v", credentials, err)
+	}
+
+	t.Setenv(Env_AccessKeyId, "")
+	credentials, err = NewCredentialsChain(AliOSSConfig{
+		CredentialCommand: `echo '{"AccessKeyId": "cmd-id", "AccessKeySecret": "cmd-secret", "Expiration": "2030-01-01T00:00:00Z"}'`,
+	}).Retrieve(ctx)
+	if err != nil || credentials.AccessKeyId != "cmd-id" || credentials.Expiration.Year() != 2030 {
+		t.Fatalf("expect the keys of the command, got %+v %v", credentials, err)
+	}
+	if _, err := NewCredentialsChain(AliOSSConfig{CredentialCommand: "exit 1"}).Retrieve(ctx); err == nil {
+		t.Fatal("expect an error of the failing command")
+	}
+	if _, err := NewCredentialsChain(AliOSSConfig{}).Retrieve(ctx); err == nil {
+		t.Fatal("expect an error without credentials")
+	}
+}
+
+func TestLoadAliOSSConfigModified(t *testing.T) {
+	t.Setenv(Env_AccessKeyId, "")
+	t.Setenv(Env_AccessKeySecret, "")
+	path := filepath.Join(t.TempDir(), "credential.yaml")
+	write := func(id string, modTime time.Time) {
+		os.WriteFile(path, []byte(fmt.Sprintf(`
+alioss:
+  endpoint: oss-cn-hangzhou.aliyuncs.com
+  access_key_id: %s
+  access_key_secret: secret
+`, id)), 0644)
+		os.Chtimes(path, modTime, modTime)
+	}
+	write("old-id", time.Now().Add(-time.Hour))
+	cfg, err := LoadAliOSSConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	client, bucket, err := aliOSSBucket(ctx, cfg, "photos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := LoadAliOSSConfig(path); again.key() != cfg.key() {
+		t.Fatal("an unchanged file is read again")
+	}
+
+	write("new-id", time.Now())
+	rotated, err := LoadAliOSSConfig(path)
+	if err != nil || rotated.AccessKeyId != "new-id" {
+		t.Fatalf("expect the rotated keys, got %+v %v", rotated, err)
+	}
+	if _, ok := aliOSSClients.Load(cfg.key()); ok {
+		t.Fatal("the client of the replaced config is kept")
+	}
+	if _, ok := aliOSSBuckets.Load(aliOSSBucketKey{config: cfg.key(), bucket: "photos"}); ok {
+		t.Fatal("the bucket of the replaced config is kept")
+	}
+	other, otherBucket, err := aliOSSBucket(ctx, rotated, "photos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == client || otherBucket == bucket || other.Config.GetCredentials().GetAccessKeyID() != "new-id" {
+		t.Fatal("the rotated keys do not sign the requests")
+	}
+}
+
+type countingProvider struct {
+	calls      int
+	expiration time.Time
+}
+
+func (provider *countingProvider) Retrieve(ctx context.Context) (*Credentials, error) {
+	provider.calls++
+	return &Credentials{AccessKeyId: "id", AccessKeySecret: "secret", Expiration: provider.expiration}, nil
+}
+
+func TestCachedCredentials(t *testing.T) {
+	now := time.Now()
+	provider := &countingProvider{expiration: now.Add(time.Hour)}
+	cache := NewCachedCredentials(provider)
+	cache.now = func() time.Time { return now }
+
+	cache.GetCredentials()
+	cache.GetCredentials()
+	if provider.calls != 1 {
+		t.Fatalf("credentials are retrieved %d times before they expire", provider.calls)
+	}
+	now = now.Add(56 * time.Minute)
+	cache.GetCredentials()
+	if provider.calls != 2 {
+		t.Fatal("credentials about to expire are not renewed")
+	}
+}
+
+func TestAssumeRole(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("Action") != "AssumeRole" || query.Get("AccessKeyId") != "id" || query.Get("Signature") == "" ||
+			query.Get("RoleArn") != "acs:ram::1:role/backup" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"Code": "InvalidParameter", "Message": r.URL.RawQuery})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"Credentials": map[string]string{
+				"AccessKeyId":     "STS.id",
+				"AccessKeySecret": "sts-secret",
+				"SecurityToken":   "sts-token",
+				"Expiration":      "2030-01-01T00:00:00Z",
+			},
+		})
+	}))
+	defer server.Close()
+
+	provider := &AssumeRoleProvider{
+		Source:   StaticProvider{AccessKeyId: "id", AccessKeySecret: "secret"},
+		RoleArn:  "acs:ram::1:role/backup",
+		Endpoint: server.URL,
+	}
+	credentials, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentials.AccessKeyId != "STS.id" || credentials.SecurityToken != "sts-token" || credentials.Expiration.Year() != 2030 {
+		t.Fatalf("unexpected credentials %+v", credentials)
+	}
+
+	provider.RoleArn = "acs:ram::1:role/other"
+	if _, err := provider.Retrieve(context.Background()); err == nil {
+		t.Fatal("expect an error of the rejected request")
+	}
+}
+
+func TestSignRpc(t *testing.T) {
+	// the example of the signature of the RPC APIs of Aliyun
+	params := map[string]string{
+		"Format":           "XML",
+		"AccessKeyId":      "testid",
+		"Action":           "DescribeRegions",
+		"SignatureMethod":  "HMAC-SHA1",
+		"SignatureNonce":   "3ee8c1b8-83d3-44af-a94f-4e0ad82fd6cf",
+		"SignatureVersion": "1.0",
+		"Timestamp":        "2016-02-23T12:46:24Z",
+		"Version":          "2014-05-26",
+	}
+	if signature := signRpc("GET", params, "testsecret"); signature != "OLeaidS1JvxuMvnyHOwuJ+uX5qY=" {
+		t.Fatalf("unexpected signature %s", signature)
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"encoding/pem"
 	"fmt"
 	"hash/crc64"
+	"math/big"
 	"math/rand"
+	"sync"
 
 	"github.com/tyler-smith/go-bip39"
 )
@@ -56,9 +58,49 @@ func GetPasswordSeed(password string) int64 {
 	return seed
 }
 
+// rsaKeys caches the keys by seed, a key takes seconds to generate
+var rsaKeys sync.Map
+
+// GenerateRsaKey returns the 4096 bits key derived from seed, the same seed always gives the same key.
+// rsa.GenerateKey and rand.Prime are not used as they may draw a random byte more, or ignore the seeded reader.
 func GenerateRsaKey(seed int64) (*rsa.PrivateKey, error) {
+	if pk, ok := rsaKeys.Load(seed); ok {
+		return pk.(*rsa.PrivateKey), nil
+	}
 	r := rand.New(rand.NewSource(seed))
-	return rsa.GenerateKey(r, 4096)
+	one := big.NewInt(1)
+	for {
+		p, err := seededPrime(r, 2048)
+		if err != nil {
+			return nil, err
+		}
+		q, err := seededPrime(r, 2048)
+		if err != nil {
+			return nil, err
+		}
+		if p.Cmp(q) == 0 {
+			continue
+		}
+		n := new(big.Int).Mul(p, q)
+		if n.BitLen() != 4096 {
+			continue
+		}
+		totient := new(big.Int).Mul(new(big.Int).Sub(p, one), new(big.Int).Sub(q, one))
+		pk := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: n, E: 65537},
+			Primes:    []*big.Int{p, q},
+		}
+		pk.D = new(big.Int).ModInverse(big.NewInt(int64(pk.E)), totient)
+		if pk.D == nil {
+			continue
+		}
+		pk.Precompute()
+		if pk.Validate() != nil {
+			continue
+		}
+		actual, _ := rsaKeys.LoadOrStore(seed, pk)
+		return actual.(*rsa.PrivateKey), nil
+	}
 }
 
 func GetPrivateKeyPEM(pk *rsa.PrivateKey, keyFormat string) ([]byte, error) {
@@ -108,3 +150,22 @@ func GetPublicKeyPEM(pk *rsa.PrivateKey, keyFormat string) ([]byte, error) {
 	return pem.EncodeToMemory(pb), nil
 
 }
+
+// seededPrime returns a prime of bits drawn from r only, its top two bits are set
+// so that the product of two primes has twice the bits
+func seededPrime(r *rand.Rand, bits int) (*big.Int, error) {
+	if bits%8 != 0 {
+		return nil, fmt.Errorf("prime size %d is not a multiple of 8", bits)
+	}
+	buffer := make([]byte, bits/8)
+	p := new(big.Int)
+	for {
+		r.Read(buffer)
+		buffer[0] |= 0xc0
+		buffer[len(buffer)-1] |= 1
+		p.SetBytes(buffer)
+		if p.ProbablyPrime(20) {
+			return p, nil
+		}
+	}
+}
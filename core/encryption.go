@@ -19,6 +19,13 @@ import (
 var ErrBlockCRC64NotMatch error = errors.New("block crc64 not match")
 var ErrHeaderTypeNotMatch error = errors.New("header type not match")
 var ErrVersionNotMatch error = errors.New("version not match")
+var ErrInvalidCryptoFile error = errors.New("invalid crypto file")
+
+// cryptoHeaderType is the type of the header of the files written by EncryptFile
+const cryptoHeaderType = 0
+
+// maxCryptoHeaderSize bounds the header read from a damaged file
+const maxCryptoHeaderSize = 1024 * 1024
 
 type CryptoFileHeader struct {
 	// HeaderSize: 4
@@ -76,37 +83,13 @@ func (header *CryptoFileHeader) Bytes() []byte {
 	return buf.Bytes()
 }
 
+// GetCrytoFileCrc64 returns the CRC64 of the plain content recorded in the header of a crypto file, 0 if it can't be read
 func GetCrytoFileCrc64(filePath string) uint64 {
-	file, err := os.Open(filePath)
-	if err != nil {
+	header, err := GetCryptoFileHeader(filePath)
+	if err != nil || header.HeaderType != cryptoHeaderType {
 		return 0
 	}
-	defer file.Close()
-
-	_, err = file.Seek(4, io.SeekStart)
-	if err != nil {
-		return 0
-	}
-	headerTypeBuf := make([]byte, 4)
-	_, err = file.Read(headerTypeBuf)
-	if err != nil {
-		return 0
-	}
-	headerType := binary.LittleEndian.Uint32(headerTypeBuf)
-	if headerType != 1 {
-		return 0
-	}
-
-	_, err = file.Seek(12, io.SeekStart)
-	if err != nil {
-		return 0
-	}
-	crc64Buf := make([]byte, 8)
-	_, err = file.Read(crc64Buf)
-	if err != nil {
-		return 0
-	}
-	return binary.LittleEndian.Uint64(crc64Buf)
+	return header.CRC64
 }
 
 func GetCryptoFileHeader(filePath string) (*CryptoFileHeader, error) {
@@ -122,16 +105,20 @@ func GetCryptoFileHeader(filePath string) (*CryptoFileHeader, error) {
 	return header, nil
 }
 
+// ReadCryptoFileHeader reads the header at the start of reader, reader is left at the first block
 func ReadCryptoFileHeader(reader io.Reader) (*CryptoFileHeader, error) {
 	headerSizeBuf := make([]byte, 4)
-	_, err := reader.Read(headerSizeBuf)
+	_, err := io.ReadFull(reader, headerSizeBuf)
 	if err != nil {
 		return nil, err
 	}
 	headerSize := binary.LittleEndian.Uint32(headerSizeBuf)
+	if headerSize < 52 || headerSize > maxCryptoHeaderSize {
+		return nil, ErrInvalidCryptoFile
+	}
 	headerBuf := make([]byte, headerSize)
 	copy(headerBuf, headerSizeBuf)
-	_, err = reader.Read(headerBuf[4:])
+	_, err = io.ReadFull(reader, headerBuf[4:])
 	if err != nil {
 		return nil, err
 	}
@@ -139,9 +126,11 @@ func ReadCryptoFileHeader(reader io.Reader) (*CryptoFileHeader, error) {
 	return header, nil
 }
 
+// ParseCryptoFileHeader parses a header from its first byte, the size included
 func ParseCryptoFileHeader(content []byte) *CryptoFileHeader {
 	header := &CryptoFileHeader{}
 	buf := bytes.NewBuffer(content)
+	binary.Read(buf, binary.LittleEndian, &header.HeaderSize)
 	binary.Read(buf, binary.LittleEndian, &header.HeaderType)
 	binary.Read(buf, binary.LittleEndian, &header.Version)
 	binary.Read(buf, binary.LittleEndian, &header.CRC64)
@@ -160,11 +149,11 @@ func ParseCryptoFileHeader(content []byte) *CryptoFileHeader {
 }
 
 type EncryptBlock struct {
-	// 0:4
+	// 0:4 size of the plain content
 	BlockSize int32
-	// 4:12
+	// 4:12 CRC64 of the plain content
 	CRC64 uint64
-	// 12:
+	// 12: encrypted content, padded to the block size of the cipher
 	Content []byte
 }
 
@@ -176,21 +165,14 @@ func (block *EncryptBlock) Bytes() []byte {
 	return buf.Bytes()
 }
 
+// Decode decrypts the content of the block and checks it by its CRC64
 func (block *EncryptBlock) Decode(iv, password []byte) ([]byte, error) {
-	buf := make([]byte, len(block.Content))
-	copy(buf, block.Content)
-	blockSizeBuf := buf[:4]
-	blockSize := binary.LittleEndian.Uint32(blockSizeBuf)
-	block.BlockSize = int32(blockSize)
-
-	crcvBuf := buf[4:12]
-	crcv := binary.LittleEndian.Uint64(crcvBuf)
-	block.CRC64 = crcv
-
-	contentBuf := buf[12:]
-	content := aesCbc.AesDecrypt(contentBuf, iv, password)
+	decrypted := aesCbc.AesDecrypt(password, iv, block.Content)
+	// the cipher trims the zeros ending the content with its padding
+	content := make([]byte, block.BlockSize)
+	copy(content, decrypted)
 	decodedCrc := crc64.Checksum(content, crc64.MakeTable(crc64.ECMA))
-	if decodedCrc != crcv {
+	if decodedCrc != block.CRC64 {
 		return nil, ErrBlockCRC64NotMatch
 	}
 
@@ -199,7 +181,7 @@ func (block *EncryptBlock) Decode(iv, password []byte) ([]byte, error) {
 
 func GenerateEncyptedBlock(content []byte, iv, password []byte) *EncryptBlock {
 	crcv := crc64.Checksum(content, crc64.MakeTable(crc64.ECMA))
-	encrytedBuf := aesCbc.AesEncrypt(content, iv, password)
+	encrytedBuf := aesCbc.AesEncrypt(password, iv, content)
 	block := &EncryptBlock{
 		BlockSize: int32(len(content)),
 		CRC64:     crcv,
@@ -208,6 +190,15 @@ func GenerateEncyptedBlock(content []byte, iv, password []byte) *EncryptBlock {
 	return block
 }
 
+// encryptedSize returns the size of the encrypted content of a block of blockSize plain bytes
+func encryptedSize(blockSize int32, iv, password []byte) int {
+	cipherBlockSize := 16
+	if c, ok := aesCbc.NewAesCipher(password, iv).(interface{ BlockSize() int }); ok {
+		cipherBlockSize = c.BlockSize()
+	}
+	return (int(blockSize)-1)/cipherBlockSize*cipherBlockSize + cipherBlockSize
+}
+
 func EncryptFile(dirPath string, destPath string, relativePath string, pk *rsa.PublicKey, crc64V uint64) (string, error) {
 
 	fileInfo, err := os.Stat(JoinUri(dirPath, relativePath))
@@ -264,7 +255,7 @@ func EncryptFile(dirPath string, destPath string, relativePath string, pk *rsa.P
 	}
 
 	header := &CryptoFileHeader{
-		HeaderType:            0,
+		HeaderType:            cryptoHeaderType,
 		Version:               1,
 		CRC64:                 crc64V,
 		Algorithm:             1,
@@ -284,29 +275,24 @@ func EncryptFile(dirPath string, destPath string, relativePath string, pk *rsa.P
 		return "", err
 	}
 
-	var eof bool
+	chunkBuf := make([]byte, header.ChunkSize)
 	for {
-		chunkBuf := make([]byte, header.ChunkSize)
-		n, err := srcFile.Read(chunkBuf)
-		if err != nil {
-			if err == io.EOF {
-				eof = true
-				endBuf := make([]byte, n)
-				copy(endBuf, chunkBuf[:n])
-				chunkBuf = endBuf
+		n, err := io.ReadFull(srcFile, chunkBuf)
+		if n > 0 {
+			block := GenerateEncyptedBlock(chunkBuf[:n], ivBuf, passwordBuf)
+			_, writeErr := destFile.Write(block.Bytes())
+			if writeErr != nil {
+				return "", writeErr
 			}
-			return "", err
 		}
-		block := GenerateEncyptedBlock(chunkBuf, ivBuf, passwordBuf)
-		_, err = destFile.Write(block.Bytes())
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
 		if err != nil {
 			return "", err
 		}
-		if eof {
-			break
-		}
 	}
-	return destFileName, nil
+	return destFilePath, nil
 }
 
 func DecryptFile(sourcePath string, destPath string, relativePath string, pk *rsa.PrivateKey) error {
@@ -326,25 +312,22 @@ func DecryptFile(sourcePath string, destPath string, relativePath string, pk *rs
 	}
 	defer destFile.Close()
 
-	headerSizeBuf := make([]byte, 4)
-	_, err = file.Read(headerSizeBuf)
-	if err != nil {
-		return err
-	}
-	headerSize := binary.LittleEndian.Uint32(headerSizeBuf)
-	headerBuf := make([]byte, headerSize)
-	_, err = file.Seek(0, io.SeekStart)
+	_, err = DecryptStream(file, pk, destFile)
+	return err
+}
+
+// DecryptStream writes the plain content of the crypto file read from reader to writer,
+// every block is checked by its CRC64 and the whole content by the CRC64 of the header
+func DecryptStream(reader io.Reader, pk *rsa.PrivateKey, writer io.Writer) (*CryptoFileHeader, error) {
+	header, err := ReadCryptoFileHeader(reader)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	header := ParseCryptoFileHeader(headerBuf)
-
-	if header.HeaderType != 0 {
-		return ErrHeaderTypeNotMatch
+	if header.HeaderType != cryptoHeaderType {
+		return header, ErrHeaderTypeNotMatch
 	}
-
 	if header.Version != 1 {
-		return ErrVersionNotMatch
+		return header, ErrVersionNotMatch
 	}
 
 	password, err := rsa.DecryptOAEP(
@@ -354,63 +337,44 @@ func DecryptFile(sourcePath string, destPath string, relativePath string, pk *rs
 		header.EncryptedPassword,
 		nil)
 	if err != nil {
-		return err
+		return header, err
 	}
 
-	// read first block
-	_, err = file.Seek(int64(headerSize), io.SeekStart)
-	if err != nil {
-		return err
-	}
-	var eof bool
 	crcCipher := crc64.New(crc64.MakeTable(crc64.ECMA))
+	blockHeaderBuf := make([]byte, 12)
 	for {
-		blockSizeBuf := make([]byte, 4)
-		_, err = file.Read(blockSizeBuf)
-		if err != nil {
-			if err == io.EOF {
-				eof = true
-			}
-			return err
+		_, err = io.ReadFull(reader, blockHeaderBuf)
+		if err == io.EOF {
+			break
 		}
-		blockSize := binary.LittleEndian.Uint32(blockSizeBuf)
-		blockBuf := make([]byte, blockSize+8)
-		_, err = file.Read(blockBuf)
 		if err != nil {
-			return err
+			return header, err
 		}
-		blockCrc64Buf := blockBuf[:8]
-		blockCrc64 := binary.LittleEndian.Uint64(blockCrc64Buf)
-		blockContentBuf := blockBuf[8:]
 		block := &EncryptBlock{
-			BlockSize: int32(blockSize),
-			CRC64:     blockCrc64,
-			Content:   blockContentBuf,
+			BlockSize: int32(binary.LittleEndian.Uint32(blockHeaderBuf[:4])),
+			CRC64:     binary.LittleEndian.Uint64(blockHeaderBuf[4:]),
 		}
-		blockContent, err := block.Decode(header.IV, password)
-		if err != nil {
-			return err
+		if block.BlockSize <= 0 || block.BlockSize > header.ChunkSize {
+			return header, ErrInvalidCryptoFile
 		}
-		decodedBlockCrc64 := crc64.Checksum(blockContent, crc64.MakeTable(crc64.ECMA))
-		if decodedBlockCrc64 != block.CRC64 {
-			return ErrCRC64NotMatch
-		}
-		_, err = destFile.Write(blockContent)
+		block.Content = make([]byte, encryptedSize(block.BlockSize, header.IV, password))
+		_, err = io.ReadFull(reader, block.Content)
 		if err != nil {
-			return err
+			return header, err
 		}
-		_, err = crcCipher.Write(blockContent)
+		blockContent, err := block.Decode(header.IV, password)
 		if err != nil {
-			return err
+			return header, err
 		}
-		if eof {
-			break
+		_, err = writer.Write(blockContent)
+		if err != nil {
+			return header, err
 		}
+		crcCipher.Write(blockContent)
 	}
 
-	fileCrc64 := crcCipher.Sum64()
-	if fileCrc64 != header.CRC64 {
-		return ErrCRC64NotMatch
+	if crcCipher.Sum64() != header.CRC64 {
+		return header, ErrCRC64NotMatch
 	}
-	return nil
+	return header, nil
 }
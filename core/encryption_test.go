@@ -0,0 +1,65 @@
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"hash/crc64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptFile(t *testing.T) {
+	dir := t.TempDir()
+	// more than a chunk, ending with zeros trimmed by the padding of the cipher
+	content := make([]byte, 1024*1024+100)
+	rand.Read(content[:1024*1024])
+	os.WriteFile(filepath.Join(dir, "a.bin"), content, 0644)
+	crc := crc64.Checksum(content, crc64.MakeTable(crc64.ECMA))
+
+	pk, err := GenerateRsaKey(GetPasswordSeed("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cryptoPath, err := EncryptFile(dir, t.TempDir(), "/a.bin", &pk.PublicKey, crc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if GetCrytoFileCrc64(cryptoPath) != crc {
+		t.Fatal("CRC64 of the header does not match the content")
+	}
+
+	encrypted, _ := os.ReadFile(cryptoPath)
+	var plain bytes.Buffer
+	header, err := DecryptStream(bytes.NewReader(encrypted), pk, &plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(header.Name) != "a.bin" || !bytes.Equal(plain.Bytes(), content) {
+		t.Fatalf("decrypted content does not match, %d bytes of %d", plain.Len(), len(content))
+	}
+
+	encrypted[len(encrypted)-40] ^= 1
+	if _, err := DecryptStream(bytes.NewReader(encrypted), pk, &bytes.Buffer{}); err == nil {
+		t.Fatal("damaged content is decrypted")
+	}
+	if _, err := DecryptStream(bytes.NewReader(encrypted[:len(encrypted)-10]), pk, &bytes.Buffer{}); err == nil {
+		t.Fatal("truncated content is decrypted")
+	}
+}
+
+func TestGenerateRsaKey(t *testing.T) {
+	seed := GetPasswordSeed("deterministic")
+	pk, err := GenerateRsaKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKeys.Delete(seed)
+	again, err := GenerateRsaKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pk.N.Cmp(again.N) != 0 || pk.D.Cmp(again.D) != 0 || pk.N.BitLen() != 4096 {
+		t.Fatal("keys of the same seed do not match")
+	}
+}
@@ -1,22 +1,21 @@
 package core
 
 import (
+	"context"
 	"crypto/md5"
+	"fmt"
 	"hash/crc64"
 	"io"
-	"math"
+	"io/fs"
 	"os"
-	"osssync/common/config"
 	"osssync/common/tracing"
-	"strconv"
+	"path/filepath"
+	"sort"
 	"strings"
-	"sync"
 	"time"
-
-	"github.com/mr-tron/base58"
 )
 
-type FileChunkWriter func(content []byte, chunk *FileChunkInfo) (n int, err error)
+type FileChunkWriter func(ctx context.Context, content []byte, chunk *FileChunkInfo) (n int, err error)
 
 type FileChunkInfo struct {
 	Number    int64
@@ -37,6 +36,7 @@ type ObjectInfo struct {
 	RelativePath string
 	FileType     FileType
 	Size         int64
+	StorageClass StorageClass
 }
 
 type FileInfo interface {
@@ -47,269 +47,375 @@ type FileInfo interface {
 	Path() string
 	RelativePath() string
 	Size() int64
-	MD5() (string, error)
-	CRC64() (uint64, error)
-	Exists() (bool, error)
+	// the operations reading or writing the content stop once ctx is done
+	MD5(ctx context.Context) (string, error)
+	CRC64(ctx context.Context) (uint64, error)
+	Exists(ctx context.Context) (bool, error)
 	Properties() map[PropertyName]string
-	Remove() error
+	Remove(ctx context.Context) error
 
 	Reader() io.Reader
 	Writer() io.Writer
-	Flush() error
+	Flush(ctx context.Context) error
 
 	// Stream() (FileStream, error)
-	WalkChunk(reader io.Reader, chunkSize int64, fileSize int64, writer FileChunkWriter) error
-	WriteChunk(content []byte, chunk *FileChunkInfo) (n int, err error)
+	WalkChunk(ctx context.Context, reader io.Reader, chunkSize int64, fileSize int64, writer FileChunkWriter) error
+	WriteChunk(ctx context.Context, content []byte, chunk *FileChunkInfo) (n int, err error)
 }
 
-type CryptoFileInfo interface {
-	FileInfo
-	UseEncryption(useMnemonic bool, content string) error
+// Copier is a file which copies the content of another file of the same backend without the content leaving the backend,
+// ErrCopyNotSupported is returned if src can't be copied this way
+type Copier interface {
+	CopyFrom(ctx context.Context, src FileInfo) error
 }
 
-type PhysicalFileInfo struct {
-	path         string
-	relativePath string
-	statInfo     os.FileInfo
-	exists       bool
-	isIdle       bool
-
-	md5       []byte
-	md5Base58 string
+// Tiered is a file of a storage with storage classes, an archived file can be read once it is restored
+type Tiered interface {
+	// SetStorageClass sets the class of the content written next
+	SetStorageClass(class StorageClass)
+	StorageClass() StorageClass
+	// Restore requests a readable copy of an archived file, a restore in progress is not requested again
+	Restore(ctx context.Context) error
+	// Readable returns true if the file is not archived or its restore is complete
+	Readable(ctx context.Context) (bool, error)
+}
 
-	crc64 uint64
+// Attributed is a file of a file system keeping the permissions and the modification time of its source
+type Attributed interface {
+	// SetAttributes sets the permission bits and the modification time of the content written next, zero values are the defaults of the backend
+	SetAttributes(mode fs.FileMode, modTime time.Time)
+	// Attributes returns the permission bits, 0 if unknown, and the modification time of the file
+	Attributes() (fs.FileMode, time.Time)
+}
 
-	f *os.File
+// Annotated is a file of an object storage with user metadata and content headers, which its copies keep
+type Annotated interface {
+	// SetMetadata sets the user metadata and the content headers of the content written next
+	SetMetadata(metadata map[string]string, headers map[string]string)
+	// Metadata returns the user metadata and the content headers of the file, nil if it does not exist
+	Metadata() (map[string]string, map[string]string)
+}
 
-	hashOnce sync.Once
+type CryptoFileInfo interface {
+	FileInfo
+	UseEncryption(useMnemonic bool, content string) error
 }
 
+// OpenPhysicalFile opens the file of relativePath under dirPath, nothing is created until content is written to it
 func OpenPhysicalFile(dirPath string, relativePath string) (FileInfo, error) {
-	filePath := JoinUri(dirPath, relativePath)
-	fileInfo := &PhysicalFileInfo{isIdle: true}
-	statInfo, err := os.Stat(filePath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			fileInfo.exists = true
-			fileDirPath := filePath[:strings.LastIndex(filePath, "/")]
-			_, err := os.Stat(fileDirPath)
-			if err != nil {
-				if os.IsNotExist(err) {
-					err = os.MkdirAll(fileDirPath, 0755)
-					if err != nil {
-						return nil, tracing.Error(err)
-					}
-				} else {
-					return nil, tracing.Error(err)
-				}
-			}
-			fd, err := os.Create(filePath)
-			if err != nil {
-				return nil, tracing.Error(err)
-			}
-			fd.Close()
-			statInfo, err = os.Stat(filePath)
-			if err != nil {
-				return nil, tracing.Error(err)
-			}
-		} else {
-			return nil, tracing.Error(err)
-		}
-	}
-	lastIndexOf := strings.LastIndex(filePath, "/")
-	if lastIndexOf == -1 {
-		fileInfo.path = "/"
-	} else {
-		fileInfo.path = filePath[:lastIndexOf]
-	}
-	fileInfo.statInfo = statInfo
-	fileInfo.exists = true
-	fileInfo.relativePath = relativePath
-
-	f, err := os.OpenFile(JoinUri(fileInfo.Path(), fileInfo.Name()), os.O_RDWR, 0)
-	if err != nil {
-		return nil, tracing.Error(err)
-	}
-	fileInfo.f = f
-	return fileInfo, nil
+	return NewBackendFile(context.Background(), NewPhysicalBackend(dirPath), relativePath)
 }
 
-func (fileInfo *PhysicalFileInfo) Reader() io.Reader {
-	return fileInfo.f
+// PhysicalBackend is a directory of the local file system
+type PhysicalBackend struct {
+	dir string
 }
 
-func (fileInfo *PhysicalFileInfo) FileType() string {
-	return string(FileType_Physical)
+func NewPhysicalBackend(dir string) *PhysicalBackend {
+	return &PhysicalBackend{dir: strings.TrimSuffix(dir, "/")}
 }
 
-func (fileInfo *PhysicalFileInfo) Close() error {
-	return fileInfo.f.Close()
+func (backend *PhysicalBackend) Type() FileType {
+	return FileType_Physical
 }
 
-func (fileInfo *PhysicalFileInfo) Name() string {
-	return fileInfo.statInfo.Name()
-}
-func (fileInfo *PhysicalFileInfo) Path() string {
-	return fileInfo.path
+func (backend *PhysicalBackend) Root() string {
+	return backend.dir
 }
-func (fileInfo *PhysicalFileInfo) RelativePath() string {
-	return fileInfo.relativePath
-}
-func (fileInfo *PhysicalFileInfo) Size() int64 {
-	return fileInfo.statInfo.Size()
+
+func (backend *PhysicalBackend) filePath(relativePath string) string {
+	return JoinUri(backend.dir, relativePath)
 }
 
-func (fileInfo *PhysicalFileInfo) Exists() (bool, error) {
-	_, err := os.Stat(JoinUri(fileInfo.Path(), fileInfo.Name()))
+func (backend *PhysicalBackend) Stat(ctx context.Context, relativePath string) (*Stat, error) {
+	statInfo, err := os.Stat(backend.filePath(relativePath))
+	if os.IsNotExist(err) {
+		return nil, tracing.Error(ErrNotFound)
+	}
 	if err != nil {
-		if os.IsNotExist(err) {
-			return false, nil
-		}
-		return false, tracing.Error(err)
+		return nil, tracing.Error(err)
+	}
+	if statInfo.IsDir() {
+		return nil, tracing.Errorf(fmt.Sprintf("%s is a directory", relativePath), ErrNotFound)
 	}
-	return true, nil
+	return physicalStat(relativePath, statInfo), nil
 }
 
-func (fileInfo *PhysicalFileInfo) WalkChunk(reader io.Reader, chunkSize int64, fileSize int64, writer FileChunkWriter) error {
-	dstObjName := fileInfo.f.Name()
-	if config.GetValueOrDefault(Arg_Zip, false) && !strings.HasSuffix(dstObjName, ".zip") {
-		dstObjName = JoinUri(fileInfo.f.Name(), ".zip")
+func physicalStat(relativePath string, statInfo os.FileInfo) *Stat {
+	return &Stat{
+		RelativePath: relativePath,
+		Size:         statInfo.Size(),
+		ModTime:      statInfo.ModTime(),
+		Mode:         statInfo.Mode().Perm(),
+		Readable:     true,
+		Properties: map[PropertyName]string{
+			PropertyName_ContentType: "application/octet-stream",
+			PropertyName_ContentMode: fmt.Sprintf("%04o", statInfo.Mode().Perm()),
+		},
 	}
-	if fileInfo.f.Name() != dstObjName {
-		fileInfo.f.Close()
-		if _, err := os.Stat(dstObjName); err == nil {
-			os.Remove(dstObjName)
+}
+
+// listPageSize is the max count of the files of a page of a listing of a directory
+var listPageSize = 1000
+
+// List walks the files under prefix in the lexical order of their paths, the hidden files and directories are skipped.
+// The continue token is the path of the last file of the page.
+func (backend *PhysicalBackend) List(ctx context.Context, prefix string, continueToken string) (*ListPage, error) {
+	page := &ListPage{Files: make([]*Stat, 0)}
+	root := backend.filePath(prefix)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipDir
+			}
+			return err
 		}
-		fileInfo.f, _ = os.Create(dstObjName)
-	}
-	chunkNum := int64(math.Ceil(float64(fileSize) / float64(chunkSize)))
-	chunkReader := NewChunkReader(reader, chunkSize)
-	defer chunkReader.Close()
-	var chunkN = (int64)(chunkNum)
-	for i := int64(0); i < chunkN; i++ {
-		size := chunkSize
-		if i == chunkN-1 {
-			size = fileSize - i*chunkSize
-		} else {
-			size = chunkSize
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
 		}
-		chunk := &FileChunkInfo{
-			Number:    i,
-			ChunkSize: size,
-			Offset:    i * chunkSize,
+		if strings.HasPrefix(d.Name(), ".") && path != root {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
 		}
-
-		_, buffer := chunkReader.ReadNext()
-
-		_, err := writer(buffer, chunk)
+		relativePath := strings.TrimPrefix(strings.TrimPrefix(filepath.ToSlash(path), backend.dir), "/")
+		if d.IsDir() {
+			// the directories before the token are listed already
+			if continueToken != "" && relativePath != "" && walkOrder(relativePath) < walkOrder(continueToken) &&
+				!strings.HasPrefix(continueToken, relativePath+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if walkOrder(relativePath) <= walkOrder(continueToken) {
+			return nil
+		}
+		if len(page.Files) == listPageSize {
+			page.IsTruncated = true
+			page.ContinueToken = page.Files[len(page.Files)-1].RelativePath
+			return filepath.SkipAll
+		}
+		statInfo, err := d.Info()
 		if err != nil {
 			return err
 		}
+		page.Files = append(page.Files, physicalStat(relativePath, statInfo))
 		return nil
+	})
+	if err != nil {
+		return nil, tracing.Error(err)
 	}
-	return nil
+	return page, nil
 }
 
-func (fileInfo *PhysicalFileInfo) Writer() io.Writer {
-	dstObjName := fileInfo.f.Name()
-	if config.GetValueOrDefault(Arg_Zip, false) && !strings.HasSuffix(dstObjName, ".zip") {
-		dstObjName = JoinUri(fileInfo.f.Name(), ".zip")
-	}
-	if fileInfo.f.Name() != dstObjName {
-		fileInfo.f.Close()
-		if _, err := os.Stat(dstObjName); err == nil {
-			os.Remove(dstObjName)
-		}
-		fileInfo.f, _ = os.Create(dstObjName)
-	}
-	return fileInfo.f
+// walkOrder makes paths compare in the order of a walk, which lists the entries of a directory by name:
+// "a/b" is walked before "a-b" as "a" is before "a-b"
+func walkOrder(path string) string {
+	return strings.ReplaceAll(path, "/", "\x00")
 }
-func (fileInfo *PhysicalFileInfo) Flush() error {
-	return nil
+
+// treeEntry is an entry of a directory of a remote file system
+type treeEntry struct {
+	name string
+	dir  bool
+	// stat is the stat of a file, its relative path is set by listTree
+	stat *Stat
 }
 
-func (fileInfo *PhysicalFileInfo) WriteChunk(content []byte, chunk *FileChunkInfo) (n int, err error) {
-	size := int64(len(content))
-	bufferSize := chunk.ChunkSize
-	if size > bufferSize {
-		return 0, ErrIndexOutOfRange
+// listTree lists the files under prefix in the order and by the pages of PhysicalBackend.List, for a file system read a directory at a time.
+// readDir returns the entries of the directory of a relative path, none if it does not exist.
+func listTree(ctx context.Context, prefix string, continueToken string, readDir func(dir string) ([]treeEntry, error)) (*ListPage, error) {
+	page := &ListPage{Files: make([]*Stat, 0)}
+	var walk func(dir string) (bool, error)
+	// walk returns false once the page is full
+	walk = func(dir string) (bool, error) {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		entries, err := readDir(dir)
+		if err != nil {
+			return false, err
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.name, ".") {
+				continue
+			}
+			relativePath := strings.TrimPrefix(dir+"/"+entry.name, "/")
+			if entry.dir {
+				// the directories before the token are listed already
+				if continueToken != "" && walkOrder(relativePath) < walkOrder(continueToken) &&
+					!strings.HasPrefix(continueToken, relativePath+"/") {
+					continue
+				}
+				more, err := walk(relativePath)
+				if !more || err != nil {
+					return more, err
+				}
+				continue
+			}
+			if walkOrder(relativePath) <= walkOrder(continueToken) {
+				continue
+			}
+			if len(page.Files) == listPageSize {
+				page.IsTruncated = true
+				page.ContinueToken = page.Files[len(page.Files)-1].RelativePath
+				return false, nil
+			}
+			entry.stat.RelativePath = relativePath
+			page.Files = append(page.Files, entry.stat)
+		}
+		return true, nil
+	}
+	_, err := walk(strings.Trim(prefix, "/"))
+	if err != nil {
+		return nil, tracing.Error(err)
 	}
+	return page, nil
+}
 
-	n, err = fileInfo.f.WriteAt(content, chunk.Offset)
+func (backend *PhysicalBackend) Open(ctx context.Context, relativePath string) (io.ReadCloser, error) {
+	f, err := os.Open(backend.filePath(relativePath))
+	if os.IsNotExist(err) {
+		return nil, tracing.Error(ErrNotFound)
+	}
 	if err != nil {
-		return 0, err
+		return nil, tracing.Error(err)
 	}
-	return n, nil
+	return f, nil
 }
 
-func (fileInfo *PhysicalFileInfo) ComputeHashOnce() error {
-	file, err := os.Open(JoinUri(fileInfo.Path(), fileInfo.Name()))
+// Create writes the content to a hidden temporary file next to the file, which replaces the file once it is committed
+func (backend *PhysicalBackend) Create(ctx context.Context, relativePath string, options CreateOptions) (Upload, error) {
+	filePath := backend.filePath(relativePath)
+	dir := filepath.Dir(filePath)
+	err := os.MkdirAll(dir, 0755)
 	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	return &physicalUpload{f: f, path: filePath, options: options}, nil
+}
+
+func (backend *PhysicalBackend) Delete(ctx context.Context, relativePath string) error {
+	err := os.Remove(backend.filePath(relativePath))
+	if err != nil && !os.IsNotExist(err) {
 		return tracing.Error(err)
 	}
+	return nil
+}
+
+// Hash reads the file to compute its MD5 and CRC64
+func (backend *PhysicalBackend) Hash(ctx context.Context, relativePath string) ([]byte, uint64, error) {
+	file, err := os.Open(backend.filePath(relativePath))
+	if err != nil {
+		return nil, 0, tracing.Error(err)
+	}
 	defer file.Close()
 	bufferSize := 1024 * 1024
 	buffer := make([]byte, bufferSize)
 	md5 := md5.New()
 	CRC64 := crc64.New(crc64.MakeTable(crc64.ECMA))
 	for {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, tracing.Error(err)
+		}
 		n, err := file.Read(buffer)
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			return tracing.Error(err)
+			return nil, 0, tracing.Error(err)
 		}
-		_, err = md5.Write(buffer[:n])
-		if err != nil {
-			return tracing.Error(err)
-		}
-		_, err = CRC64.Write(buffer[:n])
-		if err != nil {
-			return tracing.Error(err)
-		}
-
+		md5.Write(buffer[:n])
+		CRC64.Write(buffer[:n])
 	}
-	fileInfo.md5 = md5.Sum(nil)
-	fileInfo.md5Base58 = base58.Encode(fileInfo.md5)
-	fileInfo.crc64 = CRC64.Sum64()
-	return nil
+	return md5.Sum(nil), CRC64.Sum64(), nil
 }
 
-func (fileInfo *PhysicalFileInfo) MD5() (string, error) {
-	err := fileInfo.ComputeHashOnce()
+// hashContent reads content to compute its MD5 and CRC64, the read stops once ctx is done
+func hashContent(ctx context.Context, content io.Reader) ([]byte, uint64, error) {
+	md5 := md5.New()
+	CRC64 := crc64.New(crc64.MakeTable(crc64.ECMA))
+	_, err := io.Copy(io.MultiWriter(md5, CRC64), &ctxReader{ctx: ctx, r: content})
 	if err != nil {
-		return "", tracing.Error(err)
+		return nil, 0, tracing.Error(err)
+	}
+	return md5.Sum(nil), CRC64.Sum64(), nil
+}
+
+// ctxReader stops reading once ctx is done
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (reader *ctxReader) Read(p []byte) (int, error) {
+	if err := reader.ctx.Err(); err != nil {
+		return 0, err
 	}
+	return reader.r.Read(p)
+}
 
-	return fileInfo.md5Base58, nil
+type physicalUpload struct {
+	f       *os.File
+	path    string
+	options CreateOptions
 }
 
-func (fileInfo *PhysicalFileInfo) CRC64() (uint64, error) {
-	err := fileInfo.ComputeHashOnce()
+func (upload *physicalUpload) Write(p []byte) (int, error) {
+	return upload.f.Write(p)
+}
+
+func (upload *physicalUpload) WriteChunk(ctx context.Context, content []byte, chunk *FileChunkInfo) (int, error) {
+	if int64(len(content)) > chunk.ChunkSize {
+		return 0, ErrIndexOutOfRange
+	}
+	n, err := upload.f.WriteAt(content, chunk.Offset)
 	if err != nil {
 		return 0, tracing.Error(err)
 	}
-	return fileInfo.crc64, nil
-}
-func (fileInfo *PhysicalFileInfo) Properties() map[PropertyName]string {
-	properties := map[PropertyName]string{
-		PropertyName_ContentType:    "application/octet-stream",
-		PropertyName_ContentMD5:     fileInfo.md5Base58,
-		PropertyName_ContentCRC64:   strconv.FormatUint(uint64(fileInfo.crc64), 10),
-		PropertyName_ContentName:    "",
-		PropertyName_ContentModTime: "",
-		PropertyName_ContentLength:  "0",
-	}
+	return n, nil
+}
 
-	if fileInfo.statInfo != nil {
-		properties[PropertyName_ContentLength] = strconv.FormatInt(fileInfo.statInfo.Size(), 10)
-		properties[PropertyName_ContentName] = fileInfo.statInfo.Name()
-		properties[PropertyName_ContentModTime] = fileInfo.statInfo.ModTime().Format(time.RFC3339)
+func (upload *physicalUpload) Commit(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		upload.Abort()
+		return tracing.Error(err)
 	}
-
-	return properties
+	mode := upload.options.Mode.Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	err := upload.f.Chmod(mode)
+	if err == nil {
+		err = upload.f.Sync()
+	}
+	if closeErr := upload.f.Close(); err == nil {
+		err = closeErr
+	}
+	if modTime := upload.options.ModTime; err == nil && !modTime.IsZero() {
+		err = os.Chtimes(upload.f.Name(), modTime, modTime)
+	}
+	if err == nil {
+		err = os.Rename(upload.f.Name(), upload.path)
+	}
+	if err != nil {
+		os.Remove(upload.f.Name())
+		return tracing.Error(err)
+	}
+	return nil
 }
-func (fileInfo *PhysicalFileInfo) Remove() error {
-	return os.Remove(JoinUri(fileInfo.Path(), fileInfo.Name()))
+
+func (upload *physicalUpload) Abort() error {
+	upload.f.Close()
+	err := os.Remove(upload.f.Name())
+	if err != nil && !os.IsNotExist(err) {
+		return tracing.Error(err)
+	}
+	return nil
 }
@@ -0,0 +1,441 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"osssync/common/config"
+	"osssync/common/tracing"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/google/uuid"
+	"github.com/mr-tron/base58"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// gcsUploadChunkSize is the size of the requests of a resumable upload, a smaller content is uploaded by a single request
+var gcsUploadChunkSize = 16 * 1024 * 1024
+
+// gcsMaxComposeSources is the max count of the objects composed by a request
+const gcsMaxComposeSources = 32
+
+// gcsPartsDir is the directory of the parts of the multipart uploads in progress, its objects are not listed
+const gcsPartsDir = ".osy-parts"
+
+// GCSConfig is the gcs section of the credentials file, the requests are authorized by the application default credentials
+// if no credentials file is set:
+//
+//	gcs:
+//	  credentials_file: /etc/osssync/service-account.json
+type GCSConfig struct {
+	// CredentialsFile is the json key of a service account
+	CredentialsFile string `yaml:"credentials_file"`
+	// Endpoint is the url of the JSON API, e.g. http://127.0.0.1:4443/storage/v1/ for fake-gcs-server
+	Endpoint string `yaml:"endpoint"`
+	// WithoutAuthentication sends the requests unauthorized, e.g. to an emulator or to a public bucket
+	WithoutAuthentication bool `yaml:"without_authentication"`
+}
+
+type GCSCfgWrapper struct {
+	Config GCSConfig `yaml:"gcs"`
+}
+
+var gcsConfigs credentialsFiles[GCSConfig]
+
+// LoadGCSConfig reads the gcs section of the credentials file once until it is modified, an empty path is the config of the application default credentials
+func LoadGCSConfig(credentialFilePath string) (GCSConfig, error) {
+	if credentialFilePath == "" {
+		return GCSConfig{}, nil
+	}
+	return gcsConfigs.load(credentialFilePath, func() (GCSConfig, error) {
+		gcsCfg := GCSCfgWrapper{}
+		err := config.BindYaml(credentialFilePath, &gcsCfg)
+		if err != nil {
+			return GCSConfig{}, tracing.Error(err)
+		}
+		return gcsCfg.Config, nil
+	})
+}
+
+// gcsClients are the clients by their config, a client and its connections are shared by all the files
+var gcsClients sync.Map
+
+// gcsClient returns the client of config, created once. The sdk does not retry, the calls are retried by withRetry.
+func gcsClient(config GCSConfig) (*storage.Client, error) {
+	key := fmt.Sprintf("%+v", config)
+	if client, ok := gcsClients.Load(key); ok {
+		return client.(*storage.Client), nil
+	}
+	var options []option.ClientOption
+	if config.CredentialsFile != "" {
+		options = append(options, option.WithCredentialsFile(config.CredentialsFile))
+	}
+	if config.Endpoint != "" {
+		options = append(options, option.WithEndpoint(config.Endpoint))
+	}
+	if config.WithoutAuthentication {
+		options = append(options, option.WithoutAuthentication())
+	}
+	// the client outlives the context of any transfer
+	client, err := storage.NewClient(context.Background(), options...)
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	client.SetRetry(storage.WithPolicy(storage.RetryNever))
+	actual, loaded := gcsClients.LoadOrStore(key, client)
+	if loaded {
+		client.Close()
+	}
+	return actual.(*storage.Client), nil
+}
+
+func isGCSNotFound(err error) bool {
+	return errors.Is(tracing.Cause(err), storage.ErrObjectNotExist)
+}
+
+// gcsStorageClass is the class of the objects of a storage class, empty for the default class of the bucket
+func gcsStorageClass(class StorageClass) string {
+	switch class {
+	case StorageClass_Standard:
+		return "STANDARD"
+	case StorageClass_IA:
+		return "NEARLINE"
+	case StorageClass_Archive, StorageClass_ColdArchive:
+		return "ARCHIVE"
+	}
+	return ""
+}
+
+// storageClassOfGCS is the storage class of an object, the legacy classes are Standard
+func storageClassOfGCS(class string) StorageClass {
+	switch class {
+	case "NEARLINE", "COLDLINE":
+		return StorageClass_IA
+	case "ARCHIVE":
+		return StorageClass_Archive
+	}
+	return StorageClass_Standard
+}
+
+// gcsStat returns the stat of the attributes of an object, the objects of every class are readable
+func gcsStat(relativePath string, attrs *storage.ObjectAttrs) *Stat {
+	stat := &Stat{
+		RelativePath: relativePath,
+		Size:         attrs.Size,
+		ModTime:      attrs.Updated,
+		StorageClass: storageClassOfGCS(attrs.StorageClass),
+		Readable:     true,
+		Properties: map[PropertyName]string{
+			PropertyName_ContentType: attrs.ContentType,
+			PropertyName_ContentETag: attrs.Etag,
+		},
+	}
+	if attrs.ContentType == "" {
+		stat.Properties[PropertyName_ContentType] = "application/octet-stream"
+	}
+	if len(attrs.MD5) > 0 {
+		stat.Properties[PropertyName_ContentMD5] = base58.Encode(attrs.MD5)
+	}
+	for k, v := range attrs.Metadata {
+		stat.Properties[PropertyName(strings.ToLower(k))] = v
+		if strings.EqualFold(k, crc64MetadataKey) {
+			stat.CRC64, _ = strconv.ParseUint(v, 10, 64)
+		}
+	}
+	return stat
+}
+
+// GCSBackend is the objects of a bucket of Google Cloud Storage under a prefix, uri gs://bucket/prefix
+type GCSBackend struct {
+	bucket     *storage.BucketHandle
+	bucketName string
+	prefix     string
+}
+
+func NewGCSBackend(config GCSConfig, bucketName string, prefix string) (*GCSBackend, error) {
+	client, err := gcsClient(config)
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	return &GCSBackend{bucket: client.Bucket(bucketName), bucketName: bucketName, prefix: strings.Trim(prefix, "/")}, nil
+}
+
+func (backend *GCSBackend) Type() FileType {
+	return FileType_GCS
+}
+
+func (backend *GCSBackend) Root() string {
+	return fmt.Sprintf("gs://%s/%s", backend.bucketName, backend.prefix)
+}
+
+// objectName is the name of the object of relativePath, a name does not start or end with "/"
+func (backend *GCSBackend) objectName(relativePath string) string {
+	return strings.Trim(JoinUri(backend.prefix, relativePath), "/")
+}
+
+func (backend *GCSBackend) Stat(ctx context.Context, relativePath string) (*Stat, error) {
+	var attrs *storage.ObjectAttrs
+	err := withRetry(ctx, "GetObject", func() (err error) {
+		attrs, err = backend.bucket.Object(backend.objectName(relativePath)).Attrs(ctx)
+		return err
+	})
+	if isGCSNotFound(err) {
+		return nil, tracing.Error(ErrNotFound)
+	}
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	return gcsStat(relativePath, attrs), nil
+}
+
+// List lists a page of the objects under prefix, the continue token is the page token of the next page.
+// The parts of the multipart uploads in progress are not listed.
+func (backend *GCSBackend) List(ctx context.Context, prefix string, continueToken string) (*ListPage, error) {
+	namePrefix := backend.objectName(prefix)
+	if namePrefix != "" {
+		namePrefix += "/"
+	}
+	var objects []*storage.ObjectAttrs
+	var nextToken string
+	err := withRetry(ctx, "ListObjects", func() (err error) {
+		objects = objects[:0]
+		it := backend.bucket.Objects(ctx, &storage.Query{Prefix: namePrefix})
+		nextToken, err = iterator.NewPager(it, listPageSize, continueToken).NextPage(&objects)
+		return err
+	})
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	page := &ListPage{Files: make([]*Stat, 0, len(objects)), IsTruncated: nextToken != "", ContinueToken: nextToken}
+	rootPrefix := backend.objectName("")
+	if rootPrefix != "" {
+		rootPrefix += "/"
+	}
+	for _, attrs := range objects {
+		relativePath := strings.TrimPrefix(attrs.Name, rootPrefix)
+		if strings.HasPrefix(relativePath, gcsPartsDir+"/") {
+			continue
+		}
+		page.Files = append(page.Files, gcsStat(relativePath, attrs))
+	}
+	return page, nil
+}
+
+func (backend *GCSBackend) Open(ctx context.Context, relativePath string) (io.ReadCloser, error) {
+	var reader *storage.Reader
+	err := withRetry(ctx, "GetObjectMedia", func() (err error) {
+		reader, err = backend.bucket.Object(backend.objectName(relativePath)).NewReader(ctx)
+		return err
+	})
+	if isGCSNotFound(err) {
+		return nil, tracing.Error(ErrNotFound)
+	}
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	return readCloser{Reader: throttle(ctx, reader, -1), Closer: reader}, nil
+}
+
+// Create buffers the content of a simple upload, the chunks of a multipart upload are uploaded as objects
+// which are composed into the object once committed
+func (backend *GCSBackend) Create(ctx context.Context, relativePath string, options CreateOptions) (Upload, error) {
+	return &gcsUpload{
+		backend:      backend,
+		objectName:   backend.objectName(relativePath),
+		storageClass: gcsStorageClass(options.StorageClass),
+		multipart:    options.Multipart,
+		buffer:       NewBufferWriter(0),
+		partsPrefix:  backend.objectName(JoinUri(gcsPartsDir, uuid.NewString())) + "/",
+	}, nil
+}
+
+func (backend *GCSBackend) Delete(ctx context.Context, relativePath string) error {
+	err := backend.delete(ctx, backend.objectName(relativePath))
+	if err != nil {
+		return tracing.Error(err)
+	}
+	return nil
+}
+
+// delete deletes an object by its name, deleting a missing object is not an error
+func (backend *GCSBackend) delete(ctx context.Context, objectName string) error {
+	err := withRetry(ctx, "DeleteObject", func() error {
+		return backend.bucket.Object(objectName).Delete(ctx)
+	})
+	if err != nil && !isGCSNotFound(err) {
+		return tracing.Error(err)
+	}
+	return nil
+}
+
+// put uploads content to an object whose attributes are attrs, the upload is resumable if the content is larger than chunkSize.
+// A failing upload is canceled so that no partial content is stored.
+func (backend *GCSBackend) put(ctx context.Context, attrs storage.ObjectAttrs, content []byte, chunkSize int) error {
+	return withRetry(ctx, "InsertObject", func() error {
+		uploadCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		writer := backend.bucket.Object(attrs.Name).NewWriter(uploadCtx)
+		writer.ObjectAttrs = attrs
+		writer.ChunkSize = chunkSize
+		_, err := io.Copy(writer, throttle(ctx, bytes.NewReader(content), int64(len(content))))
+		if err != nil {
+			cancel()
+			writer.Close()
+			return err
+		}
+		return writer.Close()
+	})
+}
+
+type gcsUpload struct {
+	backend      *GCSBackend
+	objectName   string
+	storageClass string
+	multipart    bool
+	buffer       *BufferWriter
+	checksums    chunkChecksums
+	// partsPrefix is the prefix of the objects of the parts and of the objects composed of them
+	partsPrefix string
+	lock        sync.Mutex
+	composed    []string
+}
+
+func (upload *gcsUpload) Write(p []byte) (int, error) {
+	return upload.buffer.Write(p)
+}
+
+func (upload *gcsUpload) partName(number int64) string {
+	return upload.partsPrefix + fmt.Sprintf("%06d", number)
+}
+
+// WriteChunk uploads a chunk as an object named by its number, a chunk written again replaces the object
+func (upload *gcsUpload) WriteChunk(ctx context.Context, content []byte, chunk *FileChunkInfo) (int, error) {
+	if !upload.multipart {
+		return 0, tracing.Error(errors.New("not a multipart upload"))
+	}
+	if int64(len(content)) > chunk.ChunkSize {
+		return 0, ErrIndexOutOfRange
+	}
+	err := upload.backend.put(ctx, storage.ObjectAttrs{Name: upload.partName(chunk.Number)}, content, 0)
+	if err != nil {
+		return 0, tracing.Error(err)
+	}
+	upload.checksums.add(chunk.Number, content)
+	return len(content), nil
+}
+
+// attrs are the attributes of the object, the CRC64 of the content is kept as its metadata
+func (upload *gcsUpload) attrs(crc uint64) storage.ObjectAttrs {
+	return storage.ObjectAttrs{
+		Name:         upload.objectName,
+		StorageClass: upload.storageClass,
+		Metadata:     map[string]string{crc64MetadataKey: strconv.FormatUint(crc, 10)},
+	}
+}
+
+// Commit composes the parts in the order of their numbers or uploads the buffered content
+func (upload *gcsUpload) Commit(ctx context.Context) error {
+	if !upload.multipart {
+		content := upload.buffer.Bytes()
+		err := upload.backend.put(ctx, upload.attrs(crc64Checksum(content)), content, gcsUploadChunkSize)
+		if err != nil {
+			return tracing.Error(err)
+		}
+		return nil
+	}
+	numbers := upload.checksums.numbers()
+	if len(numbers) == 0 {
+		err := upload.backend.put(ctx, upload.attrs(0), nil, 0)
+		if err != nil {
+			return tracing.Error(err)
+		}
+		return nil
+	}
+	sources := make([]string, 0, len(numbers))
+	for _, number := range numbers {
+		sources = append(sources, upload.partName(number))
+	}
+	// a compose has 32 sources at most, the parts are composed by rounds into objects of 32 parts then of 32 of those objects...
+	for round := 0; len(sources) > gcsMaxComposeSources; round++ {
+		composed := make([]string, 0, len(sources)/gcsMaxComposeSources+1)
+		for i := 0; i < len(sources); i += gcsMaxComposeSources {
+			end := i + gcsMaxComposeSources
+			if end > len(sources) {
+				end = len(sources)
+			}
+			name := upload.partsPrefix + fmt.Sprintf("composed-%d-%06d", round, len(composed))
+			upload.lock.Lock()
+			upload.composed = append(upload.composed, name)
+			upload.lock.Unlock()
+			err := upload.compose(ctx, storage.ObjectAttrs{Name: name}, sources[i:end])
+			if err != nil {
+				return tracing.Error(err)
+			}
+			composed = append(composed, name)
+		}
+		sources = composed
+	}
+	err := upload.compose(ctx, upload.attrs(upload.checksums.sum()), sources)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	upload.deleteParts()
+	return nil
+}
+
+func (upload *gcsUpload) compose(ctx context.Context, attrs storage.ObjectAttrs, sources []string) error {
+	bucket := upload.backend.bucket
+	handles := make([]*storage.ObjectHandle, 0, len(sources))
+	for _, source := range sources {
+		handles = append(handles, bucket.Object(source))
+	}
+	return withRetry(ctx, "ComposeObject", func() error {
+		composer := bucket.Object(attrs.Name).ComposerFrom(handles...)
+		composer.ObjectAttrs = attrs
+		_, err := composer.Run(ctx)
+		return err
+	})
+}
+
+// deleteParts deletes the objects of the parts and the objects composed of them, even if the context of the transfer is done already
+func (upload *gcsUpload) deleteParts() error {
+	ctx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+	defer cancel()
+	upload.lock.Lock()
+	names := append([]string{}, upload.composed...)
+	upload.composed = nil
+	upload.lock.Unlock()
+	for _, number := range upload.checksums.numbers() {
+		names = append(names, upload.partName(number))
+	}
+	upload.checksums = chunkChecksums{}
+	var lastErr error
+	for _, name := range names {
+		if err := upload.backend.delete(ctx, name); err != nil {
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return tracing.Error(lastErr)
+	}
+	return nil
+}
+
+// Abort discards the buffered content and deletes the parts uploaded so that no part is left billed in the bucket
+func (upload *gcsUpload) Abort() error {
+	upload.buffer = NewBufferWriter(0)
+	if !upload.multipart {
+		return nil
+	}
+	err := upload.deleteParts()
+	if err != nil {
+		return tracing.Error(err)
+	}
+	return nil
+}
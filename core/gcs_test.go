@@ -0,0 +1,160 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"osssync/core/gcsfake"
+	"strings"
+	"testing"
+)
+
+// fakeGCS returns a server of the buckets and the config of its endpoint
+func fakeGCS(t *testing.T, buckets ...string) (*gcsfake.Server, GCSConfig) {
+	server := gcsfake.NewServer(buckets...)
+	t.Cleanup(server.Close)
+	SetTestRetryPolicy(t)
+	return server, GCSConfig{Endpoint: server.Endpoint(), WithoutAuthentication: true}
+}
+
+// expectNoParts fails if a part of a multipart upload is left in the bucket
+func expectNoParts(t *testing.T, server *gcsfake.Server, bucket string) {
+	for _, name := range server.Names(bucket) {
+		if strings.Contains(name, gcsPartsDir+"/") {
+			t.Fatalf("the part %s is left", name)
+		}
+	}
+}
+
+func TestGCSBackendList(t *testing.T) {
+	defer func(size int) { listPageSize = size }(listPageSize)
+	listPageSize = 2
+	server, config := fakeGCS(t, "backups")
+	for _, name := range []string{"2022/a.jpg", "2022/b/c.jpg", "2022/d.jpg", "2022/" + gcsPartsDir + "/x/000001", "2022-raw/e.jpg", "2023/f.jpg"} {
+		server.PutObject("backups", name, []byte(name), "")
+	}
+	backend, err := NewGCSBackend(config, "backups", "2022")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPaths(t, listAll(t, backend, ""), []string{"a.jpg", "b/c.jpg", "d.jpg"})
+	expectPaths(t, listAll(t, backend, "b"), []string{"b/c.jpg"})
+	expectPaths(t, listAll(t, backend, "missing"), nil)
+	if server.Requests("ListObjects") != 4 {
+		t.Fatalf("expect the listing to be paged by the tokens, got %d requests", server.Requests("ListObjects"))
+	}
+
+	backend, _ = NewGCSBackend(config, "missing", "")
+	if _, err := backend.List(context.Background(), "", ""); err == nil {
+		t.Fatal("expect a missing bucket to fail the listing")
+	}
+}
+
+func TestGCSComposedUpload(t *testing.T) {
+	server, config := fakeGCS(t, "backups")
+	ctx := context.Background()
+	backend, err := NewGCSBackend(config, "backups", "videos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := backend.Create(ctx, "a.mp4", CreateOptions{StorageClass: StorageClass_IA, Multipart: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// more parts than a compose accepts, written backwards
+	const chunks = 70
+	content := bytes.Repeat([]byte("0123456789"), chunks)
+	server.FailNext("InsertObject", 1, http.StatusServiceUnavailable)
+	for number := int64(chunks); number >= 1; number-- {
+		offset := (number - 1) * 10
+		_, err := upload.WriteChunk(ctx, content[offset:offset+10], &FileChunkInfo{Number: number, ChunkSize: 10, Offset: offset})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := upload.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	object, ok := server.Object("backups", "videos/a.mp4")
+	if !ok || !bytes.Equal(object.Data, content) {
+		t.Fatalf("unexpected content of %d bytes", len(object.Data))
+	}
+	if object.StorageClass != "NEARLINE" {
+		t.Fatalf("unexpected storage class %s", object.StorageClass)
+	}
+	// 3 rounds of 32 parts at most then the final compose
+	if server.Requests("ComposeObject") != 4 {
+		t.Fatalf("unexpected %d composes", server.Requests("ComposeObject"))
+	}
+	expectNoParts(t, server, "backups")
+	stat, err := backend.Stat(ctx, "a.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.CRC64 != crc64Of(content) || stat.StorageClass != StorageClass_IA || stat.Size != int64(len(content)) {
+		t.Fatalf("unexpected stat %+v", stat)
+	}
+}
+
+func TestGCSResumableUpload(t *testing.T) {
+	defer func(size int) { gcsUploadChunkSize = size }(gcsUploadChunkSize)
+	gcsUploadChunkSize = 256 * 1024
+	server, config := fakeGCS(t, "backups")
+	ctx := context.Background()
+	backend, err := NewGCSBackend(config, "backups", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := backend.Create(ctx, "large.bin", CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := bytes.Repeat([]byte("abcdefghij"), 60*1024)
+	if _, err := upload.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := upload.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	object, ok := server.Object("backups", "large.bin")
+	if !ok || !bytes.Equal(object.Data, content) {
+		t.Fatalf("unexpected content of %d bytes", len(object.Data))
+	}
+	if server.Requests("UploadChunk") != 3 || server.Uploads() != 0 {
+		t.Fatalf("expect the content to be uploaded by 3 chunks, got %d", server.Requests("UploadChunk"))
+	}
+	stat, err := backend.Stat(ctx, "large.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.CRC64 != crc64Of(content) || stat.Properties[PropertyName_ContentMD5] == "" {
+		t.Fatalf("unexpected stat %+v", stat)
+	}
+}
+
+func TestGCSAbortUpload(t *testing.T) {
+	server, config := fakeGCS(t, "backups")
+	ctx := context.Background()
+	backend, err := NewGCSBackend(config, "backups", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := backend.Create(ctx, "a.mp4", CreateOptions{Multipart: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for number := int64(1); number <= 3; number++ {
+		if _, err := upload.WriteChunk(ctx, []byte("chunk"), &FileChunkInfo{Number: number, ChunkSize: 5}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(server.Names("backups")) != 3 {
+		t.Fatalf("expect the parts to be uploaded, got %v", server.Names("backups"))
+	}
+	if err := upload.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if names := server.Names("backups"); len(names) != 0 {
+		t.Fatalf("expect the parts to be deleted, got %v", names)
+	}
+}
@@ -0,0 +1,484 @@
+// Package gcsfake is an in-process fake of the subset of the Google Cloud Storage protocol used by osssync, for tests running offline:
+// the JSON API to get the metadata of objects, list, delete and compose them, multipart and resumable uploads,
+// and the XML API reading their content.
+//
+// The JSON API is served under /storage/v1/ and /upload/storage/v1/, the content of an object under /bucket/object,
+// like fake-gcs-server. Requests are not authorized.
+package gcsfake
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Object is an object stored by the fake
+type Object struct {
+	Data         []byte
+	Metadata     map[string]string
+	ContentType  string
+	StorageClass string
+	Generation   int64
+	ModTime      time.Time
+	// Composed is true for an object composed of others, which has no MD5 like on the service
+	Composed bool
+}
+
+// resource is the JSON resource of an object
+type resource struct {
+	Kind         string            `json:"kind"`
+	Name         string            `json:"name"`
+	Bucket       string            `json:"bucket"`
+	Generation   string            `json:"generation"`
+	ContentType  string            `json:"contentType,omitempty"`
+	StorageClass string            `json:"storageClass,omitempty"`
+	Size         string            `json:"size"`
+	Md5Hash      string            `json:"md5Hash,omitempty"`
+	Crc32c       string            `json:"crc32c"`
+	Etag         string            `json:"etag"`
+	Updated      string            `json:"updated"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+func (object *Object) resource(bucket string, name string) resource {
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.Checksum(object.Data, crc32.MakeTable(crc32.Castagnoli)))
+	r := resource{
+		Kind:         "storage#object",
+		Name:         name,
+		Bucket:       bucket,
+		Generation:   strconv.FormatInt(object.Generation, 10),
+		ContentType:  object.ContentType,
+		StorageClass: object.StorageClass,
+		Size:         strconv.Itoa(len(object.Data)),
+		Crc32c:       base64.StdEncoding.EncodeToString(crc),
+		Etag:         "CI" + strconv.FormatInt(object.Generation, 10),
+		Updated:      object.ModTime.UTC().Format(time.RFC3339Nano),
+		Metadata:     object.Metadata,
+	}
+	if !object.Composed {
+		sum := md5.Sum(object.Data)
+		r.Md5Hash = base64.StdEncoding.EncodeToString(sum[:])
+	}
+	return r
+}
+
+type upload struct {
+	bucket string
+	attrs  resource
+	data   []byte
+}
+
+type failure struct {
+	status int
+	times  int
+}
+
+// Server is a fake GCS endpoint, its buckets exist once they are created by NewServer or CreateBucket
+type Server struct {
+	*httptest.Server
+
+	lock       sync.Mutex
+	buckets    map[string]map[string]*Object
+	uploads    map[string]*upload
+	generation int64
+	requests   map[string]int
+	failures   map[string]*failure
+}
+
+// NewServer starts a fake with the buckets of names, Close stops it
+func NewServer(buckets ...string) *Server {
+	server := &Server{
+		buckets:  make(map[string]map[string]*Object),
+		uploads:  make(map[string]*upload),
+		requests: make(map[string]int),
+		failures: make(map[string]*failure),
+	}
+	for _, bucket := range buckets {
+		server.CreateBucket(bucket)
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serve))
+	return server
+}
+
+// Endpoint is the endpoint of the JSON API for the sdk
+func (server *Server) Endpoint() string {
+	return server.URL + "/storage/v1/"
+}
+
+func (server *Server) CreateBucket(name string) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	if _, ok := server.buckets[name]; !ok {
+		server.buckets[name] = make(map[string]*Object)
+	}
+}
+
+// PutObject stores an object directly, storageClass is STANDARD if empty
+func (server *Server) PutObject(bucket string, name string, data []byte, storageClass string) {
+	server.CreateBucket(bucket)
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.store(bucket, name, &Object{Data: append([]byte{}, data...), StorageClass: storageClass})
+}
+
+// Object returns a copy of an object
+func (server *Server) Object(bucket string, name string) (Object, bool) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	object, ok := server.buckets[bucket][name]
+	if !ok {
+		return Object{}, false
+	}
+	copied := *object
+	copied.Data = append([]byte{}, object.Data...)
+	copied.Metadata = make(map[string]string)
+	for k, v := range object.Metadata {
+		copied.Metadata[k] = v
+	}
+	return copied, true
+}
+
+// Names returns the sorted names of the objects of a bucket
+func (server *Server) Names(bucket string) []string {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return sortedNames(server.buckets[bucket])
+}
+
+// Uploads returns the count of the resumable uploads which are not complete
+func (server *Server) Uploads() int {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return len(server.uploads)
+}
+
+// Requests returns the count of the requests of an operation, e.g. "InsertObject" or "ComposeObject"
+func (server *Server) Requests(operation string) int {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return server.requests[operation]
+}
+
+// FailNext fails the next times requests of an operation with an error of status
+func (server *Server) FailNext(operation string, times int, status int) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.failures[operation] = &failure{status: status, times: times}
+}
+
+func sortedNames(objects map[string]*Object) []string {
+	names := make([]string, 0, len(objects))
+	for name := range objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// store stores an object of a new generation, the default class of the buckets is STANDARD
+func (server *Server) store(bucket string, name string, object *Object) {
+	server.generation++
+	object.Generation = server.generation
+	object.ModTime = time.Now()
+	if object.StorageClass == "" {
+		object.StorageClass = "STANDARD"
+	}
+	if object.ContentType == "" {
+		object.ContentType = "application/octet-stream"
+	}
+	server.buckets[bucket][name] = object
+}
+
+// route returns the operation of a request, its bucket and the name of its object, the names of the JSON API are escaped
+func route(r *http.Request) (op string, bucket string, name string) {
+	path := r.URL.EscapedPath()
+	unescape := func(s string) string {
+		unescaped, err := url.PathUnescape(s)
+		if err != nil {
+			return s
+		}
+		return unescaped
+	}
+	switch {
+	case strings.HasPrefix(path, "/upload/storage/v1/b/"):
+		bucket, _, _ = strings.Cut(strings.TrimPrefix(path, "/upload/storage/v1/b/"), "/")
+		if r.URL.Query().Get("upload_id") != "" {
+			return "UploadChunk", unescape(bucket), ""
+		}
+		return "InsertObject", unescape(bucket), ""
+	case strings.HasPrefix(path, "/storage/v1/b/"):
+		bucket, rest, _ := strings.Cut(strings.TrimPrefix(path, "/storage/v1/b/"), "/")
+		object := strings.TrimPrefix(rest, "o")
+		if object == "" {
+			return "ListObjects", unescape(bucket), ""
+		}
+		object = strings.TrimPrefix(object, "/")
+		if strings.HasSuffix(object, "/compose") && r.Method == http.MethodPost {
+			return "ComposeObject", unescape(bucket), unescape(strings.TrimSuffix(object, "/compose"))
+		}
+		if r.Method == http.MethodDelete {
+			return "DeleteObject", unescape(bucket), unescape(object)
+		}
+		return "GetObject", unescape(bucket), unescape(object)
+	}
+	bucket, object, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	return "GetObjectMedia", bucket, object
+}
+
+func (server *Server) serve(w http.ResponseWriter, r *http.Request) {
+	op, bucketName, name := route(r)
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.requests[op]++
+	if f, ok := server.failures[op]; ok && f.times > 0 {
+		f.times--
+		writeError(w, f.status, "injected failure")
+		return
+	}
+	bucket, ok := server.buckets[bucketName]
+	if !ok {
+		writeError(w, http.StatusNotFound, "The specified bucket does not exist.")
+		return
+	}
+
+	switch op {
+	case "ListObjects":
+		server.list(w, r, bucketName, bucket)
+	case "GetObject":
+		object, ok := bucket[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, "No such object: "+bucketName+"/"+name)
+			return
+		}
+		writeJSON(w, object.resource(bucketName, name))
+	case "GetObjectMedia":
+		server.media(w, r, bucket, name)
+	case "DeleteObject":
+		if _, ok := bucket[name]; !ok {
+			writeError(w, http.StatusNotFound, "No such object: "+bucketName+"/"+name)
+			return
+		}
+		delete(bucket, name)
+		w.WriteHeader(http.StatusNoContent)
+	case "ComposeObject":
+		server.compose(w, r, bucketName, bucket, name)
+	case "InsertObject":
+		server.insert(w, r, bucketName)
+	case "UploadChunk":
+		server.uploadChunk(w, r)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"code": status, "message": message, "errors": []map[string]string{{"message": message}}},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+type listResult struct {
+	Kind          string     `json:"kind"`
+	Items         []resource `json:"items"`
+	NextPageToken string     `json:"nextPageToken,omitempty"`
+}
+
+// list returns the objects of the prefix from the page token, which is the first name of the page
+func (server *Server) list(w http.ResponseWriter, r *http.Request, bucketName string, bucket map[string]*Object) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	token := query.Get("pageToken")
+	maxResults := 1000
+	if v, err := strconv.Atoi(query.Get("maxResults")); err == nil && v > 0 {
+		maxResults = v
+	}
+	result := listResult{Kind: "storage#objects", Items: make([]resource, 0)}
+	for _, name := range sortedNames(bucket) {
+		if !strings.HasPrefix(name, prefix) || name < token {
+			continue
+		}
+		if len(result.Items) == maxResults {
+			result.NextPageToken = name
+			break
+		}
+		result.Items = append(result.Items, bucket[name].resource(bucketName, name))
+	}
+	writeJSON(w, result)
+}
+
+func (server *Server) media(w http.ResponseWriter, r *http.Request, bucket map[string]*Object, name string) {
+	object, ok := bucket[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "NoSuchKey")
+		return
+	}
+	w.Header().Set("Content-Type", object.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(object.Data)))
+	w.Header().Set("Last-Modified", object.ModTime.UTC().Format(http.TimeFormat))
+	w.Header().Set("X-Goog-Generation", strconv.FormatInt(object.Generation, 10))
+	w.Header().Set("X-Goog-Stored-Content-Length", strconv.Itoa(len(object.Data)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(object.Data)
+	}
+}
+
+type composeRequest struct {
+	Destination   resource `json:"destination"`
+	SourceObjects []struct {
+		Name string `json:"name"`
+	} `json:"sourceObjects"`
+}
+
+// compose stores the concatenation of the sources in their order, at most 32 like the service
+func (server *Server) compose(w http.ResponseWriter, r *http.Request, bucketName string, bucket map[string]*Object, name string) {
+	var request composeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(request.SourceObjects) == 0 || len(request.SourceObjects) > 32 {
+		writeError(w, http.StatusBadRequest, "The number of source components provided exceeds the maximum (32).")
+		return
+	}
+	var data []byte
+	for _, source := range request.SourceObjects {
+		object, ok := bucket[source.Name]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Object "+source.Name+" not found.")
+			return
+		}
+		data = append(data, object.Data...)
+	}
+	object := &Object{
+		Data:         data,
+		Metadata:     request.Destination.Metadata,
+		ContentType:  request.Destination.ContentType,
+		StorageClass: request.Destination.StorageClass,
+		Composed:     true,
+	}
+	server.store(bucketName, name, object)
+	writeJSON(w, object.resource(bucketName, name))
+}
+
+// insert stores the object of a multipart upload, or starts a resumable upload whose chunks are sent to the url of the Location header
+func (server *Server) insert(w http.ResponseWriter, r *http.Request, bucketName string) {
+	query := r.URL.Query()
+	switch query.Get("uploadType") {
+	case "multipart":
+		mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+			writeError(w, http.StatusBadRequest, "a multipart upload is multipart/related")
+			return
+		}
+		reader := multipart.NewReader(r.Body, params["boundary"])
+		var attrs resource
+		part, err := reader.NextPart()
+		if err == nil {
+			err = json.NewDecoder(part).Decode(&attrs)
+		}
+		var data []byte
+		if err == nil {
+			part, err = reader.NextPart()
+			if err == nil {
+				attrs.ContentType = part.Header.Get("Content-Type")
+				data, err = io.ReadAll(part)
+			}
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		server.finish(w, &upload{bucket: bucketName, attrs: attrs, data: data}, query.Get("name"))
+	case "resumable":
+		var attrs resource
+		if err := json.NewDecoder(r.Body).Decode(&attrs); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if attrs.Name == "" {
+			attrs.Name = query.Get("name")
+		}
+		attrs.ContentType = r.Header.Get("X-Upload-Content-Type")
+		server.generation++
+		id := strconv.FormatInt(server.generation, 10)
+		server.uploads[id] = &upload{bucket: bucketName, attrs: attrs}
+		w.Header().Set("Location", server.URL+"/upload/storage/v1/b/"+url.PathEscape(bucketName)+"/o?uploadType=resumable&upload_id="+id)
+		w.WriteHeader(http.StatusOK)
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported upload type "+query.Get("uploadType"))
+	}
+}
+
+// uploadChunk appends a chunk at the offset of its Content-Range to a resumable upload, the upload is complete once its size is known
+func (server *Server) uploadChunk(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("upload_id")
+	u, ok := server.uploads[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such upload")
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// bytes first-last/total, bytes first-last/* or bytes */total
+	contentRange := strings.TrimPrefix(r.Header.Get("Content-Range"), "bytes ")
+	span, total, _ := strings.Cut(contentRange, "/")
+	if span != "*" {
+		var first, last int
+		if _, err := fmt.Sscanf(span, "%d-%d", &first, &last); err != nil || first != len(u.data) || last-first+1 != len(data) {
+			writeError(w, http.StatusBadRequest, "invalid Content-Range "+contentRange)
+			return
+		}
+		u.data = append(u.data, data...)
+	}
+	if total == "*" {
+		w.Header().Set("X-Http-Status-Code-Override", "308")
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(u.data)-1))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if size, err := strconv.Atoi(total); err != nil || size != len(u.data) {
+		writeError(w, http.StatusBadRequest, "the size of the upload does not match "+contentRange)
+		return
+	}
+	delete(server.uploads, id)
+	server.finish(w, u, "")
+}
+
+func (server *Server) finish(w http.ResponseWriter, u *upload, name string) {
+	if u.attrs.Name != "" {
+		name = u.attrs.Name
+	}
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "the name of the object is required")
+		return
+	}
+	object := &Object{Data: u.data, Metadata: u.attrs.Metadata, ContentType: u.attrs.ContentType, StorageClass: u.attrs.StorageClass}
+	server.store(u.bucket, name, object)
+	writeJSON(w, object.resource(u.bucket, name))
+}
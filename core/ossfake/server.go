@@ -0,0 +1,706 @@
+// Package ossfake is an in-process fake of the subset of the OSS protocol used by osssync, for tests running offline:
+// put, get, head and delete of objects, ListObjectsV2, multipart uploads and their listings, server-side copies, archived objects
+// and their restore, with the CRC64 headers the sdk checks.
+//
+// Requests are addressed by path, http://127.0.0.1:port/bucket/object, which is what the sdk does for an ip endpoint.
+// Signatures are not checked.
+package ossfake
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Object is an object stored by the fake
+type Object struct {
+	Data         []byte
+	Header       http.Header
+	StorageClass string
+	ModTime      time.Time
+	// restoreAt is when the requested restore of an archived object completes, zero if none is requested
+	restoreAt time.Time
+}
+
+func (object *Object) crc64() string {
+	return strconv.FormatUint(crc64.Checksum(object.Data, crc64.MakeTable(crc64.ECMA)), 10)
+}
+
+func (object *Object) etag() string {
+	sum := md5.Sum(object.Data)
+	return `"` + strings.ToUpper(hex.EncodeToString(sum[:])) + `"`
+}
+
+func (object *Object) archived() bool {
+	return object.StorageClass == "Archive" || object.StorageClass == "ColdArchive"
+}
+
+// readable returns true if the object is not archived or its restore is complete
+func (object *Object) readable(now time.Time) bool {
+	return !object.archived() || (!object.restoreAt.IsZero() && !now.Before(object.restoreAt))
+}
+
+type upload struct {
+	bucket    string
+	key       string
+	header    http.Header
+	parts     map[int][]byte
+	initiated time.Time
+}
+
+type failure struct {
+	status int
+	code   string
+	times  int
+}
+
+// Server is a fake OSS endpoint, its buckets exist once they are created by NewServer or CreateBucket
+type Server struct {
+	*httptest.Server
+
+	// RestoreDelay is how long the restore of an archived object takes
+	RestoreDelay time.Duration
+	// ListPageSize is the max count of objects of a page of ListObjectsV2 when max-keys is not set
+	ListPageSize int
+
+	lock     sync.Mutex
+	buckets  map[string]map[string]*Object
+	uploads  map[string]*upload
+	nextId   int
+	requests map[string]int
+	failures map[string]*failure
+}
+
+// NewServer starts a fake with the buckets of names, Close stops it
+func NewServer(buckets ...string) *Server {
+	server := &Server{
+		ListPageSize: 100,
+		buckets:      make(map[string]map[string]*Object),
+		uploads:      make(map[string]*upload),
+		requests:     make(map[string]int),
+		failures:     make(map[string]*failure),
+	}
+	for _, bucket := range buckets {
+		server.CreateBucket(bucket)
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serve))
+	return server
+}
+
+// Endpoint is the endpoint of the fake for the sdk
+func (server *Server) Endpoint() string {
+	return server.URL
+}
+
+func (server *Server) CreateBucket(name string) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	if _, ok := server.buckets[name]; !ok {
+		server.buckets[name] = make(map[string]*Object)
+	}
+}
+
+// PutObject stores an object directly, storageClass is Standard if empty
+func (server *Server) PutObject(bucket string, key string, data []byte, storageClass string) {
+	server.CreateBucket(bucket)
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	if storageClass == "" {
+		storageClass = "Standard"
+	}
+	server.buckets[bucket][key] = &Object{
+		Data:         append([]byte{}, data...),
+		Header:       http.Header{},
+		StorageClass: storageClass,
+		ModTime:      time.Now(),
+	}
+}
+
+// SetHeader sets a header stored with an existing object, e.g. X-Oss-Meta-Owner for its user metadata
+func (server *Server) SetHeader(bucket string, key string, k string, v string) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.buckets[bucket][key].Header.Set(k, v)
+}
+
+// Object returns a copy of an object
+func (server *Server) Object(bucket string, key string) (Object, bool) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	object, ok := server.buckets[bucket][key]
+	if !ok {
+		return Object{}, false
+	}
+	copied := *object
+	copied.Data = append([]byte{}, object.Data...)
+	copied.Header = object.Header.Clone()
+	return copied, true
+}
+
+// Keys returns the sorted keys of the objects of a bucket
+func (server *Server) Keys(bucket string) []string {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return sortedKeys(server.buckets[bucket])
+}
+
+// Uploads returns the count of the multipart uploads neither completed nor aborted
+func (server *Server) Uploads() int {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return len(server.uploads)
+}
+
+// Requests returns the count of the requests of an operation, e.g. "PutObject" or "UploadPartCopy"
+func (server *Server) Requests(operation string) int {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return server.requests[operation]
+}
+
+// FailNext fails the next times requests of an operation with an error of status and code
+func (server *Server) FailNext(operation string, times int, status int, code string) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.failures[operation] = &failure{status: status, code: code, times: times}
+}
+
+func sortedKeys(objects map[string]*Object) []string {
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// operation names a request like the methods of the sdk
+func operation(r *http.Request, key string) string {
+	query := r.URL.Query()
+	has := func(k string) bool {
+		_, ok := query[k]
+		return ok
+	}
+	copySource := r.Header.Get("X-Oss-Copy-Source") != ""
+	switch r.Method {
+	case http.MethodGet:
+		if key == "" && has("uploads") {
+			return "ListMultipartUploads"
+		}
+		if key == "" {
+			return "ListObjectsV2"
+		}
+		if has("uploadId") {
+			return "ListParts"
+		}
+		return "GetObject"
+	case http.MethodHead:
+		if has("objectMeta") {
+			return "GetObjectMeta"
+		}
+		return "GetObjectDetailedMeta"
+	case http.MethodPut:
+		if has("uploadId") && copySource {
+			return "UploadPartCopy"
+		}
+		if has("uploadId") {
+			return "UploadPart"
+		}
+		if copySource {
+			return "CopyObject"
+		}
+		return "PutObject"
+	case http.MethodPost:
+		if has("uploads") {
+			return "InitiateMultipartUpload"
+		}
+		if has("uploadId") {
+			return "CompleteMultipartUpload"
+		}
+		if has("restore") {
+			return "RestoreObject"
+		}
+	case http.MethodDelete:
+		if has("uploadId") {
+			return "AbortMultipartUpload"
+		}
+		return "DeleteObject"
+	}
+	return ""
+}
+
+func (server *Server) serve(w http.ResponseWriter, r *http.Request) {
+	bucketName, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	op := operation(r, key)
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.requests[op]++
+	if f, ok := server.failures[op]; ok && f.times > 0 {
+		f.times--
+		writeError(w, r, f.status, f.code, "injected failure")
+		return
+	}
+	bucket, ok := server.buckets[bucketName]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.")
+		return
+	}
+
+	switch op {
+	case "ListObjectsV2":
+		server.list(w, r, bucketName, bucket)
+	case "ListMultipartUploads":
+		server.listUploads(w, r, bucketName)
+	case "ListParts":
+		server.listParts(w, r)
+	case "GetObject":
+		server.get(w, r, bucket, key)
+	case "GetObjectMeta", "GetObjectDetailedMeta":
+		server.head(w, r, bucket, key)
+	case "PutObject":
+		server.put(w, r, bucket, key)
+	case "CopyObject":
+		server.copy(w, r, bucket, key)
+	case "DeleteObject":
+		delete(bucket, key)
+		w.WriteHeader(http.StatusNoContent)
+	case "InitiateMultipartUpload":
+		server.initiate(w, r, bucketName, key)
+	case "UploadPart", "UploadPartCopy":
+		server.uploadPart(w, r, op)
+	case "CompleteMultipartUpload":
+		server.complete(w, r, bucket)
+	case "AbortMultipartUpload":
+		delete(server.uploads, r.URL.Query().Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case "RestoreObject":
+		server.restore(w, r, bucket, key)
+	default:
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", fmt.Sprintf("%s %s is not supported by the fake", r.Method, r.URL))
+	}
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, message string) {
+	w.Header().Set("X-Oss-Request-Id", "fake")
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message><RequestId>fake</RequestId><HostId>fake</HostId></Error>`,
+		code, xmlEscape(message))
+}
+
+func xmlEscape(s string) string {
+	var buffer bytes.Buffer
+	xml.EscapeText(&buffer, []byte(s))
+	return buffer.String()
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("X-Oss-Request-Id", "fake")
+	body, _ := xml.Marshal(v)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+func writeObjectHeader(w http.ResponseWriter, object *Object) {
+	header := w.Header()
+	for k, v := range object.Header {
+		header[k] = v
+	}
+	header.Set("Content-Length", strconv.Itoa(len(object.Data)))
+	header.Set("ETag", object.etag())
+	header.Set("Last-Modified", object.ModTime.UTC().Format(http.TimeFormat))
+	header.Set("X-Oss-Hash-Crc64ecma", object.crc64())
+	header.Set("X-Oss-Storage-Class", object.StorageClass)
+	header.Set("X-Oss-Object-Type", "Normal")
+	header.Set("X-Oss-Request-Id", "fake")
+	if !object.restoreAt.IsZero() {
+		if time.Now().Before(object.restoreAt) {
+			header.Set("X-Oss-Restore", `ongoing-request="true"`)
+		} else {
+			expiry := object.restoreAt.Add(24 * time.Hour).UTC().Format(http.TimeFormat)
+			header.Set("X-Oss-Restore", fmt.Sprintf(`ongoing-request="false", expiry-date="%s"`, expiry))
+		}
+	}
+}
+
+// userHeader keeps the headers of a request stored with an object
+func userHeader(r *http.Request) http.Header {
+	header := http.Header{}
+	for k, v := range r.Header {
+		lower := strings.ToLower(k)
+		if strings.HasPrefix(lower, "x-oss-meta-") || lower == "content-type" || lower == "content-encoding" ||
+			lower == "content-disposition" || lower == "cache-control" || lower == "expires" || lower == "content-language" {
+			header[k] = v
+		}
+	}
+	return header
+}
+
+func storageClassOf(r *http.Request) string {
+	if class := r.Header.Get("X-Oss-Storage-Class"); class != "" {
+		return class
+	}
+	return "Standard"
+}
+
+type contents struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Type         string `xml:"Type"`
+	Size         int    `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name   `xml:"ListBucketResult"`
+	Name                  string     `xml:"Name"`
+	Prefix                string     `xml:"Prefix"`
+	MaxKeys               int        `xml:"MaxKeys"`
+	IsTruncated           bool       `xml:"IsTruncated"`
+	NextContinuationToken string     `xml:"NextContinuationToken,omitempty"`
+	KeyCount              int        `xml:"KeyCount"`
+	Contents              []contents `xml:"Contents"`
+}
+
+// list returns the objects of the prefix from the continuation token, which is the first key of the page
+func (server *Server) list(w http.ResponseWriter, r *http.Request, name string, bucket map[string]*Object) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	token := query.Get("continuation-token")
+	maxKeys := server.ListPageSize
+	if v, err := strconv.Atoi(query.Get("max-keys")); err == nil && v > 0 {
+		maxKeys = v
+	}
+	result := listBucketResult{Name: name, Prefix: prefix, MaxKeys: maxKeys}
+	for _, key := range sortedKeys(bucket) {
+		if !strings.HasPrefix(key, prefix) || key < token {
+			continue
+		}
+		if len(result.Contents) == maxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = key
+			break
+		}
+		object := bucket[key]
+		result.Contents = append(result.Contents, contents{
+			Key:          key,
+			LastModified: object.ModTime.UTC().Format("2006-01-02T15:04:05.000Z"),
+			ETag:         object.etag(),
+			Type:         "Normal",
+			Size:         len(object.Data),
+			StorageClass: object.StorageClass,
+		})
+	}
+	result.KeyCount = len(result.Contents)
+	writeXML(w, result)
+}
+
+func (server *Server) get(w http.ResponseWriter, r *http.Request, bucket map[string]*Object, key string) {
+	object, ok := bucket[key]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	if !object.readable(time.Now()) {
+		writeError(w, r, http.StatusForbidden, "InvalidObjectState", "The operation is not valid for the object's state")
+		return
+	}
+	writeObjectHeader(w, object)
+	w.WriteHeader(http.StatusOK)
+	w.Write(object.Data)
+}
+
+func (server *Server) head(w http.ResponseWriter, r *http.Request, bucket map[string]*Object, key string) {
+	object, ok := bucket[key]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "")
+		return
+	}
+	writeObjectHeader(w, object)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (server *Server) put(w http.ResponseWriter, r *http.Request, bucket map[string]*Object, key string) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	object := &Object{Data: data, Header: userHeader(r), StorageClass: storageClassOf(r), ModTime: time.Now()}
+	bucket[key] = object
+	w.Header().Set("ETag", object.etag())
+	w.Header().Set("X-Oss-Hash-Crc64ecma", object.crc64())
+	w.Header().Set("X-Oss-Request-Id", "fake")
+	w.WriteHeader(http.StatusOK)
+}
+
+// source returns the object of the x-oss-copy-source header, /bucket/key with the key escaped
+func (server *Server) source(w http.ResponseWriter, r *http.Request) (*Object, bool) {
+	sourceBucket, sourceKey, _ := strings.Cut(strings.TrimPrefix(r.Header.Get("X-Oss-Copy-Source"), "/"), "/")
+	sourceKey, err := url.QueryUnescape(sourceKey)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", "invalid copy source")
+		return nil, false
+	}
+	object, ok := server.buckets[sourceBucket][sourceKey]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified source key does not exist.")
+		return nil, false
+	}
+	if !object.readable(time.Now()) {
+		writeError(w, r, http.StatusForbidden, "InvalidObjectState", "The source object is archived")
+		return nil, false
+	}
+	return object, true
+}
+
+type copyResult struct {
+	XMLName      xml.Name
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+}
+
+func (server *Server) copy(w http.ResponseWriter, r *http.Request, bucket map[string]*Object, key string) {
+	source, ok := server.source(w, r)
+	if !ok {
+		return
+	}
+	header := source.Header.Clone()
+	if strings.EqualFold(r.Header.Get("X-Oss-Metadata-Directive"), "REPLACE") {
+		header = userHeader(r)
+	}
+	object := &Object{Data: append([]byte{}, source.Data...), Header: header, StorageClass: storageClassOf(r), ModTime: time.Now()}
+	bucket[key] = object
+	writeXML(w, copyResult{
+		XMLName:      xml.Name{Local: "CopyObjectResult"},
+		LastModified: object.ModTime.UTC().Format(time.RFC3339),
+		ETag:         object.etag(),
+	})
+}
+
+type initiateResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadId string   `xml:"UploadId"`
+}
+
+func (server *Server) initiate(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	server.nextId++
+	id := fmt.Sprintf("upload-%d", server.nextId)
+	header := userHeader(r)
+	header.Set("X-Oss-Storage-Class", storageClassOf(r))
+	server.uploads[id] = &upload{bucket: bucket, key: key, header: header, parts: make(map[int][]byte), initiated: time.Now()}
+	writeXML(w, initiateResult{Bucket: bucket, Key: key, UploadId: id})
+}
+
+func (server *Server) uploadPart(w http.ResponseWriter, r *http.Request, op string) {
+	query := r.URL.Query()
+	u, ok := server.uploads[query.Get("uploadId")]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+	number, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || number < 1 || number > 10000 {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", "invalid part number")
+		return
+	}
+	var data []byte
+	if op == "UploadPartCopy" {
+		source, ok := server.source(w, r)
+		if !ok {
+			return
+		}
+		var start, end int
+		_, err := fmt.Sscanf(r.Header.Get("X-Oss-Copy-Source-Range"), "bytes=%d-%d", &start, &end)
+		if err != nil || start < 0 || end >= len(source.Data) || start > end {
+			writeError(w, r, http.StatusBadRequest, "InvalidArgument", "invalid copy source range")
+			return
+		}
+		data = append([]byte{}, source.Data[start:end+1]...)
+	} else {
+		data, err = io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+	}
+	u.parts[number] = data
+	part := &Object{Data: data}
+	if op == "UploadPartCopy" {
+		writeXML(w, copyResult{XMLName: xml.Name{Local: "CopyPartResult"}, LastModified: time.Now().UTC().Format(time.RFC3339), ETag: part.etag()})
+		return
+	}
+	w.Header().Set("ETag", part.etag())
+	w.Header().Set("X-Oss-Hash-Crc64ecma", part.crc64())
+	w.Header().Set("X-Oss-Request-Id", "fake")
+	w.WriteHeader(http.StatusOK)
+}
+
+type uploadsResult struct {
+	XMLName     xml.Name       `xml:"ListMultipartUploadsResult"`
+	Bucket      string         `xml:"Bucket"`
+	Prefix      string         `xml:"Prefix"`
+	IsTruncated bool           `xml:"IsTruncated"`
+	Uploads     []uploadResult `xml:"Upload"`
+}
+
+type uploadResult struct {
+	Key       string `xml:"Key"`
+	UploadId  string `xml:"UploadId"`
+	Initiated string `xml:"Initiated"`
+}
+
+// listUploads returns the multipart uploads of the objects of the prefix in a single page, by key then by initiation
+func (server *Server) listUploads(w http.ResponseWriter, r *http.Request, bucket string) {
+	prefix := r.URL.Query().Get("prefix")
+	ids := make([]string, 0, len(server.uploads))
+	for id, u := range server.uploads {
+		if u.bucket == bucket && strings.HasPrefix(u.key, prefix) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := server.uploads[ids[i]], server.uploads[ids[j]]
+		if a.key != b.key {
+			return a.key < b.key
+		}
+		return a.initiated.Before(b.initiated)
+	})
+	result := uploadsResult{Bucket: bucket, Prefix: prefix}
+	for _, id := range ids {
+		u := server.uploads[id]
+		result.Uploads = append(result.Uploads, uploadResult{Key: u.key, UploadId: id, Initiated: u.initiated.UTC().Format("2006-01-02T15:04:05.000Z")})
+	}
+	writeXML(w, result)
+}
+
+type partsResult struct {
+	XMLName     xml.Name     `xml:"ListPartsResult"`
+	Bucket      string       `xml:"Bucket"`
+	Key         string       `xml:"Key"`
+	UploadId    string       `xml:"UploadId"`
+	IsTruncated bool         `xml:"IsTruncated"`
+	Parts       []partResult `xml:"Part"`
+}
+
+type partResult struct {
+	PartNumber   int    `xml:"PartNumber"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+}
+
+// listParts returns the uploaded parts of a multipart upload in a single page
+func (server *Server) listParts(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("uploadId")
+	u, ok := server.uploads[id]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+	numbers := make([]int, 0, len(u.parts))
+	for number := range u.parts {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	result := partsResult{Bucket: u.bucket, Key: u.key, UploadId: id}
+	for _, number := range numbers {
+		part := &Object{Data: u.parts[number]}
+		result.Parts = append(result.Parts, partResult{
+			PartNumber:   number,
+			LastModified: u.initiated.UTC().Format("2006-01-02T15:04:05.000Z"),
+			ETag:         part.etag(),
+			Size:         len(part.Data),
+		})
+	}
+	writeXML(w, result)
+}
+
+type completeRequest struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeResult struct {
+	XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+	Bucket  string   `xml:"Bucket"`
+	Key     string   `xml:"Key"`
+	ETag    string   `xml:"ETag"`
+}
+
+// complete joins the parts listed by the request in order, every listed part must match an uploaded one
+func (server *Server) complete(w http.ResponseWriter, r *http.Request, bucket map[string]*Object) {
+	id := r.URL.Query().Get("uploadId")
+	u, ok := server.uploads[id]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+	var request completeRequest
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Parts) == 0 {
+		writeError(w, r, http.StatusBadRequest, "MalformedXML", "invalid part list")
+		return
+	}
+	var data []byte
+	last := 0
+	for _, part := range request.Parts {
+		content, ok := u.parts[part.PartNumber]
+		if !ok || part.PartNumber <= last || part.ETag != (&Object{Data: content}).etag() {
+			writeError(w, r, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d is not uploaded", part.PartNumber))
+			return
+		}
+		last = part.PartNumber
+		data = append(data, content...)
+	}
+	header := u.header.Clone()
+	class := header.Get("X-Oss-Storage-Class")
+	header.Del("X-Oss-Storage-Class")
+	object := &Object{Data: data, Header: header, StorageClass: class, ModTime: time.Now()}
+	bucket[u.key] = object
+	delete(server.uploads, id)
+	w.Header().Set("X-Oss-Hash-Crc64ecma", object.crc64())
+	writeXML(w, completeResult{Bucket: u.bucket, Key: u.key, ETag: object.etag()})
+}
+
+func (server *Server) restore(w http.ResponseWriter, r *http.Request, bucket map[string]*Object, key string) {
+	object, ok := bucket[key]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	if !object.archived() {
+		writeError(w, r, http.StatusBadRequest, "OperationNotSupported", "The object is not archived")
+		return
+	}
+	now := time.Now()
+	if !object.restoreAt.IsZero() && now.Before(object.restoreAt) {
+		writeError(w, r, http.StatusConflict, "RestoreAlreadyInProgress", "The restore of the object is in progress")
+		return
+	}
+	object.restoreAt = now.Add(server.RestoreDelay)
+	w.Header().Set("X-Oss-Request-Id", "fake")
+	w.WriteHeader(http.StatusAccepted)
+}
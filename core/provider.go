@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"os/user"
 	"osssync/common/config"
@@ -9,16 +10,35 @@ import (
 	"strings"
 )
 
-func GetFile(dirPath string, relativePath string) (fileInfo FileInfo, err error) {
+func GetFile(ctx context.Context, dirPath string, relativePath string) (fileInfo FileInfo, err error) {
+	return OpenFile(ctx, dirPath, relativePath, config.GetStringOrDefault(Arg_CredentialsFile, ""))
+}
+
+// OpenFile opens the file of an uri, credentialFilePath is only required by the object storage services
+func OpenFile(ctx context.Context, dirPath string, relativePath string, credentialFilePath string) (FileInfo, error) {
+	backend, err := OpenBackend(ctx, dirPath, credentialFilePath)
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	fileInfo, err := NewBackendFile(ctx, backend, relativePath)
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	return fileInfo, nil
+}
+
+// OpenBackend returns the backend whose root is the uri dirPath, credentialFilePath is only required by the object storage services
+func OpenBackend(ctx context.Context, dirPath string, credentialFilePath string) (Backend, error) {
 	fileType := ResolveUriType(dirPath)
 	switch fileType {
 	case FileType_Physical:
-		fileInfo, err = OpenPhysicalFile(absFilePath(dirPath), relativePath)
+		return NewPhysicalBackend(absFilePath(dirPath)), nil
 
 	case FileType_AliOSS:
-		credentialFilePath := config.RequireString(Arg_CredentialsFile)
-		aliCfg := AliOSSCfgWrapper{}
-		err := config.BindYaml(credentialFilePath, &aliCfg)
+		if credentialFilePath == "" {
+			return nil, fmt.Errorf("credentials file is required by %s", fileType)
+		}
+		aliCfg, err := LoadAliOSSConfig(credentialFilePath)
 		if err != nil {
 			return nil, tracing.Error(err)
 		}
@@ -30,17 +50,73 @@ func GetFile(dirPath string, relativePath string) (fileInfo FileInfo, err error)
 		if err != nil {
 			return nil, tracing.Error(err)
 		}
+		backend, err := NewAliOSSBackend(ctx, aliCfg, bucketName, objectName)
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		return backend, nil
 
-		fileInfo, err = OpenAliOSS(aliCfg.Config, bucketName, objectName, relativePath)
+	case FileType_SFTP:
+		sftpCfg, err := LoadSFTPConfig(credentialFilePath)
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		backend, err := NewSFTPBackend(sftpCfg, dirPath)
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		return backend, nil
 
-	default:
-		return nil, fmt.Errorf("unknown file type: %s", fileType)
-	}
+	case FileType_WebDAV:
+		webdavCfg, err := LoadWebDAVConfig(credentialFilePath)
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		backend, err := NewWebDAVBackend(webdavCfg, dirPath)
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		return backend, nil
 
-	if err != nil {
-		return nil, tracing.Error(err)
+	case FileType_AzureBlob:
+		azureCfg, err := LoadAzureBlobConfig(credentialFilePath)
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		containerName, err := ResolveBucketName(dirPath)
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		prefix, err := ResolveRelativePath(dirPath)
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		backend, err := NewAzureBlobBackend(azureCfg, containerName, prefix)
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		return backend, nil
+
+	case FileType_GCS:
+		gcsCfg, err := LoadGCSConfig(credentialFilePath)
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		bucketName, err := ResolveBucketName(dirPath)
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		prefix, err := ResolveRelativePath(dirPath)
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		backend, err := NewGCSBackend(gcsCfg, bucketName, prefix)
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		return backend, nil
 	}
-	return fileInfo, nil
+	return nil, fmt.Errorf("unknown file type: %s", fileType)
 }
 
 func absFilePath(p string) string {
@@ -44,11 +44,12 @@ type ChunkReader struct {
 	chunkSize int64
 }
 
+// ReadNext reads the next chunk, shorter than the chunk size only at the end of the content
 func (r *ChunkReader) ReadNext() (n int, content []byte) {
 	content = make([]byte, r.chunkSize)
-	n, err := r.reader.Read(content)
+	n, err := io.ReadFull(r.reader, content)
 	if err != nil {
-		if err == io.EOF {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
 			retBuf := make([]byte, n)
 			copy(retBuf, content)
 			return n, retBuf
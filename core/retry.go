@@ -0,0 +1,94 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"osssync/common/metrics"
+	"osssync/common/retry"
+	"osssync/common/tracing"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/pkg/sftp"
+	"google.golang.org/api/googleapi"
+)
+
+var backendRetries = metrics.NewCounterVec("osssync_backend_retries_total",
+	"Retries of the calls to the storage backends by operation.", "operation")
+
+// retryableCodes are the error codes of OSS worth a retry whatever the status code
+var retryableCodes = map[string]bool{
+	"RequestTimeout":     true,
+	"InternalError":      true,
+	"ServiceUnavailable": true,
+	"SlowDown":           true,
+	"Throttling":         true,
+	"QpsLimitExceeded":   true,
+}
+
+var retryPolicy = retry.Policy{
+	Attempts:  5,
+	BaseDelay: 500 * time.Millisecond,
+	MaxDelay:  30 * time.Second,
+	// a run may retry 100 calls in a row, then a retry for every 10 successful calls
+	Budget:    retry.NewBudget(100, 0.1),
+	Retryable: IsRetryable,
+}
+var retryPolicyLock sync.RWMutex
+
+// SetRetryPolicy changes the max attempts and the first delay of the retries of the backend calls
+func SetRetryPolicy(attempts int, baseDelay time.Duration) {
+	retryPolicyLock.Lock()
+	defer retryPolicyLock.Unlock()
+	if attempts > 0 {
+		retryPolicy.Attempts = attempts
+	}
+	if baseDelay > 0 {
+		retryPolicy.BaseDelay = baseDelay
+	}
+}
+
+// withRetry calls a backend operation and retries it on the transient errors until ctx is done
+func withRetry(ctx context.Context, operation string, fn func() error) error {
+	retryPolicyLock.RLock()
+	policy := retryPolicy
+	retryPolicyLock.RUnlock()
+	retries := backendRetries.WithLabelValues(operation)
+	policy.OnRetry = func(attempt int, delay time.Duration, err error) {
+		retries.Inc()
+	}
+	return policy.DoContext(ctx, fn)
+}
+
+// IsRetryable returns true for the transient errors of the backends: network errors, timeouts, 5xx and throttling
+func IsRetryable(err error) bool {
+	cause := tracing.Cause(err)
+	switch e := cause.(type) {
+	case oss.ServiceError:
+		return retryableStatus(e.StatusCode) || retryableCodes[e.Code]
+	case oss.UnexpectedStatusCodeError:
+		return retryableStatus(e.Got())
+	case oss.CRCCheckError:
+		// the data was damaged on its way
+		return true
+	case *WebDAVError:
+		return retryableStatus(e.StatusCode)
+	case *azcore.ResponseError:
+		return retryableStatus(e.StatusCode)
+	}
+	var googleErr *googleapi.Error
+	if errors.As(cause, &googleErr) {
+		return retryableStatus(googleErr.Code)
+	}
+	if errors.Is(cause, sftp.ErrSSHFxConnectionLost) {
+		return true
+	}
+	return retry.IsTransient(cause)
+}
+
+func retryableStatus(statusCode int) bool {
+	return statusCode >= http.StatusInternalServerError || statusCode == http.StatusTooManyRequests || statusCode == http.StatusRequestTimeout
+}
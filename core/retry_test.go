@@ -0,0 +1,40 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"osssync/common/tracing"
+	"syscall"
+	"testing"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		err       error
+		retryable bool
+	}{
+		{oss.ServiceError{StatusCode: 503, Code: "ServiceUnavailable"}, true},
+		{oss.ServiceError{StatusCode: 429}, true},
+		{oss.ServiceError{StatusCode: 400, Code: "RequestTimeout"}, true},
+		{oss.ServiceError{StatusCode: 403, Code: "AccessDenied"}, false},
+		{oss.ServiceError{StatusCode: 404, Code: "NoSuchKey"}, false},
+		{oss.CheckRespCode(502, []int{200}), true},
+		{oss.CheckRespCode(409, []int{200}), false},
+		{tracing.Errorf("upload part", tracing.Error(io.ErrUnexpectedEOF)), true},
+		{errors.New("chunkNum invalid"), false},
+		{&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, true},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "oss.invalid", IsNotFound: true}}, false},
+		{&net.DNSError{Err: "i/o timeout", Name: "oss.invalid", IsTimeout: true}, true},
+		{io.EOF, false},
+	}
+	for _, c := range cases {
+		if IsRetryable(c.err) != c.retryable {
+			t.Errorf("IsRetryable(%v) should be %v", tracing.Message(c.err), c.retryable)
+		}
+	}
+}
@@ -0,0 +1,476 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"osssync/common/config"
+	"osssync/common/tracing"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPConfig is the sftp section of the credentials file, the user and the port of the uri take precedence over the ones of the config.
+// The keys of ~/.ssh are used if neither a private key nor a password is set, the host key is checked by ~/.ssh/known_hosts by default.
+type SFTPConfig struct {
+	User                  string `yaml:"user"`
+	Port                  int    `yaml:"port"`
+	PrivateKeyPath        string `yaml:"private_key_path"`
+	PrivateKeyPassphrase  string `yaml:"private_key_passphrase"`
+	Password              string `yaml:"password"`
+	KnownHostsPath        string `yaml:"known_hosts_path"`
+	InsecureIgnoreHostKey bool   `yaml:"insecure_ignore_host_key"`
+}
+
+type SFTPCfgWrapper struct {
+	Config SFTPConfig `yaml:"sftp"`
+}
+
+var sftpConfigs credentialsFiles[SFTPConfig]
+
+// LoadSFTPConfig reads the sftp section of the credentials file once until it is modified, an empty path is the default config
+func LoadSFTPConfig(credentialFilePath string) (SFTPConfig, error) {
+	if credentialFilePath == "" {
+		return SFTPConfig{}, nil
+	}
+	return sftpConfigs.load(credentialFilePath, func() (SFTPConfig, error) {
+		sftpCfg := SFTPCfgWrapper{}
+		err := config.BindYaml(credentialFilePath, &sftpCfg)
+		if err != nil {
+			return SFTPConfig{}, tracing.Error(err)
+		}
+		return sftpCfg.Config, nil
+	})
+}
+
+// sftpDialTimeout bounds the connection and the handshake of a server
+const sftpDialTimeout = 30 * time.Second
+
+// sftpClients are the connected clients by their config and server, a client is dropped once its connection is lost
+var sftpClients = make(map[string]*sftp.Client)
+var sftpClientsLock sync.Mutex
+
+// SFTPBackend is a directory of a server reached by SSH
+type SFTPBackend struct {
+	config SFTPConfig
+	user   string
+	addr   string
+	dir    string
+}
+
+// NewSFTPBackend returns the backend of an uri sftp://user@host:port/path, the path is absolute.
+// The server is connected by the first call.
+func NewSFTPBackend(config SFTPConfig, uri string) (*SFTPBackend, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "sftp" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid uri: %s", uri)
+	}
+	backend := &SFTPBackend{config: config, user: u.User.Username(), dir: "/" + strings.Trim(u.Path, "/")}
+	if backend.user == "" {
+		backend.user = config.User
+	}
+	if backend.user == "" {
+		return nil, fmt.Errorf("user of %s is required", uri)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "22"
+		if config.Port != 0 {
+			port = strconv.Itoa(config.Port)
+		}
+	}
+	backend.addr = net.JoinHostPort(u.Hostname(), port)
+	return backend, nil
+}
+
+func (backend *SFTPBackend) Type() FileType {
+	return FileType_SFTP
+}
+
+func (backend *SFTPBackend) Root() string {
+	return "sftp://" + backend.user + "@" + backend.addr + strings.TrimSuffix(backend.dir, "/")
+}
+
+func (backend *SFTPBackend) filePath(relativePath string) string {
+	return path.Join(backend.dir, relativePath)
+}
+
+func (backend *SFTPBackend) key() string {
+	return fmt.Sprintf("%+v|%s@%s", backend.config, backend.user, backend.addr)
+}
+
+// client returns the connected client of the server, connected once
+func (backend *SFTPBackend) client(ctx context.Context) (*sftp.Client, error) {
+	key := backend.key()
+	sftpClientsLock.Lock()
+	defer sftpClientsLock.Unlock()
+	if client, ok := sftpClients[key]; ok {
+		return client, nil
+	}
+	client, err := backend.connect(ctx)
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	sftpClients[key] = client
+	go func() {
+		client.Wait()
+		backend.drop(client)
+	}()
+	return client, nil
+}
+
+// drop forgets a client whose connection is lost, the next call connects again
+func (backend *SFTPBackend) drop(client *sftp.Client) {
+	sftpClientsLock.Lock()
+	defer sftpClientsLock.Unlock()
+	if sftpClients[backend.key()] == client {
+		delete(sftpClients, backend.key())
+	}
+	client.Close()
+}
+
+func (backend *SFTPBackend) connect(ctx context.Context) (*sftp.Client, error) {
+	auth, err := backend.config.authMethods()
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	hostKeyCallback, err := backend.config.hostKeyCallback()
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	dialer := net.Dialer{Timeout: sftpDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", backend.addr)
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	sshConfig := &ssh.ClientConfig{User: backend.user, Auth: auth, HostKeyCallback: hostKeyCallback, Timeout: sftpDialTimeout}
+	conn.SetDeadline(time.Now().Add(sftpDialTimeout))
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, backend.addr, sshConfig)
+	if err != nil {
+		conn.Close()
+		return nil, tracing.Errorf(fmt.Sprintf("failed to connect %s@%s", backend.user, backend.addr), err)
+	}
+	conn.SetDeadline(time.Time{})
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+	client, err := sftp.NewClient(sshClient, sftp.UseConcurrentWrites(true))
+	if err != nil {
+		sshClient.Close()
+		return nil, tracing.Error(err)
+	}
+	return client, nil
+}
+
+func sshDir() (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", tracing.Error(err)
+	}
+	return filepath.Join(usr.HomeDir, ".ssh"), nil
+}
+
+// authMethods are the private key and the password of the config, or the keys of ~/.ssh if neither is set
+func (config SFTPConfig) authMethods() ([]ssh.AuthMethod, error) {
+	keyPaths := []string{}
+	if config.PrivateKeyPath != "" {
+		keyPaths = append(keyPaths, absFilePath(config.PrivateKeyPath))
+	} else if config.Password == "" {
+		dir, err := sshDir()
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				keyPaths = append(keyPaths, filepath.Join(dir, name))
+			}
+		}
+	}
+	methods := make([]ssh.AuthMethod, 0)
+	signers := make([]ssh.Signer, 0)
+	for _, keyPath := range keyPaths {
+		pem, err := os.ReadFile(keyPath)
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		var signer ssh.Signer
+		if config.PrivateKeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(config.PrivateKeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(pem)
+		}
+		if err != nil {
+			return nil, tracing.Errorf(fmt.Sprintf("failed to parse the private key %s", keyPath), err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	if config.Password != "" {
+		methods = append(methods, ssh.Password(config.Password))
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("neither a private key nor a password is found for sftp")
+	}
+	return methods, nil
+}
+
+func (config SFTPConfig) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if config.InsecureIgnoreHostKey {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	knownHostsPath := config.KnownHostsPath
+	if knownHostsPath == "" {
+		dir, err := sshDir()
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		knownHostsPath = filepath.Join(dir, "known_hosts")
+	}
+	callback, err := knownhosts.New(absFilePath(knownHostsPath))
+	if err != nil {
+		return nil, tracing.Errorf("failed to read the known hosts", err)
+	}
+	return callback, nil
+}
+
+// do calls fn with the client of the server and retries it on the transient errors, a lost connection is connected again
+func (backend *SFTPBackend) do(ctx context.Context, operation string, fn func(client *sftp.Client) error) error {
+	return withRetry(ctx, operation, func() error {
+		client, err := backend.client(ctx)
+		if err != nil {
+			return err
+		}
+		err = fn(client)
+		if errors.Is(tracing.Cause(err), sftp.ErrSSHFxConnectionLost) {
+			backend.drop(client)
+		}
+		return err
+	})
+}
+
+func (backend *SFTPBackend) Stat(ctx context.Context, relativePath string) (*Stat, error) {
+	var statInfo os.FileInfo
+	err := backend.do(ctx, "Stat", func(client *sftp.Client) (err error) {
+		statInfo, err = client.Stat(backend.filePath(relativePath))
+		return err
+	})
+	if os.IsNotExist(tracing.Cause(err)) {
+		return nil, tracing.Error(ErrNotFound)
+	}
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	if statInfo.IsDir() {
+		return nil, tracing.Errorf(fmt.Sprintf("%s is a directory", relativePath), ErrNotFound)
+	}
+	return physicalStat(relativePath, statInfo), nil
+}
+
+// List walks the files under prefix in the lexical order of their paths like PhysicalBackend, the hidden files and directories are skipped.
+// The continue token is the path of the last file of the page.
+func (backend *SFTPBackend) List(ctx context.Context, prefix string, continueToken string) (*ListPage, error) {
+	var page *ListPage
+	err := backend.do(ctx, "ReadDir", func(client *sftp.Client) (err error) {
+		page, err = listTree(ctx, prefix, continueToken, func(dir string) ([]treeEntry, error) {
+			infos, err := client.ReadDir(backend.filePath(dir))
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			entries := make([]treeEntry, 0, len(infos))
+			for _, info := range infos {
+				entries = append(entries, treeEntry{name: info.Name(), dir: info.IsDir(), stat: physicalStat("", info)})
+			}
+			return entries, nil
+		})
+		return err
+	})
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	return page, nil
+}
+
+func (backend *SFTPBackend) Open(ctx context.Context, relativePath string) (io.ReadCloser, error) {
+	var f *sftp.File
+	err := backend.do(ctx, "Open", func(client *sftp.Client) (err error) {
+		f, err = client.Open(backend.filePath(relativePath))
+		return err
+	})
+	if os.IsNotExist(tracing.Cause(err)) {
+		return nil, tracing.Error(ErrNotFound)
+	}
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	return readCloser{Reader: throttle(ctx, f, -1), Closer: f}, nil
+}
+
+// Create writes the content to a hidden temporary file next to the file, which replaces the file once it is committed.
+// The content is written at the offsets of the chunks, so a write failing on a lost connection is written again once connected again.
+func (backend *SFTPBackend) Create(ctx context.Context, relativePath string, options CreateOptions) (Upload, error) {
+	filePath := backend.filePath(relativePath)
+	upload := &sftpUpload{
+		backend: backend,
+		ctx:     ctx,
+		path:    filePath,
+		tmpPath: path.Join(path.Dir(filePath), "."+path.Base(filePath)+"."+uuid.NewString()+".tmp"),
+		options: options,
+	}
+	err := backend.do(ctx, "Create", func(client *sftp.Client) error {
+		if err := client.MkdirAll(path.Dir(filePath)); err != nil {
+			return err
+		}
+		f, err := client.OpenFile(upload.tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			return err
+		}
+		upload.f, upload.client = f, client
+		return nil
+	})
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	return upload, nil
+}
+
+func (backend *SFTPBackend) Delete(ctx context.Context, relativePath string) error {
+	err := backend.do(ctx, "Remove", func(client *sftp.Client) error {
+		return client.Remove(backend.filePath(relativePath))
+	})
+	if err != nil && !os.IsNotExist(tracing.Cause(err)) {
+		return tracing.Error(err)
+	}
+	return nil
+}
+
+// Hash reads the file to compute its MD5 and CRC64, the content is downloaded in full
+func (backend *SFTPBackend) Hash(ctx context.Context, relativePath string) ([]byte, uint64, error) {
+	body, err := backend.Open(ctx, relativePath)
+	if err != nil {
+		return nil, 0, tracing.Error(err)
+	}
+	defer body.Close()
+	return hashContent(ctx, body)
+}
+
+type sftpUpload struct {
+	backend *SFTPBackend
+	// ctx is the context of Create, Write has no context of its own
+	ctx     context.Context
+	path    string
+	tmpPath string
+	options CreateOptions
+	// f is the temporary file opened by client, it is opened again once client is connected again
+	f      *sftp.File
+	client *sftp.Client
+	offset int64
+}
+
+// writeAt writes p at off of the temporary file, opened again if the connection it was opened by is lost.
+// The bytes are throttled by the bandwidth before they are written.
+func (upload *sftpUpload) writeAt(ctx context.Context, p []byte, off int64) (int, error) {
+	if err := currentLimiter().WaitN(ctx, len(p)); err != nil {
+		return 0, tracing.Error(err)
+	}
+	var n int
+	err := upload.backend.do(ctx, "WriteAt", func(client *sftp.Client) (err error) {
+		if client != upload.client {
+			f, err := client.OpenFile(upload.tmpPath, os.O_WRONLY)
+			if err != nil {
+				return err
+			}
+			upload.f, upload.client = f, client
+		}
+		n, err = upload.f.WriteAt(p, off)
+		return err
+	})
+	if err != nil {
+		return 0, tracing.Error(err)
+	}
+	return n, nil
+}
+
+func (upload *sftpUpload) Write(p []byte) (int, error) {
+	n, err := upload.writeAt(upload.ctx, p, upload.offset)
+	upload.offset += int64(n)
+	return n, err
+}
+
+func (upload *sftpUpload) WriteChunk(ctx context.Context, content []byte, chunk *FileChunkInfo) (int, error) {
+	if int64(len(content)) > chunk.ChunkSize {
+		return 0, ErrIndexOutOfRange
+	}
+	return upload.writeAt(ctx, content, chunk.Offset)
+}
+
+// Commit sets the permissions and the modification time of the options and renames the temporary file to the file
+func (upload *sftpUpload) Commit(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		upload.Abort()
+		return tracing.Error(err)
+	}
+	mode := upload.options.Mode.Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	err := upload.backend.do(ctx, "Commit", func(client *sftp.Client) error {
+		if client == upload.client {
+			if err := upload.f.Close(); err != nil {
+				return err
+			}
+		}
+		upload.client = nil
+		if err := client.Chmod(upload.tmpPath, mode); err != nil {
+			return err
+		}
+		if modTime := upload.options.ModTime; !modTime.IsZero() {
+			if err := client.Chtimes(upload.tmpPath, modTime, modTime); err != nil {
+				return err
+			}
+		}
+		if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+			return client.PosixRename(upload.tmpPath, upload.path)
+		}
+		// a plain rename does not replace the file
+		if err := client.Remove(upload.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return client.Rename(upload.tmpPath, upload.path)
+	})
+	if err != nil {
+		upload.Abort()
+		return tracing.Error(err)
+	}
+	return nil
+}
+
+func (upload *sftpUpload) Abort() error {
+	if upload.client != nil {
+		upload.f.Close()
+		upload.client = nil
+	}
+	err := upload.backend.do(context.Background(), "Remove", func(client *sftp.Client) error {
+		return client.Remove(upload.tmpPath)
+	})
+	if err != nil && !os.IsNotExist(tracing.Cause(err)) {
+		return tracing.Error(err)
+	}
+	return nil
+}
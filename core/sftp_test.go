@@ -0,0 +1,140 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"osssync/core/sftpfake"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSFTP returns a server and the config of its client, the files are served from dir
+func fakeSFTP(t *testing.T) (*sftpfake.Server, SFTPConfig, string) {
+	server := sftpfake.NewServer()
+	t.Cleanup(server.Close)
+	SetTestRetryPolicy(t)
+	privateKeyPath, knownHostsPath, err := server.WriteCredentials(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, SFTPConfig{PrivateKeyPath: privateKeyPath, KnownHostsPath: knownHostsPath}, t.TempDir()
+}
+
+func TestSFTPBackendList(t *testing.T) {
+	defer func(size int) { listPageSize = size }(listPageSize)
+	listPageSize = 2
+	server, config, dir := fakeSFTP(t)
+	for _, relativePath := range []string{"a/x", "a/y/z", "a-b", "b", ".hidden", "c/.tmp/d", "c/e"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, relativePath)), 0755)
+		os.WriteFile(filepath.Join(dir, relativePath), []byte(relativePath), 0644)
+	}
+	backend, err := NewSFTPBackend(config, server.Uri("backup", dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPaths(t, listAll(t, backend, ""), []string{"a/x", "a/y/z", "a-b", "b", "c/e"})
+	expectPaths(t, listAll(t, backend, "a"), []string{"a/x", "a/y/z"})
+	expectPaths(t, listAll(t, backend, "missing"), nil)
+	if server.Sessions() != 1 {
+		t.Fatalf("expect the connection to be shared, got %d sessions", server.Sessions())
+	}
+}
+
+func TestSFTPResumeWrite(t *testing.T) {
+	server, config, dir := fakeSFTP(t)
+	ctx := context.Background()
+	backend, err := NewSFTPBackend(config, server.Uri("backup", dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := NewBackendFile(ctx, backend, "videos/a.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	writer := func(ctx context.Context, content []byte, chunk *FileChunkInfo) (int, error) {
+		// the connection is lost between the chunks, the next chunk is written once connected again
+		if chunk.Number == 3 {
+			server.DropConnections()
+		}
+		return file.WriteChunk(ctx, content, chunk)
+	}
+	err = file.WalkChunk(ctx, bytes.NewReader(content), 300, int64(len(content)), writer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	written, err := os.ReadFile(filepath.Join(dir, "videos/a.mp4"))
+	if err != nil || !bytes.Equal(written, content) {
+		t.Fatalf("unexpected content of %d bytes: %v", len(written), err)
+	}
+	if server.Sessions() < 2 {
+		t.Fatal("expect the server to be connected again")
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, "videos"))
+	if len(entries) != 1 {
+		t.Fatalf("the temporary file is left: %v", entries)
+	}
+}
+
+func TestSFTPAttributes(t *testing.T) {
+	server, config, dir := fakeSFTP(t)
+	ctx := context.Background()
+	backend, err := NewSFTPBackend(config, server.Uri("backup", dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := NewBackendFile(ctx, backend, "run.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	modTime := time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+	file.SetAttributes(0750, modTime)
+	file.Writer().Write([]byte("#!/bin/sh"))
+	if err := file.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mode, fileModTime := file.Attributes()
+	if mode != 0750 || !fileModTime.Equal(modTime) {
+		t.Fatalf("unexpected mode %o and modification time %s", mode, fileModTime)
+	}
+	if file.Properties()[PropertyName_ContentMode] != "0750" {
+		t.Fatalf("unexpected mode property %q", file.Properties()[PropertyName_ContentMode])
+	}
+	statInfo, err := os.Stat(filepath.Join(dir, "run.sh"))
+	if err != nil || statInfo.Mode().Perm() != 0750 {
+		t.Fatalf("the permissions are not kept: %v", err)
+	}
+
+	reader, err := backend.Open(ctx, "run.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if content, _ := io.ReadAll(reader); string(content) != "#!/bin/sh" {
+		t.Fatalf("unexpected content %q", content)
+	}
+}
+
+func TestSFTPUnknownHost(t *testing.T) {
+	server, config, dir := fakeSFTP(t)
+	other := sftpfake.NewServer()
+	defer other.Close()
+	_, config.KnownHostsPath, _ = other.WriteCredentials(t.TempDir())
+	backend, err := NewSFTPBackend(config, server.Uri("backup", dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = backend.Stat(context.Background(), "a")
+	if err == nil || !strings.Contains(err.Error(), "key") {
+		t.Fatalf("expect the host key to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+// Package sftpfake is an in-process SSH server of the sftp subsystem, for tests running offline.
+//
+// The server serves the local file system, the paths of the uris are absolute paths of the host, e.g. those of t.TempDir().
+// It accepts the key of its only client, whose private key and the known hosts line of the server are written by WriteCredentials.
+package sftpfake
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Server is an SSH server listening on 127.0.0.1
+type Server struct {
+	listener  net.Listener
+	hostKey   ssh.Signer
+	clientKey *ecdsa.PrivateKey
+	config    *ssh.ServerConfig
+
+	lock     sync.Mutex
+	conns    map[net.Conn]bool
+	sessions int
+	wg       sync.WaitGroup
+}
+
+func newKey() *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// NewServer starts a server of new host and client keys
+func NewServer() *Server {
+	hostKey, err := ssh.NewSignerFromKey(newKey())
+	if err != nil {
+		panic(err)
+	}
+	server := &Server{hostKey: hostKey, clientKey: newKey(), conns: make(map[net.Conn]bool)}
+	clientPublicKey, err := ssh.NewPublicKey(&server.clientKey.PublicKey)
+	if err != nil {
+		panic(err)
+	}
+	server.config = &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientPublicKey.Marshal()) {
+				return nil, fmt.Errorf("unknown key of %s", conn.User())
+			}
+			return nil, nil
+		},
+	}
+	server.config.AddHostKey(hostKey)
+	server.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	server.wg.Add(1)
+	go server.accept()
+	return server
+}
+
+// Addr is the host and the port of the server
+func (server *Server) Addr() string {
+	return server.listener.Addr().String()
+}
+
+// Uri returns the uri of dir on the server for user
+func (server *Server) Uri(user string, dir string) string {
+	return "sftp://" + user + "@" + server.Addr() + filepath.ToSlash(dir)
+}
+
+// WriteCredentials writes the private key of the client and the known hosts file of the server to dir
+func (server *Server) WriteCredentials(dir string) (privateKeyPath string, knownHostsPath string, err error) {
+	der, err := x509.MarshalECPrivateKey(server.clientKey)
+	if err != nil {
+		return "", "", err
+	}
+	privateKeyPath = filepath.Join(dir, "id_ecdsa")
+	err = os.WriteFile(privateKeyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		return "", "", err
+	}
+	knownHostsPath = filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(server.Addr())}, server.hostKey.PublicKey())
+	err = os.WriteFile(knownHostsPath, []byte(line+"\n"), 0644)
+	if err != nil {
+		return "", "", err
+	}
+	return privateKeyPath, knownHostsPath, nil
+}
+
+// Sessions is the count of the sftp sessions served
+func (server *Server) Sessions() int {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return server.sessions
+}
+
+// DropConnections closes the connections of the clients as a network failure would
+func (server *Server) DropConnections() {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	for conn := range server.conns {
+		conn.Close()
+	}
+}
+
+// Close stops the server and closes its connections
+func (server *Server) Close() {
+	server.listener.Close()
+	server.DropConnections()
+	server.wg.Wait()
+}
+
+func (server *Server) accept() {
+	defer server.wg.Done()
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		server.lock.Lock()
+		server.conns[conn] = true
+		server.lock.Unlock()
+		server.wg.Add(1)
+		go func() {
+			defer server.wg.Done()
+			server.serve(conn)
+			server.lock.Lock()
+			delete(server.conns, conn)
+			server.lock.Unlock()
+		}()
+	}
+}
+
+func (server *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, server.config)
+	if err != nil {
+		return
+	}
+	defer sshConn.Close()
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are served")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		server.wg.Add(1)
+		go func() {
+			defer server.wg.Done()
+			defer channel.Close()
+			for req := range requests {
+				// the payload of a subsystem request is the string of its name
+				if req.Type != "subsystem" || len(req.Payload) < 4 || string(req.Payload[4:]) != "sftp" {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+				server.lock.Lock()
+				server.sessions++
+				server.lock.Unlock()
+				go ssh.DiscardRequests(requests)
+				sftpServer, err := sftp.NewServer(channel)
+				if err != nil {
+					return
+				}
+				sftpServer.Serve()
+				sftpServer.Close()
+				return
+			}
+		}()
+	}
+}
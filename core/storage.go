@@ -0,0 +1,23 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+var storageClasses = []StorageClass{StorageClass_Standard, StorageClass_IA, StorageClass_Archive, StorageClass_ColdArchive}
+
+// ParseStorageClass returns the storage class named s whatever its case, e.g. "archive" or "ColdArchive"
+func ParseStorageClass(s string) (StorageClass, error) {
+	for _, class := range storageClasses {
+		if strings.EqualFold(s, string(class)) {
+			return class, nil
+		}
+	}
+	return "", fmt.Errorf("unknown storage class %s, expect one of %v", s, storageClasses)
+}
+
+// IsArchived returns true if the files of class must be restored before they are read
+func (class StorageClass) IsArchived() bool {
+	return class == StorageClass_Archive || class == StorageClass_ColdArchive
+}
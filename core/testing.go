@@ -0,0 +1,20 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+// SetTestRetryPolicy retries the backend calls of a test against a fake 3 times from 1ms,
+// the previous policy is restored once the test ends
+func SetTestRetryPolicy(t testing.TB) {
+	retryPolicyLock.RLock()
+	previous := retryPolicy
+	retryPolicyLock.RUnlock()
+	SetRetryPolicy(3, time.Millisecond)
+	t.Cleanup(func() {
+		retryPolicyLock.Lock()
+		defer retryPolicyLock.Unlock()
+		retryPolicy = previous
+	})
+}
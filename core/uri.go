@@ -31,6 +31,18 @@ func ResolveUriType(uri string) FileType {
 	if strings.HasPrefix(uri, "oss://") {
 		return FileType_AliOSS
 	}
+	if strings.HasPrefix(uri, "sftp://") {
+		return FileType_SFTP
+	}
+	if strings.HasPrefix(uri, "webdav://") || strings.HasPrefix(uri, "webdavs://") {
+		return FileType_WebDAV
+	}
+	if strings.HasPrefix(uri, "azblob://") {
+		return FileType_AzureBlob
+	}
+	if strings.HasPrefix(uri, "gs://") {
+		return FileType_GCS
+	}
 	return FileType_Physical
 }
 
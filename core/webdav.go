@@ -0,0 +1,580 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"osssync/common/config"
+	"osssync/common/tracing"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// WebDAVConfig is the webdav section of the credentials file, the user of the uri takes precedence over the one of the config
+type WebDAVConfig struct {
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	// DisableChunking uploads the chunks by a single PUT even if the server supports chunked uploads
+	DisableChunking bool `yaml:"disable_chunking"`
+}
+
+type WebDAVCfgWrapper struct {
+	Config WebDAVConfig `yaml:"webdav"`
+}
+
+var webdavConfigs credentialsFiles[WebDAVConfig]
+
+// LoadWebDAVConfig reads the webdav section of the credentials file once until it is modified, an empty path is an anonymous config
+func LoadWebDAVConfig(credentialFilePath string) (WebDAVConfig, error) {
+	if credentialFilePath == "" {
+		return WebDAVConfig{}, nil
+	}
+	return webdavConfigs.load(credentialFilePath, func() (WebDAVConfig, error) {
+		webdavCfg := WebDAVCfgWrapper{}
+		err := config.BindYaml(credentialFilePath, &webdavCfg)
+		if err != nil {
+			return WebDAVConfig{}, tracing.Error(err)
+		}
+		return webdavCfg.Config, nil
+	})
+}
+
+// webdavClient is the client of all the servers, its connections are shared and a request has no timeout of its own as an upload may be long
+var webdavClient = &http.Client{}
+
+// WebDAVError is a request answered by an unexpected status
+type WebDAVError struct {
+	Method     string
+	Url        string
+	StatusCode int
+}
+
+func (e *WebDAVError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.Url, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func isWebDAVStatus(err error, statusCode int) bool {
+	e, ok := tracing.Cause(err).(*WebDAVError)
+	return ok && e.StatusCode == statusCode
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/><d:getetag/><d:getcontenttype/></d:prop></d:propfind>`
+
+type davMultistatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+	Href      string `xml:"DAV: href"`
+	Propstats []struct {
+		Status string  `xml:"DAV: status"`
+		Prop   davProp `xml:"DAV: prop"`
+	} `xml:"DAV: propstat"`
+}
+
+type davProp struct {
+	ContentLength int64  `xml:"DAV: getcontentlength"`
+	LastModified  string `xml:"DAV: getlastmodified"`
+	ETag          string `xml:"DAV: getetag"`
+	ContentType   string `xml:"DAV: getcontenttype"`
+	ResourceType  struct {
+		Collection *struct{} `xml:"DAV: collection"`
+	} `xml:"DAV: resourcetype"`
+}
+
+// prop returns the properties found of a response, the propstats of the properties which are not found are ignored
+func (response *davResponse) prop() davProp {
+	prop := davProp{}
+	for _, propstat := range response.Propstats {
+		if !strings.Contains(propstat.Status, " 200 ") {
+			continue
+		}
+		p := propstat.Prop
+		if p.ContentLength != 0 {
+			prop.ContentLength = p.ContentLength
+		}
+		if p.LastModified != "" {
+			prop.LastModified = p.LastModified
+		}
+		if p.ETag != "" {
+			prop.ETag = p.ETag
+		}
+		if p.ContentType != "" {
+			prop.ContentType = p.ContentType
+		}
+		if p.ResourceType.Collection != nil {
+			prop.ResourceType.Collection = p.ResourceType.Collection
+		}
+	}
+	return prop
+}
+
+// WebDAVBackend is a collection of a WebDAV share, uri webdav://user@host/path over http or webdavs://user@host/path over https.
+// The chunks of a file are uploaded by the chunked uploads of Nextcloud if the path is one of a Nextcloud server, /remote.php/dav/files/<user>/...,
+// otherwise by a single PUT.
+type WebDAVBackend struct {
+	config WebDAVConfig
+	user   string
+	scheme string
+	host   string
+	root   string
+	dir    string
+	// uploads is the collection of the chunked uploads of the user, empty if the server does not support them
+	uploads string
+	// dirs are the collections known to exist
+	dirs sync.Map
+}
+
+func NewWebDAVBackend(config WebDAVConfig, uri string) (*WebDAVBackend, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" || (u.Scheme != "webdav" && u.Scheme != "webdavs") {
+		return nil, fmt.Errorf("invalid uri: %s", uri)
+	}
+	backend := &WebDAVBackend{config: config, user: u.User.Username(), scheme: "http", host: u.Host, dir: "/" + strings.Trim(u.Path, "/")}
+	if u.Scheme == "webdavs" {
+		backend.scheme = "https"
+	}
+	if backend.user == "" {
+		backend.user = config.User
+	}
+	backend.root = u.Scheme + "://" + u.Host + strings.TrimSuffix(backend.dir, "/")
+	if i := strings.Index(backend.dir+"/", "/remote.php/dav/files/"); i != -1 && !config.DisableChunking {
+		owner := strings.SplitN(strings.TrimPrefix(backend.dir+"/", backend.dir[:i]+"/remote.php/dav/files/"), "/", 2)[0]
+		if owner != "" {
+			backend.uploads = backend.dir[:i] + "/remote.php/dav/uploads/" + owner
+		}
+	}
+	return backend, nil
+}
+
+func (backend *WebDAVBackend) Type() FileType {
+	return FileType_WebDAV
+}
+
+func (backend *WebDAVBackend) Root() string {
+	return backend.root
+}
+
+func (backend *WebDAVBackend) filePath(relativePath string) string {
+	return path.Join(backend.dir, relativePath)
+}
+
+// url returns the url of a path of the server, the url of a collection ends with a slash
+func (backend *WebDAVBackend) url(p string, collection bool) string {
+	if collection && !strings.HasSuffix(p, "/") {
+		p += "/"
+	}
+	u := url.URL{Scheme: backend.scheme, Host: backend.host, Path: p}
+	return u.String()
+}
+
+// do sends a request of body throttled by the bandwidth and retries it on the transient errors,
+// WebDAVError is returned for a status other than the expected ones. The body of the response is closed by the caller.
+func (backend *WebDAVBackend) do(ctx context.Context, method string, target string, header http.Header, body []byte, expected ...int) (*http.Response, error) {
+	var resp *http.Response
+	err := withRetry(ctx, method, func() error {
+		var content io.Reader = http.NoBody
+		if len(body) > 0 {
+			content = throttle(ctx, bytes.NewReader(body), int64(len(body)))
+		}
+		req, err := http.NewRequestWithContext(ctx, method, target, content)
+		if err != nil {
+			return err
+		}
+		req.ContentLength = int64(len(body))
+		backend.authorize(req, header)
+		r, err := webdavClient.Do(req)
+		if err != nil {
+			return err
+		}
+		for _, statusCode := range expected {
+			if r.StatusCode == statusCode {
+				resp = r
+				return nil
+			}
+		}
+		io.Copy(io.Discard, r.Body)
+		r.Body.Close()
+		return &WebDAVError{Method: method, Url: target, StatusCode: r.StatusCode}
+	})
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	return resp, nil
+}
+
+func (backend *WebDAVBackend) authorize(req *http.Request, header http.Header) {
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if backend.user != "" {
+		req.SetBasicAuth(backend.user, backend.config.Password)
+	}
+}
+
+// propfind returns the responses of a PROPFIND of depth, ErrNotFound if the target does not exist
+func (backend *WebDAVBackend) propfind(ctx context.Context, target string, depth string) ([]davResponse, error) {
+	header := http.Header{"Depth": {depth}, "Content-Type": {"application/xml; charset=utf-8"}}
+	resp, err := backend.do(ctx, "PROPFIND", target, header, []byte(propfindBody), http.StatusMultiStatus)
+	if isWebDAVStatus(err, http.StatusNotFound) {
+		return nil, tracing.Error(ErrNotFound)
+	}
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	defer resp.Body.Close()
+	multistatus := davMultistatus{}
+	err = xml.NewDecoder(resp.Body).Decode(&multistatus)
+	if err != nil {
+		return nil, tracing.Errorf(fmt.Sprintf("invalid response of PROPFIND %s", target), err)
+	}
+	return multistatus.Responses, nil
+}
+
+func davStat(relativePath string, prop davProp) *Stat {
+	stat := &Stat{
+		RelativePath: relativePath,
+		Size:         prop.ContentLength,
+		Readable:     true,
+		Properties: map[PropertyName]string{
+			PropertyName_ContentType: prop.ContentType,
+			PropertyName_ContentETag: prop.ETag,
+		},
+	}
+	if prop.ContentType == "" {
+		stat.Properties[PropertyName_ContentType] = "application/octet-stream"
+	}
+	stat.ModTime, _ = http.ParseTime(prop.LastModified)
+	return stat
+}
+
+func (backend *WebDAVBackend) Stat(ctx context.Context, relativePath string) (*Stat, error) {
+	responses, err := backend.propfind(ctx, backend.url(backend.filePath(relativePath), false), "0")
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	if len(responses) == 0 {
+		return nil, tracing.Error(ErrNotFound)
+	}
+	prop := responses[0].prop()
+	if prop.ResourceType.Collection != nil {
+		return nil, tracing.Errorf(fmt.Sprintf("%s is a directory", relativePath), ErrNotFound)
+	}
+	return davStat(relativePath, prop), nil
+}
+
+// List reads the collections under prefix by PROPFIND of depth 1, the files are listed like PhysicalBackend.
+// The continue token is the path of the last file of the page.
+func (backend *WebDAVBackend) List(ctx context.Context, prefix string, continueToken string) (*ListPage, error) {
+	page, err := listTree(ctx, prefix, continueToken, func(dir string) ([]treeEntry, error) {
+		dirPath := backend.filePath(dir)
+		responses, err := backend.propfind(ctx, backend.url(dirPath, true), "1")
+		if tracing.IsError(err, ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]treeEntry, 0, len(responses))
+		for _, response := range responses {
+			href, err := url.Parse(response.Href)
+			if err != nil {
+				return nil, tracing.Errorf(fmt.Sprintf("invalid href %s", response.Href), err)
+			}
+			hrefPath := strings.TrimSuffix(href.Path, "/")
+			// the collection itself is the first response
+			if hrefPath == strings.TrimSuffix(dirPath, "/") {
+				continue
+			}
+			prop := response.prop()
+			entries = append(entries, treeEntry{name: path.Base(hrefPath), dir: prop.ResourceType.Collection != nil, stat: davStat("", prop)})
+		}
+		return entries, nil
+	})
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	return page, nil
+}
+
+func (backend *WebDAVBackend) Open(ctx context.Context, relativePath string) (io.ReadCloser, error) {
+	resp, err := backend.do(ctx, "GET", backend.url(backend.filePath(relativePath), false), nil, nil, http.StatusOK)
+	if isWebDAVStatus(err, http.StatusNotFound) {
+		return nil, tracing.Error(ErrNotFound)
+	}
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	return readCloser{Reader: throttle(ctx, resp.Body, -1), Closer: resp.Body}, nil
+}
+
+// mkdirs creates the collection of dirPath and the collections between it and the root of the backend by MKCOL
+func (backend *WebDAVBackend) mkdirs(ctx context.Context, dirPath string) error {
+	dirs := []string{backend.dir}
+	if relativeDir := strings.Trim(strings.TrimPrefix(dirPath, backend.dir), "/"); relativeDir != "" {
+		for _, name := range strings.Split(relativeDir, "/") {
+			dirs = append(dirs, path.Join(dirs[len(dirs)-1], name))
+		}
+	}
+	for _, p := range dirs {
+		if _, ok := backend.dirs.Load(p); ok {
+			continue
+		}
+		// a collection which exists already is not allowed
+		resp, err := backend.do(ctx, "MKCOL", backend.url(p, true), nil, nil, http.StatusCreated, http.StatusMethodNotAllowed)
+		if err != nil {
+			return tracing.Error(err)
+		}
+		resp.Body.Close()
+		backend.dirs.Store(p, true)
+	}
+	return nil
+}
+
+// Create writes the content to a hidden temporary file next to the file, which is moved to the file once it is committed.
+// The chunks of a server supporting chunked uploads are uploaded one by one and assembled by the server once committed.
+func (backend *WebDAVBackend) Create(ctx context.Context, relativePath string, options CreateOptions) (Upload, error) {
+	filePath := backend.filePath(relativePath)
+	err := backend.mkdirs(ctx, path.Dir(filePath))
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	header := http.Header{}
+	if !options.ModTime.IsZero() {
+		// the modification time of the file on Nextcloud and ownCloud
+		header.Set("X-OC-Mtime", strconv.FormatInt(options.ModTime.Unix(), 10))
+	}
+	if options.Multipart && backend.uploads != "" {
+		upload := &webdavChunkedUpload{
+			backend:     backend,
+			url:         backend.url(path.Join(backend.uploads, uuid.NewString()), true),
+			destination: backend.url(filePath, false),
+			header:      header,
+		}
+		resp, err := backend.do(ctx, "MKCOL", upload.url, http.Header{"Destination": {upload.destination}}, nil, http.StatusCreated)
+		if err != nil {
+			return nil, tracing.Error(err)
+		}
+		resp.Body.Close()
+		return upload, nil
+	}
+
+	tmpPath := path.Join(path.Dir(filePath), "."+path.Base(filePath)+"."+uuid.NewString()+".tmp")
+	reader, writer := io.Pipe()
+	upload := &webdavUpload{
+		backend:     backend,
+		url:         backend.url(tmpPath, false),
+		destination: backend.url(filePath, false),
+		writer:      writer,
+		done:        make(chan error, 1),
+	}
+	// the content is streamed by a single PUT, which can't be retried
+	req, err := http.NewRequestWithContext(ctx, "PUT", upload.url, throttle(ctx, reader, -1))
+	if err != nil {
+		return nil, tracing.Error(err)
+	}
+	backend.authorize(req, header)
+	go func() {
+		resp, err := webdavClient.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+				err = &WebDAVError{Method: "PUT", Url: upload.url, StatusCode: resp.StatusCode}
+			}
+		}
+		reader.CloseWithError(errUploadDone)
+		upload.done <- err
+	}()
+	return upload, nil
+}
+
+func (backend *WebDAVBackend) Delete(ctx context.Context, relativePath string) error {
+	resp, err := backend.do(ctx, "DELETE", backend.url(backend.filePath(relativePath), false), nil, nil, http.StatusNoContent, http.StatusOK)
+	if isWebDAVStatus(err, http.StatusNotFound) {
+		return nil
+	}
+	if err != nil {
+		return tracing.Error(err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Hash reads the file to compute its MD5 and CRC64, the content is downloaded in full
+func (backend *WebDAVBackend) Hash(ctx context.Context, relativePath string) ([]byte, uint64, error) {
+	body, err := backend.Open(ctx, relativePath)
+	if err != nil {
+		return nil, 0, tracing.Error(err)
+	}
+	defer body.Close()
+	return hashContent(ctx, body)
+}
+
+// move moves the file of url to destination, the file of destination is replaced
+func (backend *WebDAVBackend) move(ctx context.Context, url string, destination string, header http.Header) error {
+	h := http.Header{"Destination": {destination}, "Overwrite": {"T"}}
+	for k, v := range header {
+		h[k] = v
+	}
+	resp, err := backend.do(ctx, "MOVE", url, h, nil, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return tracing.Error(err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// errUploadDone is the error of a write once the PUT of the upload is done
+var errUploadDone = errors.New("upload is done")
+
+// errUploadAborted is the error of the PUT of an aborted upload
+var errUploadAborted = errors.New("upload is aborted")
+
+// webdavUpload streams the content to the PUT of a temporary file
+type webdavUpload struct {
+	backend     *WebDAVBackend
+	url         string
+	destination string
+	writer      *io.PipeWriter
+	done        chan error
+	offset      int64
+	finished    bool
+	err         error
+}
+
+func (upload *webdavUpload) Write(p []byte) (int, error) {
+	n, err := upload.writer.Write(p)
+	upload.offset += int64(n)
+	if err != nil {
+		if putErr := upload.wait(); putErr != nil {
+			err = putErr
+		}
+		return n, tracing.Error(err)
+	}
+	return n, nil
+}
+
+// WriteChunk writes the chunks in the order of their offsets, the PUT is a single stream
+func (upload *webdavUpload) WriteChunk(ctx context.Context, content []byte, chunk *FileChunkInfo) (int, error) {
+	if int64(len(content)) > chunk.ChunkSize {
+		return 0, ErrIndexOutOfRange
+	}
+	if chunk.Offset != upload.offset {
+		return 0, tracing.Error(fmt.Errorf("chunk %d at %d is not the next of the upload at %d", chunk.Number, chunk.Offset, upload.offset))
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, tracing.Error(err)
+	}
+	return upload.Write(content)
+}
+
+// wait returns the result of the PUT once it is done
+func (upload *webdavUpload) wait() error {
+	if !upload.finished {
+		upload.err = <-upload.done
+		upload.finished = true
+	}
+	return upload.err
+}
+
+// Commit ends the PUT and moves the temporary file to the file
+func (upload *webdavUpload) Commit(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		upload.Abort()
+		return tracing.Error(err)
+	}
+	upload.writer.Close()
+	err := upload.wait()
+	if err == nil {
+		err = upload.backend.move(ctx, upload.url, upload.destination, nil)
+	}
+	if err != nil {
+		upload.Abort()
+		return tracing.Error(err)
+	}
+	return nil
+}
+
+func (upload *webdavUpload) Abort() error {
+	upload.writer.CloseWithError(errUploadAborted)
+	upload.wait()
+	resp, err := upload.backend.do(context.Background(), "DELETE", upload.url, nil, nil, http.StatusNoContent, http.StatusOK)
+	if isWebDAVStatus(err, http.StatusNotFound) {
+		return nil
+	}
+	if err != nil {
+		return tracing.Error(err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// webdavChunkedUpload is a chunked upload of Nextcloud: the chunks are files of an upload collection, which are assembled
+// by the MOVE of the virtual file .file of the collection to the destination
+type webdavChunkedUpload struct {
+	backend     *WebDAVBackend
+	url         string
+	destination string
+	header      http.Header
+	lock        sync.Mutex
+	size        int64
+}
+
+func (upload *webdavChunkedUpload) Write(p []byte) (int, error) {
+	return 0, tracing.Error(errors.New("a chunked upload is written by chunks"))
+}
+
+// WriteChunk uploads a chunk as a file of the upload collection named by its number, a chunk written again replaces the chunk
+func (upload *webdavChunkedUpload) WriteChunk(ctx context.Context, content []byte, chunk *FileChunkInfo) (int, error) {
+	if int64(len(content)) > chunk.ChunkSize {
+		return 0, ErrIndexOutOfRange
+	}
+	header := http.Header{"Destination": {upload.destination}}
+	resp, err := upload.backend.do(ctx, "PUT", upload.url+fmt.Sprintf("%05d", chunk.Number), header, content, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return 0, tracing.Error(err)
+	}
+	resp.Body.Close()
+	upload.lock.Lock()
+	if end := chunk.Offset + int64(len(content)); end > upload.size {
+		upload.size = end
+	}
+	upload.lock.Unlock()
+	return len(content), nil
+}
+
+func (upload *webdavChunkedUpload) Commit(ctx context.Context) error {
+	header := http.Header{"Oc-Total-Length": {strconv.FormatInt(upload.size, 10)}}
+	for k, v := range upload.header {
+		header[k] = v
+	}
+	err := upload.backend.move(ctx, upload.url+".file", upload.destination, header)
+	if err != nil {
+		upload.Abort()
+		return tracing.Error(err)
+	}
+	return nil
+}
+
+func (upload *webdavChunkedUpload) Abort() error {
+	resp, err := upload.backend.do(context.Background(), "DELETE", upload.url, nil, nil, http.StatusNoContent, http.StatusOK)
+	if isWebDAVStatus(err, http.StatusNotFound) {
+		return nil
+	}
+	if err != nil {
+		return tracing.Error(err)
+	}
+	resp.Body.Close()
+	return nil
+}
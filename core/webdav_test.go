@@ -0,0 +1,106 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"osssync/core/webdavfake"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeWebDAV returns a server of a new directory and the config of its user
+func fakeWebDAV(t *testing.T) (*webdavfake.Server, WebDAVConfig) {
+	server := webdavfake.NewServer(t.TempDir(), "alice", "secret")
+	t.Cleanup(server.Close)
+	SetTestRetryPolicy(t)
+	return server, WebDAVConfig{Password: "secret"}
+}
+
+func TestWebDAVBackendList(t *testing.T) {
+	defer func(size int) { listPageSize = size }(listPageSize)
+	listPageSize = 2
+	server, config := fakeWebDAV(t)
+	for _, relativePath := range []string{"a/x", "a/y/z", "a-b", "b", ".hidden", "c/.tmp/d", "c/e", "c/f g"} {
+		filePath := filepath.Join(server.Dir, "backup", relativePath)
+		os.MkdirAll(filepath.Dir(filePath), 0755)
+		os.WriteFile(filePath, []byte(relativePath), 0644)
+	}
+	backend, err := NewWebDAVBackend(config, server.Uri("/backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPaths(t, listAll(t, backend, ""), []string{"a/x", "a/y/z", "a-b", "b", "c/e", "c/f g"})
+	expectPaths(t, listAll(t, backend, "a"), []string{"a/x", "a/y/z"})
+	expectPaths(t, listAll(t, backend, "missing"), nil)
+
+	config.Password = "wrong"
+	backend, _ = NewWebDAVBackend(config, server.Uri("/backup"))
+	if _, err := backend.List(context.Background(), "", ""); !isWebDAVStatus(err, http.StatusUnauthorized) {
+		t.Fatalf("expect the request to be unauthorized, got %v", err)
+	}
+}
+
+func TestWebDAVChunkedUpload(t *testing.T) {
+	server, config := fakeWebDAV(t)
+	os.MkdirAll(filepath.Join(server.Dir, "remote.php/dav/uploads/alice"), 0755)
+	os.MkdirAll(filepath.Join(server.Dir, "remote.php/dav/files/alice"), 0755)
+	ctx := context.Background()
+	backend, err := NewWebDAVBackend(config, server.Uri("/remote.php/dav/files/alice/backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := NewBackendFile(ctx, backend, "videos/a.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	modTime := time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+	file.SetAttributes(0, modTime)
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	err = file.WalkChunk(ctx, bytes.NewReader(content), 300, int64(len(content)), file.WriteChunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	written, err := os.ReadFile(filepath.Join(server.Dir, "remote.php/dav/files/alice/backup/videos/a.mp4"))
+	if err != nil || !bytes.Equal(written, content) {
+		t.Fatalf("unexpected content of %d bytes: %v", len(written), err)
+	}
+	if server.Requests("PUT") != 4 {
+		t.Fatalf("expect the 4 chunks to be uploaded one by one, got %d PUT", server.Requests("PUT"))
+	}
+	if _, fileModTime := file.Attributes(); !fileModTime.Equal(modTime) {
+		t.Fatalf("unexpected modification time %s", fileModTime)
+	}
+	if file.Properties()[PropertyName_ContentETag] == "" {
+		t.Fatal("the etag is not a property")
+	}
+	if entries, _ := os.ReadDir(filepath.Join(server.Dir, "remote.php/dav/uploads/alice")); len(entries) != 0 {
+		t.Fatalf("the upload collection is left: %v", entries)
+	}
+}
+
+func TestWebDAVAbort(t *testing.T) {
+	server, config := fakeWebDAV(t)
+	ctx := context.Background()
+	backend, err := NewWebDAVBackend(config, server.Uri("/backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := backend.Create(ctx, "a.txt", CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload.Write([]byte("never stored"))
+	if err := upload.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(server.Dir, "backup")); len(entries) != 0 {
+		t.Fatalf("the aborted upload is left: %v", entries)
+	}
+}
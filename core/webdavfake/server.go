@@ -0,0 +1,160 @@
+// Package webdavfake is an in-process WebDAV server for tests running offline: the handler of golang.org/x/net/webdav serving a directory,
+// with the extensions of Nextcloud used by osssync.
+//
+//   - the X-OC-Mtime header of a PUT or a MOVE sets the modification time of the file, unless IgnoreModTime is set
+//   - the chunks PUT to an upload collection /remote.php/dav/uploads/<user>/<id>/ are assembled by the MOVE of its .file
+//
+// Every request is authorized by the basic auth of the user and the password of the server.
+package webdavfake
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// Server is a WebDAV server of the files of Dir
+type Server struct {
+	*httptest.Server
+	Dir      string
+	User     string
+	Password string
+	// IgnoreModTime ignores the X-OC-Mtime header like the servers other than Nextcloud and ownCloud
+	IgnoreModTime bool
+
+	handler  *webdav.Handler
+	lock     sync.Mutex
+	requests map[string]int
+}
+
+// NewServer starts a server of the files of dir
+func NewServer(dir string, user string, password string) *Server {
+	server := &Server{
+		Dir:      dir,
+		User:     user,
+		Password: password,
+		handler:  &webdav.Handler{FileSystem: webdav.Dir(dir), LockSystem: webdav.NewMemLS()},
+		requests: make(map[string]int),
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serve))
+	return server
+}
+
+// Uri returns the uri webdav://user@host:port/dirPath of the server
+func (server *Server) Uri(dirPath string) string {
+	return "webdav://" + server.User + "@" + strings.TrimPrefix(server.URL, "http://") + dirPath
+}
+
+// Requests returns the count of the requests of a method
+func (server *Server) Requests(method string) int {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return server.requests[method]
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (server *Server) serve(w http.ResponseWriter, r *http.Request) {
+	server.lock.Lock()
+	server.requests[r.Method]++
+	server.lock.Unlock()
+	if user, password, ok := r.BasicAuth(); !ok || user != server.User || password != server.Password {
+		w.Header().Set("WWW-Authenticate", `Basic realm="webdavfake"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	target := r.URL.Path
+	if r.Method == "MOVE" {
+		destination, err := url.Parse(r.Header.Get("Destination"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		target = destination.Path
+		if strings.Contains(r.URL.Path, "/remote.php/dav/uploads/") && path.Base(r.URL.Path) == ".file" {
+			server.assemble(w, r, path.Dir(r.URL.Path), target)
+			return
+		}
+	}
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	server.handler.ServeHTTP(sw, r)
+	if (r.Method == "PUT" || r.Method == "MOVE") && sw.status < 300 {
+		server.setModTime(r, target)
+	}
+}
+
+// assemble writes the chunks of an upload collection to the file of target in the order of their names
+func (server *Server) assemble(w http.ResponseWriter, r *http.Request, collection string, target string) {
+	collectionPath := filepath.Join(server.Dir, filepath.FromSlash(collection))
+	entries, err := os.ReadDir(collectionPath)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	_, err = os.Stat(filepath.Join(server.Dir, filepath.FromSlash(target)))
+	existed := err == nil
+	f, err := os.Create(filepath.Join(server.Dir, filepath.FromSlash(target)))
+	if err != nil {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	var size int64
+	for _, name := range names {
+		chunk, err := os.Open(filepath.Join(collectionPath, name))
+		if err != nil {
+			f.Close()
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		n, _ := io.Copy(f, chunk)
+		chunk.Close()
+		size += n
+	}
+	f.Close()
+	if total := r.Header.Get("OC-Total-Length"); total != "" && total != strconv.FormatInt(size, 10) {
+		os.Remove(f.Name())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	os.RemoveAll(collectionPath)
+	server.setModTime(r, target)
+	if existed {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (server *Server) setModTime(r *http.Request, target string) {
+	mtime, err := strconv.ParseInt(r.Header.Get("X-OC-Mtime"), 10, 64)
+	if err != nil || server.IgnoreModTime {
+		return
+	}
+	modTime := time.Unix(mtime, 0)
+	os.Chtimes(filepath.Join(server.Dir, filepath.FromSlash(target)), modTime, modTime)
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
 	"os/user"
 	"osssync/app"
 	"osssync/common/config"
+	"osssync/common/logging"
+	"osssync/common/tracing"
 	"osssync/core"
 	"path/filepath"
 	"strings"
@@ -17,20 +21,45 @@ func main() {
 	//flag.StringVar(&args.Provider, "provider", "", "object storage service provider. e.g. alioss")
 	flag.StringVar(&args.DestPath, "dest", "", "dest path")
 	flag.StringVar(&args.CredentialsFile, "credentials", "", "credentials file")
+	flag.StringVar(&args.SourceCredentials, "sourceCredentials", "", "credentials file of the source, e.g. a bucket of another region, the credentials file if empty")
 	//flag.BoolVar(&args.IndexOnly, "indexOnly", false, "only index files")
 	flag.BoolVar(&args.FullIndex, "fullIndex", false, "full index")
 	//flag.StringVar(&args.Salt, "salt", "", "salt")
 	flag.Int64Var(&args.ChunkSizeMb, "chunkSize", 0, "chunk size in MB")
-	flag.StringVar(&args.Operation, "operation", "", "[index, push, pull, sync]")
+	flag.StringVar(&args.Operation, "operation", "", "[index, push, pull, sync, scrub, watch, verify, history]")
 	flag.StringVar(&args.DbPath, "db", "", "db path")
 	flag.StringVar(&args.Password, "password", "", "password")
 	flag.StringVar(&args.Mnemonic, "mnemonic", "", "mnemonic")
 	flag.BoolVar(&args.Zip, "zip", false, "compress files to zip")
 	flag.StringVar(&args.TmpDir, "tmpDir", "./.tmp", "tmp dir")
+	flag.BoolVar(&args.Daemon, "daemon", false, "keep running and execute the operation by the cron expression")
+	flag.StringVar(&args.Cron, "cron", "", "cron expression of daemon mode, e.g. \"0 3 * * *\" or \"@every 6h\"")
+	flag.StringVar(&args.CronJitter, "cronJitter", "", "max random delay of each scheduled run, e.g. 5m")
+	flag.BoolVar(&args.ExecNow, "execNow", false, "execute once immediately when the daemon starts")
+	flag.StringVar(&args.WatchDebounce, "watchDebounce", "", "quiet time before a changed file is pushed by watch, e.g. 2s")
+	flag.StringVar(&args.WatchReconcile, "watchReconcile", "", "interval of the full reconciliation of watch, e.g. 1h")
+	flag.StringVar(&args.Job, "job", "", "run the job of the config file with this name")
+	flag.BoolVar(&args.AllJobs, "all", false, "run all the jobs of the config file")
+	flag.IntVar(&args.Workers, "workers", 0, "max count of files transferred at the same time by all jobs")
+	flag.IntVar(&args.Concurrency, "concurrency", 0, "max count of files transferred at the same time by a job")
+	flag.StringVar(&args.Progress, "progress", "", "[auto, bar, log, json, none] how the progress is reported")
+	flag.StringVar(&args.ProgressInterval, "progressInterval", "", "interval of the progress logs and json events, e.g. 10s")
+	flag.StringVar(&args.HttpAddr, "http", "", "listen address of the http endpoints, e.g. :9100 for /metrics and the control api")
+	flag.StringVar(&args.Run, "run", "", "id of the run whose details are shown by the history operation")
+	flag.IntVar(&args.HistoryKeep, "historyKeep", 0, "count of runs kept in the history of each job, 100 by default")
+	flag.IntVar(&args.Retries, "retries", 0, "max attempts of a call to the storage backend failing by a transient error, 5 by default")
+	flag.StringVar(&args.RetryDelay, "retryDelay", "", "delay before the first retry, doubled at each retry, e.g. 500ms")
+	flag.StringVar(&args.Bandwidth, "bandwidth", "", "max MB/s of the transfers to and from the object storage by all jobs, by time of day, e.g. \"09:00-18:00=2,unlimited\"")
+	flag.StringVar(&args.StorageClass, "storageClass", "", "[Standard, IA, Archive, ColdArchive] storage class of the uploaded objects, the default of the bucket if empty")
+	flag.StringVar(&args.RestorePoll, "restorePoll", "", "interval of the checks of the restores of the archived objects to pull, 1m by default")
+	flag.BoolVar(&args.Move, "move", false, "delete the objects pulled from oss to oss once their copies are verified")
+	flag.Float64Var(&args.VerifySample, "verifySample", 0, "percentage of the files downloaded in full, and decrypted if encrypted, by the verify operation")
+	flag.StringVar(&args.ShutdownTimeout, "shutdownTimeout", "", "time given to the files in progress to finish on SIGINT or SIGTERM before they are aborted, 30s by default")
 	flag.Parse()
 
 	config.AttachValue(core.Arg_SourcePath, absFilePath(args.SourcePath))
 	config.AttachValue(core.Arg_CredentialsFile, absFilePath(args.CredentialsFile))
+	config.AttachValue(core.Arg_SourceCredentials, absFilePath(args.SourceCredentials))
 	config.AttachValue(core.Arg_Config, absFilePath(args.Config))
 	config.AttachValue(core.Arg_Config, absFilePath(args.Config))
 	config.AttachValue(core.Arg_DestPath, absFilePath(args.DestPath))
@@ -42,45 +71,119 @@ func main() {
 	config.AttachValue(core.Arg_Password, args.Password)
 	config.AttachValue(core.Arg_Mnemonic, strings.TrimPrefix(strings.TrimSuffix(args.Mnemonic, "'"), "'"))
 	config.AttachValue(core.Arg_TmpDir, absFilePath(args.TmpDir))
-
-	if args.Operation != "generateKey" {
+	config.AttachValue(core.Arg_Daemon, args.Daemon)
+	config.AttachValue(core.Arg_Cron, args.Cron)
+	config.AttachValue(core.Arg_CronJitter, args.CronJitter)
+	config.AttachValue(core.Arg_ExecNow, args.ExecNow)
+	config.AttachValue(core.Arg_WatchDebounce, args.WatchDebounce)
+	config.AttachValue(core.Arg_WatchReconcile, args.WatchReconcile)
+	config.AttachValue(core.Arg_Job, args.Job)
+	config.AttachValue(core.Arg_AllJobs, args.AllJobs)
+	config.AttachValue(core.Arg_Workers, args.Workers)
+	config.AttachValue(core.Arg_Concurrency, args.Concurrency)
+	config.AttachValue(core.Arg_Progress, args.Progress)
+	config.AttachValue(core.Arg_ProgressInterval, args.ProgressInterval)
+	config.AttachValue(core.Arg_HttpAddr, args.HttpAddr)
+	// the token is not a flag to keep it out of the process list
+	config.AttachEnv(core.Arg_HttpToken, true)
+	config.AttachValue(core.Arg_Run, args.Run)
+	config.AttachValue(core.Arg_HistoryKeep, args.HistoryKeep)
+	config.AttachValue(core.Arg_Retries, args.Retries)
+	config.AttachValue(core.Arg_RetryDelay, args.RetryDelay)
+	config.AttachValue(core.Arg_ShutdownTimeout, args.ShutdownTimeout)
+	config.AttachValue(core.Arg_Bandwidth, args.Bandwidth)
+	config.AttachValue(core.Arg_VerifySample, args.VerifySample)
+	config.AttachValue(core.Arg_StorageClass, args.StorageClass)
+	config.AttachValue(core.Arg_RestorePoll, args.RestorePoll)
+	config.AttachValue(core.Arg_Move, args.Move)
+
+	// jobs of the config file are validated when they are loaded
+	selectJob := config.GetStringOrDefault(core.Arg_Job, "") != "" || config.GetValueOrDefault(core.Arg_AllJobs, false)
+	if args.Operation != "generateKey" && args.Operation != "history" && !selectJob {
 		if config.GetStringOrDefault(core.Arg_SourcePath, "") == "" {
-			panic("source path is required")
+			exit(fmt.Errorf("source path is required"))
 		}
 
-		if config.GetStringOrDefault(core.Arg_DestPath, "") == "" {
-			panic("DestPath is required")
+		if config.GetStringOrDefault(core.Arg_DestPath, "") == "" && args.Operation != "scrub" {
+			exit(fmt.Errorf("DestPath is required"))
 		}
 	}
 
 	err := app.Startup()
 	if err != nil {
-		panic(err)
+		exit(err)
 	}
 
 	// print config
 	config.Print()
 
-	err = app.Run()
-	if err != nil {
-		panic(err)
+	if config.GetValueOrDefault[bool](core.Arg_Daemon, false) {
+		err = app.RunDaemon()
+	} else {
+		err = app.Run()
+	}
+	if code := app.ExitCode(err); code == app.ExitCanceled {
+		logging.Warn(tracing.Message(err), nil)
+		os.Exit(code)
+	} else if err != nil {
+		logging.Error(err, nil)
+		os.Exit(code)
 	}
 }
 
+// exit stops the process before the logging is initialized, a panic would exit with the code of a partial failure
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, tracing.Message(err))
+	os.Exit(app.ExitCode(err))
+}
+
 type Args struct {
 	Config string
 
 	SourcePath string
 
-	DestPath        string
-	CredentialsFile string
+	DestPath          string
+	CredentialsFile   string
+	SourceCredentials string
 	// IndexOnly       bool
 	FullIndex   bool
 	Salt        string
 	ChunkSizeMb int64
 
-	Operation string
-	Daemon    bool
+	Operation  string
+	Daemon     bool
+	Cron       string
+	CronJitter string
+	ExecNow    bool
+
+	WatchDebounce  string
+	WatchReconcile string
+
+	Job         string
+	AllJobs     bool
+	Workers     int
+	Concurrency int
+
+	Progress         string
+	ProgressInterval string
+
+	HttpAddr string
+
+	Run         string
+	HistoryKeep int
+
+	Retries    int
+	RetryDelay string
+
+	ShutdownTimeout string
+
+	Bandwidth string
+
+	VerifySample float64
+
+	StorageClass string
+	RestorePoll  string
+	Move         bool
 
 	DbPath string
 